@@ -12,9 +12,11 @@ import (
 	"C"
 	"bhd/bhdmodels"
 	"bhd/cryptopera"
+	"bhd/cryptopera/bip44"
 	_ "embed"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"github.com/skip2/go-qrcode"
 	"strconv"
 )
@@ -41,6 +43,75 @@ func InitializeWallet(mnemonic string) *ApiReturnStruct {
 	return rValue
 }
 
+// InitializeWalletWithOptions creates the wallet from the mnemonic of the language
+// (detected when empty) protected by the optional BIP39 passphrase, when the
+// mnemonic is empty the new one of the words count (24 when empty) is generated
+func InitializeWalletWithOptions(mnemonic string, passphrase string, language string, words string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	var wordsInt = bip44.DefaultMnemonicWords
+	if words != "" {
+		var err error
+		wordsInt, err = strconv.Atoi(words)
+		if err != nil {
+			rValue.ErrorID = 3
+			rValue.ErrorDescription = "Bad words parameter:" + err.Error()
+			return rValue
+		}
+	}
+	err := cryptopera.NewWalletFromMnemonic(mnemonic, passphrase, bip44.Language(language), wordsInt)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+	}
+	return rValue
+}
+
+// ValidateMnemonic checks the words and the checksum of the mnemonic before the import,
+// for the word which isn't in the word list the content is its position (from 1)
+func ValidateMnemonic(mnemonic string, language string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	err := bip44.ValidateMnemonic(mnemonic, bip44.Language(language))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = err.Error()
+		var wordErr *bip44.MnemonicWordError
+		if errors.As(err, &wordErr) {
+			rValue.Content = strconv.Itoa(wordErr.Position)
+		}
+	}
+	return rValue
+}
+
+// InitializeWatchOnlyWallet will create the wallet from the account xpub, it
+// tracks the balance and builds unsigned transactions but can't sign
+func InitializeWatchOnlyWallet(xpub string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	err := cryptopera.NewWatchOnlyWallet(xpub)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+	}
+	return rValue
+}
+
+// GetAccountXPub returns the account xpub to create the watch-only wallet
+func GetAccountXPub() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	xpub, err := cryptopera.Service.GetAccountXPub()
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	rValue.Content = xpub
+	return rValue
+}
+
 // GetWalletMnemonic returns wallet mnemonic to the frontend client
 func GetWalletMnemonic() *ApiReturnStruct {
 	var rValue = &ApiReturnStruct{}
@@ -49,6 +120,11 @@ func GetWalletMnemonic() *ApiReturnStruct {
 		rValue.ErrorDescription = "Wallet not initialized"
 		return rValue
 	}
+	if cryptopera.Service.IsWatchOnly() {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Watch-only wallet has no mnemonic"
+		return rValue
+	}
 	rValue.Content = cryptopera.Service.Mnemonic
 	return rValue
 }
@@ -81,7 +157,7 @@ func SignTransaction(txStr string) *ApiReturnStruct {
 		return rValue
 	}
 
-	err = cryptopera.Service.SignTransaction(cryptopera.Service.Mnemonic, tx)
+	err = cryptopera.Service.SignTransaction(tx)
 	if err != nil {
 		rValue.ErrorID = 4
 		rValue.ErrorDescription = "Cannot sign tx due to:" + err.Error()
@@ -119,3 +195,77 @@ func GetBchAddressQrCode(qrCode string, size string) *ApiReturnStruct {
 	rValue.Content = hex.EncodeToString(png)
 	return rValue
 }
+
+// UseRemoteSigner makes the wallet sign with the signer listening on the local
+// socket, network is unix or tcp on the loopback address
+func UseRemoteSigner(network string, address string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	signer, err := cryptopera.NewRemoteSigner(network, address)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Bad remote signer address:" + err.Error()
+		return rValue
+	}
+	cryptopera.Service.Signer = signer
+	return rValue
+}
+
+// SetSignatureType selects the signature algorithm of the wallet, "schnorr" or "ecdsa"
+func SetSignatureType(name string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	sigType, err := cryptopera.ParseSignatureType(name)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	err = cryptopera.Service.SetSignatureType(sigType)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	return rValue
+}
+
+// ExportUnsignedTransaction returns the unsigned transaction with the sig hashes,
+// public keys and derivation paths for the offline signer, watch-only wallet only
+func ExportUnsignedTransaction(txStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var tx = &bhdmodels.Tx{}
+	err := json.Unmarshal([]byte(txStr), tx)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize tx request due to:" + err.Error()
+		return rValue
+	}
+	unsigned, err := cryptopera.Service.ExportUnsigned(tx)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot export unsigned tx due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(unsigned)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize unsigned tx due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
@@ -0,0 +1,85 @@
+package app
+
+import (
+	"bhd/bch/broadcast"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+const (
+	// maxPendingEvents is the size of the event queue, the oldest events are dropped
+	// when the frontend doesn't poll for them
+	maxPendingEvents = 256
+
+	EventTxRejected    = "txRejected"
+	EventTxPropagated  = "txPropagated"
+	EventTxDoubleSpend = "txDoubleSpend"
+)
+
+// Event is the notification for the frontend, Content is json of the event data
+type Event struct {
+	Type      string          `json:"type"`
+	Timestamp int64           `json:"timestamp"`
+	Content   json.RawMessage `json:"content"`
+}
+
+var (
+	eventsLock sync.Mutex
+	events     = make([]*Event, 0)
+	// Broadcaster sends the signed transactions to the connected peers, its notifications
+	// are queued as events
+	Broadcaster = newBroadcaster()
+)
+
+func newBroadcaster() *broadcast.Broadcaster {
+	b := broadcast.NewBroadcaster()
+	b.OnReject = func(status *broadcast.TxStatus, reject *broadcast.RejectInfo) {
+		PushEvent(EventTxRejected, struct {
+			Status *broadcast.TxStatus   `json:"status"`
+			Reject *broadcast.RejectInfo `json:"reject"`
+		}{status, reject})
+	}
+	b.OnPropagated = func(status *broadcast.TxStatus) {
+		PushEvent(EventTxPropagated, status)
+	}
+	b.OnDoubleSpend = func(report *broadcast.DoubleSpendReport) {
+		PushEvent(EventTxDoubleSpend, report)
+	}
+	return b
+}
+
+// PushEvent queues the event for the frontend
+func PushEvent(eventType string, content interface{}) {
+	data, err := json.Marshal(content)
+	if err != nil {
+		return
+	}
+	eventsLock.Lock()
+	defer eventsLock.Unlock()
+	if len(events) >= maxPendingEvents {
+		events = events[1:]
+	}
+	events = append(events, &Event{
+		Type:      eventType,
+		Timestamp: time.Now().Unix(),
+		Content:   data,
+	})
+}
+
+// GetEvents returns and clears all pending events
+func GetEvents() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	eventsLock.Lock()
+	pending := events
+	events = make([]*Event, 0)
+	eventsLock.Unlock()
+	content, err := json.Marshal(pending)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize events due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
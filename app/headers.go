@@ -0,0 +1,57 @@
+package app
+
+import (
+	"bhd/bch/chain"
+	"bhd/bch/msg"
+	"bhd/cryptopera"
+	"os"
+	"path/filepath"
+)
+
+var (
+	// HeaderChain is the block header chain the mempool finds the confirming blocks in,
+	// created by InitializeHeaderChain
+	HeaderChain     *chain.HeaderChain
+	headerChainPath string
+)
+
+// InitializeHeaderChain loads the headers saved in the base directory, the chain starts
+// from the genesis of the wallet network when there are none. Call it before InitializeMemPool.
+func InitializeHeaderChain(baseDir string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	path := filepath.Join(baseDir, msg.HeadersFile)
+	hc, err := chain.LoadHeaderChainFromFile(cryptopera.Service.NetParams, path)
+	if os.IsNotExist(err) {
+		hc, err = chain.NewHeaderChain(cryptopera.Service.NetParams, nil)
+	}
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create header chain due to:" + err.Error()
+		return rValue
+	}
+	HeaderChain = hc
+	headerChainPath = path
+	return rValue
+}
+
+// SaveHeaderChain writes the header chain to the base directory it was loaded from
+func SaveHeaderChain() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if HeaderChain == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Header chain not initialized"
+		return rValue
+	}
+	err := HeaderChain.SaveToFile(headerChainPath)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot save header chain due to:" + err.Error()
+		return rValue
+	}
+	return rValue
+}
@@ -0,0 +1,98 @@
+package app
+
+import (
+	"bhd/bch/mempool"
+	"bhd/bhdmodels"
+	"bhd/cryptopera"
+	"encoding/json"
+)
+
+const (
+	EventTxPending    = "txPending"
+	EventTxConfirmed  = "txConfirmed"
+	EventTxConflicted = "txConflicted"
+)
+
+// MemPool tracks the unconfirmed wallet transactions, created by InitializeMemPool
+var MemPool *mempool.Pool
+
+// InitializeMemPool creates the mempool tracker for the wallet, uxtosStr is
+// json list of the confirmed uxtos as returned by the BHD server
+func InitializeMemPool(uxtosStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	uxtos, err := parseUxtos(uxtosStr)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize uxtos due to:" + err.Error()
+		return rValue
+	}
+	err = newMemPool(uxtos)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create mempool due to:" + err.Error()
+		return rValue
+	}
+	return rValue
+}
+
+// newMemPool replaces MemPool with the tracker of the uxtos pushing its events, the
+// heights of the confirmed transactions are UnknownHeight without the HeaderChain
+func newMemPool(uxtos []*bhdmodels.Uxto) error {
+	pool, err := cryptopera.Service.NewMemPool(HeaderChain, uxtos)
+	if err != nil {
+		return err
+	}
+	pool.OnTxAdded = func(tx *mempool.WalletTx) {
+		PushEvent(EventTxPending, tx)
+	}
+	pool.OnTxConfirmed = func(tx *mempool.WalletTx) {
+		PushEvent(EventTxConfirmed, tx)
+	}
+	pool.OnTxConflicted = func(tx *mempool.WalletTx) {
+		PushEvent(EventTxConflicted, tx)
+	}
+	MemPool = pool
+	return nil
+}
+
+// GetWalletBalance returns confirmed and pending balance of the wallet
+func GetWalletBalance() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if MemPool == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Mempool not initialized"
+		return rValue
+	}
+	content, err := json.Marshal(MemPool.Balance())
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize balance due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+// GetWalletTransactions returns the tracked wallet transactions in the state
+// (pending, confirmed, conflicted), all of them when the state is empty
+func GetWalletTransactions(state string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if MemPool == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Mempool not initialized"
+		return rValue
+	}
+	content, err := json.Marshal(MemPool.Transactions(state))
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize transactions due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
@@ -0,0 +1,107 @@
+package app
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera"
+	"encoding/json"
+	"errors"
+	"strconv"
+)
+
+// SignMessage signs the message with the key of the wallet address (the wallet
+// address when empty) and returns the base64 signature
+func SignMessage(address string, message string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	signature, err := cryptopera.Service.SignMessage(address, message)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot sign message due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = signature
+	return rValue
+}
+
+// VerifyMessage checks the base64 signature of the message against the cash
+// address, the content is true when the address key signed the message
+func VerifyMessage(address string, message string, signature string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	err := cryptopera.VerifyMessage(address, message, signature)
+	if err != nil && !errors.Is(err, cryptopera.ErrMessageSignature) {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot verify message due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = strconv.FormatBool(err == nil)
+	return rValue
+}
+
+// AnswerLoginChallenge signs the login challenge of the BHD server (json of
+// bhdmodels.LoginChallengeResponse) and returns the json of bhdmodels.LoginRequest
+func AnswerLoginChallenge(playerId string, challengeStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var challenge = &bhdmodels.LoginChallengeResponse{}
+	err := json.Unmarshal([]byte(challengeStr), challenge)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize login challenge due to:" + err.Error()
+		return rValue
+	}
+	rq, err := cryptopera.Service.NewLoginRequest(playerId, challenge)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot sign login challenge due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(rq)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize login request due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+// AnswerRegisterAddressChallenge signs the challenge the BHD server issued for
+// the wallet address (json of bhdmodels.LoginChallengeResponse) and returns the
+// json of bhdmodels.RegisterBchAddressRequest for the session
+func AnswerRegisterAddressChallenge(playerId string, sessionToken string, address string, challengeStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var challenge = &bhdmodels.LoginChallengeResponse{}
+	err := json.Unmarshal([]byte(challengeStr), challenge)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize register address challenge due to:" + err.Error()
+		return rValue
+	}
+	rq, err := cryptopera.Service.NewRegisterAddressRequest(playerId, sessionToken, address, challenge)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot sign register address challenge due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(rq)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize register address request due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
@@ -0,0 +1,71 @@
+package app
+
+import (
+	"bhd/bch/bcmr"
+	"encoding/json"
+	"os"
+)
+
+// TokenMetadata resolves the token categories, created by InitializeTokenMetadata
+var TokenMetadata *bcmr.Resolver
+
+// InitializeTokenMetadata opens the metadata registry cache in the base directory
+func InitializeTokenMetadata(baseDir string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	resolver, err := bcmr.NewResolver(baseDir, bcmr.NewHTTPFetcher(), bcmr.DefaultMaxAge)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create metadata resolver due to:" + err.Error()
+		return rValue
+	}
+	TokenMetadata = resolver
+	return rValue
+}
+
+// LoadTokenRegistry adds the metadata registry from the local file or the uri,
+// contentHash is the hex sha256 of the registry published on chain, can be empty
+func LoadTokenRegistry(source string, contentHash string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if TokenMetadata == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Metadata resolver not initialized"
+		return rValue
+	}
+	var err error
+	if _, statErr := os.Stat(source); statErr == nil {
+		err = TokenMetadata.LoadFile(source)
+	} else {
+		err = TokenMetadata.Load(source, contentHash)
+	}
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot load metadata registry due to:" + err.Error()
+		return rValue
+	}
+	return rValue
+}
+
+// ResolveTokenMetadata returns the display metadata of the token category and
+// the NFT commitment (hex)
+func ResolveTokenMetadata(category string, commitment string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if TokenMetadata == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Metadata resolver not initialized"
+		return rValue
+	}
+	metadata, err := TokenMetadata.Resolve(category, commitment)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(metadata)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize token metadata due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
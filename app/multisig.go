@@ -0,0 +1,89 @@
+package app
+
+import (
+	"bhd/cryptopera"
+	"bhd/cryptopera/bip44"
+	"encoding/json"
+	"strconv"
+)
+
+// GetMultisigXPub returns the cosigner xpub of the wallet for the multisig account
+func GetMultisigXPub() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	xpub, err := cryptopera.Service.GetMultisigXPub()
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	rValue.Content = xpub
+	return rValue
+}
+
+// CreateMultisigAccount creates the m-of-n account, required is the number of the
+// signatures and xpubsStr the json list of the cosigner xpubs, returns the address
+func CreateMultisigAccount(required string, xpubsStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	requiredInt, err := strconv.Atoi(required)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Bad required signatures parameter:" + err.Error()
+		return rValue
+	}
+	var xpubs []string
+	err = json.Unmarshal([]byte(xpubsStr), &xpubs)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize xpubs due to:" + err.Error()
+		return rValue
+	}
+	address, err := cryptopera.Service.SetMultisigAccount(requiredInt, xpubs)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create multisig account due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = address
+	return rValue
+}
+
+// GetMultisigAddress returns the address of the multisig account, change is 0 for
+// the external and 1 for the internal chain
+func GetMultisigAddress(change string, index string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	changeInt, err := strconv.ParseUint(change, 10, 32)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Bad change parameter:" + err.Error()
+		return rValue
+	}
+	indexInt, err := strconv.ParseUint(index, 10, 32)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Bad index parameter:" + err.Error()
+		return rValue
+	}
+	address, err := cryptopera.Service.GetMultisigAddress(bip44.ChangeType(changeInt), uint32(indexInt))
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+		return rValue
+	}
+	rValue.Content = address
+	return rValue
+}
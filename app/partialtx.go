@@ -0,0 +1,164 @@
+package app
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera"
+	"encoding/json"
+)
+
+// CreatePartialTransaction converts the tx (json of bhdmodels.Tx) to the partially
+// signed transaction with the derivations of the wallet keys
+func CreatePartialTransaction(txStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var tx = &bhdmodels.Tx{}
+	err := json.Unmarshal([]byte(txStr), tx)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize tx request due to:" + err.Error()
+		return rValue
+	}
+	p, err := cryptopera.Service.NewPartialTx(tx)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	return partialTxResult(rValue, p)
+}
+
+// SignPartialTransaction adds the wallet signatures to the partially signed transaction
+func SignPartialTransaction(partialStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	p, err := cryptopera.ParsePartialTx([]byte(partialStr))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	signed, err := cryptopera.Service.SignPartial(p)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot sign partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	if signed == 0 {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "The wallet has no key for any unsigned input of the tx"
+		return rValue
+	}
+	return partialTxResult(rValue, p)
+}
+
+// CombinePartialTransactions merges the signatures of the two copies of the partially signed transaction
+func CombinePartialTransactions(partialStr string, otherStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	p, err := cryptopera.ParsePartialTx([]byte(partialStr))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	other, err := cryptopera.ParsePartialTx([]byte(otherStr))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	err = p.Combine(other)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot combine partially signed txs due to:" + err.Error()
+		return rValue
+	}
+	return partialTxResult(rValue, p)
+}
+
+// AppendToPartialTransaction adds the inputs and outputs of the tx (json of bhdmodels.Tx,
+// the inputs carry their hash type) to the partially signed transaction of the other
+// player, the signatures made with ANYONECANPAY, SINGLE or NONE stay valid
+func AppendToPartialTransaction(partialStr string, txStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	p, err := cryptopera.ParsePartialTx([]byte(partialStr))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	var tx = &bhdmodels.Tx{}
+	err = json.Unmarshal([]byte(txStr), tx)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize tx request due to:" + err.Error()
+		return rValue
+	}
+	other, err := cryptopera.Service.NewPartialTx(tx)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	err = p.Append(other)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot append to partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	return partialTxResult(rValue, p)
+}
+
+// FinalizePartialTransaction finalizes the partially signed transaction and
+// returns the signed tx (json of bhdmodels.Tx) ready for broadcasting
+func FinalizePartialTransaction(partialStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	p, err := cryptopera.ParsePartialTx([]byte(partialStr))
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	tx, err := cryptopera.Service.FinalizePartial(p)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot finalize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(tx)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize signed tx due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+func partialTxResult(rValue *ApiReturnStruct, p *cryptopera.PartialTx) *ApiReturnStruct {
+	content, err := p.Serialize()
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize partially signed tx due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
@@ -0,0 +1,152 @@
+package app
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera"
+	"encoding/json"
+	"strconv"
+)
+
+const (
+	EventRestoreProgress = "restoreProgress"
+	EventRestoreDone     = "restoreDone"
+
+	RestoreStepTransactions = "transactions"
+	RestoreStepUxtos        = "uxtos"
+	RestoreStepDone         = "done"
+)
+
+// RestoreStep is the next request the frontend sends to the BHD server, the
+// answer is passed to ContinueWalletRestore. Result is set when the type is done.
+type RestoreStep struct {
+	Type        string                    `json:"type"`
+	RequestType int                       `json:"requestType,omitempty"`
+	Request     interface{}               `json:"request,omitempty"`
+	Result      *cryptopera.RestoreResult `json:"result,omitempty"`
+}
+
+var (
+	// restorer is the running restore, created by StartWalletRestore
+	restorer *cryptopera.Restorer
+	// restorePending is the type of the request sent by the frontend, the
+	// answer passed to ContinueWalletRestore is decoded by it
+	restorePending string
+)
+
+// StartWalletRestore starts the rescan of the wallet history with the gap limit
+// (20 when empty) and returns the first request for the BHD server
+func StartWalletRestore(gapLimit string, playerId string, sessionToken string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var gapLimitInt = cryptopera.DefaultGapLimit
+	if gapLimit != "" {
+		var err error
+		gapLimitInt, err = strconv.Atoi(gapLimit)
+		if err != nil {
+			rValue.ErrorID = 3
+			rValue.ErrorDescription = "Bad gap limit parameter:" + err.Error()
+			return rValue
+		}
+	}
+	r, err := cryptopera.Service.NewRestorer(gapLimitInt)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot start restore due to:" + err.Error()
+		return rValue
+	}
+	r.PlayerId = playerId
+	r.SessionToken = sessionToken
+	r.OnProgress = func(progress *cryptopera.RestoreProgress) {
+		PushEvent(EventRestoreProgress, progress)
+	}
+	restorer = r
+	return restoreStep()
+}
+
+// ContinueWalletRestore passes the BHD server answer to the last request of
+// the restore and returns the next one
+func ContinueWalletRestore(responseStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if restorer == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Restore not started"
+		return rValue
+	}
+	var err error
+	switch restorePending {
+	case RestoreStepTransactions:
+		var rsp bhdmodels.ProvideTransactionsResponse
+		err = json.Unmarshal([]byte(responseStr), &rsp)
+		if err != nil {
+			rValue.ErrorID = 3
+			rValue.ErrorDescription = "Cannot deserialize transactions due to:" + err.Error()
+			return rValue
+		}
+		err = restorer.AddTransactions(&rsp)
+	case RestoreStepUxtos:
+		var rsp bhdmodels.ProvideUxtoResponse
+		err = json.Unmarshal([]byte(responseStr), &rsp)
+		if err != nil {
+			rValue.ErrorID = 3
+			rValue.ErrorDescription = "Cannot deserialize uxtos due to:" + err.Error()
+			return rValue
+		}
+		err = restorer.AddUxtos(&rsp)
+	default:
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "No restore request is pending"
+		return rValue
+	}
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot process response due to:" + err.Error()
+		return rValue
+	}
+	return restoreStep()
+}
+
+// restoreStep returns the next request of the restore, when it's done the
+// mempool is rebuilt from the restored uxtos
+func restoreStep() *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	var step = &RestoreStep{}
+	rq, err := restorer.TransactionsRequest()
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create request due to:" + err.Error()
+		return rValue
+	}
+	if rq != nil {
+		step.Type = RestoreStepTransactions
+		step.RequestType = bhdmodels.BhdGetTransactionsRequestType
+		step.Request = rq
+	} else if uxtoRq := restorer.UxtoRequest(); uxtoRq != nil {
+		step.Type = RestoreStepUxtos
+		step.RequestType = bhdmodels.BhdGetUxtosRequestType
+		step.Request = uxtoRq
+	} else {
+		step.Type = RestoreStepDone
+		step.Result = restorer.Result()
+		err = newMemPool(step.Result.Uxtos)
+		if err != nil {
+			rValue.ErrorID = 6
+			rValue.ErrorDescription = "Cannot create mempool due to:" + err.Error()
+			return rValue
+		}
+		restorer = nil
+		PushEvent(EventRestoreDone, step.Result)
+	}
+	restorePending = step.Type
+	content, err := json.Marshal(step)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize restore step due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"bhd/cryptopera"
+	"bhd/cryptopera/bip44"
+	"bhd/cryptopera/slip39"
+	"encoding/json"
+	"strconv"
+)
+
+// CreateSeedShares splits the wallet mnemonic into the SLIP-39 shares, groupsStr
+// is json list of the groups {"threshold":2,"count":3}, groupThreshold of them
+// (1 when empty) recover the wallet. The content is json list of the group shares.
+func CreateSeedShares(groupThreshold string, groupsStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var groupThresholdInt = 1
+	if groupThreshold != "" {
+		var err error
+		groupThresholdInt, err = strconv.Atoi(groupThreshold)
+		if err != nil {
+			rValue.ErrorID = 3
+			rValue.ErrorDescription = "Bad group threshold parameter:" + err.Error()
+			return rValue
+		}
+	}
+	var groups []slip39.Group
+	err := json.Unmarshal([]byte(groupsStr), &groups)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize groups due to:" + err.Error()
+		return rValue
+	}
+	shares, err := cryptopera.Service.NewSeedShares(groupThresholdInt, groups)
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = "Cannot create shares due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(shares)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize shares due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+// InitializeWalletFromShares creates the wallet from json list of the SLIP-39
+// shares, passphrase and language are the ones of the original wallet, the
+// language is required
+func InitializeWalletFromShares(sharesStr string, passphrase string, language string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if language == "" {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Bad language parameter: the language of the original mnemonic is required"
+		return rValue
+	}
+	var shares []string
+	err := json.Unmarshal([]byte(sharesStr), &shares)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize shares due to:" + err.Error()
+		return rValue
+	}
+	err = cryptopera.NewWalletFromShares(shares, passphrase, bip44.Language(language))
+	if err != nil {
+		rValue.ErrorID = 6
+		rValue.ErrorDescription = err.Error()
+	}
+	return rValue
+}
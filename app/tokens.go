@@ -0,0 +1,115 @@
+package app
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera"
+	"encoding/json"
+)
+
+// parseUxtos deserializes the json list of the uxtos as returned by the BHD server
+func parseUxtos(uxtosStr string) ([]*bhdmodels.Uxto, error) {
+	var uxtos = make([]*bhdmodels.Uxto, 0)
+	if uxtosStr == "" {
+		return uxtos, nil
+	}
+	err := json.Unmarshal([]byte(uxtosStr), &uxtos)
+	if err != nil {
+		return nil, err
+	}
+	return uxtos, nil
+}
+
+// GetTokenInventory returns the fungible token balances and the NFTs held by the uxtos
+func GetTokenInventory(uxtosStr string) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	uxtos, err := parseUxtos(uxtosStr)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize uxtos due to:" + err.Error()
+		return rValue
+	}
+	inventory, err := cryptopera.NewTokenInventory(uxtos)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot read tokens due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(inventory)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize token inventory due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+// buildTokenTx deserializes the request and the uxtos, builds the signed transaction
+// with the wallet and returns it serialized for broadcasting
+func buildTokenTx[T any](requestStr string, uxtosStr string, build func(*T, []*bhdmodels.Uxto) (*bhdmodels.Tx, error)) *ApiReturnStruct {
+	var rValue = &ApiReturnStruct{}
+	if cryptopera.Service == nil {
+		rValue.ErrorID = 2
+		rValue.ErrorDescription = "Wallet not initialized"
+		return rValue
+	}
+	var rq = new(T)
+	err := json.Unmarshal([]byte(requestStr), rq)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize token request due to:" + err.Error()
+		return rValue
+	}
+	uxtos, err := parseUxtos(uxtosStr)
+	if err != nil {
+		rValue.ErrorID = 3
+		rValue.ErrorDescription = "Cannot deserialize uxtos due to:" + err.Error()
+		return rValue
+	}
+	tx, err := build(rq, uxtos)
+	if err != nil {
+		rValue.ErrorID = 4
+		rValue.ErrorDescription = "Cannot create token tx due to:" + err.Error()
+		return rValue
+	}
+	content, err := json.Marshal(tx)
+	if err != nil {
+		rValue.ErrorID = 5
+		rValue.ErrorDescription = "Cannot serialize signed tx due to:" + err.Error()
+		return rValue
+	}
+	rValue.Content = string(content)
+	return rValue
+}
+
+// SendTokens builds and signs the token transfer, requestStr is json of
+// bhdmodels.TokenTransferRequest, the signed tx is returned for broadcasting
+func SendTokens(requestStr string, uxtosStr string) *ApiReturnStruct {
+	return buildTokenTx(requestStr, uxtosStr, func(rq *bhdmodels.TokenTransferRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+		return cryptopera.Service.BuildTokenTransfer(rq, uxtos)
+	})
+}
+
+// CreateTokenCategory builds and signs the genesis of the new token category,
+// requestStr is json of bhdmodels.TokenGenesisRequest
+func CreateTokenCategory(requestStr string, uxtosStr string) *ApiReturnStruct {
+	return buildTokenTx(requestStr, uxtosStr, func(rq *bhdmodels.TokenGenesisRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+		return cryptopera.Service.BuildTokenGenesis(rq, uxtos)
+	})
+}
+
+// MintTokens builds and signs the transaction minting the NFTs with the
+// minting NFT, requestStr is json of bhdmodels.TokenMintRequest
+func MintTokens(requestStr string, uxtosStr string) *ApiReturnStruct {
+	return buildTokenTx(requestStr, uxtosStr, func(rq *bhdmodels.TokenMintRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+		return cryptopera.Service.BuildTokenMint(rq, uxtos)
+	})
+}
+
+// UpdateTokenCommitment builds and signs the commitment change of the mutable
+// NFT, requestStr is json of bhdmodels.TokenCommitmentRequest
+func UpdateTokenCommitment(requestStr string, uxtosStr string) *ApiReturnStruct {
+	return buildTokenTx(requestStr, uxtosStr, func(rq *bhdmodels.TokenCommitmentRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+		return cryptopera.Service.BuildCommitmentUpdate(rq, uxtos)
+	})
+}
@@ -0,0 +1,177 @@
+// Package bcmr reads the Bitcoin Cash Metadata Registries, the JSON documents
+// describing the token categories (name, symbol, decimals, icons) and the NFT
+// types of the category keyed by the commitment.
+package bcmr
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"strings"
+	"time"
+)
+
+var (
+	ErrUnknownCategory = errors.New("token category is not in any registry")
+	ErrInvalidRegistry = errors.New("not a valid metadata registry")
+)
+
+// Version is the version of the registry
+type Version struct {
+	Major int `json:"major"`
+	Minor int `json:"minor"`
+	Patch int `json:"patch"`
+}
+
+// NftType is the metadata of the NFTs with the same commitment
+type NftType struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Fields      []string          `json:"fields,omitempty"`
+	Uris        map[string]string `json:"uris,omitempty"`
+	Extensions  json.RawMessage   `json:"extensions,omitempty"`
+}
+
+// NftParse tells how the commitment maps to the NFT type, only the sequential
+// NFTs are supported (no bytecode), their types are keyed by the hex commitment
+type NftParse struct {
+	Bytecode string              `json:"bytecode,omitempty"`
+	Types    map[string]*NftType `json:"types"`
+}
+
+// NftCategory describes the NFTs of the token category
+type NftCategory struct {
+	Description string          `json:"description,omitempty"`
+	Fields      json.RawMessage `json:"fields,omitempty"`
+	Parse       *NftParse       `json:"parse,omitempty"`
+}
+
+// TokenCategory is the token part of the identity snapshot
+type TokenCategory struct {
+	Category string       `json:"category"`
+	Symbol   string       `json:"symbol"`
+	Decimals int          `json:"decimals,omitempty"`
+	Nfts     *NftCategory `json:"nfts,omitempty"`
+}
+
+// IdentitySnapshot is the identity metadata valid from its timestamp
+type IdentitySnapshot struct {
+	Name        string            `json:"name"`
+	Description string            `json:"description,omitempty"`
+	Tags        []string          `json:"tags,omitempty"`
+	Status      string            `json:"status,omitempty"`
+	Uris        map[string]string `json:"uris,omitempty"`
+	Token       *TokenCategory    `json:"token,omitempty"`
+	Extensions  json.RawMessage   `json:"extensions,omitempty"`
+}
+
+// Registry is the parsed metadata registry. Identities are keyed by the
+// authbase and then by the ISO timestamp of the snapshot.
+type Registry struct {
+	Version        Version                                 `json:"version"`
+	LatestRevision string                                  `json:"latestRevision"`
+	Identities     map[string]map[string]*IdentitySnapshot `json:"identities"`
+	// snapshots of the token categories sorted from the newest
+	categories map[string][]*datedSnapshot
+}
+
+type datedSnapshot struct {
+	from     time.Time
+	snapshot *IdentitySnapshot
+}
+
+// ParseRegistry parses the registry json and indexes the token categories
+func ParseRegistry(content []byte) (*Registry, error) {
+	r := &Registry{}
+	err := json.Unmarshal(content, r)
+	if err != nil {
+		return nil, errors.New(ErrInvalidRegistry.Error() + ": " + err.Error())
+	}
+	if r.Identities == nil {
+		return nil, ErrInvalidRegistry
+	}
+	r.categories = make(map[string][]*datedSnapshot)
+	for _, history := range r.Identities {
+		for timestamp, snapshot := range history {
+			if snapshot == nil || snapshot.Token == nil || snapshot.Token.Category == "" {
+				continue
+			}
+			from, err := time.Parse(time.RFC3339, timestamp)
+			if err != nil {
+				return nil, errors.New(ErrInvalidRegistry.Error() + ": bad snapshot timestamp " + timestamp)
+			}
+			category := strings.ToLower(snapshot.Token.Category)
+			r.categories[category] = append(r.categories[category], &datedSnapshot{from: from, snapshot: snapshot})
+		}
+	}
+	for _, snapshots := range r.categories {
+		sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].from.After(snapshots[j].from) })
+	}
+	return r, nil
+}
+
+// Snapshot returns the identity snapshot of the token category valid at the time,
+// the snapshots with the future timestamp are not active yet
+func (r *Registry) Snapshot(category string, at time.Time) *IdentitySnapshot {
+	snapshots := r.categories[strings.ToLower(category)]
+	for _, dated := range snapshots {
+		if !dated.from.After(at) {
+			return dated.snapshot
+		}
+	}
+	return nil
+}
+
+// Categories returns the token categories described by the registry
+func (r *Registry) Categories() []string {
+	categories := make([]string, 0, len(r.categories))
+	for category := range r.categories {
+		categories = append(categories, category)
+	}
+	sort.Strings(categories)
+	return categories
+}
+
+// Metadata is the display data of the token, the Nft fields are set when
+// the registry knows the type of the commitment
+type Metadata struct {
+	Category       string            `json:"category"`
+	Name           string            `json:"name"`
+	Description    string            `json:"description,omitempty"`
+	Symbol         string            `json:"symbol"`
+	Decimals       int               `json:"decimals"`
+	Icon           string            `json:"icon,omitempty"`
+	Uris           map[string]string `json:"uris,omitempty"`
+	NftName        string            `json:"nftName,omitempty"`
+	NftDescription string            `json:"nftDescription,omitempty"`
+	NftIcon        string            `json:"nftIcon,omitempty"`
+}
+
+// NewMetadata creates the display data of the snapshot and the NFT commitment
+// (hex), commitment is ignored when the category has no matching NFT type
+func NewMetadata(snapshot *IdentitySnapshot, commitment string) *Metadata {
+	m := &Metadata{
+		Name:        snapshot.Name,
+		Description: snapshot.Description,
+		Uris:        snapshot.Uris,
+		Icon:        snapshot.Uris["icon"],
+	}
+	token := snapshot.Token
+	if token == nil {
+		return m
+	}
+	m.Category = strings.ToLower(token.Category)
+	m.Symbol = token.Symbol
+	m.Decimals = token.Decimals
+	if token.Nfts == nil || token.Nfts.Parse == nil || token.Nfts.Parse.Bytecode != "" {
+		return m
+	}
+	nft, ok := token.Nfts.Parse.Types[strings.ToLower(commitment)]
+	if !ok || nft == nil {
+		return m
+	}
+	m.NftName = nft.Name
+	m.NftDescription = nft.Description
+	m.NftIcon = nft.Uris["icon"]
+	return m
+}
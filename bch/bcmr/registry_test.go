@@ -0,0 +1,102 @@
+package bcmr
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	itemsCategory = "89cad9e3e34280eb1e8bc420542c00a7fcc01002b663dbf7f38bceddf80e680c"
+	goldCategory  = "b1a2c3d4e5f60718293a4b5c6d7e8f90a1b2c3d4e5f60718293a4b5c6d7e8f90"
+)
+
+func readRegistry(t *testing.T) []byte {
+	t.Helper()
+	content, err := os.ReadFile(filepath.Join("testdata", "registry.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return content
+}
+
+func TestSnapshotSelection(t *testing.T) {
+	r, err := ParseRegistry(readRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if categories := r.Categories(); len(categories) != 2 || categories[0] != itemsCategory || categories[1] != goldCategory {
+		t.Errorf("unexpected categories %v", categories)
+	}
+	for _, test := range []struct {
+		at     string
+		symbol string
+	}{
+		{"2022-12-31T23:59:59Z", ""},
+		{"2023-01-01T00:00:00Z", "OLD"},
+		{"2024-05-31T23:59:59Z", "OLD"},
+		{"2024-06-01T00:00:00Z", "ITEM"},
+		{"2998-12-31T00:00:00Z", "ITEM"},
+		{"2999-01-01T00:00:00Z", "FUT"},
+	} {
+		at, _ := time.Parse(time.RFC3339, test.at)
+		snapshot := r.Snapshot(itemsCategory, at)
+		var symbol string
+		if snapshot != nil {
+			symbol = snapshot.Token.Symbol
+		}
+		if symbol != test.symbol {
+			t.Errorf("at %s expected the snapshot %q, got %q", test.at, test.symbol, symbol)
+		}
+	}
+	// the category is matched case insensitive
+	if r.Snapshot("89CAD9E3E34280EB1E8BC420542C00A7FCC01002B663DBF7F38BCEDDF80E680C", time.Now()) == nil {
+		t.Error("upper case category not found")
+	}
+}
+
+func TestNftTypeByCommitment(t *testing.T) {
+	r, err := ParseRegistry(readRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	snapshot := r.Snapshot(itemsCategory, time.Now())
+	for _, test := range []struct {
+		commitment string
+		name       string
+		icon       string
+	}{
+		{"01", "Sword", "ipfs://sword"},
+		{"02", "Shield", "ipfs://shield"},
+		{"", "Empty commitment", ""},
+		{"03", "", ""},
+	} {
+		m := NewMetadata(snapshot, test.commitment)
+		if m.NftName != test.name || m.NftIcon != test.icon {
+			t.Errorf("commitment %q: expected %q %q, got %q %q", test.commitment, test.name, test.icon, m.NftName, m.NftIcon)
+		}
+		if m.Category != itemsCategory || m.Symbol != "ITEM" || m.Decimals != 2 || m.Icon != "ipfs://items-v2" {
+			t.Errorf("commitment %q: unexpected token metadata %+v", test.commitment, m)
+		}
+	}
+	// the types of the bytecode parsed NFTs are not keyed by the commitment
+	if m := NewMetadata(r.Snapshot(goldCategory, time.Now()), "01"); m.NftName != "" || m.Decimals != 8 {
+		t.Errorf("unexpected metadata of the parsed NFT %+v", m)
+	}
+}
+
+func TestParseRegistryErrors(t *testing.T) {
+	for _, content := range []string{
+		``,
+		`[]`,
+		`{"version": {"major": 1}}`,
+		`{"identities": {"aa": {"yesterday": {"name": "x", "token": {"category": "aa", "symbol": "X"}}}}}`,
+	} {
+		// the parse errors are prefixed with ErrInvalidRegistry
+		if _, err := ParseRegistry([]byte(content)); err == nil || !strings.HasPrefix(err.Error(), ErrInvalidRegistry.Error()) {
+			t.Errorf("%q: expected invalid registry, got %v", content, err)
+		}
+	}
+}
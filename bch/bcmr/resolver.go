@@ -0,0 +1,265 @@
+package bcmr
+
+import (
+	"bhd/bch/msg"
+	"bhd/log"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	indexFileName = "index.json"
+	// DefaultMaxAge is how long the cached registry is used before it's fetched again
+	DefaultMaxAge = 24 * time.Hour
+	// MaxRegistrySize is the largest registry we accept
+	MaxRegistrySize = 16 * 1024 * 1024
+	// DefaultFetchTimeout is the timeout of the HTTP fetcher
+	DefaultFetchTimeout = 30 * time.Second
+)
+
+var (
+	ErrHashMismatch = errors.New("registry content doesn't match the published hash")
+)
+
+// Fetcher downloads the registry from the uri, it's injected so the resolver
+// can run offline or use the app transport
+type Fetcher interface {
+	Fetch(uri string) ([]byte, error)
+}
+
+// HTTPFetcher downloads the registries over https, ipfs:// uris are fetched
+// through the IpfsGateway
+type HTTPFetcher struct {
+	Client      *http.Client
+	IpfsGateway string
+}
+
+// NewHTTPFetcher creates the fetcher with the default timeout and gateway
+func NewHTTPFetcher() *HTTPFetcher {
+	return &HTTPFetcher{
+		Client:      &http.Client{Timeout: DefaultFetchTimeout},
+		IpfsGateway: "https://ipfs.io/ipfs/",
+	}
+}
+
+func (f *HTTPFetcher) Fetch(uri string) ([]byte, error) {
+	if strings.HasPrefix(uri, "ipfs://") {
+		uri = f.IpfsGateway + strings.TrimPrefix(uri, "ipfs://")
+	} else if !strings.Contains(uri, "://") {
+		// the registry uris published on chain usually omit the scheme
+		uri = "https://" + uri
+	}
+	rsp, err := f.Client.Get(uri)
+	if err != nil {
+		return nil, err
+	}
+	defer rsp.Body.Close()
+	if rsp.StatusCode != http.StatusOK {
+		return nil, errors.New("registry fetch failed with status " + rsp.Status)
+	}
+	content, err := io.ReadAll(io.LimitReader(rsp.Body, MaxRegistrySize+1))
+	if err != nil {
+		return nil, err
+	}
+	if len(content) > MaxRegistrySize {
+		return nil, errors.New("registry is too large")
+	}
+	return content, nil
+}
+
+// cacheEntry describes one cached registry
+type cacheEntry struct {
+	Source    string `json:"source"`
+	File      string `json:"file"`
+	FetchedAt int64  `json:"fetchedAt"`
+}
+
+// Resolver resolves the token categories with the loaded registries, the fetched
+// registries are cached in the BcmrFolder. It's safe for concurrent use.
+type Resolver struct {
+	sync.Mutex
+	dir        string
+	fetcher    Fetcher
+	maxAge     time.Duration
+	entries    map[string]*cacheEntry
+	registries map[string]*Registry
+	// order of the sources, the later registries override the earlier ones
+	sources []string
+}
+
+// NewResolver opens the registry cache under the base directory and loads the
+// cached registries, fetcher can be nil when only the files are used
+func NewResolver(baseDir string, fetcher Fetcher, maxAge time.Duration) (*Resolver, error) {
+	dir := filepath.Join(baseDir, msg.BcmrFolder)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	r := &Resolver{
+		dir:        dir,
+		fetcher:    fetcher,
+		maxAge:     maxAge,
+		entries:    make(map[string]*cacheEntry),
+		registries: make(map[string]*Registry),
+	}
+	r.loadCache()
+	return r, nil
+}
+
+// loadCache reads the cached registries, broken ones are dropped
+func (r *Resolver) loadCache() {
+	content, err := os.ReadFile(filepath.Join(r.dir, indexFileName))
+	if err != nil {
+		return
+	}
+	var entries []*cacheEntry
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		log.Warn("Metadata registry cache index is corrupted, starting with empty cache:", err.Error())
+		return
+	}
+	for _, entry := range entries {
+		content, err := os.ReadFile(filepath.Join(r.dir, entry.File))
+		if err != nil {
+			continue
+		}
+		registry, err := ParseRegistry(content)
+		if err != nil {
+			continue
+		}
+		r.entries[entry.Source] = entry
+		r.addRegistry(entry.Source, registry)
+	}
+}
+
+// saveCache writes the index to the temporary file first so crash
+// in the middle never leaves us with half written index
+func (r *Resolver) saveCache() error {
+	entries := make([]*cacheEntry, 0, len(r.entries))
+	for _, source := range r.sources {
+		if entry, ok := r.entries[source]; ok {
+			entries = append(entries, entry)
+		}
+	}
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(r.dir, indexFileName)
+	err = os.WriteFile(path+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (r *Resolver) addRegistry(source string, registry *Registry) {
+	if _, ok := r.registries[source]; !ok {
+		r.sources = append(r.sources, source)
+	}
+	r.registries[source] = registry
+}
+
+// AddRegistry parses the registry content and adds it under the source name,
+// it's not cached, use it for the registries bundled with the app
+func (r *Resolver) AddRegistry(source string, content []byte) error {
+	registry, err := ParseRegistry(content)
+	if err != nil {
+		return err
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.addRegistry(source, registry)
+	return nil
+}
+
+// LoadFile adds the registry from the local file
+func (r *Resolver) LoadFile(path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return r.AddRegistry(path, content)
+}
+
+// Load adds the registry from the uri, the cached copy is used while it's younger
+// than maxAge. contentHash is the hex sha256 of the registry as published on chain,
+// the check is skipped when it's empty. When the fetch fails the stale copy is kept.
+func (r *Resolver) Load(uri string, contentHash string) error {
+	r.Lock()
+	entry, cached := r.entries[uri]
+	r.Unlock()
+	if cached && (r.maxAge == 0 || time.Since(time.Unix(entry.FetchedAt, 0)) < r.maxAge) {
+		return nil
+	}
+	if r.fetcher == nil {
+		return errors.New("no fetcher to load the registry " + uri)
+	}
+	content, err := r.fetcher.Fetch(uri)
+	if err == nil && contentHash != "" {
+		err = checkHash(content, contentHash)
+	}
+	var registry *Registry
+	if err == nil {
+		registry, err = ParseRegistry(content)
+	}
+	if err != nil {
+		if cached {
+			log.Warn("Cannot refresh metadata registry", uri, "using the cached copy:", err.Error())
+			return nil
+		}
+		return err
+	}
+	sum := sha256.Sum256([]byte(uri))
+	entry = &cacheEntry{
+		Source:    uri,
+		File:      hex.EncodeToString(sum[:]) + ".json",
+		FetchedAt: time.Now().Unix(),
+	}
+	r.Lock()
+	defer r.Unlock()
+	r.addRegistry(uri, registry)
+	err = os.WriteFile(filepath.Join(r.dir, entry.File), content, 0600)
+	if err != nil {
+		return err
+	}
+	r.entries[uri] = entry
+	return r.saveCache()
+}
+
+func checkHash(content []byte, contentHash string) error {
+	expected, err := hex.DecodeString(contentHash)
+	if err != nil {
+		return err
+	}
+	sum := sha256.Sum256(content)
+	if !bytes.Equal(sum[:], expected) {
+		return ErrHashMismatch
+	}
+	return nil
+}
+
+// Resolve returns the display metadata of the token category and the NFT commitment
+// (hex), the latest loaded registry wins
+func (r *Resolver) Resolve(category string, commitment string) (*Metadata, error) {
+	r.Lock()
+	defer r.Unlock()
+	now := time.Now()
+	for i := len(r.sources) - 1; i >= 0; i-- {
+		snapshot := r.registries[r.sources[i]].Snapshot(category, now)
+		if snapshot != nil {
+			return NewMetadata(snapshot, commitment), nil
+		}
+	}
+	return nil, ErrUnknownCategory
+}
@@ -0,0 +1,155 @@
+package bcmr
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+)
+
+const registryUri = "example.com/.well-known/bitcoin-cash-metadata-registry.json"
+
+// stubFetcher serves the registries from memory, err fails every fetch
+type stubFetcher struct {
+	content map[string][]byte
+	err     error
+	fetches int
+}
+
+func (f *stubFetcher) Fetch(uri string) ([]byte, error) {
+	f.fetches++
+	if f.err != nil {
+		return nil, f.err
+	}
+	content, ok := f.content[uri]
+	if !ok {
+		return nil, errors.New("not found " + uri)
+	}
+	return content, nil
+}
+
+func registryHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+func TestResolverLoad(t *testing.T) {
+	content := readRegistry(t)
+	fetcher := &stubFetcher{content: map[string][]byte{registryUri: content}}
+	r, err := NewResolver(t.TempDir(), fetcher, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Resolve(itemsCategory, ""); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("expected ErrUnknownCategory before the load, got %v", err)
+	}
+	err = r.Load(registryUri, registryHash(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	m, err := r.Resolve(itemsCategory, "01")
+	if err != nil || m.Symbol != "ITEM" || m.NftName != "Sword" {
+		t.Fatalf("unexpected metadata %+v (%v)", m, err)
+	}
+	// the fresh copy is not fetched again
+	err = r.Load(registryUri, registryHash(content))
+	if err != nil || fetcher.fetches != 1 {
+		t.Errorf("expected 1 fetch of the fresh registry, got %d (%v)", fetcher.fetches, err)
+	}
+}
+
+func TestResolverHashMismatch(t *testing.T) {
+	content := readRegistry(t)
+	fetcher := &stubFetcher{content: map[string][]byte{registryUri: content}}
+	dir := t.TempDir()
+	r, err := NewResolver(dir, fetcher, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Load(registryUri, registryHash(append([]byte(" "), content...)))
+	if !errors.Is(err, ErrHashMismatch) {
+		t.Fatalf("expected ErrHashMismatch, got %v", err)
+	}
+	if _, err := r.Resolve(itemsCategory, ""); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("the registry with the wrong hash was added: %v", err)
+	}
+	// nothing was cached
+	cached, err := NewResolver(dir, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.Resolve(itemsCategory, ""); !errors.Is(err, ErrUnknownCategory) {
+		t.Errorf("the registry with the wrong hash was cached: %v", err)
+	}
+}
+
+func TestResolverStaleCache(t *testing.T) {
+	content := readRegistry(t)
+	fetcher := &stubFetcher{content: map[string][]byte{registryUri: content}}
+	dir := t.TempDir()
+	r, err := NewResolver(dir, fetcher, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.Load(registryUri, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the new resolver reads the cache and works offline
+	offline, err := NewResolver(dir, nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := offline.Load(registryUri, ""); err != nil {
+		t.Errorf("cached registry not used: %v", err)
+	}
+	if m, err := offline.Resolve(itemsCategory, "02"); err != nil || m.NftName != "Shield" {
+		t.Errorf("unexpected cached metadata %+v (%v)", m, err)
+	}
+
+	// the stale copy is refreshed, when the fetch or the hash check fails it's kept
+	failing := &stubFetcher{err: errors.New("network is down")}
+	stale, err := NewResolver(dir, failing, time.Nanosecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := stale.Load(registryUri, ""); err != nil || failing.fetches != 1 {
+		t.Errorf("expected the refresh to fall back to the stale copy, got %d fetches (%v)", failing.fetches, err)
+	}
+	if err := stale.Load(registryUri, registryHash(nil)); err != nil {
+		t.Errorf("expected the stale copy with the failing refresh, got %v", err)
+	}
+	if m, err := stale.Resolve(itemsCategory, "01"); err != nil || m.NftName != "Sword" {
+		t.Errorf("unexpected stale metadata %+v (%v)", m, err)
+	}
+
+	// without the cache the fetch error is returned
+	if err := stale.Load("other.example.com/registry.json", ""); err == nil {
+		t.Error("failed fetch of the uncached registry succeeded")
+	}
+}
+
+func TestResolverOrder(t *testing.T) {
+	r, err := NewResolver(t.TempDir(), nil, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = r.AddRegistry("bundled", readRegistry(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	override := `{"identities": {"x": {"2020-01-01T00:00:00Z": {"name": "Renamed", "token": {"category": "` + goldCategory + `", "symbol": "G"}}}}}`
+	err = r.AddRegistry("override", []byte(override))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the later registry wins, the other categories still come from the first one
+	if m, err := r.Resolve(goldCategory, ""); err != nil || m.Symbol != "G" {
+		t.Errorf("expected the overriding registry, got %+v (%v)", m, err)
+	}
+	if m, err := r.Resolve(itemsCategory, ""); err != nil || m.Symbol != "ITEM" {
+		t.Errorf("expected the bundled registry, got %+v (%v)", m, err)
+	}
+}
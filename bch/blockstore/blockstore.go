@@ -0,0 +1,289 @@
+// Package blockstore keeps the raw blocks we have downloaded on the disk, so the
+// mobile client doesn't have to download them again after restart. Each block is
+// in its own flat file and the index maps hash and height to the file.
+package blockstore
+
+import (
+	"bhd/bch/msg"
+	"bhd/log"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	indexFileName = "index.json"
+	blockFileExt  = ".blk"
+	// DefaultMaxSize is the default disk budget of the store
+	DefaultMaxSize int64 = 256 * 1024 * 1024
+	// DefaultMaxAge is the default time blocks are kept
+	DefaultMaxAge = 30 * 24 * time.Hour
+)
+
+var (
+	ErrBlockNotFound = errors.New("block is not in the local store")
+)
+
+// IndexEntry describes one stored block
+type IndexEntry struct {
+	Hash     string `json:"hash"`
+	Height   int32  `json:"height"`
+	Size     int64  `json:"size"`
+	StoredAt int64  `json:"storedAt"`
+}
+
+// Store is the local block cache, it's safe for concurrent use
+type Store struct {
+	sync.Mutex
+	dir       string
+	maxSize   int64
+	maxAge    time.Duration
+	entries   map[string]*IndexEntry
+	byHeight  map[int32]string
+	totalSize int64
+}
+
+// NewStore opens the store in the BlockFolder under the base directory, maxSize
+// is the disk budget in bytes and maxAge how long blocks are kept, zero values
+// disable the limit
+func NewStore(baseDir string, maxSize int64, maxAge time.Duration) (*Store, error) {
+	dir := filepath.Join(baseDir, msg.BlockFolder)
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, err
+	}
+	s := &Store{
+		dir:      dir,
+		maxSize:  maxSize,
+		maxAge:   maxAge,
+		entries:  make(map[string]*IndexEntry),
+		byHeight: make(map[int32]string),
+	}
+	err = s.loadIndex()
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// loadIndex reads the index, entries without the block file are dropped
+func (s *Store) loadIndex() error {
+	content, err := os.ReadFile(filepath.Join(s.dir, indexFileName))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var entries []*IndexEntry
+	err = json.Unmarshal(content, &entries)
+	if err != nil {
+		log.Warn("Block store index is corrupted, starting with empty store:", err.Error())
+		return nil
+	}
+	for _, entry := range entries {
+		if _, err := os.Stat(s.blockPath(entry.Hash)); err != nil {
+			continue
+		}
+		s.addEntry(entry)
+	}
+	return nil
+}
+
+// saveIndex writes the index to the temporary file first so crash
+// in the middle never leaves us with half written index
+func (s *Store) saveIndex() error {
+	entries := make([]*IndexEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Height < entries[j].Height })
+	content, err := json.Marshal(entries)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(s.dir, indexFileName)
+	err = os.WriteFile(path+".tmp", content, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func (s *Store) blockPath(hash string) string {
+	return filepath.Join(s.dir, hash+blockFileExt)
+}
+
+func (s *Store) addEntry(entry *IndexEntry) {
+	s.entries[entry.Hash] = entry
+	s.byHeight[entry.Height] = entry.Hash
+	s.totalSize += entry.Size
+}
+
+func (s *Store) removeEntry(hash string) {
+	entry, ok := s.entries[hash]
+	if !ok {
+		return
+	}
+	delete(s.entries, hash)
+	if s.byHeight[entry.Height] == hash {
+		delete(s.byHeight, entry.Height)
+	}
+	s.totalSize -= entry.Size
+	err := os.Remove(s.blockPath(hash))
+	if err != nil && !os.IsNotExist(err) {
+		log.Warn("Failed to remove block file", hash, err.Error())
+	}
+}
+
+// Put stores the block at the height, it does nothing when
+// caching blocks locally is switched off
+func (s *Store) Put(block *msg.BlockMsg, height int32) error {
+	if !msg.CacheBlocksLocally {
+		return nil
+	}
+	return s.PutRaw(block.BlockHeader.Hash(), height, block.Pack())
+}
+
+// PutRaw stores the serialized block as it was received from the peer,
+// the block header must hash to the hash
+func (s *Store) PutRaw(hash msg.Hash, height int32, raw []byte) error {
+	if !msg.CacheBlocksLocally {
+		return nil
+	}
+	if len(raw) < msg.BlockHeaderSize || !hash.IsEqual(msg.DoubleHashB(raw[:msg.BlockHeaderSize])) {
+		return errors.New("raw block does not match the hash " + hash.ToString())
+	}
+	s.Lock()
+	defer s.Unlock()
+	key := hash.ToString()
+	if _, ok := s.entries[key]; ok {
+		return nil
+	}
+	err := os.WriteFile(s.blockPath(key), raw, 0600)
+	if err != nil {
+		return err
+	}
+	// block on the same height was reorged out
+	if old, ok := s.byHeight[height]; ok {
+		s.removeEntry(old)
+	}
+	s.addEntry(&IndexEntry{
+		Hash:     key,
+		Height:   height,
+		Size:     int64(len(raw)),
+		StoredAt: time.Now().Unix(),
+	})
+	// the new block stays even when it alone is over the budget
+	s.prune(key)
+	return s.saveIndex()
+}
+
+// Has returns true if the block is in the store
+func (s *Store) Has(hash msg.Hash) bool {
+	s.Lock()
+	defer s.Unlock()
+	_, ok := s.entries[hash.ToString()]
+	return ok
+}
+
+// GetByHash loads and decodes the stored block
+func (s *Store) GetByHash(hash msg.Hash) (*msg.BlockMsg, error) {
+	raw, err := s.GetRaw(hash)
+	if err != nil {
+		return nil, err
+	}
+	block, err := msg.DecodeBlockMsg(bytes.NewReader(raw))
+	if err != nil {
+		return nil, err
+	}
+	if !block.BlockHeader.Hash().IsEqual(hash) {
+		s.Remove(hash)
+		return nil, errors.New("stored block " + hash.ToString() + " is corrupted")
+	}
+	return block, nil
+}
+
+// GetByHeight loads the stored block at the height
+func (s *Store) GetByHeight(height int32) (*msg.BlockMsg, error) {
+	s.Lock()
+	key, ok := s.byHeight[height]
+	s.Unlock()
+	if !ok {
+		return nil, ErrBlockNotFound
+	}
+	hash, err := msg.NewHashFromString(key)
+	if err != nil {
+		return nil, err
+	}
+	return s.GetByHash(hash)
+}
+
+// GetRaw returns the serialized block
+func (s *Store) GetRaw(hash msg.Hash) ([]byte, error) {
+	s.Lock()
+	defer s.Unlock()
+	key := hash.ToString()
+	if _, ok := s.entries[key]; !ok {
+		return nil, ErrBlockNotFound
+	}
+	return os.ReadFile(s.blockPath(key))
+}
+
+// Remove deletes the block from the store
+func (s *Store) Remove(hash msg.Hash) error {
+	s.Lock()
+	defer s.Unlock()
+	s.removeEntry(hash.ToString())
+	return s.saveIndex()
+}
+
+// Size returns the total size of the stored blocks
+func (s *Store) Size() int64 {
+	s.Lock()
+	defer s.Unlock()
+	return s.totalSize
+}
+
+// Prune removes blocks older than max age and then the lowest
+// blocks until the store fits into the size budget
+func (s *Store) Prune() error {
+	s.Lock()
+	defer s.Unlock()
+	s.prune("")
+	return s.saveIndex()
+}
+
+// prune removes the blocks over the limits except the kept one
+func (s *Store) prune(keep string) {
+	if s.maxAge > 0 {
+		limit := time.Now().Add(-s.maxAge).Unix()
+		for hash, entry := range s.entries {
+			if entry.StoredAt < limit && hash != keep {
+				s.removeEntry(hash)
+			}
+		}
+	}
+	if s.maxSize <= 0 || s.totalSize <= s.maxSize {
+		return
+	}
+	entries := make([]*IndexEntry, 0, len(s.entries))
+	for _, entry := range s.entries {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Height < entries[j].Height })
+	for _, entry := range entries {
+		if s.totalSize <= s.maxSize {
+			break
+		}
+		if entry.Hash != keep {
+			s.removeEntry(entry.Hash)
+		}
+	}
+	log.Debug("Block store pruned to", s.totalSize, "bytes")
+}
@@ -0,0 +1,160 @@
+package blockstore
+
+import (
+	"bhd/bch/msg"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readBlock reads the block sample shared with the msg package tests
+func readBlock(t *testing.T, name string) (msg.Hash, []byte) {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "msg", "testdata", name+".hex"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return msg.DoubleHashB(raw[:msg.BlockHeaderSize]), raw
+}
+
+func TestStorePutGet(t *testing.T) {
+	dir := t.TempDir()
+	s, err := NewStore(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash0, raw0 := readBlock(t, "block0")
+	hash1, raw1 := readBlock(t, "block1")
+	for height, raw := range [][]byte{raw0, raw1} {
+		if err := s.PutRaw(msg.DoubleHashB(raw[:msg.BlockHeaderSize]), int32(height), raw); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if s.Size() != int64(len(raw0)+len(raw1)) {
+		t.Errorf("unexpected store size %d", s.Size())
+	}
+
+	// the index survives the restart
+	s, err = NewStore(dir, 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := s.GetByHeight(1)
+	if err != nil || !block.BlockHeader.Hash().IsEqual(hash1) || !bytes.Equal(block.Pack(), raw1) {
+		t.Fatalf("unexpected block at height 1 (%v)", err)
+	}
+	if raw, err := s.GetRaw(hash0); err != nil || !bytes.Equal(raw, raw0) {
+		t.Fatalf("unexpected genesis block (%v)", err)
+	}
+	if err := s.Remove(hash0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetByHash(hash0); !errors.Is(err, ErrBlockNotFound) {
+		t.Errorf("expected ErrBlockNotFound, got %v", err)
+	}
+	if _, err := os.Stat(s.blockPath(hash0.ToString())); !os.IsNotExist(err) {
+		t.Error("the removed block file is still there")
+	}
+}
+
+func TestStorePutRawHashMismatch(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash0, _ := readBlock(t, "block0")
+	hash1, raw1 := readBlock(t, "block1")
+	if err := s.PutRaw(hash0, 0, raw1); err == nil {
+		t.Error("the block stored under the other hash")
+	}
+	if err := s.PutRaw(hash1, 1, raw1[:msg.BlockHeaderSize-1]); err == nil {
+		t.Error("the truncated block stored")
+	}
+	if s.Has(hash0) || s.Has(hash1) || s.Size() != 0 {
+		t.Error("the rejected block is in the store")
+	}
+}
+
+func TestStorePrune(t *testing.T) {
+	hash0, raw0 := readBlock(t, "block0")
+	hash1, raw1 := readBlock(t, "block1")
+	hash100000, raw100000 := readBlock(t, "block100000")
+
+	// the budget fits the single block only, the new block is kept even
+	// when it's lower than the stored ones
+	s, err := NewStore(t.TempDir(), int64(len(raw100000)), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRaw(hash100000, 100000, raw100000); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRaw(hash1, 1, raw1); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has(hash1) || s.Has(hash100000) {
+		t.Error("the new block pruned instead of the stored one")
+	}
+	// the block over the budget alone is kept too
+	s.maxSize = 1
+	if err := s.PutRaw(hash0, 0, raw0); err != nil {
+		t.Fatal(err)
+	}
+	if !s.Has(hash0) || s.Has(hash1) || s.Size() != int64(len(raw0)) {
+		t.Error("the new block over the budget pruned")
+	}
+	// the explicit prune keeps nothing over the budget
+	if err := s.Prune(); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(hash0) || s.Size() != 0 {
+		t.Error("the block over the budget not pruned")
+	}
+
+	// the old blocks expire
+	s, err = NewStore(t.TempDir(), 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRaw(hash0, 0, raw0); err != nil {
+		t.Fatal(err)
+	}
+	s.entries[hash0.ToString()].StoredAt -= 2 * 3600
+	if err := s.PutRaw(hash1, 1, raw1); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(hash0) || !s.Has(hash1) {
+		t.Error("the expired block not pruned")
+	}
+}
+
+func TestStoreReorg(t *testing.T) {
+	s, err := NewStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash0, raw0 := readBlock(t, "block0")
+	hash1, raw1 := readBlock(t, "block1")
+	if err := s.PutRaw(hash0, 1, raw0); err != nil {
+		t.Fatal(err)
+	}
+	// the block on the same height replaces the reorged one
+	if err := s.PutRaw(hash1, 1, raw1); err != nil {
+		t.Fatal(err)
+	}
+	if s.Has(hash0) || s.Size() != int64(len(raw1)) {
+		t.Error("the reorged block is still stored")
+	}
+	if block, err := s.GetByHeight(1); err != nil || !block.BlockHeader.Hash().IsEqual(hash1) {
+		t.Errorf("unexpected block at height 1 (%v)", err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package bloom implements the BIP37 bloom filter, light client loads the filter
+// on the connection and the peer sends only transactions that match it
+package bloom
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"math"
+	"sync"
+
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	ln2Squared = math.Ln2 * math.Ln2
+	// hashSeedMultiplier is the constant from BIP37 used to derive the seed of each hash function
+	hashSeedMultiplier = 0xfba4c795
+)
+
+// Filter is BIP37 bloom filter, it's safe to use it from multiple go routines
+type Filter struct {
+	sync.Mutex
+	data      []byte
+	hashFuncs uint32
+	tweak     uint32
+	flags     uint8
+}
+
+// NewFilter creates the filter sized for the number of elements and false positive rate,
+// lower rate means less unrelated transactions but also less privacy. The flags are
+// one of the msg.BloomFilterUpdate* values.
+func NewFilter(elements uint32, tweak uint32, fpRate float64, flags uint8) *Filter {
+	if fpRate > 1.0 {
+		fpRate = 1.0
+	}
+	if fpRate < 1e-9 {
+		fpRate = 1e-9
+	}
+	if elements == 0 {
+		elements = 1
+	}
+	dataLen := uint32(-1 * float64(elements) * math.Log(fpRate) / ln2Squared / 8)
+	if dataLen > msg.MaxFilterLoadFilterSize {
+		dataLen = msg.MaxFilterLoadFilterSize
+	}
+	if dataLen == 0 {
+		dataLen = 1
+	}
+	hashFuncs := uint32(float64(dataLen*8) / float64(elements) * math.Ln2)
+	if hashFuncs > msg.MaxFilterLoadHashFuncs {
+		hashFuncs = msg.MaxFilterLoadHashFuncs
+	}
+	if hashFuncs == 0 {
+		hashFuncs = 1
+	}
+	return &Filter{
+		data:      make([]byte, dataLen),
+		hashFuncs: hashFuncs,
+		tweak:     tweak,
+		flags:     flags,
+	}
+}
+
+// LoadFilter creates the filter from the filterload message
+func LoadFilter(m *msg.FilterLoadMsg) *Filter {
+	data := make([]byte, len(m.Filter))
+	copy(data, m.Filter)
+	return &Filter{
+		data:      data,
+		hashFuncs: m.HashFuncs,
+		tweak:     m.Tweak,
+		flags:     m.Flags,
+	}
+}
+
+// hash returns the bit index for the hash function number
+func (f *Filter) hash(hashNum uint32, data []byte) uint32 {
+	return murmurHash3(hashNum*hashSeedMultiplier+f.tweak, data) % (uint32(len(f.data)) << 3)
+}
+
+func (f *Filter) add(data []byte) {
+	if len(f.data) == 0 {
+		return
+	}
+	for i := uint32(0); i < f.hashFuncs; i++ {
+		idx := f.hash(i, data)
+		f.data[idx>>3] |= 1 << (idx & 7)
+	}
+}
+
+func (f *Filter) matches(data []byte) bool {
+	if len(f.data) == 0 {
+		return false
+	}
+	for i := uint32(0); i < f.hashFuncs; i++ {
+		idx := f.hash(i, data)
+		if f.data[idx>>3]&(1<<(idx&7)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add inserts the data into the filter
+func (f *Filter) Add(data []byte) {
+	f.Lock()
+	defer f.Unlock()
+	f.add(data)
+}
+
+// Matches returns true if the data is (probably) in the filter
+func (f *Filter) Matches(data []byte) bool {
+	f.Lock()
+	defer f.Unlock()
+	return f.matches(data)
+}
+
+// AddHash inserts the transaction hash into the filter
+func (f *Filter) AddHash(hash msg.Hash) {
+	f.Add(hash)
+}
+
+// outPointBytes is the serialized outpoint, hash followed by index
+func outPointBytes(hash msg.Hash, index uint32) []byte {
+	return append(append([]byte{}, hash...), utils.UInt32ToByte(index)...)
+}
+
+// AddOutPoint inserts the outpoint, peer will match transactions spending it
+func (f *Filter) AddOutPoint(hash msg.Hash, index uint32) {
+	f.Add(outPointBytes(hash, index))
+}
+
+// MatchesOutPoint returns true if the outpoint is in the filter
+func (f *Filter) MatchesOutPoint(hash msg.Hash, index uint32) bool {
+	return f.Matches(outPointBytes(hash, index))
+}
+
+// AddAddress inserts the hash160 of the cash address, that's the
+// data pushed by p2pkh and p2sh locking scripts
+func (f *Filter) AddAddress(address string, params *chaincfg.Params) error {
+	addr, err := bchutil.DecodeAddress(address, params)
+	if err != nil {
+		return err
+	}
+	f.Add(addr.ScriptAddress())
+	return nil
+}
+
+// MatchTxAndUpdate checks if the transaction is relevant to the filter, in the same way
+// the full node does it. When output matches and the update flag allows it the outpoint
+// is added, so we also match the transaction that spends it later.
+func (f *Filter) MatchTxAndUpdate(tx *msg.Tx) bool {
+	f.Lock()
+	defer f.Unlock()
+	hash := tx.TxHash
+	if hash == nil || hash.IsEmpty() {
+		hash = tx.GetHash()
+	}
+	matched := f.matches(hash)
+	for i, out := range tx.Outputs {
+		pushes, err := txscript.PushedData(out.AddressScript())
+		if err != nil {
+			continue
+		}
+		for _, data := range pushes {
+			if len(data) == 0 || !f.matches(data) {
+				continue
+			}
+			matched = true
+			f.maybeAddOutPoint(out.AddressScript(), hash, uint32(i))
+			break
+		}
+	}
+	if matched {
+		return true
+	}
+	for _, in := range tx.Inputs {
+		if f.matches(outPointBytes(in.PreviousOutputHash, in.PreviousIndex)) {
+			return true
+		}
+		pushes, err := txscript.PushedData(in.UnlockingScript)
+		if err != nil {
+			continue
+		}
+		for _, data := range pushes {
+			if len(data) > 0 && f.matches(data) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// maybeAddOutPoint adds the matched output according to update flags
+func (f *Filter) maybeAddOutPoint(script []byte, hash msg.Hash, index uint32) {
+	switch f.flags {
+	case msg.BloomFilterUpdateAll:
+		f.add(outPointBytes(hash, index))
+	case msg.BloomFilterUpdateP2PPubKeyOnly:
+		class := txscript.GetScriptClass(script)
+		if class == txscript.PubKeyTy || class == txscript.MultiSigTy {
+			f.add(outPointBytes(hash, index))
+		}
+	}
+}
+
+// FilterLoadMsg returns the filterload message to send to the peer
+func (f *Filter) FilterLoadMsg() *msg.FilterLoadMsg {
+	f.Lock()
+	defer f.Unlock()
+	data := make([]byte, len(f.data))
+	copy(data, f.data)
+	return &msg.FilterLoadMsg{
+		Filter:    data,
+		HashFuncs: f.hashFuncs,
+		Tweak:     f.tweak,
+		Flags:     f.flags,
+	}
+}
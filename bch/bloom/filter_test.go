@@ -0,0 +1,141 @@
+package bloom
+
+import (
+	"bhd/bch/msg"
+	"bytes"
+	"encoding/hex"
+	"testing"
+
+	"github.com/gcash/bchutil"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+// the BIP37 filter vectors of the reference implementation, the filter of 3
+// elements matches the inserted data and serializes to the same filterload
+func TestFilterInsertSerialize(t *testing.T) {
+	for _, test := range []struct {
+		tweak      uint32
+		serialized string
+	}{
+		{0, "03614e9b050000000000000001"},
+		{2147483649, "03ce4299050000000100008001"},
+	} {
+		f := NewFilter(3, test.tweak, 0.01, msg.BloomFilterUpdateAll)
+		for _, item := range []struct {
+			data   string
+			insert bool
+		}{
+			{"99108ad8ed9bb6274d3980bab5a85c048f0950c8", true},
+			{"19108ad8ed9bb6274d3980bab5a85c048f0950c8", false},
+			{"b5a2c786d9ef4658287ced5914b37a1b4aa32eee", true},
+			{"b9300670b4c5366e95b2699e8b18bc75e5f729c5", true},
+		} {
+			data := decodeHex(t, item.data)
+			if item.insert {
+				f.Add(data)
+			}
+			if f.Matches(data) != item.insert {
+				t.Errorf("tweak %d: expected match %v for %s", test.tweak, item.insert, item.data)
+			}
+		}
+		if packed := hex.EncodeToString(f.FilterLoadMsg().Pack()); packed != test.serialized {
+			t.Errorf("tweak %d: expected filterload %s, got %s", test.tweak, test.serialized, packed)
+		}
+	}
+}
+
+func TestFilterInsertKey(t *testing.T) {
+	wif, err := bchutil.DecodeWIF("5Kg1gnAjaLfKiwhhPpGS3QfRg2m6awQvaj98JCZBZQ5SuS2F15C")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f := NewFilter(2, 0, 0.001, msg.BloomFilterUpdateAll)
+	f.Add(wif.SerializePubKey())
+	f.Add(bchutil.Hash160(wif.SerializePubKey()))
+	if packed := hex.EncodeToString(f.FilterLoadMsg().Pack()); packed != "038fc16b080000000000000001" {
+		t.Errorf("unexpected filterload %s", packed)
+	}
+}
+
+const (
+	// matchTx is the transaction b4749f017444b051c44dfd2720e88f314ff94f3dd6d56d40ef65854fcd7fff6b
+	// of the reference implementation vectors, spendingTx spends its first output
+	matchTx = "01000000010b26e9b7735eb6aabdf358bab62f9816a21ba9ebdb719d5299e" +
+		"88607d722c190000000008b4830450220070aca44506c5cef3a16ed519d7" +
+		"c3c39f8aab192c4e1c90d065f37b8a4af6141022100a8e160b856c2d43d2" +
+		"7d8fba71e5aef6405b8643ac4cb7cb3c462aced7f14711a0141046d11fee" +
+		"51b0e60666d5049a9101a72741df480b96ee26488a4d3466b95c9a40ac5e" +
+		"eef87e10a5cd336c19a84565f80fa6c547957b7700ff4dfbdefe76036c33" +
+		"9ffffffff021bff3d11000000001976a91404943fdd508053c75000106d3" +
+		"bc6e2754dbcff1988ac2f15de00000000001976a914a266436d296554760" +
+		"8b9e15d9032a7b9d64fa43188ac00000000"
+	spendingTx = "01000000016bff7fcd4f8565ef406dd5d63d4ff94f318fe82027fd4dc451b0" +
+		"4474019f74b4000000008c493046022100da0dc6aecefe1e06efdf05773757de" +
+		"b168820930e3b0d03f46f5fcf150bf990c022100d25b5c87040076e4f253f826" +
+		"2e763e2dd51e7ff0be157727c4bc42807f17bd39014104e6c26ef67dc610d2cd" +
+		"192484789a6cf9aea9930b944b7e2db5342b9d9e5b9ff79aff9a2ee1978dd7fd" +
+		"01dfc522ee02283d3b06a9d03acf8096968d7dbb0f9178ffffffff028ba7940e" +
+		"000000001976a914badeecfdef0507247fc8f74241d73bc039972d7b88ac4094" +
+		"a802000000001976a914c10932483fec93ed51f5fe95e72559f2cc7043f988ac" +
+		"00000000"
+)
+
+func decodeTx(t *testing.T, s string) *msg.Tx {
+	t.Helper()
+	tx, err := msg.DecodeTxMsg(bytes.NewReader(decodeHex(t, s)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func TestFilterMatchTx(t *testing.T) {
+	tx := decodeTx(t, matchTx)
+	spending := decodeTx(t, spendingTx)
+	txHash, err := msg.NewHashFromString("b4749f017444b051c44dfd2720e88f314ff94f3dd6d56d40ef65854fcd7fff6b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range []struct {
+		name  string
+		data  []byte
+		match bool
+	}{
+		{"tx hash", txHash, true},
+		{"input signature", decodeHex(t, "30450220070aca44506c5cef3a16ed519d7c3c39f8aab192c4e1c90d065f37b8a4af6141022100a8e160b856c2d43d27d8fba71e5aef6405b8643ac4cb7cb3c462aced7f14711a01"), true},
+		{"input pubkey", decodeHex(t, "046d11fee51b0e60666d5049a9101a72741df480b96ee26488a4d3466b95c9a40ac5eeef87e10a5cd336c19a84565f80fa6c547957b7700ff4dfbdefe76036c339"), true},
+		{"output address", decodeHex(t, "04943fdd508053c75000106d3bc6e2754dbcff19"), true},
+		{"second output address", decodeHex(t, "a266436d2965547608b9e15d9032a7b9d64fa431"), true},
+		{"spent outpoint", outPointBytes(tx.Inputs[0].PreviousOutputHash, 0), true},
+		{"random hash", decodeHex(t, "00000009e784f32f62ef849763d4f45b98e07ba658647343b915ff832b110436"), false},
+		{"random address", decodeHex(t, "0000006d2965547608b9e15d9032a7b9d64fa431"), false},
+		{"other outpoint", outPointBytes(tx.Inputs[0].PreviousOutputHash, 1), false},
+	} {
+		f := NewFilter(10, 0, 0.000001, msg.BloomFilterUpdateAll)
+		f.Add(test.data)
+		if f.MatchTxAndUpdate(tx) != test.match {
+			t.Errorf("%s: expected match %v", test.name, test.match)
+		}
+	}
+
+	// the matched output is added, so the transaction spending it matches too
+	f := NewFilter(10, 0, 0.000001, msg.BloomFilterUpdateAll)
+	f.Add(decodeHex(t, "04943fdd508053c75000106d3bc6e2754dbcff19"))
+	if !f.MatchTxAndUpdate(tx) || !f.MatchTxAndUpdate(spending) {
+		t.Error("the transaction spending the matched output doesn't match")
+	}
+	// without the update the spending transaction doesn't match
+	f = NewFilter(10, 0, 0.000001, msg.BloomFilterUpdateNone)
+	f.Add(decodeHex(t, "04943fdd508053c75000106d3bc6e2754dbcff19"))
+	if !f.MatchTxAndUpdate(tx) || f.MatchTxAndUpdate(spending) {
+		t.Error("the filter without the update matched the spending transaction")
+	}
+}
@@ -0,0 +1,53 @@
+package bloom
+
+import (
+	"encoding/binary"
+)
+
+const (
+	murmurC1 = 0xcc9e2d51
+	murmurC2 = 0x1b873593
+)
+
+// murmurHash3 is the 32 bit x86 variant of murmur3 with the seed
+// that BIP37 uses to pick the bits in the filter
+func murmurHash3(seed uint32, data []byte) uint32 {
+	h1 := seed
+	numBlocks := len(data) / 4
+	for i := 0; i < numBlocks; i++ {
+		k1 := binary.LittleEndian.Uint32(data[i*4:])
+		k1 *= murmurC1
+		k1 = (k1 << 15) | (k1 >> 17)
+		k1 *= murmurC2
+		h1 ^= k1
+		h1 = (h1 << 13) | (h1 >> 19)
+		h1 = h1*5 + 0xe6546b64
+	}
+
+	// the remaining bytes
+	tail := data[numBlocks*4:]
+	var k1 uint32
+	switch len(tail) {
+	case 3:
+		k1 ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k1 ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k1 ^= uint32(tail[0])
+		k1 *= murmurC1
+		k1 = (k1 << 15) | (k1 >> 17)
+		k1 *= murmurC2
+		h1 ^= k1
+	}
+
+	// finalization
+	h1 ^= uint32(len(data))
+	h1 ^= h1 >> 16
+	h1 *= 0x85ebca6b
+	h1 ^= h1 >> 13
+	h1 *= 0xc2b2ae35
+	h1 ^= h1 >> 16
+	return h1
+}
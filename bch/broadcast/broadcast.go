@@ -0,0 +1,457 @@
+// Package broadcast sends our signed transactions directly to the bitcoin cash nodes,
+// so the wallet doesn't depend on the BHD server to get them into the mempool
+package broadcast
+
+import (
+	"bhd/bch/msg"
+	"bhd/bch/peer"
+	"bhd/log"
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultPropagationThreshold is the number of peers that must request or announce
+	// the transaction before we consider it propagated through the network
+	DefaultPropagationThreshold = 2
+	// maxSeenProofs is the number of double spend proof hashes we remember
+	maxSeenProofs = 1000
+	// seenProofExpiry is how long the seen proof is not requested again
+	seenProofExpiry = 24 * time.Hour
+)
+
+// RejectInfo is the reject message received for our transaction
+type RejectInfo struct {
+	PeerId string `json:"peerId"`
+	Code   uint8  `json:"code"`
+	Type   string `json:"type"`
+	Reason string `json:"reason"`
+}
+
+// TxStatus describes how far the transaction got
+type TxStatus struct {
+	TxHash      string        `json:"txHash"`
+	AnnouncedTo []string      `json:"announcedTo"`
+	RequestedBy []string      `json:"requestedBy"`
+	SeenFrom    []string      `json:"seenFrom"`
+	Rejects     []*RejectInfo `json:"rejects"`
+	Propagated  bool          `json:"propagated"`
+	DoubleSpend bool          `json:"doubleSpend"`
+	BroadcastAt int64         `json:"broadcastAt"`
+}
+
+// clone returns the copy of the status, the lists are copied too so the
+// copy can be read while the broadcaster keeps updating the status
+func (s *TxStatus) clone() *TxStatus {
+	status := *s
+	status.AnnouncedTo = append([]string(nil), s.AnnouncedTo...)
+	status.RequestedBy = append([]string(nil), s.RequestedBy...)
+	status.SeenFrom = append([]string(nil), s.SeenFrom...)
+	status.Rejects = append([]*RejectInfo(nil), s.Rejects...)
+	return &status
+}
+
+// DoubleSpendReport is raised when the peer relays double spend proof
+// for the outpoint spent by one of our transactions. Verified is false when
+// the signatures of the proof could not be checked, e.g. the spent output
+// is not known, such report is only a hint.
+type DoubleSpendReport struct {
+	TxHash       string `json:"txHash"`
+	PrevTxId     string `json:"prevTxId"`
+	PrevOutIndex uint32 `json:"prevOutIndex"`
+	ProofHash    string `json:"proofHash"`
+	PeerId       string `json:"peerId"`
+	Verified     bool   `json:"verified"`
+}
+
+type trackedTx struct {
+	tx          *msg.Tx
+	status      *TxStatus
+	broadcasted bool
+}
+
+// Broadcaster announces transactions with inv and serves them when the peers
+// ask with getdata, it listens to reject and dsproof-beta for the ones we track.
+// SpentOutput returns the output spent by the tracked transaction, nil when it's
+// not known, the double spend proofs are verified against it.
+type Broadcaster struct {
+	sync.Mutex
+	txs                  map[string]*trackedTx
+	outpoints            map[string][]string
+	seenProofs           map[string]time.Time
+	PropagationThreshold int
+	SpentOutput          func(prevTxId msg.Hash, index uint32) *msg.TxOutput
+	OnReject             func(status *TxStatus, reject *RejectInfo)
+	OnPropagated         func(status *TxStatus)
+	OnDoubleSpend        func(report *DoubleSpendReport)
+}
+
+func NewBroadcaster() *Broadcaster {
+	return &Broadcaster{
+		txs:                  make(map[string]*trackedTx),
+		outpoints:            make(map[string][]string),
+		seenProofs:           make(map[string]time.Time),
+		PropagationThreshold: DefaultPropagationThreshold,
+	}
+}
+
+// outPointKey returns the map key of the outpoint
+func outPointKey(hash msg.Hash, index uint32) string {
+	return string(hash) + string(utils.UInt32ToByte(index))
+}
+
+// track starts tracking the transaction, must be called with lock held
+func (b *Broadcaster) track(tx *msg.Tx) *trackedTx {
+	hash := tx.GetHash()
+	key := string(hash)
+	if tracked, ok := b.txs[key]; ok {
+		return tracked
+	}
+	tracked := &trackedTx{
+		tx: tx,
+		status: &TxStatus{
+			TxHash:      hash.ToString(),
+			AnnouncedTo: make([]string, 0),
+			RequestedBy: make([]string, 0),
+			SeenFrom:    make([]string, 0),
+			Rejects:     make([]*RejectInfo, 0),
+		},
+	}
+	b.txs[key] = tracked
+	for _, in := range tx.Inputs {
+		opKey := outPointKey(in.PreviousOutputHash, in.PreviousIndex)
+		b.outpoints[opKey] = append(b.outpoints[opKey], key)
+	}
+	return tracked
+}
+
+// Watch tracks the transaction for double spend proofs without broadcasting it,
+// use it for unconfirmed payments we have received
+func (b *Broadcaster) Watch(tx *msg.Tx) {
+	b.Lock()
+	defer b.Unlock()
+	b.track(tx)
+}
+
+// Broadcast announces the signed transaction to the peers, they request
+// it with getdata which is handled in HandleMessage
+func (b *Broadcaster) Broadcast(tx *msg.Tx, peers []peer.Peer) error {
+	if len(peers) == 0 {
+		return errors.New("no connected peers to broadcast the transaction to")
+	}
+	b.Lock()
+	tracked := b.track(tx)
+	tracked.broadcasted = true
+	if tracked.status.BroadcastAt == 0 {
+		tracked.status.BroadcastAt = time.Now().Unix()
+	}
+	hash := tx.GetHash()
+	b.Unlock()
+
+	inv := msg.NewInvMsg()
+	inv.AddItem(msg.NewInvVect(msg.InvTypeTransaction, hash))
+	var sent = 0
+	for _, p := range peers {
+		err := p.Send(inv)
+		if err != nil {
+			log.Warn("Failed to announce tx", hash.ToString(), "to", p.Id(), err.Error())
+			continue
+		}
+		sent++
+		b.Lock()
+		tracked.status.AnnouncedTo = appendUnique(tracked.status.AnnouncedTo, p.Id())
+		b.Unlock()
+	}
+	if sent == 0 {
+		return errors.New("transaction " + hash.ToString() + " could not be announced to any peer")
+	}
+	log.Info("Transaction", hash.ToString(), "announced to", sent, "peers")
+	return nil
+}
+
+// Rebroadcast announces again the transactions that have not propagated yet
+func (b *Broadcaster) Rebroadcast(peers []peer.Peer) {
+	b.Lock()
+	var pending []*msg.Tx
+	for _, tracked := range b.txs {
+		if tracked.broadcasted && !tracked.status.Propagated {
+			pending = append(pending, tracked.tx)
+		}
+	}
+	b.Unlock()
+	for _, tx := range pending {
+		err := b.Broadcast(tx, peers)
+		if err != nil {
+			log.Warn("Rebroadcast failed", err.Error())
+		}
+	}
+}
+
+// Status returns the copy of the transaction status, nil if it's not tracked
+func (b *Broadcaster) Status(hash msg.Hash) *TxStatus {
+	b.Lock()
+	defer b.Unlock()
+	tracked, ok := b.txs[string(hash)]
+	if !ok {
+		return nil
+	}
+	return tracked.status.clone()
+}
+
+// Forget stops tracking the transaction, call it once it's confirmed
+func (b *Broadcaster) Forget(hash msg.Hash) {
+	b.Lock()
+	defer b.Unlock()
+	tracked, ok := b.txs[string(hash)]
+	if !ok {
+		return
+	}
+	// other tracked transactions can spend the same outpoints
+	for _, in := range tracked.tx.Inputs {
+		opKey := outPointKey(in.PreviousOutputHash, in.PreviousIndex)
+		keys := b.outpoints[opKey][:0]
+		for _, key := range b.outpoints[opKey] {
+			if key != string(hash) {
+				keys = append(keys, key)
+			}
+		}
+		if len(keys) == 0 {
+			delete(b.outpoints, opKey)
+		} else {
+			b.outpoints[opKey] = keys
+		}
+	}
+	delete(b.txs, string(hash))
+}
+
+// HandleMessage processes the messages related to the broadcast: getdata, inv, reject and dsproof-beta
+func (b *Broadcaster) HandleMessage(p peer.Peer, command string, payload *bytes.Reader) error {
+	switch command {
+	case msg.CmdGetData:
+		getData, err := msg.DecodeGetDataMsg(payload)
+		if err != nil {
+			return err
+		}
+		return b.handleGetData(p, getData)
+	case msg.CmdInv:
+		inv, err := msg.DecodeInvMsg(payload)
+		if err != nil {
+			return err
+		}
+		return b.handleInv(p, inv)
+	case msg.CmdReject:
+		reject, err := msg.DecodeRejectMsg(payload)
+		if err != nil {
+			return err
+		}
+		b.handleReject(p, reject)
+	case msg.CmdDsProof:
+		proof, err := msg.DecodeDsProofMsg(payload)
+		if err != nil {
+			return err
+		}
+		b.handleDsProof(p, proof)
+	}
+	return nil
+}
+
+func (b *Broadcaster) handleGetData(p peer.Peer, getData *msg.GetDataMsg) error {
+	notFound := msg.NewNotFoundMsg()
+	for _, item := range getData.Items {
+		if item.Type != msg.InvTypeTransaction {
+			continue
+		}
+		b.Lock()
+		tracked, ok := b.txs[string(item.Hash)]
+		if !ok || !tracked.broadcasted {
+			b.Unlock()
+			notFound.AddItem(item)
+			continue
+		}
+		tracked.status.RequestedBy = appendUnique(tracked.status.RequestedBy, p.Id())
+		propagated := b.checkPropagated(tracked)
+		status := tracked.status.clone()
+		b.Unlock()
+		err := p.Send(tracked.tx)
+		if err != nil {
+			return err
+		}
+		if propagated && b.OnPropagated != nil {
+			b.OnPropagated(status)
+		}
+	}
+	if len(notFound.Items) > 0 {
+		return p.Send(notFound)
+	}
+	return nil
+}
+
+func (b *Broadcaster) handleInv(p peer.Peer, inv *msg.InvMsg) error {
+	getData := msg.NewGetDataMsg()
+	for _, item := range inv.Items {
+		switch item.Type {
+		case msg.InvTypeTransaction:
+			// other peers announcing our tx means it went through
+			b.Lock()
+			tracked, ok := b.txs[string(item.Hash)]
+			if !ok {
+				b.Unlock()
+				continue
+			}
+			tracked.status.SeenFrom = appendUnique(tracked.status.SeenFrom, p.Id())
+			propagated := b.checkPropagated(tracked)
+			status := tracked.status.clone()
+			b.Unlock()
+			if propagated && b.OnPropagated != nil {
+				b.OnPropagated(status)
+			}
+		case msg.InvTypeDblSpendProof:
+			b.Lock()
+			seen := b.proofSeen(item.Hash)
+			tracking := len(b.txs) > 0
+			b.Unlock()
+			if !seen && tracking {
+				getData.AddItem(item)
+			}
+		}
+	}
+	if len(getData.Items) > 0 {
+		return p.Send(getData)
+	}
+	return nil
+}
+
+// checkPropagated marks the transaction propagated, returns true only the first time
+func (b *Broadcaster) checkPropagated(tracked *trackedTx) bool {
+	if tracked.status.Propagated {
+		return false
+	}
+	peers := appendUnique(append([]string{}, tracked.status.RequestedBy...), tracked.status.SeenFrom...)
+	if len(peers) < b.PropagationThreshold {
+		return false
+	}
+	tracked.status.Propagated = true
+	return true
+}
+
+func (b *Broadcaster) handleReject(p peer.Peer, reject *msg.RejectMsg) {
+	if reject.Message != msg.CmdTx || reject.Hash == nil {
+		return
+	}
+	b.Lock()
+	tracked, ok := b.txs[string(reject.Hash)]
+	if !ok {
+		b.Unlock()
+		return
+	}
+	info := &RejectInfo{
+		PeerId: p.Id(),
+		Code:   reject.Code,
+		Type:   reject.CodeString(),
+		Reason: reject.Reason,
+	}
+	tracked.status.Rejects = append(tracked.status.Rejects, info)
+	status := tracked.status.clone()
+	b.Unlock()
+	log.Warn("Transaction", status.TxHash, "rejected by", p.Id(), "due to", info.Type, info.Reason)
+	if b.OnReject != nil {
+		b.OnReject(status, info)
+	}
+}
+
+// proofSeen returns true if the proof was seen and hasn't expired yet,
+// must be called with lock held
+func (b *Broadcaster) proofSeen(proofHash msg.Hash) bool {
+	seenAt, ok := b.seenProofs[string(proofHash)]
+	return ok && time.Since(seenAt) < seenProofExpiry
+}
+
+// markProofSeen remembers the proof, the expired ones are dropped when the limit
+// is reached and then the oldest one, must be called with lock held
+func (b *Broadcaster) markProofSeen(proofHash msg.Hash) {
+	if len(b.seenProofs) >= maxSeenProofs {
+		var oldest string
+		var oldestAt time.Time
+		for hash, seenAt := range b.seenProofs {
+			if time.Since(seenAt) >= seenProofExpiry {
+				delete(b.seenProofs, hash)
+			} else if oldest == "" || seenAt.Before(oldestAt) {
+				oldest, oldestAt = hash, seenAt
+			}
+		}
+		if len(b.seenProofs) >= maxSeenProofs {
+			delete(b.seenProofs, oldest)
+		}
+	}
+	b.seenProofs[string(proofHash)] = time.Now()
+}
+
+func (b *Broadcaster) handleDsProof(p peer.Peer, proof *msg.DsProofMsg) {
+	proofHash := proof.Hash()
+	b.Lock()
+	if b.proofSeen(proofHash) {
+		b.Unlock()
+		return
+	}
+	b.markProofSeen(proofHash)
+	var spending []*trackedTx
+	for _, key := range b.outpoints[outPointKey(proof.PrevTxId, proof.PrevOutIndex)] {
+		spending = append(spending, b.txs[key])
+	}
+	b.Unlock()
+	if len(spending) == 0 {
+		return
+	}
+	var spent *msg.TxOutput
+	if b.SpentOutput != nil {
+		spent = b.SpentOutput(proof.PrevTxId, proof.PrevOutIndex)
+	}
+	for _, tracked := range spending {
+		report := &DoubleSpendReport{
+			TxHash:       tracked.status.TxHash,
+			PrevTxId:     proof.PrevTxId.ToString(),
+			PrevOutIndex: proof.PrevOutIndex,
+			ProofHash:    proofHash.ToString(),
+			PeerId:       p.Id(),
+		}
+		if spent != nil {
+			err := verifyDsProof(proof, tracked.tx, spent)
+			if errors.Is(err, ErrBadDsProof) {
+				log.Warn("Invalid double spend proof for", report.TxHash, "from", p.Id(), err.Error())
+				continue
+			}
+			if err != nil {
+				log.Debug("Double spend proof for", report.TxHash, "not verified:", err.Error())
+			}
+			report.Verified = err == nil
+		}
+		if report.Verified {
+			b.Lock()
+			tracked.status.DoubleSpend = true
+			b.Unlock()
+		}
+		log.Warn("Double spend proof received for", report.TxHash, "from", p.Id(), "verified:", report.Verified)
+		if b.OnDoubleSpend != nil {
+			b.OnDoubleSpend(report)
+		}
+	}
+}
+
+func appendUnique(list []string, items ...string) []string {
+	for _, item := range items {
+		var found = false
+		for _, el := range list {
+			if el == item {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, item)
+		}
+	}
+	return list
+}
@@ -0,0 +1,215 @@
+package broadcast
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+const spentValue = 100000
+
+type testPeer struct {
+	sent []msg.ProtocolPdu
+}
+
+func (p *testPeer) Id() string {
+	return "test:8333"
+}
+
+func (p *testPeer) Send(pdu msg.ProtocolPdu) error {
+	p.sent = append(p.sent, pdu)
+	return nil
+}
+
+func newTestKey(t *testing.T, seed byte) *bchec.PrivateKey {
+	t.Helper()
+	key, _ := bchec.PrivKeyFromBytes(bchec.S256(), bytes.Repeat([]byte{seed}, 32))
+	return key
+}
+
+func p2pkhScript(t *testing.T, pkHash []byte) []byte {
+	t.Helper()
+	script, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+		AddData(pkHash).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return script
+}
+
+// spend signs the transaction spending the output 0 of the prevTx paying
+// to the key, the destination makes the transactions spending it differ
+type spend struct {
+	tx  *wire.MsgTx
+	sig []byte
+}
+
+func newSpend(t *testing.T, key *bchec.PrivateKey, prevTx chainhash.Hash, destination byte, schnorr bool) *spend {
+	t.Helper()
+	tx := wire.NewMsgTx(2)
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&prevTx, 0), nil))
+	tx.AddTxOut(wire.NewTxOut(spentValue-1000, p2pkhScript(t, bytes.Repeat([]byte{destination}, 20))))
+	subScript := p2pkhScript(t, bchutil.Hash160(key.PubKey().SerializeCompressed()))
+	var sig []byte
+	var err error
+	if schnorr {
+		sig, err = txscript.RawTxInSchnorrSignature(tx, 0, subScript, txscript.SigHashAll, key, spentValue)
+	} else {
+		sig, err = txscript.RawTxInECDSASignature(tx, 0, subScript, txscript.SigHashAll, key, spentValue)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.TxIn[0].SignatureScript, err = txscript.NewScriptBuilder().AddData(sig).AddData(key.PubKey().SerializeCompressed()).Script()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &spend{tx: tx, sig: sig}
+}
+
+func (s *spend) msgTx(t *testing.T) *msg.Tx {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := s.tx.BchEncode(&buf, wire.ProtocolVersion, wire.BaseEncoding); err != nil {
+		t.Fatal(err)
+	}
+	tx, err := msg.DecodeTxMsg(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+func (s *spend) spender() *msg.DsProofSpender {
+	hashes := txscript.NewTxSigHashes(s.tx)
+	return &msg.DsProofSpender{
+		TxVersion:       uint32(s.tx.Version),
+		OutSequence:     s.tx.TxIn[0].Sequence,
+		LockTime:        s.tx.LockTime,
+		HashPrevOutputs: append(msg.Hash{}, hashes.HashPrevOuts[:]...),
+		HashSequence:    append(msg.Hash{}, hashes.HashSequence[:]...),
+		HashOutputs:     append(msg.Hash{}, hashes.HashOutputs[:]...),
+		PushData:        [][]byte{s.sig},
+	}
+}
+
+func newDsProof(prevTx chainhash.Hash, first, second *spend) *msg.DsProofMsg {
+	return &msg.DsProofMsg{
+		PrevTxId:     append(msg.Hash{}, prevTx[:]...),
+		PrevOutIndex: 0,
+		Spender1:     first.spender(),
+		Spender2:     second.spender(),
+	}
+}
+
+// newTestBroadcaster returns the broadcaster collecting the double spend reports,
+// the spent output value is known when value isn't zero
+func newTestBroadcaster(value uint64) (*Broadcaster, *[]*DoubleSpendReport) {
+	b := NewBroadcaster()
+	if value != 0 {
+		b.SpentOutput = func(prevTxId msg.Hash, index uint32) *msg.TxOutput {
+			return &msg.TxOutput{Value: value}
+		}
+	}
+	var reports []*DoubleSpendReport
+	b.OnDoubleSpend = func(report *DoubleSpendReport) {
+		reports = append(reports, report)
+	}
+	return b, &reports
+}
+
+func TestDsProof(t *testing.T) {
+	key := newTestKey(t, 1)
+	prevTx := chainhash.DoubleHashH([]byte("funding"))
+	ours := newSpend(t, key, prevTx, 1, false)
+	theirs := newSpend(t, key, prevTx, 2, true)
+	forged := newSpend(t, newTestKey(t, 2), prevTx, 2, true)
+
+	for _, test := range []struct {
+		name     string
+		value    uint64
+		proof    *msg.DsProofMsg
+		reported bool
+		verified bool
+	}{
+		{"valid", spentValue, newDsProof(prevTx, ours, theirs), true, true},
+		{"swapped spenders", spentValue, newDsProof(prevTx, theirs, ours), true, true},
+		{"unknown spent output", 0, newDsProof(prevTx, ours, theirs), true, false},
+		{"other key", spentValue, newDsProof(prevTx, ours, forged), false, false},
+		{"other value", spentValue + 1, newDsProof(prevTx, ours, theirs), false, false},
+		{"same spenders", spentValue, newDsProof(prevTx, ours, ours), false, false},
+	} {
+		b, reports := newTestBroadcaster(test.value)
+		tx := ours.msgTx(t)
+		b.Watch(tx)
+		p := &testPeer{}
+		for i := 0; i < 2; i++ {
+			err := b.HandleMessage(p, msg.CmdDsProof, bytes.NewReader(test.proof.Pack()))
+			if err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		// the proof seen before is reported only once
+		if len(*reports) != map[bool]int{true: 1}[test.reported] {
+			t.Errorf("%s: expected reported %v, got %d reports", test.name, test.reported, len(*reports))
+			continue
+		}
+		if test.reported && ((*reports)[0].Verified != test.verified || (*reports)[0].TxHash != tx.GetHash().ToString()) {
+			t.Errorf("%s: unexpected report %+v", test.name, (*reports)[0])
+		}
+		if b.Status(tx.GetHash()).DoubleSpend != test.verified {
+			t.Errorf("%s: expected the double spend status %v", test.name, test.verified)
+		}
+	}
+}
+
+func TestForgetSharedOutPoint(t *testing.T) {
+	key := newTestKey(t, 1)
+	prevTx := chainhash.DoubleHashH([]byte("funding"))
+	first := newSpend(t, key, prevTx, 1, false)
+	second := newSpend(t, key, prevTx, 2, false)
+	other := newSpend(t, key, prevTx, 3, true)
+
+	b, reports := newTestBroadcaster(spentValue)
+	b.Watch(first.msgTx(t))
+	b.Watch(second.msgTx(t))
+	b.Forget(first.msgTx(t).GetHash())
+	b.handleDsProof(&testPeer{}, newDsProof(prevTx, second, other))
+	if len(*reports) != 1 || (*reports)[0].TxHash != second.msgTx(t).GetHash().ToString() || !(*reports)[0].Verified {
+		t.Fatalf("expected the report for the second transaction, got %v", *reports)
+	}
+	b.Forget(second.msgTx(t).GetHash())
+	if len(b.outpoints) != 0 || len(b.txs) != 0 {
+		t.Error("the forgotten transactions are still tracked")
+	}
+}
+
+func TestSeenProofsLimit(t *testing.T) {
+	b := NewBroadcaster()
+	expired := msg.Hash(bytes.Repeat([]byte{0xff}, 32))
+	b.markProofSeen(expired)
+	b.seenProofs[string(expired)] = time.Now().Add(-seenProofExpiry)
+	if b.proofSeen(expired) {
+		t.Error("the expired proof is still seen")
+	}
+	for i := 0; i < maxSeenProofs+10; i++ {
+		b.markProofSeen(msg.DoubleHashB(utils.UInt32ToByte(uint32(i))))
+	}
+	if len(b.seenProofs) != maxSeenProofs {
+		t.Errorf("expected %d seen proofs, got %d", maxSeenProofs, len(b.seenProofs))
+	}
+	if _, ok := b.seenProofs[string(expired)]; ok {
+		t.Error("the expired proof is kept")
+	}
+	if !b.proofSeen(msg.DoubleHashB(utils.UInt32ToByte(maxSeenProofs + 9))) {
+		t.Error("the last proof is not seen")
+	}
+}
@@ -0,0 +1,116 @@
+package broadcast
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"fmt"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	sigHashForkId = 0x40
+	// sigHashUtxos commits to the spent outputs the proof doesn't carry
+	sigHashUtxos = 0x20
+)
+
+var (
+	ErrBadDsProof = errors.New("double spend proof is not valid")
+)
+
+// verifyDsProof checks that both spenders of the proof signed the spent output with
+// the public key of our pay to public key hash input. ErrBadDsProof is returned for
+// the forged proofs, the other errors mean the proof can't be checked.
+func verifyDsProof(proof *msg.DsProofMsg, tx *msg.Tx, spent *msg.TxOutput) error {
+	var in *msg.TxInput
+	for i := range tx.Inputs {
+		if tx.Inputs[i].PreviousOutputHash.IsEqual(proof.PrevTxId) && tx.Inputs[i].PreviousIndex == proof.PrevOutIndex {
+			in = &tx.Inputs[i]
+			break
+		}
+	}
+	if in == nil {
+		return errors.New("transaction does not spend the outpoint of the proof")
+	}
+	pushes, err := txscript.PushedData(in.UnlockingScript)
+	if err != nil || len(pushes) != 2 {
+		return errors.New("only pay to public key hash inputs can be verified")
+	}
+	pubKey, err := bchec.ParsePubKey(pushes[1], bchec.S256())
+	if err != nil {
+		return err
+	}
+	scriptCode, err := txscript.NewScriptBuilder().AddOp(txscript.OP_DUP).AddOp(txscript.OP_HASH160).
+		AddData(bchutil.Hash160(pushes[1])).AddOp(txscript.OP_EQUALVERIFY).AddOp(txscript.OP_CHECKSIG).Script()
+	if err != nil {
+		return err
+	}
+	if len(spent.LockingScript) > 0 && !bytes.Equal(spent.AddressScript(), scriptCode) {
+		return errors.New("spent output is not paying to the public key of the input")
+	}
+	var tokenPrefix []byte
+	if spent.Token != nil {
+		tokenPrefix, err = spent.Token.Pack()
+		if err != nil {
+			return err
+		}
+	}
+
+	spenders := []*msg.DsProofSpender{proof.Spender1, proof.Spender2}
+	for i, spender := range spenders {
+		if spender == nil || len(spender.PushData) != 1 || len(spender.PushData[0]) == 0 {
+			return fmt.Errorf("%w, spender %d is not a single signature", ErrBadDsProof, i+1)
+		}
+	}
+	if bytes.Equal(proof.Spender1.PushData[0], proof.Spender2.PushData[0]) {
+		return fmt.Errorf("%w, both spenders are the same", ErrBadDsProof)
+	}
+	for i, spender := range spenders {
+		sig := spender.PushData[0]
+		hashType := uint32(sig[len(sig)-1])
+		if hashType&sigHashForkId == 0 {
+			return fmt.Errorf("%w, spender %d signature without the fork id", ErrBadDsProof, i+1)
+		}
+		if hashType&sigHashUtxos != 0 {
+			return errors.New("spender signed the spent outputs")
+		}
+		var signature *bchec.Signature
+		if len(sig)-1 == 64 {
+			signature, err = bchec.ParseSchnorrSignature(sig[:64])
+		} else {
+			signature, err = bchec.ParseDERSignature(sig[:len(sig)-1], bchec.S256())
+		}
+		if err != nil {
+			return fmt.Errorf("%w, spender %d: %s", ErrBadDsProof, i+1, err.Error())
+		}
+		hash := dsProofSigHash(proof, spender, scriptCode, tokenPrefix, spent.Value, hashType)
+		if !signature.Verify(hash, pubKey) {
+			return fmt.Errorf("%w, spender %d signature verification failed", ErrBadDsProof, i+1)
+		}
+	}
+	return nil
+}
+
+// dsProofSigHash returns the signature hash of the spender, the preimage of
+// the BCH signature hash with the parts the proof carries
+func dsProofSigHash(proof *msg.DsProofMsg, spender *msg.DsProofSpender, scriptCode []byte, tokenPrefix []byte, value uint64, hashType uint32) []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.UInt32ToByte(spender.TxVersion))
+	buf.Write(spender.HashPrevOutputs)
+	buf.Write(spender.HashSequence)
+	buf.Write(proof.PrevTxId)
+	buf.Write(utils.UInt32ToByte(proof.PrevOutIndex))
+	buf.Write(tokenPrefix)
+	buf.Write(utils.VarIntToByte(uint64(len(scriptCode))))
+	buf.Write(scriptCode)
+	buf.Write(utils.UInt64ToByte(value))
+	buf.Write(utils.UInt32ToByte(spender.OutSequence))
+	buf.Write(spender.HashOutputs)
+	buf.Write(utils.UInt32ToByte(spender.LockTime))
+	buf.Write(utils.UInt32ToByte(hashType))
+	return msg.DoubleHashB(buf.Bytes())
+}
@@ -0,0 +1,239 @@
+package cfilter
+
+import (
+	"bhd/bch/chain"
+	"bhd/bch/msg"
+	"errors"
+	"strconv"
+	"sync"
+)
+
+var (
+	ErrCheckpointsDisagree = errors.New("peers sent different filter checkpoints")
+	ErrNotEnoughPeers      = errors.New("not enough peers sent filter checkpoints")
+	ErrBadFilterHeader     = errors.New("filter header does not match the checkpoint")
+	ErrFilterNotConnected  = errors.New("cfheaders do not connect to known filter headers")
+)
+
+// MakeHeader returns the filter header, dsha256(filter hash || previous filter header)
+func MakeHeader(filterHash msg.Hash, prevHeader msg.Hash) msg.Hash {
+	return msg.HashMerkleBranches(filterHash, prevHeader)
+}
+
+// FilterHeaderChain verifies the filter headers against the block header chain
+// and against the checkpoints several peers agree on. Single lying peer can
+// then not hide our transactions by serving a forged filter.
+type FilterHeaderChain struct {
+	sync.RWMutex
+	headers     *chain.HeaderChain
+	minPeers    int
+	peerCkpts   map[string][]msg.Hash
+	checkpoints []msg.Hash
+	// filter headers indexed by height - baseHeight
+	baseHeight    int32
+	filterHeaders []msg.Hash
+}
+
+// NewFilterHeaderChain creates the filter header chain on top of the block headers,
+// minPeers is the number of peers whose checkpoints must match before we trust them
+func NewFilterHeaderChain(headers *chain.HeaderChain, minPeers int) *FilterHeaderChain {
+	if minPeers < 1 {
+		minPeers = 1
+	}
+	return &FilterHeaderChain{
+		headers:   headers,
+		minPeers:  minPeers,
+		peerCkpts: make(map[string][]msg.Hash),
+	}
+}
+
+// AddPeerCheckpoints stores the cfcheckpt response of the peer, once enough peers
+// answered and they all agree the checkpoints are accepted. Peers that disagree are
+// returned with ErrCheckpointsDisagree so the caller can fetch filters, find the liar
+// and remove it with RemovePeer.
+func (fc *FilterHeaderChain) AddPeerCheckpoints(peerId string, m *msg.CFCheckptMsg) ([]string, error) {
+	if m.FilterType != msg.FilterTypeBasic {
+		return nil, errors.New("unsupported filter type:" + strconv.Itoa(int(m.FilterType)))
+	}
+	stopNode := fc.headers.NodeByHash(m.StopHash)
+	if stopNode == nil || !fc.headers.IsInBestChain(m.StopHash) {
+		return nil, errors.New("cfcheckpt stop hash from " + peerId + " is not in the best chain")
+	}
+	if int(stopNode.Height/msg.CFCheckptInterval) != len(m.FilterHeaders) {
+		return nil, errors.New("cfcheckpt from " + peerId + " does not match the stop block")
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	fc.peerCkpts[peerId] = m.FilterHeaders
+	return fc.compareCheckpoints()
+}
+
+// RemovePeer forgets the checkpoints of the peer, call it for the peer found lying
+// about the filters and for the disconnected ones. The checkpoints of the remaining
+// peers are compared again and the result is reported like by AddPeerCheckpoints.
+func (fc *FilterHeaderChain) RemovePeer(peerId string) ([]string, error) {
+	fc.Lock()
+	defer fc.Unlock()
+	delete(fc.peerCkpts, peerId)
+	return fc.compareCheckpoints()
+}
+
+// compareCheckpoints accepts the checkpoints when enough peers sent them and all agree
+func (fc *FilterHeaderChain) compareCheckpoints() ([]string, error) {
+	if len(fc.peerCkpts) < fc.minPeers {
+		return nil, ErrNotEnoughPeers
+	}
+	// compare the common prefix of every pair
+	var disagreeing []string
+	var reference []msg.Hash
+	var referencePeer string
+	for peer, ckpts := range fc.peerCkpts {
+		if reference == nil {
+			reference, referencePeer = ckpts, peer
+			continue
+		}
+		for i := 0; i < len(ckpts) && i < len(reference); i++ {
+			if !ckpts[i].IsEqual(reference[i]) {
+				disagreeing = append(disagreeing, peer)
+				break
+			}
+		}
+		if len(ckpts) > len(reference) {
+			reference = ckpts
+		}
+	}
+	if len(disagreeing) > 0 {
+		return append(disagreeing, referencePeer), ErrCheckpointsDisagree
+	}
+	fc.checkpoints = reference
+	return nil, nil
+}
+
+// checkpointAt returns the agreed checkpoint at the height, nil when there isn't any
+func (fc *FilterHeaderChain) checkpointAt(height int32) msg.Hash {
+	if height <= 0 || height%msg.CFCheckptInterval != 0 {
+		return nil
+	}
+	idx := int(height/msg.CFCheckptInterval) - 1
+	if idx >= len(fc.checkpoints) {
+		return nil
+	}
+	return fc.checkpoints[idx]
+}
+
+// headerAt returns the known filter header at the height
+func (fc *FilterHeaderChain) headerAt(height int32) msg.Hash {
+	idx := int(height - fc.baseHeight)
+	if len(fc.filterHeaders) == 0 || idx < 0 || idx >= len(fc.filterHeaders) {
+		return fc.checkpointAt(height)
+	}
+	return fc.filterHeaders[idx]
+}
+
+// NextGetCFHeadersMsg returns the request for the filter headers following the ones we
+// have, the first request starts right after the last checkpoint below the block tip
+func (fc *FilterHeaderChain) NextGetCFHeadersMsg() *msg.GetCFHeadersMsg {
+	fc.RLock()
+	defer fc.RUnlock()
+	start := fc.baseHeight + int32(len(fc.filterHeaders))
+	if len(fc.filterHeaders) == 0 {
+		start = int32(len(fc.checkpoints)) * msg.CFCheckptInterval
+		if start > fc.headers.Height() {
+			start = fc.headers.Height() / msg.CFCheckptInterval * msg.CFCheckptInterval
+		}
+		if start > 0 {
+			start++
+		}
+	}
+	stop := start + msg.MaxCFHeadersPerMsg - 1
+	if stop > fc.headers.Height() {
+		stop = fc.headers.Height()
+	}
+	stopNode := fc.headers.NodeByHeight(stop)
+	if stopNode == nil {
+		return nil
+	}
+	return msg.NewGetCFHeadersMsg(uint32(start), stopNode.Hash)
+}
+
+// ProcessCFHeaders connects the filter headers, every header that falls on
+// a checkpoint height must match the checkpoint peers agreed on
+func (fc *FilterHeaderChain) ProcessCFHeaders(m *msg.CFHeadersMsg) error {
+	if m.FilterType != msg.FilterTypeBasic {
+		return errors.New("unsupported filter type:" + strconv.Itoa(int(m.FilterType)))
+	}
+	stopNode := fc.headers.NodeByHash(m.StopHash)
+	if stopNode == nil || !fc.headers.IsInBestChain(m.StopHash) {
+		return errors.New("cfheaders stop hash is not in the best chain")
+	}
+	fc.Lock()
+	defer fc.Unlock()
+	startHeight := stopNode.Height - int32(len(m.FilterHashes)) + 1
+	prev := fc.headerAt(startHeight - 1)
+	if startHeight == 0 {
+		// genesis filter header is preceded by zero hash
+		prev = msg.EmptyHash
+	}
+	if prev == nil || !prev.IsEqual(m.PrevFilterHeader) {
+		return ErrFilterNotConnected
+	}
+	computed := make([]msg.Hash, 0, len(m.FilterHashes))
+	for i, filterHash := range m.FilterHashes {
+		header := MakeHeader(filterHash, prev)
+		ckpt := fc.checkpointAt(startHeight + int32(i))
+		if ckpt != nil && !ckpt.IsEqual(header) {
+			return ErrBadFilterHeader
+		}
+		computed = append(computed, header)
+		prev = header
+	}
+	// headers can be re-sent after reorg, cut ours and append
+	if len(fc.filterHeaders) == 0 {
+		fc.baseHeight = startHeight
+		fc.filterHeaders = computed
+		return nil
+	}
+	cut := int(startHeight - fc.baseHeight)
+	if cut < 0 || cut > len(fc.filterHeaders) {
+		return ErrFilterNotConnected
+	}
+	fc.filterHeaders = append(fc.filterHeaders[:cut], computed...)
+	return nil
+}
+
+// FilterHeader returns the verified filter header at the height
+func (fc *FilterHeaderChain) FilterHeader(height int32) msg.Hash {
+	fc.RLock()
+	defer fc.RUnlock()
+	return fc.headerAt(height)
+}
+
+// VerifyFilter decodes the cfilter and checks that its hash connects the
+// filter headers we have verified for the previous and current block
+func (fc *FilterHeaderChain) VerifyFilter(m *msg.CFilterMsg) (*Filter, error) {
+	if m.FilterType != msg.FilterTypeBasic {
+		return nil, errors.New("unsupported filter type:" + strconv.Itoa(int(m.FilterType)))
+	}
+	node := fc.headers.NodeByHash(m.BlockHash)
+	if node == nil {
+		return nil, errors.New("cfilter for unknown block " + m.BlockHash.ToString())
+	}
+	fc.RLock()
+	prev := fc.headerAt(node.Height - 1)
+	current := fc.headerAt(node.Height)
+	fc.RUnlock()
+	if node.Height == 0 {
+		prev = msg.EmptyHash
+	}
+	if prev == nil || current == nil {
+		return nil, errors.New("filter header for block " + m.BlockHash.ToString() + " is not known yet")
+	}
+	filter, err := DecodeBasicFilter(m.Data)
+	if err != nil {
+		return nil, err
+	}
+	if !MakeHeader(filter.Hash(), prev).IsEqual(current) {
+		return nil, errors.New("cfilter for block " + m.BlockHash.ToString() + " does not match filter header")
+	}
+	return filter, nil
+}
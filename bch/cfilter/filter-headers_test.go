@@ -0,0 +1,166 @@
+package cfilter
+
+import (
+	"bhd/bch/chain"
+	"bhd/bch/msg"
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+// the BIP158 basic filter header of the genesis block
+const genesisFilterHeader = "9f3c30f0c37fb977cf3e1a3173c631e8ff119ad3088b6f5b2bced0802139c202"
+
+// mainnetChain returns the mainnet header chain with the block 1
+func mainnetChain(t *testing.T) *chain.HeaderChain {
+	t.Helper()
+	hc, err := chain.NewHeaderChain(&chaincfg.MainNetParams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	block, err := msg.DecodeBlockMsg(readMsgSample(t, "block1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := hc.AddHeader(&block.BlockHeader); err != nil {
+		t.Fatal(err)
+	}
+	return hc
+}
+
+// mineBranch mines count regtest headers on top of the parent, 1 second apart
+func mineBranch(t *testing.T, hc *chain.HeaderChain, parent *msg.BlockHeader, count int, merkle byte) []*msg.BlockHeader {
+	t.Helper()
+	var headers []*msg.BlockHeader
+	for i := 0; i < count; i++ {
+		header := &msg.BlockHeader{
+			BlockVersion:  4,
+			PrevBlockHash: parent.Hash(),
+			MerkleRoot:    make(msg.Hash, 32),
+			Timestamp:     parent.Timestamp + 1,
+			HashTarget:    parent.HashTarget,
+		}
+		header.MerkleRoot[0] = merkle
+		for chain.CheckProofOfWork(header, chaincfg.RegressionNetParams.PowLimit) != nil {
+			header.Nonce++
+		}
+		if _, err := hc.AddHeader(header); err != nil {
+			t.Fatalf("header %d of the branch rejected: %v", i, err)
+		}
+		headers = append(headers, header)
+		parent = header
+	}
+	return headers
+}
+
+func TestMainnetFilterHeaders(t *testing.T) {
+	hc := mainnetChain(t)
+	fc := NewFilterHeaderChain(hc, 2)
+	checkpt, err := msg.DecodeCFCheckptMsg(readMsgSample(t, "cfcheckpt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := fc.AddPeerCheckpoints("peer1", checkpt); !errors.Is(err, ErrNotEnoughPeers) {
+		t.Fatalf("expected ErrNotEnoughPeers, got %v", err)
+	}
+	if _, err := fc.AddPeerCheckpoints("peer2", checkpt); err != nil {
+		t.Fatal(err)
+	}
+
+	// the first request starts at the genesis
+	request := fc.NextGetCFHeadersMsg()
+	if request.StartHeight != 0 || !request.StopHash.IsEqual(hc.Tip().Hash) {
+		t.Fatalf("unexpected cfheaders request from %d to %s", request.StartHeight, request.StopHash.ToString())
+	}
+	headers, err := msg.DecodeCFHeadersMsg(readMsgSample(t, "cfheaders1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := fc.ProcessCFHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	if header := hex.EncodeToString(fc.FilterHeader(0)); header != genesisFilterHeader {
+		t.Errorf("expected the genesis filter header %s, got %s", genesisFilterHeader, header)
+	}
+
+	for _, sample := range []string{"cfilter0", "cfilter1"} {
+		cf, err := msg.DecodeCFilterMsg(readMsgSample(t, sample))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := fc.VerifyFilter(cf); err != nil {
+			t.Errorf("%s: %v", sample, err)
+		}
+		// the filter of the other block
+		cf.BlockHash = hc.NodeByHeight(1 - hc.NodeByHash(cf.BlockHash).Height).Hash
+		if _, err := fc.VerifyFilter(cf); err == nil {
+			t.Errorf("%s: the filter accepted for the other block", sample)
+		}
+	}
+
+	// the forged filter hash doesn't connect to the checked headers
+	headers.FilterHashes[1] = append(msg.Hash{}, headers.FilterHashes[1]...)
+	headers.FilterHashes[1][0] ^= 1
+	if err := fc.ProcessCFHeaders(headers); err != nil {
+		t.Fatal(err)
+	}
+	cf, _ := msg.DecodeCFilterMsg(readMsgSample(t, "cfilter1"))
+	if _, err := fc.VerifyFilter(cf); err == nil {
+		t.Error("the filter accepted against the forged cfheaders")
+	}
+	headers.PrevFilterHeader = fc.FilterHeader(0)
+	if err := fc.ProcessCFHeaders(headers); !errors.Is(err, ErrFilterNotConnected) {
+		t.Errorf("expected ErrFilterNotConnected, got %v", err)
+	}
+}
+
+func TestPeerCheckpoints(t *testing.T) {
+	hc, err := chain.NewHeaderChain(&chaincfg.RegressionNetParams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	best := mineBranch(t, hc, hc.Root().Header, msg.CFCheckptInterval+1, 1)
+	side := mineBranch(t, hc, hc.Root().Header, 1, 2)
+	stop := best[msg.CFCheckptInterval-1].Hash()
+	honest := &msg.CFCheckptMsg{StopHash: stop, FilterHeaders: []msg.Hash{make(msg.Hash, 32)}}
+	honest.FilterHeaders[0][0] = 1
+	liar := &msg.CFCheckptMsg{StopHash: stop, FilterHeaders: []msg.Hash{make(msg.Hash, 32)}}
+	liar.FilterHeaders[0][0] = 2
+
+	fc := NewFilterHeaderChain(hc, 2)
+	for _, m := range []*msg.CFCheckptMsg{
+		{StopHash: side[0].Hash()},
+		{StopHash: make(msg.Hash, 32)},
+		{StopHash: stop},
+		{StopHash: best[0].Hash(), FilterHeaders: honest.FilterHeaders},
+	} {
+		if _, err := fc.AddPeerCheckpoints("bad", m); err == nil || errors.Is(err, ErrNotEnoughPeers) {
+			t.Errorf("cfcheckpt to %s accepted: %v", m.StopHash.ToString(), err)
+		}
+	}
+	if _, err := fc.AddPeerCheckpoints("liar", liar); !errors.Is(err, ErrNotEnoughPeers) {
+		t.Fatalf("expected ErrNotEnoughPeers, got %v", err)
+	}
+	peers, err := fc.AddPeerCheckpoints("honest1", honest)
+	if !errors.Is(err, ErrCheckpointsDisagree) || len(peers) != 2 {
+		t.Fatalf("expected both peers disagreeing, got %v %v", peers, err)
+	}
+	// the liar blocks the agreement until it's removed
+	if _, err := fc.AddPeerCheckpoints("honest2", honest); !errors.Is(err, ErrCheckpointsDisagree) {
+		t.Fatalf("expected ErrCheckpointsDisagree, got %v", err)
+	}
+	if fc.FilterHeader(msg.CFCheckptInterval) != nil {
+		t.Fatal("the disagreed checkpoint accepted")
+	}
+	if _, err := fc.RemovePeer("liar"); err != nil {
+		t.Fatal(err)
+	}
+	if !fc.FilterHeader(msg.CFCheckptInterval).IsEqual(honest.FilterHeaders[0]) {
+		t.Error("the checkpoint of the honest peers is not accepted")
+	}
+	if _, err := fc.RemovePeer("honest1"); !errors.Is(err, ErrNotEnoughPeers) {
+		t.Errorf("expected ErrNotEnoughPeers, got %v", err)
+	}
+}
@@ -0,0 +1,163 @@
+// Package cfilter is the client side of the compact block filters (BIP157/158). Instead of
+// telling the peer what we are interested in (bloom filters), we download the filter of every
+// block, test our scripts locally and fetch only the blocks that match.
+package cfilter
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/dchest/siphash"
+)
+
+const (
+	// BasicP is the golomb-rice parameter of the basic filter
+	BasicP = 19
+	// BasicM is the inverse false positive rate of the basic filter
+	BasicM uint64 = 784931
+	// KeySize is the size of the siphash key, first 16 bytes of the block hash
+	KeySize = 16
+)
+
+// Filter is decoded golomb coded set, the values are
+// the sorted hashes of all items in the filter
+type Filter struct {
+	N      uint32
+	P      uint8
+	M      uint64
+	values []uint64
+	raw    []byte
+}
+
+// bitReader reads the golomb coded stream, bits are ordered from the
+// most significant bit of the first byte
+type bitReader struct {
+	data []byte
+	pos  int
+}
+
+func (b *bitReader) readBit() (uint64, error) {
+	if b.pos >= len(b.data)*8 {
+		return 0, errors.New("filter ended unexpectedly")
+	}
+	bit := uint64(b.data[b.pos/8]>>(7-uint(b.pos%8))) & 1
+	b.pos++
+	return bit, nil
+}
+
+func (b *bitReader) readBits(count uint8) (uint64, error) {
+	var value uint64
+	for i := uint8(0); i < count; i++ {
+		bit, err := b.readBit()
+		if err != nil {
+			return 0, err
+		}
+		value = value<<1 | bit
+	}
+	return value, nil
+}
+
+// DecodeBasicFilter decodes the cfilter data of the basic filter type
+func DecodeBasicFilter(data []byte) (*Filter, error) {
+	return DecodeFilter(BasicP, BasicM, data)
+}
+
+// DecodeFilter decodes the N prefixed golomb coded set
+func DecodeFilter(p uint8, m uint64, data []byte) (*Filter, error) {
+	if len(data) == 0 {
+		return nil, errors.New("filter is empty")
+	}
+	var reader = bytes.NewReader(data)
+	n, err := utils.NewBinaryReader(reader).ReadVarInt()
+	if err != nil {
+		return nil, err
+	}
+	// every item takes at least p+1 bits
+	if n >= 1<<32 || n*(uint64(p)+1) > uint64(reader.Len())*8 {
+		return nil, errors.New("filter item count does not fit the data")
+	}
+	var stream = &bitReader{data: data[len(data)-reader.Len():]}
+	var f = &Filter{
+		N:      uint32(n),
+		P:      p,
+		M:      m,
+		values: make([]uint64, 0, n),
+		raw:    data,
+	}
+	var value uint64
+	for i := uint64(0); i < n; i++ {
+		var quotient uint64
+		for {
+			bit, err := stream.readBit()
+			if err != nil {
+				return nil, err
+			}
+			if bit == 0 {
+				break
+			}
+			quotient++
+		}
+		remainder, err := stream.readBits(p)
+		if err != nil {
+			return nil, err
+		}
+		value += quotient<<p | remainder
+		f.values = append(f.values, value)
+	}
+	return f, nil
+}
+
+// Hash returns the filter hash used in the filter header chain
+func (f *Filter) Hash() msg.Hash {
+	return msg.DoubleHashB(f.raw)
+}
+
+// Key returns the siphash key of the block filter
+func Key(blockHash msg.Hash) [KeySize]byte {
+	var key [KeySize]byte
+	copy(key[:], blockHash)
+	return key
+}
+
+// hashToRange maps the item into [0, N*M) as in BIP158
+func (f *Filter) hashToRange(key [KeySize]byte, item []byte) uint64 {
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	hi, _ := bits.Mul64(siphash.Hash(k0, k1, item), uint64(f.N)*f.M)
+	return hi
+}
+
+// Match returns true if the item is (probably) in the filter
+func (f *Filter) Match(key [KeySize]byte, item []byte) bool {
+	return f.MatchAny(key, [][]byte{item})
+}
+
+// MatchAny returns true if any of the items is (probably) in the filter,
+// both lists are sorted so it's single pass over the filter
+func (f *Filter) MatchAny(key [KeySize]byte, items [][]byte) bool {
+	if f.N == 0 || len(items) == 0 {
+		return false
+	}
+	targets := make([]uint64, 0, len(items))
+	for _, item := range items {
+		targets = append(targets, f.hashToRange(key, item))
+	}
+	sort.Slice(targets, func(i, j int) bool { return targets[i] < targets[j] })
+	i, j := 0, 0
+	for i < len(f.values) && j < len(targets) {
+		switch {
+		case f.values[i] == targets[j]:
+			return true
+		case f.values[i] < targets[j]:
+			i++
+		default:
+			j++
+		}
+	}
+	return false
+}
@@ -0,0 +1,79 @@
+package cfilter
+
+import (
+	"bhd/bch/msg"
+	"bytes"
+	"encoding/hex"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readMsgSample reads the wire sample shared with the msg package tests
+func readMsgSample(tb testing.TB, name string) *bytes.Reader {
+	tb.Helper()
+	data, err := os.ReadFile(filepath.Join("..", "msg", "testdata", name+".hex"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sample, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return bytes.NewReader(sample)
+}
+
+func TestBlockOneFilter(t *testing.T) {
+	block, err := msg.DecodeBlockMsg(readMsgSample(t, "block1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cf, err := msg.DecodeCFilterMsg(readMsgSample(t, "cfilter1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	headers, err := msg.DecodeCFHeadersMsg(readMsgSample(t, "cfheaders1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cf.BlockHash.IsEqual(block.BlockHeader.Hash()) || !headers.StopHash.IsEqual(cf.BlockHash) {
+		t.Fatal("the samples are not of the block 1")
+	}
+	filter, err := DecodeBasicFilter(cf.Data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if filter.N != 1 {
+		t.Fatalf("expected 1 item in the filter, got %d", filter.N)
+	}
+	key := Key(cf.BlockHash)
+	if !filter.Match(key, block.Transactions[0].Outputs[0].LockingScript) {
+		t.Error("the coinbase script does not match the filter")
+	}
+	if filter.Match(key, []byte{0x51}) {
+		t.Error("the unrelated script matches the filter")
+	}
+	// the cfheaders from the genesis carry the filter hashes of the blocks 0 and 1
+	if len(headers.FilterHashes) != 2 || !filter.Hash().IsEqual(headers.FilterHashes[1]) {
+		t.Errorf("the filter hash %s is not in the cfheaders", filter.Hash().ToString())
+	}
+}
+
+func FuzzDecodeBasicFilter(f *testing.F) {
+	cf, err := msg.DecodeCFilterMsg(readMsgSample(f, "cfilter1"))
+	if err != nil {
+		f.Fatal(err)
+	}
+	f.Add(cf.Data)
+	f.Add([]byte{0x00})
+	f.Fuzz(func(t *testing.T, data []byte) {
+		filter, err := DecodeBasicFilter(data)
+		if (filter == nil) == (err == nil) {
+			t.Fatalf("decoder returned filter %v and error %v", filter, err)
+		}
+		if err == nil {
+			filter.Match([KeySize]byte{}, data)
+		}
+	})
+}
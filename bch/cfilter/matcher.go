@@ -0,0 +1,50 @@
+package cfilter
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"sync"
+)
+
+// Matcher keeps the wallet scripts and outpoints and tests them against the
+// block filters, only blocks that match have to be downloaded
+type Matcher struct {
+	sync.Mutex
+	items [][]byte
+}
+
+// NewMatcher creates the matcher for the locking scripts of the wallet
+func NewMatcher(scripts [][]byte) *Matcher {
+	var m = &Matcher{
+		items: make([][]byte, 0, len(scripts)),
+	}
+	for _, script := range scripts {
+		m.AddScript(script)
+	}
+	return m
+}
+
+// AddScript adds the locking script, blocks paying to it will match
+func (m *Matcher) AddScript(script []byte) {
+	if len(script) == 0 {
+		return
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.items = append(m.items, script)
+}
+
+// AddOutPoint adds our unspent output, the BCH basic filter contains
+// the spent outpoints so blocks spending our coins will match too
+func (m *Matcher) AddOutPoint(hash msg.Hash, index uint32) {
+	m.Lock()
+	defer m.Unlock()
+	m.items = append(m.items, append(append([]byte{}, hash...), utils.UInt32ToByte(index)...))
+}
+
+// MatchBlock returns true if the filter of the block contains any of our items
+func (m *Matcher) MatchBlock(blockHash msg.Hash, filter *Filter) bool {
+	m.Lock()
+	defer m.Unlock()
+	return filter.MatchAny(Key(blockHash), m.items)
+}
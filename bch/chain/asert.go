@@ -0,0 +1,73 @@
+package chain
+
+import (
+	"github.com/gcash/bchd/chaincfg"
+	"math/big"
+	"time"
+)
+
+const (
+	// idealBlockTime is the 10 minute block spacing the asert algorithm aims for
+	idealBlockTime = 600
+	// asertRadix and asertRBits are used for the fixed point math of asert
+	asertRadix = 65536
+	asertRBits = 16
+)
+
+// CalcAsertRequiredBits calculates the aserti3-2d target for the block
+// following the parent with given height and timestamp. BCH has been using
+// asert since the Axion upgrade (November 15, 2020), the anchor block is taken
+// from the network params so there is no need to store the chain back to it.
+func CalcAsertRequiredBits(params *chaincfg.Params, parentHeight int32, parentTimestamp int64, newBlockTimestamp int64) uint32 {
+	// test networks allow min difficulty block when no
+	// block has been mined for a while
+	if params.ReduceMinDifficulty {
+		reductionTime := int64(params.MinDiffReductionTime / time.Second)
+		if newBlockTimestamp > parentTimestamp+reductionTime {
+			return params.PowLimitBits
+		}
+	}
+
+	target := CompactToBig(params.AsertDifficultyAnchorBits)
+	tDelta := parentTimestamp - params.AsertDifficultyAnchorParentTimestamp
+	hDelta := int64(parentHeight - params.AsertDifficultyAnchorHeight)
+	bigRadix := big.NewInt(asertRadix)
+
+	// exponent = ((time_diff - IDEAL_BLOCK_TIME * (height_diff + 1)) * RADIX) / HALFLIFE
+	exponent := new(big.Int).Sub(big.NewInt(tDelta), new(big.Int).Mul(big.NewInt(idealBlockTime), big.NewInt(hDelta+1)))
+	exponent.Mul(exponent, bigRadix)
+	exponent.Quo(exponent, big.NewInt(params.AsertDifficultyHalflife))
+
+	// shifts = exponent >> RBITS, exponent -= shifts * RADIX
+	shifts := new(big.Int).Rsh(exponent, asertRBits)
+	exponent.Sub(exponent, new(big.Int).Mul(shifts, bigRadix))
+
+	// target *= RADIX + ((195766423245049 * exponent + 971821376 * exponent**2 + 5127 * exponent**3 + 2**47) >> (RBITS * 3))
+	factor := new(big.Int).Mul(big.NewInt(195766423245049), exponent)
+	factor.Add(factor, new(big.Int).Mul(big.NewInt(971821376), new(big.Int).Exp(exponent, big.NewInt(2), nil)))
+	factor.Add(factor, new(big.Int).Mul(big.NewInt(5127), new(big.Int).Exp(exponent, big.NewInt(3), nil)))
+	factor.Add(factor, new(big.Int).Lsh(bigOne, 47))
+	factor.Rsh(factor, asertRBits*3)
+	target.Mul(target, new(big.Int).Add(bigRadix, factor))
+
+	if shifts.Sign() < 0 {
+		target.Rsh(target, uint(-shifts.Int64()))
+	} else {
+		target.Lsh(target, uint(shifts.Int64()))
+	}
+	target.Rsh(target, asertRBits)
+
+	if target.Sign() == 0 {
+		return BigToCompact(bigOne)
+	}
+	if target.Cmp(params.PowLimit) > 0 {
+		return params.PowLimitBits
+	}
+	return BigToCompact(target)
+}
+
+// isAsertActive returns true when the block at given
+// height must follow the asert difficulty rules
+func isAsertActive(params *chaincfg.Params, height int32) bool {
+	return height > params.AxionActivationHeight
+}
@@ -0,0 +1,227 @@
+package chain
+
+import (
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+type asertBlock struct {
+	height    int32
+	timestamp int64
+	bits      uint32
+}
+
+// the aserti3-2d test vectors of the specification, the block is the parent
+// and the bits are the target of the block following it
+var asertVectors = []struct {
+	run          int
+	anchorHeight int32
+	anchorTime   int64
+	anchorBits   uint32
+	blocks       []asertBlock
+}{
+	{
+		run: 1, anchorHeight: 1, anchorTime: 0, anchorBits: 0x1d00ffff,
+		blocks: []asertBlock{
+			{2, 1200, 0x1d00ffff}, {3, 1800, 0x1d00ffff}, {4, 2400, 0x1d00ffff},
+			{5, 3000, 0x1d00ffff}, {6, 3600, 0x1d00ffff}, {7, 4200, 0x1d00ffff},
+			{8, 4800, 0x1d00ffff}, {9, 5400, 0x1d00ffff}, {10, 6000, 0x1d00ffff},
+			{11, 6600, 0x1d00ffff},
+		},
+	},
+	{
+		run: 2, anchorHeight: 1, anchorTime: 0, anchorBits: 0x1a2b3c4d,
+		blocks: []asertBlock{
+			{2, 1200, 0x1a2b3c4d}, {3, 1800, 0x1a2b3c4d}, {4, 2400, 0x1a2b3c4d},
+			{5, 3000, 0x1a2b3c4d}, {6, 3600, 0x1a2b3c4d}, {7, 4200, 0x1a2b3c4d},
+			{8, 4800, 0x1a2b3c4d}, {9, 5400, 0x1a2b3c4d}, {10, 6000, 0x1a2b3c4d},
+			{11, 6600, 0x1a2b3c4d},
+		},
+	},
+	{
+		run: 3, anchorHeight: 1, anchorTime: 0, anchorBits: 0x01010000,
+		blocks: []asertBlock{
+			{2, 1200, 0x01010000}, {3, 1800, 0x01010000}, {4, 2400, 0x01010000},
+			{5, 3000, 0x01010000}, {6, 3600, 0x01010000}, {7, 4200, 0x01010000},
+			{8, 4800, 0x01010000}, {9, 5400, 0x01010000}, {10, 6000, 0x01010000},
+			{11, 6600, 0x01010000},
+		},
+	},
+	{
+		run: 4, anchorHeight: 1, anchorTime: 0, anchorBits: 0x01010000,
+		blocks: []asertBlock{
+			{2, 174000, 0x01020000}, {3, 347400, 0x01040000}, {4, 520800, 0x01080000},
+			{5, 694200, 0x01100000}, {6, 867600, 0x01200000}, {7, 1041000, 0x01400000},
+			{8, 1214400, 0x02008000}, {9, 1387800, 0x02010000}, {10, 1561200, 0x02020000},
+			{11, 1734600, 0x02040000}, {12, 1908000, 0x02080000}, {13, 2081400, 0x02100000},
+			{14, 2254800, 0x02200000}, {15, 2428200, 0x02400000}, {16, 2601600, 0x03008000},
+			{17, 2775000, 0x03010000}, {18, 2948400, 0x03020000}, {19, 3121800, 0x03040000},
+			{20, 3295200, 0x03080000}, {21, 3468600, 0x03100000}, {22, 3642000, 0x03200000},
+			{23, 3815400, 0x03400000}, {24, 3988800, 0x04008000}, {25, 4162200, 0x04010000},
+			{26, 4335600, 0x04020000}, {27, 4509000, 0x04040000}, {28, 4682400, 0x04080000},
+			{29, 4855800, 0x04100000}, {30, 5029200, 0x04200000}, {31, 5202600, 0x04400000},
+			{32, 5376000, 0x05008000}, {33, 5549400, 0x05010000}, {34, 5722800, 0x05020000},
+			{35, 5896200, 0x05040000}, {36, 6069600, 0x05080000}, {37, 6243000, 0x05100000},
+			{38, 6416400, 0x05200000}, {39, 6589800, 0x05400000}, {40, 6763200, 0x06008000},
+			{41, 6936600, 0x06010000}, {42, 7110000, 0x06020000}, {43, 7283400, 0x06040000},
+			{44, 7456800, 0x06080000}, {45, 7630200, 0x06100000}, {46, 7803600, 0x06200000},
+			{47, 7977000, 0x06400000}, {48, 8150400, 0x07008000}, {49, 8323800, 0x07010000},
+			{50, 8497200, 0x07020000}, {51, 8670600, 0x07040000}, {52, 8844000, 0x07080000},
+			{53, 9017400, 0x07100000}, {54, 9190800, 0x07200000}, {55, 9364200, 0x07400000},
+			{56, 9537600, 0x08008000}, {57, 9711000, 0x08010000}, {58, 9884400, 0x08020000},
+			{59, 10057800, 0x08040000}, {60, 10231200, 0x08080000}, {61, 10404600, 0x08100000},
+			{62, 10578000, 0x08200000}, {63, 10751400, 0x08400000}, {64, 10924800, 0x09008000},
+			{65, 11098200, 0x09010000}, {66, 11271600, 0x09020000}, {67, 11445000, 0x09040000},
+			{68, 11618400, 0x09080000}, {69, 11791800, 0x09100000}, {70, 11965200, 0x09200000},
+			{71, 12138600, 0x09400000}, {72, 12312000, 0x0a008000}, {73, 12485400, 0x0a010000},
+			{74, 12658800, 0x0a020000}, {75, 12832200, 0x0a040000}, {76, 13005600, 0x0a080000},
+			{77, 13179000, 0x0a100000}, {78, 13352400, 0x0a200000}, {79, 13525800, 0x0a400000},
+			{80, 13699200, 0x0b008000}, {81, 13872600, 0x0b010000}, {82, 14046000, 0x0b020000},
+			{83, 14219400, 0x0b040000}, {84, 14392800, 0x0b080000}, {85, 14566200, 0x0b100000},
+			{86, 14739600, 0x0b200000}, {87, 14913000, 0x0b400000}, {88, 15086400, 0x0c008000},
+			{89, 15259800, 0x0c010000}, {90, 15433200, 0x0c020000}, {91, 15606600, 0x0c040000},
+			{92, 15780000, 0x0c080000}, {93, 15953400, 0x0c100000}, {94, 16126800, 0x0c200000},
+			{95, 16300200, 0x0c400000}, {96, 16473600, 0x0d008000}, {97, 16647000, 0x0d010000},
+			{98, 16820400, 0x0d020000}, {99, 16993800, 0x0d040000}, {100, 17167200, 0x0d080000},
+			{101, 17340600, 0x0d100000}, {102, 17514000, 0x0d200000}, {103, 17687400, 0x0d400000},
+			{104, 17860800, 0x0e008000}, {105, 18034200, 0x0e010000}, {106, 18207600, 0x0e020000},
+			{107, 18381000, 0x0e040000}, {108, 18554400, 0x0e080000}, {109, 18727800, 0x0e100000},
+			{110, 18901200, 0x0e200000}, {111, 19074600, 0x0e400000}, {112, 19248000, 0x0f008000},
+			{113, 19421400, 0x0f010000}, {114, 19594800, 0x0f020000}, {115, 19768200, 0x0f040000},
+			{116, 19941600, 0x0f080000}, {117, 20115000, 0x0f100000}, {118, 20288400, 0x0f200000},
+			{119, 20461800, 0x0f400000}, {120, 20635200, 0x10008000}, {121, 20808600, 0x10010000},
+			{122, 20982000, 0x10020000}, {123, 21155400, 0x10040000}, {124, 21328800, 0x10080000},
+			{125, 21502200, 0x10100000}, {126, 21675600, 0x10200000}, {127, 21849000, 0x10400000},
+			{128, 22022400, 0x11008000}, {129, 22195800, 0x11010000}, {130, 22369200, 0x11020000},
+			{131, 22542600, 0x11040000}, {132, 22716000, 0x11080000}, {133, 22889400, 0x11100000},
+			{134, 23062800, 0x11200000}, {135, 23236200, 0x11400000}, {136, 23409600, 0x12008000},
+			{137, 23583000, 0x12010000}, {138, 23756400, 0x12020000}, {139, 23929800, 0x12040000},
+			{140, 24103200, 0x12080000}, {141, 24276600, 0x12100000}, {142, 24450000, 0x12200000},
+			{143, 24623400, 0x12400000}, {144, 24796800, 0x13008000}, {145, 24970200, 0x13010000},
+			{146, 25143600, 0x13020000}, {147, 25317000, 0x13040000}, {148, 25490400, 0x13080000},
+			{149, 25663800, 0x13100000}, {150, 25837200, 0x13200000}, {151, 26010600, 0x13400000},
+			{152, 26184000, 0x14008000}, {153, 26357400, 0x14010000}, {154, 26530800, 0x14020000},
+			{155, 26704200, 0x14040000}, {156, 26877600, 0x14080000}, {157, 27051000, 0x14100000},
+			{158, 27224400, 0x14200000}, {159, 27397800, 0x14400000}, {160, 27571200, 0x15008000},
+			{161, 27744600, 0x15010000}, {162, 27918000, 0x15020000}, {163, 28091400, 0x15040000},
+			{164, 28264800, 0x15080000}, {165, 28438200, 0x15100000}, {166, 28611600, 0x15200000},
+			{167, 28785000, 0x15400000}, {168, 28958400, 0x16008000}, {169, 29131800, 0x16010000},
+			{170, 29305200, 0x16020000}, {171, 29478600, 0x16040000}, {172, 29652000, 0x16080000},
+			{173, 29825400, 0x16100000}, {174, 29998800, 0x16200000}, {175, 30172200, 0x16400000},
+			{176, 30345600, 0x17008000}, {177, 30519000, 0x17010000}, {178, 30692400, 0x17020000},
+			{179, 30865800, 0x17040000}, {180, 31039200, 0x17080000}, {181, 31212600, 0x17100000},
+			{182, 31386000, 0x17200000}, {183, 31559400, 0x17400000}, {184, 31732800, 0x18008000},
+			{185, 31906200, 0x18010000}, {186, 32079600, 0x18020000}, {187, 32253000, 0x18040000},
+			{188, 32426400, 0x18080000}, {189, 32599800, 0x18100000}, {190, 32773200, 0x18200000},
+			{191, 32946600, 0x18400000}, {192, 33120000, 0x19008000}, {193, 33293400, 0x19010000},
+			{194, 33466800, 0x19020000}, {195, 33640200, 0x19040000}, {196, 33813600, 0x19080000},
+			{197, 33987000, 0x19100000}, {198, 34160400, 0x19200000}, {199, 34333800, 0x19400000},
+			{200, 34507200, 0x1a008000}, {201, 34680600, 0x1a010000}, {202, 34854000, 0x1a020000},
+			{203, 35027400, 0x1a040000}, {204, 35200800, 0x1a080000}, {205, 35374200, 0x1a100000},
+			{206, 35547600, 0x1a200000}, {207, 35721000, 0x1a400000}, {208, 35894400, 0x1b008000},
+			{209, 36067800, 0x1b010000}, {210, 36241200, 0x1b020000}, {211, 36414600, 0x1b040000},
+			{212, 36588000, 0x1b080000}, {213, 36761400, 0x1b100000}, {214, 36934800, 0x1b200000},
+			{215, 37108200, 0x1b400000}, {216, 37281600, 0x1c008000}, {217, 37455000, 0x1c010000},
+			{218, 37628400, 0x1c020000}, {219, 37801800, 0x1c040000}, {220, 37975200, 0x1c080000},
+			{221, 38148600, 0x1c100000}, {222, 38322000, 0x1c200000}, {223, 38495400, 0x1c400000},
+			{224, 38668800, 0x1d008000}, {225, 38842200, 0x1d00ffff}, {226, 39015600, 0x1d00ffff},
+		},
+	},
+	{
+		run: 5, anchorHeight: 1, anchorTime: 0, anchorBits: 0x1d00ffff,
+		blocks: []asertBlock{
+			{2, 0, 0x1d00fec5}, {290, 0, 0x1c7f62c0}, {578, 0, 0x1c3fb160},
+			{866, 0, 0x1c1fd8b0}, {1154, 0, 0x1c0fec58}, {1442, 0, 0x1c07f62c},
+			{1730, 0, 0x1c03fb16}, {2018, 0, 0x1c01fd8b}, {2306, 0, 0x1c00fec5},
+			{2594, 0, 0x1b7f62c0}, {2882, 0, 0x1b3fb160}, {3170, 0, 0x1b1fd8b0},
+			{3458, 0, 0x1b0fec58}, {3746, 0, 0x1b07f62c}, {4034, 0, 0x1b03fb16},
+			{4322, 0, 0x1b01fd8b}, {4610, 0, 0x1b00fec5}, {4898, 0, 0x1a7f62c0},
+			{5186, 0, 0x1a3fb160}, {5474, 0, 0x1a1fd8b0}, {5762, 0, 0x1a0fec58},
+			{6050, 0, 0x1a07f62c}, {6338, 0, 0x1a03fb16}, {6626, 0, 0x1a01fd8b},
+			{6914, 0, 0x1a00fec5}, {7202, 0, 0x197f62c0}, {7490, 0, 0x193fb160},
+			{7778, 0, 0x191fd8b0}, {8066, 0, 0x190fec58}, {8354, 0, 0x1907f62c},
+			{8642, 0, 0x1903fb16}, {8930, 0, 0x1901fd8b}, {9218, 0, 0x1900fec5},
+			{9506, 0, 0x187f62c0}, {9794, 0, 0x183fb160}, {10082, 0, 0x181fd8b0},
+			{10370, 0, 0x180fec58}, {10658, 0, 0x1807f62c}, {10946, 0, 0x1803fb16},
+			{11234, 0, 0x1801fd8b}, {11522, 0, 0x1800fec5}, {11810, 0, 0x177f62c0},
+			{12098, 0, 0x173fb160}, {12386, 0, 0x171fd8b0}, {12674, 0, 0x170fec58},
+			{12962, 0, 0x1707f62c}, {13250, 0, 0x1703fb16}, {13538, 0, 0x1701fd8b},
+			{13826, 0, 0x1700fec5}, {14114, 0, 0x167f62c0}, {14402, 0, 0x163fb160},
+			{14690, 0, 0x161fd8b0}, {14978, 0, 0x160fec58}, {15266, 0, 0x1607f62c},
+			{15554, 0, 0x1603fb16}, {15842, 0, 0x1601fd8b}, {16130, 0, 0x1600fec5},
+			{16418, 0, 0x157f62c0}, {16706, 0, 0x153fb160}, {16994, 0, 0x151fd8b0},
+			{17282, 0, 0x150fec58}, {17570, 0, 0x1507f62c}, {17858, 0, 0x1503fb16},
+			{18146, 0, 0x1501fd8b}, {18434, 0, 0x1500fec5}, {18722, 0, 0x147f62c0},
+			{19010, 0, 0x143fb160}, {19298, 0, 0x141fd8b0}, {19586, 0, 0x140fec58},
+			{19874, 0, 0x1407f62c}, {20162, 0, 0x1403fb16}, {20450, 0, 0x1401fd8b},
+			{20738, 0, 0x1400fec5}, {21026, 0, 0x137f62c0}, {21314, 0, 0x133fb160},
+			{21602, 0, 0x131fd8b0}, {21890, 0, 0x130fec58}, {22178, 0, 0x1307f62c},
+			{22466, 0, 0x1303fb16}, {22754, 0, 0x1301fd8b}, {23042, 0, 0x1300fec5},
+			{23330, 0, 0x127f62c0}, {23618, 0, 0x123fb160}, {23906, 0, 0x121fd8b0},
+			{24194, 0, 0x120fec58}, {24482, 0, 0x1207f62c}, {24770, 0, 0x1203fb16},
+			{25058, 0, 0x1201fd8b}, {25346, 0, 0x1200fec5}, {25634, 0, 0x117f62c0},
+			{25922, 0, 0x113fb160}, {26210, 0, 0x111fd8b0}, {26498, 0, 0x110fec58},
+			{26786, 0, 0x1107f62c}, {27074, 0, 0x1103fb16}, {27362, 0, 0x1101fd8b},
+			{27650, 0, 0x1100fec5}, {27938, 0, 0x107f62c0}, {28226, 0, 0x103fb160},
+			{28514, 0, 0x101fd8b0}, {28802, 0, 0x100fec58}, {29090, 0, 0x1007f62c},
+			{29378, 0, 0x1003fb16}, {29666, 0, 0x1001fd8b}, {29954, 0, 0x1000fec5},
+			{30242, 0, 0x0f7f62c0}, {30530, 0, 0x0f3fb160}, {30818, 0, 0x0f1fd8b0},
+			{31106, 0, 0x0f0fec58}, {31394, 0, 0x0f07f62c}, {31682, 0, 0x0f03fb16},
+			{31970, 0, 0x0f01fd8b}, {32258, 0, 0x0f00fec5}, {32546, 0, 0x0e7f62c0},
+			{32834, 0, 0x0e3fb160}, {33122, 0, 0x0e1fd8b0}, {33410, 0, 0x0e0fec58},
+			{33698, 0, 0x0e07f62c}, {33986, 0, 0x0e03fb16}, {34274, 0, 0x0e01fd8b},
+			{34562, 0, 0x0e00fec5}, {34850, 0, 0x0d7f62c0}, {35138, 0, 0x0d3fb160},
+			{35426, 0, 0x0d1fd8b0}, {35714, 0, 0x0d0fec58}, {36002, 0, 0x0d07f62c},
+			{36290, 0, 0x0d03fb16}, {36578, 0, 0x0d01fd8b}, {36866, 0, 0x0d00fec5},
+			{37154, 0, 0x0c7f62c0}, {37442, 0, 0x0c3fb160}, {37730, 0, 0x0c1fd8b0},
+			{38018, 0, 0x0c0fec58}, {38306, 0, 0x0c07f62c}, {38594, 0, 0x0c03fb16},
+			{38882, 0, 0x0c01fd8b}, {39170, 0, 0x0c00fec5}, {39458, 0, 0x0b7f62c0},
+			{39746, 0, 0x0b3fb160}, {40034, 0, 0x0b1fd8b0}, {40322, 0, 0x0b0fec58},
+			{40610, 0, 0x0b07f62c}, {40898, 0, 0x0b03fb16}, {41186, 0, 0x0b01fd8b},
+			{41474, 0, 0x0b00fec5}, {41762, 0, 0x0a7f62c0}, {42050, 0, 0x0a3fb160},
+			{42338, 0, 0x0a1fd8b0}, {42626, 0, 0x0a0fec58}, {42914, 0, 0x0a07f62c},
+			{43202, 0, 0x0a03fb16}, {43490, 0, 0x0a01fd8b}, {43778, 0, 0x0a00fec5},
+			{44066, 0, 0x097f62c0}, {44354, 0, 0x093fb160}, {44642, 0, 0x091fd8b0},
+			{44930, 0, 0x090fec58}, {45218, 0, 0x0907f62c}, {45506, 0, 0x0903fb16},
+			{45794, 0, 0x0901fd8b}, {46082, 0, 0x0900fec5}, {46370, 0, 0x087f62c0},
+			{46658, 0, 0x083fb160}, {46946, 0, 0x081fd8b0}, {47234, 0, 0x080fec58},
+			{47522, 0, 0x0807f62c}, {47810, 0, 0x0803fb16}, {48098, 0, 0x0801fd8b},
+			{48386, 0, 0x0800fec5}, {48674, 0, 0x077f62c0}, {48962, 0, 0x073fb160},
+			{49250, 0, 0x071fd8b0}, {49538, 0, 0x070fec58}, {49826, 0, 0x0707f62c},
+			{50114, 0, 0x0703fb16}, {50402, 0, 0x0701fd8b}, {50690, 0, 0x0700fec5},
+			{50978, 0, 0x067f62c0}, {51266, 0, 0x063fb160}, {51554, 0, 0x061fd8b0},
+			{51842, 0, 0x060fec58}, {52130, 0, 0x0607f62c}, {52418, 0, 0x0603fb16},
+			{52706, 0, 0x0601fd8b}, {52994, 0, 0x0600fec5}, {53282, 0, 0x057f62c0},
+			{53570, 0, 0x053fb160}, {53858, 0, 0x051fd8b0}, {54146, 0, 0x050fec58},
+			{54434, 0, 0x0507f62c}, {54722, 0, 0x0503fb16}, {55010, 0, 0x0501fd8b},
+			{55298, 0, 0x0500fec5}, {55586, 0, 0x047f62c0}, {55874, 0, 0x043fb160},
+			{56162, 0, 0x041fd8b0}, {56450, 0, 0x040fec58}, {56738, 0, 0x0407f62c},
+			{57026, 0, 0x0403fb16}, {57314, 0, 0x0401fd8b}, {57602, 0, 0x0400fec5},
+			{57890, 0, 0x037f62c0}, {58178, 0, 0x033fb160}, {58466, 0, 0x031fd8b0},
+			{58754, 0, 0x030fec58}, {59042, 0, 0x0307f62c}, {59330, 0, 0x0303fb16},
+			{59618, 0, 0x0301fd8b}, {59906, 0, 0x0300fec5}, {60194, 0, 0x027f6200},
+			{60482, 0, 0x023fb100}, {60770, 0, 0x021fd800}, {61058, 0, 0x020fec00},
+			{61346, 0, 0x0207f600}, {61634, 0, 0x0203fb00}, {61922, 0, 0x0201fd00},
+			{62210, 0, 0x0200fe00}, {62498, 0, 0x017f0000}, {62786, 0, 0x013f0000},
+			{63074, 0, 0x011f0000}, {63362, 0, 0x010f0000}, {63650, 0, 0x01070000},
+			{63938, 0, 0x01030000}, {64226, 0, 0x01010000}, {64514, 0, 0x01010000},
+		},
+	},
+}
+
+func TestCalcAsertRequiredBits(t *testing.T) {
+	for _, test := range asertVectors {
+		params := chaincfg.MainNetParams
+		params.ReduceMinDifficulty = false
+		params.AsertDifficultyAnchorHeight = test.anchorHeight
+		params.AsertDifficultyAnchorParentTimestamp = test.anchorTime
+		params.AsertDifficultyAnchorBits = test.anchorBits
+		for _, block := range test.blocks {
+			bits := CalcAsertRequiredBits(&params, block.height, block.timestamp, block.timestamp)
+			if bits != block.bits {
+				t.Errorf("run %d, height %d: expected bits %08x, got %08x", test.run, block.height, block.bits, bits)
+			}
+		}
+	}
+}
@@ -0,0 +1,154 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"errors"
+	"strconv"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+const (
+	// MinTransactionSize is the minimum serialized transaction size since the May 2023 upgrade
+	MinTransactionSize = 65
+	// LegacyMinTransactionSize is the minimum transaction size from the November 2018
+	// (magnetic anomaly) upgrade to the May 2023 upgrade
+	LegacyMinTransactionSize = 100
+	// Upgrade9ForkHeight is the last mainnet block before the May 2023 upgrade
+	Upgrade9ForkHeight = 792772
+	// minTransactionSizeInBlock is the smallest size a transaction can have on the wire,
+	// it's used only to bound the transaction count before we decode them
+	minTransactionSizeInBlock = 60
+)
+
+var (
+	// MaxBlockSize is the largest block we accept, BCH blocks can grow with the
+	// adaptive block size limit so keep this in line with the network
+	MaxBlockSize = 32 * 1000 * 1000
+
+	ErrNoTransactions     = errors.New("block has no transactions")
+	ErrBlockTooBig        = errors.New("block is bigger than the maximum block size")
+	ErrFirstTxNotCoinbase = errors.New("first transaction in block is not a coinbase")
+	ErrMultipleCoinbases  = errors.New("block contains more than one coinbase")
+	ErrInvalidTxOrder     = errors.New("transactions are not in canonical order")
+	ErrDuplicateTx        = errors.New("block contains duplicate transaction")
+	ErrBadMerkleRoot      = errors.New("block merkle root does not match the transactions")
+)
+
+// CheckBlockSanity performs the checks of the block which need only its height,
+// the rules of the upgrades apply from their activation heights. Before its
+// transactions can be trusted the header must also be part of the header
+// chain, see HeaderChain.CheckBlock
+func CheckBlockSanity(block *msg.BlockMsg, height int32, params *chaincfg.Params) error {
+	err := CheckProofOfWork(&block.BlockHeader, params.PowLimit)
+	if err != nil {
+		return err
+	}
+	if len(block.Transactions) == 0 {
+		return ErrNoTransactions
+	}
+	if len(block.Transactions) > MaxBlockSize/minTransactionSizeInBlock {
+		return ErrBlockTooBig
+	}
+	if !block.Transactions[0].IsCoinBase() {
+		return ErrFirstTxNotCoinbase
+	}
+	var size = 80 + 9
+	// canonical transaction ordering since the magnetic anomaly upgrade
+	var ctor = height > params.MagneticAnonomalyForkHeight
+	var seen = make(map[string]struct{}, len(block.Transactions))
+	var hashes = block.TxHashes()
+	for i := range block.Transactions {
+		tx := &block.Transactions[i]
+		if i > 0 && tx.IsCoinBase() {
+			return ErrMultipleCoinbases
+		}
+		err = CheckTransactionSanity(tx, height, params)
+		if err != nil {
+			return errors.New("transaction " + strconv.Itoa(i) + " is invalid: " + err.Error())
+		}
+		size += len(tx.Pack())
+		if size > MaxBlockSize {
+			return ErrBlockTooBig
+		}
+		// all but coinbase are sorted by txid
+		if ctor && i > 1 && hashes[i-1].Compare(hashes[i]) >= 0 {
+			return ErrInvalidTxOrder
+		}
+		if _, ok := seen[string(hashes[i])]; ok {
+			return ErrDuplicateTx
+		}
+		seen[string(hashes[i])] = struct{}{}
+	}
+	if !msg.CalcMerkleRoot(hashes).IsEqual(block.MerkleRoot) {
+		return ErrBadMerkleRoot
+	}
+	return nil
+}
+
+// minTransactionSize returns the minimum transaction size of the block at the
+// height, 0 before the magnetic anomaly upgrade. Only the mainnet activation
+// of the May 2023 upgrade is known, the other networks use the current limit.
+func minTransactionSize(height int32, params *chaincfg.Params) int {
+	if height <= params.MagneticAnonomalyForkHeight {
+		return 0
+	}
+	if params.Net == chaincfg.MainNetParams.Net && height <= Upgrade9ForkHeight {
+		return LegacyMinTransactionSize
+	}
+	return MinTransactionSize
+}
+
+// CheckTransactionSanity performs the checks of the transaction in the block
+// at the height which don't need the spent outputs
+func CheckTransactionSanity(tx *msg.Tx, height int32, params *chaincfg.Params) error {
+	if len(tx.Inputs) == 0 {
+		return errors.New("transaction has no inputs")
+	}
+	if len(tx.Outputs) == 0 {
+		return errors.New("transaction has no outputs")
+	}
+	size := len(tx.Pack())
+	if size < minTransactionSize(height, params) {
+		return errors.New("transaction size " + strconv.Itoa(size) + " is below the minimum")
+	}
+	var total uint64
+	for _, out := range tx.Outputs {
+		if out.Value > maxSatoshi {
+			return errors.New("output value is higher than the max money")
+		}
+		total += out.Value
+		if total > maxSatoshi {
+			return errors.New("total output value is higher than the max money")
+		}
+	}
+	seen := make(map[string]struct{}, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		key := string(in.PreviousOutputHash) + strconv.FormatUint(uint64(in.PreviousIndex), 10)
+		if _, ok := seen[key]; ok {
+			return errors.New("transaction spends the same outpoint twice")
+		}
+		seen[key] = struct{}{}
+	}
+	if tx.IsCoinBase() {
+		scriptLen := len(tx.Inputs[0].UnlockingScript)
+		if scriptLen < 2 || scriptLen > 100 {
+			return errors.New("coinbase script length is out of range")
+		}
+	}
+	return nil
+}
+
+// maxSatoshi is 21 million coins
+const maxSatoshi = 21e6 * 1e8
+
+// CheckBlock checks the block is sane and that its header is in our header
+// chain, so its transactions have the proof of work of the whole chain on top
+func (hc *HeaderChain) CheckBlock(block *msg.BlockMsg) error {
+	hash := block.BlockHeader.Hash()
+	node := hc.NodeByHash(hash)
+	if node == nil {
+		return errors.New("block " + hash.ToString() + " is not in the header chain")
+	}
+	return CheckBlockSanity(block, node.Height, hc.params)
+}
@@ -0,0 +1,125 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"bytes"
+	"errors"
+	"math"
+	"sort"
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+func testCoinbase() msg.Tx {
+	return msg.Tx{
+		Version: 1,
+		Inputs: []msg.TxInput{{
+			PreviousOutputHash: make(msg.Hash, 32),
+			PreviousIndex:      math.MaxUint32,
+			UnlockingScript:    msg.Script{0x03, 0x01, 0x02, 0x03},
+			SequenceNumber:     math.MaxUint32,
+		}},
+		Outputs: []msg.TxOutput{{Value: 50e8, LockingScript: bytes.Repeat([]byte{0x51}, 25)}},
+	}
+}
+
+// testTx spends the outpoint of the prev byte, the unlocking script of the
+// size makes the transaction bigger or smaller than the minimum size
+func testTx(prev byte, scriptSize int) msg.Tx {
+	hash := make(msg.Hash, 32)
+	hash[0] = prev
+	return msg.Tx{
+		Version: 2,
+		Inputs: []msg.TxInput{{
+			PreviousOutputHash: hash,
+			UnlockingScript:    bytes.Repeat([]byte{0x01}, scriptSize),
+			SequenceNumber:     math.MaxUint32,
+		}},
+		Outputs: []msg.TxOutput{{Value: 1000, LockingScript: msg.Script{0x51}}},
+	}
+}
+
+// testBlock creates the regtest block of the transactions with the proof of work
+func testBlock(t *testing.T, txs ...msg.Tx) *msg.BlockMsg {
+	t.Helper()
+	block := &msg.BlockMsg{
+		BlockHeader: msg.BlockHeader{
+			BlockVersion:  4,
+			PrevBlockHash: make(msg.Hash, 32),
+			Timestamp:     1700000000,
+			HashTarget:    msg.NewCompressedTargetFormat(chaincfg.RegressionNetParams.PowLimitBits),
+		},
+		TransactionCount: uint64(len(txs)),
+		Transactions:     txs,
+	}
+	block.MerkleRoot = block.CalcMerkleRoot()
+	for CheckProofOfWork(&block.BlockHeader, chaincfg.RegressionNetParams.PowLimit) != nil {
+		block.Nonce++
+	}
+	return block
+}
+
+// unorderedTxs returns the transactions sorted by txid in the descending order
+func unorderedTxs() []msg.Tx {
+	txs := []msg.Tx{testTx(1, 107), testTx(2, 107), testTx(3, 107)}
+	sort.Slice(txs, func(i, j int) bool { return txs[i].GetHash().Compare(txs[j].GetHash()) > 0 })
+	return txs
+}
+
+func TestCheckBlockSanityOrdering(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	pre := params.MagneticAnonomalyForkHeight
+	txs := unorderedTxs()
+
+	// before the magnetic anomaly the transactions are in any order
+	block := testBlock(t, append([]msg.Tx{testCoinbase()}, txs...)...)
+	if err := CheckBlockSanity(block, pre, params); err != nil {
+		t.Errorf("pre-CTOR block rejected: %v", err)
+	}
+	if err := CheckBlockSanity(block, pre+1, params); !errors.Is(err, ErrInvalidTxOrder) {
+		t.Errorf("expected ErrInvalidTxOrder for the post-CTOR block, got %v", err)
+	}
+
+	for i, j := 0, len(txs)-1; i < j; i, j = i+1, j-1 {
+		txs[i], txs[j] = txs[j], txs[i]
+	}
+	block = testBlock(t, append([]msg.Tx{testCoinbase()}, txs...)...)
+	if err := CheckBlockSanity(block, pre+1, params); err != nil {
+		t.Errorf("post-CTOR block rejected: %v", err)
+	}
+
+	block.MerkleRoot[0] ^= 1
+	if err := CheckBlockSanity(block, pre+1, params); err == nil {
+		t.Error("block with the wrong merkle root accepted")
+	}
+}
+
+func TestCheckTransactionSanityMinSize(t *testing.T) {
+	params := &chaincfg.RegressionNetParams
+	small := testTx(1, 0)
+	if size := len(small.Pack()); size >= MinTransactionSize {
+		t.Fatalf("test transaction has %d bytes", size)
+	}
+	if err := CheckTransactionSanity(&small, params.MagneticAnonomalyForkHeight, params); err != nil {
+		t.Errorf("small transaction before the magnetic anomaly rejected: %v", err)
+	}
+	if err := CheckTransactionSanity(&small, params.MagneticAnonomalyForkHeight+1, params); err == nil {
+		t.Error("small transaction after the magnetic anomaly accepted")
+	}
+
+	mainnet := &chaincfg.MainNetParams
+	for _, test := range []struct {
+		height int32
+		size   int
+	}{
+		{mainnet.MagneticAnonomalyForkHeight, 0},
+		{mainnet.MagneticAnonomalyForkHeight + 1, LegacyMinTransactionSize},
+		{Upgrade9ForkHeight, LegacyMinTransactionSize},
+		{Upgrade9ForkHeight + 1, MinTransactionSize},
+	} {
+		if size := minTransactionSize(test.height, mainnet); size != test.size {
+			t.Errorf("height %d: expected minimum size %d, got %d", test.height, test.size, size)
+		}
+	}
+}
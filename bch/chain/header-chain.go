@@ -0,0 +1,312 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"bhd/log"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+const (
+	// medianTimeBlocks is the number of previous blocks used for median time past
+	medianTimeBlocks = 11
+	// maxFutureBlockTime is how far in the future the header timestamp can be
+	maxFutureBlockTime = 2 * 60 * 60
+)
+
+var (
+	ErrOrphanHeader    = errors.New("header does not connect to any known header")
+	ErrBadDifficulty   = errors.New("header difficulty bits do not match the required target")
+	ErrTimeTooOld      = errors.New("header timestamp is not after the median time past")
+	ErrTimeTooNew      = errors.New("header timestamp is too far in the future")
+	ErrNotContinuous   = errors.New("headers in the message are not continuous")
+	ErrCheckpointWrong = errors.New("header does not match the checkpoint")
+)
+
+// HeaderNode is the header with the data computed when it was
+// connected to the chain
+type HeaderNode struct {
+	Header *msg.BlockHeader
+	Hash   msg.Hash
+	Height int32
+	// Work is cumulative work of the chain up to and including this header
+	Work   *big.Int
+	Parent *HeaderNode
+}
+
+// Checkpoint is the trusted header the chain starts from, light client
+// doesn't need to start at genesis, any header after the asert anchor
+// is good enough to verify everything that follows
+type Checkpoint struct {
+	Height int32
+	Header *msg.BlockHeader
+}
+
+// ReorgHandler is called when the best chain changes to a different branch,
+// detached are the headers that are no longer in best chain (tip first) and
+// attached are the new ones (lowest first)
+type ReorgHandler func(detached []*HeaderNode, attached []*HeaderNode)
+
+// HeaderChain keeps all the valid headers we have seen and
+// tracks the tip with the most cumulative work
+type HeaderChain struct {
+	sync.RWMutex
+	params  *chaincfg.Params
+	nodes   map[string]*HeaderNode
+	root    *HeaderNode
+	tip     *HeaderNode
+	best    []*HeaderNode // best chain indexed by height - root height
+	now     func() int64
+	OnReorg ReorgHandler
+}
+
+// NewHeaderChain creates the header chain starting at the checkpoint,
+// when checkpoint is nil the genesis block of the network is used
+func NewHeaderChain(params *chaincfg.Params, checkpoint *Checkpoint) (*HeaderChain, error) {
+	if checkpoint == nil {
+		checkpoint = &Checkpoint{Height: 0, Header: genesisHeader(params)}
+	}
+	if checkpoint.Header == nil {
+		return nil, errors.New("checkpoint has no header")
+	}
+	root := &HeaderNode{
+		Header: checkpoint.Header,
+		Hash:   checkpoint.Header.Hash(),
+		Height: checkpoint.Height,
+		Work:   CalcWork(checkpoint.Header.HashTarget.Bits()),
+	}
+	hc := &HeaderChain{
+		params: params,
+		nodes:  map[string]*HeaderNode{string(root.Hash): root},
+		root:   root,
+		tip:    root,
+		best:   []*HeaderNode{root},
+		now:    nowUnix,
+	}
+	return hc, nil
+}
+
+// Params returns the network the chain is on
+func (hc *HeaderChain) Params() *chaincfg.Params {
+	return hc.params
+}
+
+// Tip returns the header with the most work
+func (hc *HeaderChain) Tip() *HeaderNode {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.tip
+}
+
+// Root returns the checkpoint header the chain has started from
+func (hc *HeaderChain) Root() *HeaderNode {
+	return hc.root
+}
+
+// Height returns the height of the best chain
+func (hc *HeaderChain) Height() int32 {
+	return hc.Tip().Height
+}
+
+// NodeByHash returns known header node, it does not have
+// to be part of the best chain
+func (hc *HeaderChain) NodeByHash(hash msg.Hash) *HeaderNode {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.nodes[string(hash)]
+}
+
+// NodeByHeight returns the header in the best chain at the height
+func (hc *HeaderChain) NodeByHeight(height int32) *HeaderNode {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.nodeByHeight(height)
+}
+
+func (hc *HeaderChain) nodeByHeight(height int32) *HeaderNode {
+	idx := int(height - hc.root.Height)
+	if idx < 0 || idx >= len(hc.best) {
+		return nil
+	}
+	return hc.best[idx]
+}
+
+// IsInBestChain returns true if the hash is part of the chain with most work
+func (hc *HeaderChain) IsInBestChain(hash msg.Hash) bool {
+	hc.RLock()
+	defer hc.RUnlock()
+	node, ok := hc.nodes[string(hash)]
+	if !ok {
+		return false
+	}
+	return hc.nodeByHeight(node.Height) == node
+}
+
+// Confirmations returns the number of confirmations of the block, 0 if
+// the block is not known or not in the best chain
+func (hc *HeaderChain) Confirmations(hash msg.Hash) int32 {
+	hc.RLock()
+	defer hc.RUnlock()
+	node, ok := hc.nodes[string(hash)]
+	if !ok || hc.nodeByHeight(node.Height) != node {
+		return 0
+	}
+	return hc.tip.Height - node.Height + 1
+}
+
+// ProcessHeaders validates and connects all the headers in the message, it
+// returns true if the message was a full batch and peer should be asked
+// for more headers
+func (hc *HeaderChain) ProcessHeaders(headers *msg.HeadersMsg) (bool, error) {
+	var prev msg.Hash
+	for i, header := range headers.Items {
+		if i > 0 && !msg.Hash(header.PrevBlockHash).IsEqual(prev) {
+			return false, ErrNotContinuous
+		}
+		_, err := hc.AddHeader(header)
+		if err != nil {
+			return false, err
+		}
+		prev = header.Hash()
+	}
+	return len(headers.Items) >= msg.MaxHeadersPerMsg, nil
+}
+
+// AddHeader validates the header and connects it to its parent, if the new branch
+// has more work than the current tip, the tip is moved and reorg handler called
+func (hc *HeaderChain) AddHeader(header *msg.BlockHeader) (*HeaderNode, error) {
+	hc.Lock()
+	hash := header.Hash()
+	if node, ok := hc.nodes[string(hash)]; ok {
+		hc.Unlock()
+		return node, nil
+	}
+	parent, ok := hc.nodes[string(header.PrevBlockHash)]
+	if !ok {
+		hc.Unlock()
+		return nil, ErrOrphanHeader
+	}
+	err := hc.checkHeader(header, parent)
+	if err != nil {
+		hc.Unlock()
+		return nil, err
+	}
+	node := &HeaderNode{
+		Header: header,
+		Hash:   hash,
+		Height: parent.Height + 1,
+		Work:   new(big.Int).Add(parent.Work, CalcWork(header.HashTarget.Bits())),
+		Parent: parent,
+	}
+	hc.nodes[string(hash)] = node
+	if node.Work.Cmp(hc.tip.Work) <= 0 {
+		hc.Unlock()
+		log.Debug("Header", hash.ToString(), "at height", node.Height, "added to side chain")
+		return node, nil
+	}
+	detached, attached := hc.setTip(node)
+	handler := hc.OnReorg
+	hc.Unlock()
+	if len(detached) > 0 {
+		log.Info("Chain reorganized, detached", len(detached), "headers, new tip", hash.ToString())
+		if handler != nil {
+			handler(detached, attached)
+		}
+	}
+	return node, nil
+}
+
+// checkHeader verifies proof of work, difficulty and timestamp of the header
+func (hc *HeaderChain) checkHeader(header *msg.BlockHeader, parent *HeaderNode) error {
+	height := parent.Height + 1
+	err := CheckProofOfWork(header, hc.params.PowLimit)
+	if err != nil {
+		return err
+	}
+	for _, cp := range hc.params.Checkpoints {
+		if cp.Height == height && !header.Hash().IsEqual(cp.Hash[:]) {
+			return ErrCheckpointWrong
+		}
+	}
+	// the networks without retargeting (regtest) keep the bits of the parent,
+	// before the axion upgrade the DAA needs the 144 block window,
+	// we only check the difficulty from the asert activation on
+	if hc.params.NoDifficultyAdjustment {
+		if header.HashTarget.Bits() != parent.Header.HashTarget.Bits() {
+			return ErrBadDifficulty
+		}
+	} else if isAsertActive(hc.params, height) {
+		expected := CalcAsertRequiredBits(hc.params, parent.Height, int64(parent.Header.Timestamp), int64(header.Timestamp))
+		if header.HashTarget.Bits() != expected {
+			return fmt.Errorf("%w, got %x expected %x", ErrBadDifficulty, header.HashTarget.Bits(), expected)
+		}
+	}
+	mtp, ok := medianTimePast(parent)
+	if ok && int64(header.Timestamp) <= mtp {
+		return ErrTimeTooOld
+	}
+	if int64(header.Timestamp) > hc.now()+maxFutureBlockTime {
+		return ErrTimeTooNew
+	}
+	return nil
+}
+
+// setTip moves the best chain to the node, returns the
+// detached and attached nodes when the branch changes
+func (hc *HeaderChain) setTip(node *HeaderNode) ([]*HeaderNode, []*HeaderNode) {
+	var attached []*HeaderNode
+	fork := node
+	for fork != nil && hc.nodeByHeight(fork.Height) != fork {
+		attached = append(attached, fork)
+		fork = fork.Parent
+	}
+	var detached []*HeaderNode
+	for n := hc.tip; n != fork; n = n.Parent {
+		detached = append(detached, n)
+	}
+	// attached were collected from tip down
+	for i, j := 0, len(attached)-1; i < j; i, j = i+1, j-1 {
+		attached[i], attached[j] = attached[j], attached[i]
+	}
+	hc.best = hc.best[:fork.Height-hc.root.Height+1]
+	hc.best = append(hc.best, attached...)
+	hc.tip = node
+	return detached, attached
+}
+
+// medianTimePast returns the median timestamp of the last 11 blocks,
+// it's false if we don't have enough headers before the node
+func medianTimePast(node *HeaderNode) (int64, bool) {
+	var timestamps []int64
+	for n := node; n != nil && len(timestamps) < medianTimeBlocks; n = n.Parent {
+		timestamps = append(timestamps, int64(n.Header.Timestamp))
+	}
+	if len(timestamps) < medianTimeBlocks {
+		return 0, false
+	}
+	sort.Slice(timestamps, func(i, j int) bool { return timestamps[i] < timestamps[j] })
+	return timestamps[len(timestamps)/2], true
+}
+
+// genesisHeader converts the network genesis block to our header structure
+func genesisHeader(params *chaincfg.Params) *msg.BlockHeader {
+	gh := params.GenesisBlock.Header
+	return &msg.BlockHeader{
+		BlockVersion:  gh.Version,
+		PrevBlockHash: msg.Hash(gh.PrevBlock.CloneBytes()),
+		MerkleRoot:    msg.Hash(gh.MerkleRoot.CloneBytes()),
+		Timestamp:     uint32(gh.Timestamp.Unix()),
+		HashTarget:    msg.NewCompressedTargetFormat(gh.Bits),
+		Nonce:         gh.Nonce,
+	}
+}
+
+func nowUnix() int64 {
+	return time.Now().Unix()
+}
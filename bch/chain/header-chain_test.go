@@ -0,0 +1,149 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"errors"
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+// mineHeader creates the header on top of the parent with the proof of work
+// of the bits, the regtest target is met by every other nonce
+func mineHeader(t *testing.T, parent *msg.BlockHeader, timestamp uint32, bits uint32, merkle byte) *msg.BlockHeader {
+	t.Helper()
+	header := &msg.BlockHeader{
+		BlockVersion:  4,
+		PrevBlockHash: parent.Hash(),
+		MerkleRoot:    make(msg.Hash, 32),
+		Timestamp:     timestamp,
+		HashTarget:    msg.NewCompressedTargetFormat(bits),
+	}
+	header.MerkleRoot[0] = merkle
+	for ; header.Nonce < 1000; header.Nonce++ {
+		if CheckProofOfWork(header, chaincfg.RegressionNetParams.PowLimit) == nil {
+			return header
+		}
+	}
+	t.Fatal("cannot mine the regtest header")
+	return nil
+}
+
+// mineBranch mines count headers on top of the parent, 1 second apart
+func mineBranch(t *testing.T, hc *HeaderChain, parent *msg.BlockHeader, count int, merkle byte) []*msg.BlockHeader {
+	t.Helper()
+	var headers []*msg.BlockHeader
+	for i := 0; i < count; i++ {
+		header := mineHeader(t, parent, parent.Timestamp+1, parent.HashTarget.Bits(), merkle)
+		_, err := hc.AddHeader(header)
+		if err != nil {
+			t.Fatalf("header %d of the branch rejected: %v", i, err)
+		}
+		headers = append(headers, header)
+		parent = header
+	}
+	return headers
+}
+
+func newRegtestChain(t *testing.T) *HeaderChain {
+	t.Helper()
+	hc, err := NewHeaderChain(&chaincfg.RegressionNetParams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hc
+}
+
+func TestRegtestHeaders(t *testing.T) {
+	hc := newRegtestChain(t)
+	// the blocks much faster than 10 minutes keep the regtest bits
+	headers := mineBranch(t, hc, hc.Root().Header, 30, 1)
+	if hc.Height() != 30 || !hc.Tip().Hash.IsEqual(headers[29].Hash()) {
+		t.Fatalf("expected tip at height 30, got %d", hc.Height())
+	}
+	tip := headers[29]
+
+	bad := mineHeader(t, tip, tip.Timestamp+1, tip.HashTarget.Bits(), 2)
+	for CheckProofOfWork(bad, chaincfg.RegressionNetParams.PowLimit) == nil {
+		bad.Nonce++
+	}
+	if _, err := hc.AddHeader(bad); err == nil {
+		t.Error("header without the proof of work accepted")
+	}
+
+	harder := mineHeader(t, tip, tip.Timestamp+1, 0x207ffffe, 3)
+	if _, err := hc.AddHeader(harder); !errors.Is(err, ErrBadDifficulty) {
+		t.Errorf("expected ErrBadDifficulty for the changed bits, got %v", err)
+	}
+
+	old := mineHeader(t, tip, headers[20].Timestamp, tip.HashTarget.Bits(), 4)
+	if _, err := hc.AddHeader(old); !errors.Is(err, ErrTimeTooOld) {
+		t.Errorf("expected ErrTimeTooOld, got %v", err)
+	}
+
+	orphan := mineHeader(t, bad, tip.Timestamp+2, tip.HashTarget.Bits(), 5)
+	if _, err := hc.AddHeader(orphan); !errors.Is(err, ErrOrphanHeader) {
+		t.Errorf("expected ErrOrphanHeader, got %v", err)
+	}
+}
+
+func TestRegtestReorg(t *testing.T) {
+	hc := newRegtestChain(t)
+	var detached, attached []*HeaderNode
+	hc.OnReorg = func(d []*HeaderNode, a []*HeaderNode) {
+		detached, attached = d, a
+	}
+	main := mineBranch(t, hc, hc.Root().Header, 5, 1)
+
+	// the branch with the same work stays on the side
+	side := mineBranch(t, hc, main[1], 3, 2)
+	if !hc.Tip().Hash.IsEqual(main[4].Hash()) || detached != nil {
+		t.Fatal("the tip moved to the branch with the same work")
+	}
+	if hc.IsInBestChain(side[2].Hash()) || hc.Confirmations(side[0].Hash()) != 0 {
+		t.Error("the side branch is in the best chain")
+	}
+
+	// one more header gives the side branch the most work
+	side = append(side, mineBranch(t, hc, side[2], 1, 2)...)
+	if !hc.Tip().Hash.IsEqual(side[3].Hash()) || hc.Height() != 6 {
+		t.Fatalf("expected the tip on the side branch at height 6, got %d", hc.Height())
+	}
+	if len(detached) != 3 || len(attached) != 4 {
+		t.Fatalf("expected 3 detached and 4 attached headers, got %d and %d", len(detached), len(attached))
+	}
+	if !detached[0].Hash.IsEqual(main[4].Hash()) || !attached[0].Hash.IsEqual(side[0].Hash()) {
+		t.Error("detached must start at the old tip and attached at the fork")
+	}
+	for height := int32(3); height <= 6; height++ {
+		if !hc.NodeByHeight(height).Hash.IsEqual(side[height-3].Hash()) {
+			t.Errorf("best chain at height %d is not on the side branch", height)
+		}
+	}
+	if hc.IsInBestChain(main[4].Hash()) || hc.Confirmations(main[1].Hash()) != 5 {
+		t.Error("the old branch is still in the best chain")
+	}
+}
+
+func TestAsertBadDifficulty(t *testing.T) {
+	// the regtest chain with the ASERT retargeting of the mainnet
+	params := chaincfg.RegressionNetParams
+	params.NoDifficultyAdjustment = false
+	hc, err := NewHeaderChain(&params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	root := hc.Root()
+	if !isAsertActive(&params, root.Height+1) {
+		t.Fatal("ASERT is not active on the regtest chain")
+	}
+	expected := CalcAsertRequiredBits(&params, root.Height, int64(root.Header.Timestamp), int64(root.Header.Timestamp)+600)
+	header := mineHeader(t, root.Header, root.Header.Timestamp+600, expected-1, 1)
+	if _, err := hc.AddHeader(header); !errors.Is(err, ErrBadDifficulty) {
+		t.Errorf("expected ErrBadDifficulty from the ASERT check, got %v", err)
+	}
+	header = mineHeader(t, root.Header, root.Header.Timestamp+600, expected, 1)
+	if _, err := hc.AddHeader(header); err != nil {
+		t.Errorf("header with the ASERT bits %x rejected: %v", expected, err)
+	}
+}
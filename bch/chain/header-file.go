@@ -0,0 +1,70 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"os"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+// headerSize is the serialized size of block header
+const headerSize = 80
+
+// SaveToFile writes the best chain to the file, first 4 bytes are height of
+// the root followed by 80 byte headers. Side branches are not stored, they
+// would be received again from peers if they ever become the best chain.
+func (hc *HeaderChain) SaveToFile(path string) error {
+	hc.RLock()
+	var buf bytes.Buffer
+	buf.Write(utils.UInt32ToByte(uint32(hc.root.Height)))
+	for _, node := range hc.best {
+		buf.Write(node.Header.Pack())
+	}
+	hc.RUnlock()
+	tmpPath := path + ".tmp"
+	err := os.WriteFile(tmpPath, buf.Bytes(), 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmpPath, path)
+}
+
+// LoadHeaderChainFromFile restores the chain saved by SaveToFile, all
+// headers are verified again as they are connected
+func LoadHeaderChainFromFile(params *chaincfg.Params, path string) (*HeaderChain, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(content) < 4+headerSize || (len(content)-4)%headerSize != 0 {
+		return nil, errors.New("header file " + path + " is corrupted")
+	}
+	var reader = bytes.NewReader(content)
+	rootHeight, err := utils.NewBinaryReader(reader).ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	height := int32(rootHeight)
+	root, err := msg.DecodeBlockHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	hc, err := NewHeaderChain(params, &Checkpoint{Height: height, Header: root})
+	if err != nil {
+		return nil, err
+	}
+	for reader.Len() > 0 {
+		header, err := msg.DecodeBlockHeader(reader)
+		if err != nil {
+			return nil, err
+		}
+		_, err = hc.AddHeader(header)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return hc, nil
+}
@@ -0,0 +1,72 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+)
+
+// Locator returns the block locator for the current tip
+func (hc *HeaderChain) Locator() []msg.Hash {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.locatorFrom(hc.tip)
+}
+
+// LocatorFrom returns the block locator starting at the node, the node
+// can be on a side branch, which is what we want after the reorg
+func (hc *HeaderChain) LocatorFrom(node *HeaderNode) []msg.Hash {
+	hc.RLock()
+	defer hc.RUnlock()
+	return hc.locatorFrom(node)
+}
+
+// locatorFrom walks back from the node, the first 10 hashes are consecutive
+// and after that the step doubles each time, so even a long chain fits in
+// a few dozen hashes. The root (checkpoint) is always the last one so the
+// peer can always find a common block with us.
+func (hc *HeaderChain) locatorFrom(node *HeaderNode) []msg.Hash {
+	if node == nil {
+		return []msg.Hash{hc.root.Hash}
+	}
+	locator := make([]msg.Hash, 0, 32)
+	step := int32(1)
+	for node != nil {
+		locator = append(locator, node.Hash)
+		if node.Height == hc.root.Height || len(locator) >= msg.MaxLocatorHashes-1 {
+			break
+		}
+		height := node.Height - step
+		if height < hc.root.Height {
+			height = hc.root.Height
+		}
+		node = hc.ancestor(node, height)
+		if len(locator) >= 10 {
+			step *= 2
+		}
+	}
+	if !locator[len(locator)-1].IsEqual(hc.root.Hash) {
+		locator = append(locator, hc.root.Hash)
+	}
+	return locator
+}
+
+// ancestor returns the node's ancestor at the height, when the node is
+// on the best chain the index is used, otherwise parents are followed
+// until the branch joins the best chain
+func (hc *HeaderChain) ancestor(node *HeaderNode, height int32) *HeaderNode {
+	if height > node.Height || height < hc.root.Height {
+		return nil
+	}
+	for node != nil && node.Height > height {
+		if hc.nodeByHeight(node.Height) == node {
+			return hc.nodeByHeight(height)
+		}
+		node = node.Parent
+	}
+	return node
+}
+
+// NextGetHeadersMsg builds the getheaders request that asks the
+// peer for the headers following our tip
+func (hc *HeaderChain) NextGetHeadersMsg() *msg.GetHeadersMsg {
+	return msg.NewGetHeadersMsg(hc.Locator(), msg.EmptyHash)
+}
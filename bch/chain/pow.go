@@ -0,0 +1,97 @@
+package chain
+
+import (
+	"bhd/bch/msg"
+	"errors"
+	"math/big"
+)
+
+var (
+	bigOne    = big.NewInt(1)
+	oneLsh256 = new(big.Int).Lsh(bigOne, 256)
+)
+
+// CompactToBig expands the compact target representation (nBits) to
+// the full 256 bit number. The format is similar to floating point,
+// the highest byte is base 256 exponent, bit 23 is the sign and the
+// lowest 23 bits are the mantissa:
+//
+//	N = (-1^sign) * mantissa * 256^(exponent-3)
+func CompactToBig(compact uint32) *big.Int {
+	mantissa := compact & 0x007fffff
+	isNegative := compact&0x00800000 != 0
+	exponent := uint(compact >> 24)
+
+	var bn *big.Int
+	if exponent <= 3 {
+		mantissa >>= 8 * (3 - exponent)
+		bn = big.NewInt(int64(mantissa))
+	} else {
+		bn = big.NewInt(int64(mantissa))
+		bn.Lsh(bn, 8*(exponent-3))
+	}
+	if isNegative {
+		bn = bn.Neg(bn)
+	}
+	return bn
+}
+
+// BigToCompact converts the target to the compact representation, only
+// the 23 most significant bits of the number are preserved
+func BigToCompact(n *big.Int) uint32 {
+	if n.Sign() == 0 {
+		return 0
+	}
+	var mantissa uint32
+	exponent := uint(len(n.Bytes()))
+	if exponent <= 3 {
+		mantissa = uint32(n.Bits()[0])
+		mantissa <<= 8 * (3 - exponent)
+	} else {
+		tn := new(big.Int).Set(n)
+		mantissa = uint32(tn.Rsh(tn, 8*(exponent-3)).Bits()[0])
+	}
+	// the sign bit would be set, shift one more byte
+	if mantissa&0x00800000 != 0 {
+		mantissa >>= 8
+		exponent++
+	}
+	compact := uint32(exponent<<24) | mantissa
+	if n.Sign() < 0 {
+		compact |= 0x00800000
+	}
+	return compact
+}
+
+// HashToBig converts the (little endian) block hash
+// to number so it can be compared with the target
+func HashToBig(hash msg.Hash) *big.Int {
+	return new(big.Int).SetBytes(hash.Reverse())
+}
+
+// CalcWork returns the amount of work the target represents, which is
+// 2^256 / (target + 1). The chain with the most cumulative work wins.
+func CalcWork(bits uint32) *big.Int {
+	target := CompactToBig(bits)
+	if target.Sign() <= 0 {
+		return big.NewInt(0)
+	}
+	denominator := new(big.Int).Add(target, bigOne)
+	return new(big.Int).Div(oneLsh256, denominator)
+}
+
+// CheckProofOfWork verifies that the target of the header is sane and
+// not above the network pow limit and that the header hash meets it
+func CheckProofOfWork(header *msg.BlockHeader, powLimit *big.Int) error {
+	target := CompactToBig(header.HashTarget.Bits())
+	if target.Sign() <= 0 {
+		return errors.New("block target difficulty is too low")
+	}
+	if target.Cmp(powLimit) > 0 {
+		return errors.New("block target difficulty is higher than the pow limit")
+	}
+	if HashToBig(header.Hash()).Cmp(target) > 0 {
+		return errors.New("block hash " + header.Hash().ToString() + " is higher than the target")
+	}
+	return nil
+}
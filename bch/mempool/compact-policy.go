@@ -0,0 +1,64 @@
+package mempool
+
+import (
+	"bhd/bch/msg"
+	"sync"
+)
+
+const (
+	// DefaultMinHitRate is the share of the compact block transactions the pool
+	// must know for the compact blocks to save bandwidth
+	DefaultMinHitRate = 0.5
+	// DefaultHitRateWindow is the number of the last compact blocks the hit rate is averaged over
+	DefaultHitRateWindow = 6
+)
+
+// CompactBlockPolicy decides if the compact blocks are worth asking for. The
+// wallet pool knows only the wallet transactions, when the short ids mostly
+// miss the block is fetched with getblocktxn anyway and the round trip only
+// adds to the full block. Record the rebuilt blocks and stop sending sendcmpct
+// (ask for the filtered blocks instead) when UseCompactBlocks returns false.
+type CompactBlockPolicy struct {
+	sync.Mutex
+	MinHitRate float64
+	Window     int
+	rates      []float64
+}
+
+// NewCompactBlockPolicy creates the policy with the default hit rate and window
+func NewCompactBlockPolicy() *CompactBlockPolicy {
+	return &CompactBlockPolicy{
+		MinHitRate: DefaultMinHitRate,
+		Window:     DefaultHitRateWindow,
+	}
+}
+
+// Record adds the hit rate of the compact block rebuilt from the pool
+func (c *CompactBlockPolicy) Record(block *msg.PartialBlock) {
+	c.Lock()
+	defer c.Unlock()
+	c.rates = append(c.rates, block.HitRate())
+	if len(c.rates) > c.Window {
+		c.rates = c.rates[len(c.rates)-c.Window:]
+	}
+}
+
+// HitRate returns the average hit rate of the recorded blocks, 1 when there are none
+func (c *CompactBlockPolicy) HitRate() float64 {
+	c.Lock()
+	defer c.Unlock()
+	if len(c.rates) == 0 {
+		return 1
+	}
+	var sum float64
+	for _, rate := range c.rates {
+		sum += rate
+	}
+	return sum / float64(len(c.rates))
+}
+
+// UseCompactBlocks returns false when the pool misses too many transactions
+// of the compact blocks to save bandwidth
+func (c *CompactBlockPolicy) UseCompactBlocks() bool {
+	return c.HitRate() >= c.MinHitRate
+}
@@ -0,0 +1,69 @@
+package mempool
+
+import (
+	"bhd/bch/msg"
+	"testing"
+)
+
+func testTxs(count int) []*msg.Tx {
+	var txs []*msg.Tx
+	for i := 0; i < count; i++ {
+		prev := make(msg.Hash, 32)
+		prev[0] = byte(i + 1)
+		txs = append(txs, &msg.Tx{
+			Version:  2,
+			Inputs:   []msg.TxInput{{PreviousOutputHash: prev, UnlockingScript: msg.Script{0x51}}},
+			Outputs:  []msg.TxOutput{{Value: 1000, LockingScript: msg.Script{0x51}}},
+			LockTime: 0,
+		})
+	}
+	return txs
+}
+
+// testCmpctBlock announces the transactions, the first one is prefilled
+func testCmpctBlock(txs []*msg.Tx) *msg.CmpctBlockMsg {
+	cmpct := &msg.CmpctBlockMsg{
+		BlockHeader: msg.BlockHeader{PrevBlockHash: make(msg.Hash, 32), MerkleRoot: make(msg.Hash, 32)},
+		Nonce:       42,
+		PrefilledTx: []*msg.PrefilledTx{{Index: 0, Tx: txs[0]}},
+	}
+	k0, k1 := cmpct.ShortIdKeys()
+	for _, tx := range txs[1:] {
+		cmpct.ShortIds = append(cmpct.ShortIds, msg.ShortTxId(k0, k1, tx.GetHash()))
+	}
+	return cmpct
+}
+
+func TestCompactBlockPolicy(t *testing.T) {
+	txs := testTxs(9)
+	cmpct := testCmpctBlock(txs)
+	policy := NewCompactBlockPolicy()
+	if !policy.UseCompactBlocks() {
+		t.Fatal("compact blocks must be used before any block is recorded")
+	}
+
+	// the wallet pool knows 2 of the 8 short ids
+	block, err := msg.NewPartialBlock(cmpct, txs[1:3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rate := block.HitRate(); rate != 0.25 || len(block.Missing()) != 6 {
+		t.Fatalf("expected hit rate 0.25 and 6 missing, got %v and %d", rate, len(block.Missing()))
+	}
+	policy.Record(block)
+	if policy.UseCompactBlocks() {
+		t.Error("compact blocks used with the low hit rate")
+	}
+
+	// the full mempool rebuilds the whole block
+	for i := 0; i < DefaultHitRateWindow; i++ {
+		block, err = msg.NewPartialBlock(cmpct, txs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		policy.Record(block)
+	}
+	if !block.IsComplete() || block.HitRate() != 1 || !policy.UseCompactBlocks() {
+		t.Errorf("expected the complete block and compact blocks in use, hit rate %v", policy.HitRate())
+	}
+}
@@ -0,0 +1,502 @@
+// Package mempool tracks the unconfirmed transactions relevant to the wallet. It asks the
+// peers for their mempool filtered with the wallet bloom filter, follows the transactions
+// until they are confirmed in a block or conflicted by another spend of the same coins.
+package mempool
+
+import (
+	"bhd/bch/bloom"
+	"bhd/bch/chain"
+	"bhd/bch/msg"
+	"bhd/bch/peer"
+	"bhd/log"
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"sync"
+	"time"
+)
+
+const (
+	StatePending    = "pending"
+	StateConfirmed  = "confirmed"
+	StateConflicted = "conflicted"
+	// UnknownHeight is the height of the transaction confirmed in the block we don't have the header of
+	UnknownHeight = -1
+	// requestTimeout is how long we wait for the transaction the peer announced or matched in the block
+	requestTimeout = 2 * time.Minute
+)
+
+// WalletTx is the transaction paying to the wallet or spending its coins
+type WalletTx struct {
+	TxHash       string `json:"txHash"`
+	State        string `json:"state"`
+	Height       int32  `json:"height"`
+	Received     int64  `json:"received"`
+	Spent        int64  `json:"spent"`
+	FirstSeen    int64  `json:"firstSeen"`
+	ConflictedBy string `json:"conflictedBy,omitempty"`
+	tx           *msg.Tx
+}
+
+// Balance is the wallet balance, Pending is the net amount of the unconfirmed
+// transactions, it's negative when we are spending more than receiving
+type Balance struct {
+	Confirmed int64 `json:"confirmed"`
+	Pending   int64 `json:"pending"`
+}
+
+// coin is the wallet output, confirmed or created by a pending transaction
+type coin struct {
+	value     int64
+	confirmed bool
+}
+
+// blockTx is the transaction matched in the merkleblock, the peer sends it right after
+type blockTx struct {
+	height    int32
+	expiresAt time.Time
+}
+
+// Pool keeps the wallet transactions seen in the mempool
+type Pool struct {
+	sync.Mutex
+	chain          *chain.HeaderChain
+	filter         *bloom.Filter
+	scripts        map[string]struct{}
+	coins          map[string]*coin
+	txs            map[string]*WalletTx
+	spends         map[string]string
+	requested      map[string]time.Time
+	blockTxs       map[string]*blockTx
+	OnTxAdded      func(tx *WalletTx)
+	OnTxConfirmed  func(tx *WalletTx)
+	OnTxConflicted func(tx *WalletTx)
+}
+
+// NewPool creates the pool for the wallet locking scripts, filter is loaded to the
+// peers before asking for the mempool. The header chain is used to find the height
+// of the confirming block, it can be nil.
+func NewPool(headerChain *chain.HeaderChain, filter *bloom.Filter, scripts [][]byte) *Pool {
+	p := &Pool{
+		chain:     headerChain,
+		filter:    filter,
+		scripts:   make(map[string]struct{}),
+		coins:     make(map[string]*coin),
+		txs:       make(map[string]*WalletTx),
+		spends:    make(map[string]string),
+		requested: make(map[string]time.Time),
+		blockTxs:  make(map[string]*blockTx),
+	}
+	for _, script := range scripts {
+		p.scripts[string(script)] = struct{}{}
+	}
+	return p
+}
+
+// outPointKey returns the map key of the outpoint
+func outPointKey(hash msg.Hash, index uint32) string {
+	return string(hash) + string(utils.UInt32ToByte(index))
+}
+
+// AddConfirmedCoin adds the confirmed wallet output, usually from the uxto list
+func (p *Pool) AddConfirmedCoin(hash msg.Hash, index uint32, value int64) {
+	p.Lock()
+	defer p.Unlock()
+	p.coins[outPointKey(hash, index)] = &coin{value: value, confirmed: true}
+	if p.filter != nil {
+		p.filter.AddOutPoint(hash, index)
+	}
+}
+
+// RequestMemPool loads the bloom filter to the peers and asks them for their mempool
+func (p *Pool) RequestMemPool(peers []peer.Peer) error {
+	if len(peers) == 0 {
+		return errors.New("no connected peers to request the mempool from")
+	}
+	var sent = 0
+	for _, pr := range peers {
+		if p.filter != nil {
+			err := pr.Send(p.filter.FilterLoadMsg())
+			if err != nil {
+				log.Warn("Failed to load filter to", pr.Id(), err.Error())
+				continue
+			}
+		}
+		err := pr.Send(msg.NewMemPoolMsg())
+		if err != nil {
+			log.Warn("Failed to request mempool from", pr.Id(), err.Error())
+			continue
+		}
+		sent++
+	}
+	if sent == 0 {
+		return errors.New("mempool could not be requested from any peer")
+	}
+	return nil
+}
+
+// HandleMessage processes inv, notfound, tx and merkleblock messages
+func (p *Pool) HandleMessage(pr peer.Peer, command string, payload *bytes.Reader) error {
+	switch command {
+	case msg.CmdInv:
+		inv, err := msg.DecodeInvMsg(payload)
+		if err != nil {
+			return err
+		}
+		return p.handleInv(pr, inv)
+	case msg.CmdNotFound:
+		notFound, err := msg.DecodeNotFoundMsg(payload)
+		if err != nil {
+			return err
+		}
+		p.Lock()
+		for _, item := range notFound.Items {
+			delete(p.requested, string(item.Hash))
+		}
+		p.Unlock()
+	case msg.CmdTx:
+		tx, err := msg.DecodeTxMsg(payload)
+		if err != nil {
+			return err
+		}
+		p.Lock()
+		inBlock, ok := p.blockTxs[string(tx.GetHash())]
+		delete(p.blockTxs, string(tx.GetHash()))
+		p.Unlock()
+		if ok {
+			p.AddTx(tx, inBlock.height)
+		} else {
+			p.AddTx(tx, 0)
+		}
+	case msg.CmdMerkleBlock:
+		block, err := msg.DecodeMerkleBlockMsg(payload)
+		if err != nil {
+			return err
+		}
+		return p.handleMerkleBlock(block)
+	}
+	return nil
+}
+
+func (p *Pool) handleInv(pr peer.Peer, inv *msg.InvMsg) error {
+	getData := msg.NewGetDataMsg()
+	p.Lock()
+	p.pruneRequests()
+	for _, item := range inv.Items {
+		if item.Type != msg.InvTypeTransaction {
+			continue
+		}
+		key := string(item.Hash)
+		if _, ok := p.txs[key]; ok {
+			continue
+		}
+		if _, ok := p.requested[key]; ok {
+			continue
+		}
+		p.requested[key] = time.Now().Add(requestTimeout)
+		getData.AddItem(item)
+	}
+	p.Unlock()
+	if len(getData.Items) > 0 {
+		return pr.Send(getData)
+	}
+	return nil
+}
+
+// handleMerkleBlock confirms the matched transactions, the ones we don't know
+// yet are sent by the peer right after the merkleblock
+func (p *Pool) handleMerkleBlock(block *msg.MerkleBlockMsg) error {
+	matches, err := block.ExtractMatches()
+	if err != nil {
+		return err
+	}
+	height := p.blockHeight(block.BlockHeader.Hash())
+	var confirmed []*msg.Tx
+	p.Lock()
+	p.pruneRequests()
+	for _, hash := range matches {
+		walletTx, ok := p.txs[string(hash)]
+		if !ok {
+			p.blockTxs[string(hash)] = &blockTx{height: height, expiresAt: time.Now().Add(requestTimeout)}
+			continue
+		}
+		if walletTx.State != StateConfirmed {
+			confirmed = append(confirmed, walletTx.tx)
+		}
+	}
+	p.Unlock()
+	for _, tx := range confirmed {
+		p.AddTx(tx, height)
+	}
+	return nil
+}
+
+// pruneRequests drops the requested and matched transactions the peers
+// have not sent in time, must be called with lock held
+func (p *Pool) pruneRequests() {
+	now := time.Now()
+	for key, expiresAt := range p.requested {
+		if now.After(expiresAt) {
+			delete(p.requested, key)
+		}
+	}
+	for key, inBlock := range p.blockTxs {
+		if now.After(inBlock.expiresAt) {
+			delete(p.blockTxs, key)
+		}
+	}
+}
+
+// ProcessBlock confirms the wallet transactions included in the full block,
+// use it when the block is downloaded because of the compact filter match
+func (p *Pool) ProcessBlock(block *msg.BlockMsg, height int32) {
+	for i := range block.Transactions {
+		p.AddTx(&block.Transactions[i], height)
+	}
+}
+
+// blockHeight returns the height of the block, UnknownHeight when it's not in the header chain
+func (p *Pool) blockHeight(hash msg.Hash) int32 {
+	if p.chain == nil {
+		return UnknownHeight
+	}
+	node := p.chain.NodeByHash(hash)
+	if node == nil {
+		return UnknownHeight
+	}
+	return node.Height
+}
+
+// AddTx processes the transaction, it's ignored when it doesn't pay to the wallet or
+// spend its coins. The height is 0 for the mempool transactions and UnknownHeight for the
+// confirmed ones when the block is not in the header chain. Returns true when the
+// transaction is relevant to the wallet.
+func (p *Pool) AddTx(tx *msg.Tx, height int32) bool {
+	var added, confirmed, conflicted []*WalletTx
+	p.Lock()
+	hash := tx.GetHash()
+	key := string(hash)
+	delete(p.requested, key)
+	if height != 0 {
+		delete(p.blockTxs, key)
+	}
+	walletTx, known := p.txs[key]
+	if known {
+		// the conflicted transaction can still be confirmed when the chain
+		// decided differently than the first seen rule
+		if height != 0 && walletTx.State != StateConfirmed {
+			conflicted = append(conflicted, p.confirmSpends(tx, key)...)
+			p.confirm(walletTx, height)
+			confirmed = append(confirmed, walletTx)
+		}
+		p.Unlock()
+		p.notify(added, confirmed, conflicted)
+		return true
+	}
+
+	var received, spent int64
+	for _, in := range tx.Inputs {
+		if c, ok := p.coins[outPointKey(in.PreviousOutputHash, in.PreviousIndex)]; ok {
+			spent += c.value
+		}
+	}
+	for _, out := range tx.Outputs {
+		if _, ok := p.scripts[string(out.AddressScript())]; ok {
+			received += int64(out.Value)
+		}
+	}
+	if received == 0 && spent == 0 {
+		p.Unlock()
+		return false
+	}
+	walletTx = &WalletTx{
+		TxHash:    hash.ToString(),
+		State:     StatePending,
+		Received:  received,
+		Spent:     spent,
+		FirstSeen: time.Now().Unix(),
+		tx:        tx,
+	}
+	p.txs[key] = walletTx
+	if p.filter != nil {
+		p.filter.MatchTxAndUpdate(tx)
+	}
+
+	if height != 0 {
+		conflicted = append(conflicted, p.confirmSpends(tx, key)...)
+		p.addCoins(tx, hash)
+		p.confirm(walletTx, height)
+		confirmed = append(confirmed, walletTx)
+	} else if other := p.conflictingTx(tx, key); other != nil {
+		// first seen wins until one of them is confirmed
+		walletTx.State = StateConflicted
+		walletTx.ConflictedBy = other.TxHash
+		conflicted = append(conflicted, walletTx)
+	} else {
+		p.recordSpends(tx, key)
+		p.addCoins(tx, hash)
+		added = append(added, walletTx)
+	}
+	p.Unlock()
+	p.notify(added, confirmed, conflicted)
+	return true
+}
+
+// conflictingTx returns the pending transaction spending any of the inputs of tx
+func (p *Pool) conflictingTx(tx *msg.Tx, key string) *WalletTx {
+	for _, in := range tx.Inputs {
+		other, ok := p.spends[outPointKey(in.PreviousOutputHash, in.PreviousIndex)]
+		if ok && other != key && p.txs[other].State == StatePending {
+			return p.txs[other]
+		}
+	}
+	return nil
+}
+
+func (p *Pool) recordSpends(tx *msg.Tx, key string) {
+	for _, in := range tx.Inputs {
+		p.spends[outPointKey(in.PreviousOutputHash, in.PreviousIndex)] = key
+	}
+}
+
+// addCoins adds the outputs paying to the wallet as pending coins
+func (p *Pool) addCoins(tx *msg.Tx, hash msg.Hash) {
+	for i, out := range tx.Outputs {
+		if _, ok := p.scripts[string(out.AddressScript())]; !ok {
+			continue
+		}
+		opKey := outPointKey(hash, uint32(i))
+		if _, ok := p.coins[opKey]; !ok {
+			p.coins[opKey] = &coin{value: int64(out.Value)}
+		}
+	}
+}
+
+// confirmSpends records the spends of the confirmed transaction, the pending
+// transactions spending the same coins are conflicted
+func (p *Pool) confirmSpends(tx *msg.Tx, key string) []*WalletTx {
+	var conflicted []*WalletTx
+	for _, in := range tx.Inputs {
+		opKey := outPointKey(in.PreviousOutputHash, in.PreviousIndex)
+		if other, ok := p.spends[opKey]; ok && other != key {
+			conflicted = append(conflicted, p.conflict(p.txs[other], key)...)
+		}
+		p.spends[opKey] = key
+	}
+	return conflicted
+}
+
+// conflict marks the transaction and all pending transactions spending its outputs conflicted
+func (p *Pool) conflict(walletTx *WalletTx, byKey string) []*WalletTx {
+	if walletTx.State != StatePending {
+		return nil
+	}
+	walletTx.State = StateConflicted
+	walletTx.ConflictedBy = msg.Hash(byKey).ToString()
+	conflicted := []*WalletTx{walletTx}
+	hash := walletTx.tx.GetHash()
+	for i := range walletTx.tx.Outputs {
+		opKey := outPointKey(hash, uint32(i))
+		delete(p.coins, opKey)
+		if child, ok := p.spends[opKey]; ok {
+			conflicted = append(conflicted, p.conflict(p.txs[child], byKey)...)
+		}
+	}
+	return conflicted
+}
+
+// confirm marks the transaction confirmed, its outputs become confirmed coins and the spent ones are removed
+func (p *Pool) confirm(walletTx *WalletTx, height int32) {
+	walletTx.State = StateConfirmed
+	walletTx.Height = height
+	walletTx.ConflictedBy = ""
+	hash := walletTx.tx.GetHash()
+	p.addCoins(walletTx.tx, hash)
+	for i := range walletTx.tx.Outputs {
+		if c, ok := p.coins[outPointKey(hash, uint32(i))]; ok {
+			c.confirmed = true
+		}
+	}
+	for _, in := range walletTx.tx.Inputs {
+		delete(p.coins, outPointKey(in.PreviousOutputHash, in.PreviousIndex))
+	}
+}
+
+func (p *Pool) notify(added, confirmed, conflicted []*WalletTx) {
+	for _, tx := range added {
+		log.Info("Wallet transaction", tx.TxHash, "seen in mempool")
+		if p.OnTxAdded != nil {
+			p.OnTxAdded(tx)
+		}
+	}
+	for _, tx := range confirmed {
+		log.Info("Wallet transaction", tx.TxHash, "confirmed at height", tx.Height)
+		if p.OnTxConfirmed != nil {
+			p.OnTxConfirmed(tx)
+		}
+	}
+	for _, tx := range conflicted {
+		log.Warn("Wallet transaction", tx.TxHash, "conflicted by", tx.ConflictedBy)
+		if p.OnTxConflicted != nil {
+			p.OnTxConflicted(tx)
+		}
+	}
+}
+
+// Balance returns the confirmed balance and the net amount of the pending transactions
+func (p *Pool) Balance() *Balance {
+	p.Lock()
+	defer p.Unlock()
+	balance := &Balance{}
+	for _, c := range p.coins {
+		if c.confirmed {
+			balance.Confirmed += c.value
+		}
+	}
+	for _, tx := range p.txs {
+		if tx.State == StatePending {
+			balance.Pending += tx.Received - tx.Spent
+		}
+	}
+	return balance
+}
+
+// Transactions returns the copies of the tracked transactions with the state, all when state is empty
+func (p *Pool) Transactions(state string) []*WalletTx {
+	p.Lock()
+	defer p.Unlock()
+	list := make([]*WalletTx, 0)
+	for _, tx := range p.txs {
+		if state == "" || tx.State == state {
+			cp := *tx
+			list = append(list, &cp)
+		}
+	}
+	return list
+}
+
+// Tx returns the decoded transaction, nil when it's not tracked
+func (p *Pool) Tx(hash msg.Hash) *msg.Tx {
+	p.Lock()
+	defer p.Unlock()
+	walletTx, ok := p.txs[string(hash)]
+	if !ok {
+		return nil
+	}
+	return walletTx.tx
+}
+
+// PendingTxs returns the unconfirmed transactions, used to rebuild compact blocks.
+// The pool holds only the wallet transactions (the peers relay the ones matching
+// the bloom filter), so most short ids of the block miss, see CompactBlockPolicy.
+func (p *Pool) PendingTxs() []*msg.Tx {
+	p.Lock()
+	defer p.Unlock()
+	list := make([]*msg.Tx, 0)
+	for _, tx := range p.txs {
+		if tx.State == StatePending {
+			list = append(list, tx.tx)
+		}
+	}
+	return list
+}
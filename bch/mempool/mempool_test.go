@@ -0,0 +1,219 @@
+package mempool
+
+import (
+	"bhd/bch/chain"
+	"bhd/bch/msg"
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/gcash/bchd/chaincfg"
+)
+
+var walletScript = msg.Script{0x76, 0xa9, 0x14, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18, 19, 20, 0x88, 0xac}
+
+type testPeer struct {
+	sent []msg.ProtocolPdu
+}
+
+func (p *testPeer) Id() string {
+	return "test:8333"
+}
+
+func (p *testPeer) Send(pdu msg.ProtocolPdu) error {
+	p.sent = append(p.sent, pdu)
+	return nil
+}
+
+// spendTx spends the outpoint, the value goes to the wallet when change is set
+func spendTx(prev msg.Hash, index uint32, value uint64, change bool) *msg.Tx {
+	script := msg.Script{0x51}
+	if change {
+		script = walletScript
+	}
+	return &msg.Tx{
+		Version: 2,
+		Inputs:  []msg.TxInput{{PreviousOutputHash: prev, PreviousIndex: index, UnlockingScript: msg.Script{0x51}}},
+		Outputs: []msg.TxOutput{{Value: value, LockingScript: script}},
+	}
+}
+
+// testEvents collects the pool notifications
+type testEvents struct {
+	added, confirmed, conflicted []string
+}
+
+func newTestPool(headerChain *chain.HeaderChain) (*Pool, *testEvents) {
+	pool := NewPool(headerChain, nil, [][]byte{walletScript})
+	events := &testEvents{}
+	pool.OnTxAdded = func(tx *WalletTx) { events.added = append(events.added, tx.TxHash) }
+	pool.OnTxConfirmed = func(tx *WalletTx) { events.confirmed = append(events.confirmed, tx.TxHash) }
+	pool.OnTxConflicted = func(tx *WalletTx) { events.conflicted = append(events.conflicted, tx.TxHash) }
+	return pool, events
+}
+
+func txState(pool *Pool, tx *msg.Tx) *WalletTx {
+	for _, walletTx := range pool.Transactions("") {
+		if walletTx.TxHash == tx.GetHash().ToString() {
+			return walletTx
+		}
+	}
+	return nil
+}
+
+func TestConflict(t *testing.T) {
+	pool, events := newTestPool(nil)
+	funding := msg.DoubleHashB([]byte("funding"))
+	pool.AddConfirmedCoin(funding, 0, 5000)
+
+	first := spendTx(funding, 0, 4000, true)
+	child := spendTx(first.GetHash(), 0, 3000, false)
+	second := spendTx(funding, 0, 2000, true)
+	unrelated := spendTx(msg.DoubleHashB([]byte("other")), 0, 1000, false)
+	for _, tx := range []*msg.Tx{first, child, second} {
+		if !pool.AddTx(tx, 0) {
+			t.Fatal("the wallet transaction ignored")
+		}
+	}
+	if pool.AddTx(unrelated, 0) {
+		t.Error("the unrelated transaction added")
+	}
+	// the first seen spend wins in the mempool
+	if state := txState(pool, second); state.State != StateConflicted || state.ConflictedBy != first.GetHash().ToString() {
+		t.Fatalf("expected the second spend conflicted by the first, got %+v", state)
+	}
+	if balance := pool.Balance(); balance.Confirmed != 5000 || balance.Pending != -1000-4000 {
+		t.Errorf("unexpected pending balance %+v", balance)
+	}
+
+	// the block decides for the second one, the first and its child are conflicted
+	pool.AddTx(second, 100)
+	for _, tx := range []*msg.Tx{first, child} {
+		if state := txState(pool, tx); state.State != StateConflicted || state.ConflictedBy != second.GetHash().ToString() {
+			t.Errorf("expected conflicted by the confirmed spend, got %+v", state)
+		}
+	}
+	if state := txState(pool, second); state.State != StateConfirmed || state.Height != 100 || state.ConflictedBy != "" {
+		t.Errorf("unexpected confirmed spend %+v", state)
+	}
+	if balance := pool.Balance(); balance.Confirmed != 2000 || balance.Pending != 0 {
+		t.Errorf("unexpected balance after the confirmation %+v", balance)
+	}
+	if len(events.added) != 2 || len(events.confirmed) != 1 || len(events.conflicted) != 3 {
+		t.Errorf("unexpected events %+v", events)
+	}
+}
+
+// mineBlock returns the regtest header on top of the genesis committing to the single transaction
+func mineBlock(t *testing.T, tx *msg.Tx) *msg.BlockHeader {
+	t.Helper()
+	genesis := chaincfg.RegressionNetParams.GenesisBlock.Header
+	header := &msg.BlockHeader{
+		BlockVersion:  4,
+		PrevBlockHash: append(msg.Hash{}, chaincfg.RegressionNetParams.GenesisHash[:]...),
+		MerkleRoot:    tx.GetHash(),
+		Timestamp:     uint32(genesis.Timestamp.Unix()) + 1,
+		HashTarget:    msg.NewCompressedTargetFormat(genesis.Bits),
+	}
+	for chain.CheckProofOfWork(header, chaincfg.RegressionNetParams.PowLimit) != nil {
+		header.Nonce++
+	}
+	return header
+}
+
+func TestConfirmMerkleBlock(t *testing.T) {
+	hc, err := chain.NewHeaderChain(&chaincfg.RegressionNetParams, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	payment := spendTx(msg.DoubleHashB([]byte("payer")), 0, 1000, true)
+	header := mineBlock(t, payment)
+	if _, err := hc.AddHeader(header); err != nil {
+		t.Fatal(err)
+	}
+	merkleBlock := &msg.MerkleBlockMsg{
+		BlockHeader:      *header,
+		TransactionCount: 1,
+		Hashes:           []msg.Hash{payment.GetHash()},
+		Flags:            []byte{0x01},
+	}
+
+	for _, test := range []struct {
+		name   string
+		chain  *chain.HeaderChain
+		height int32
+	}{
+		{"known block", hc, 1},
+		{"unknown block", nil, UnknownHeight},
+	} {
+		pool, events := newTestPool(test.chain)
+		p := &testPeer{}
+		inv := msg.NewInvMsg()
+		inv.AddItem(msg.NewInvVect(msg.InvTypeTransaction, payment.GetHash()))
+		for _, m := range []msg.ProtocolPdu{inv, merkleBlock, payment} {
+			if err := pool.HandleMessage(p, m.GetCommandString(), bytes.NewReader(m.Pack())); err != nil {
+				t.Fatalf("%s: %v", test.name, err)
+			}
+		}
+		if len(p.sent) != 1 {
+			t.Errorf("%s: expected the getdata, got %d messages", test.name, len(p.sent))
+		}
+		if state := txState(pool, payment); state == nil || state.State != StateConfirmed || state.Height != test.height {
+			t.Errorf("%s: expected confirmed at %d, got %+v", test.name, test.height, state)
+		}
+		if len(events.added) != 0 || len(events.confirmed) != 1 {
+			t.Errorf("%s: unexpected events %+v", test.name, events)
+		}
+		if len(pool.requested) != 0 || len(pool.blockTxs) != 0 {
+			t.Errorf("%s: the received transaction is still expected", test.name)
+		}
+		if balance := pool.Balance(); balance.Confirmed != 1000 {
+			t.Errorf("%s: unexpected balance %+v", test.name, balance)
+		}
+	}
+}
+
+func TestPruneRequests(t *testing.T) {
+	pool, _ := newTestPool(nil)
+	p := &testPeer{}
+	lost := spendTx(msg.DoubleHashB([]byte("lost")), 0, 1000, true)
+	missing := spendTx(msg.DoubleHashB([]byte("missing")), 0, 1000, true)
+	inv := msg.NewInvMsg()
+	inv.AddItem(msg.NewInvVect(msg.InvTypeTransaction, lost.GetHash()))
+	inv.AddItem(msg.NewInvVect(msg.InvTypeTransaction, missing.GetHash()))
+	if err := pool.handleInv(p, inv); err != nil {
+		t.Fatal(err)
+	}
+	merkleBlock := &msg.MerkleBlockMsg{
+		BlockHeader:      *mineBlock(t, lost),
+		TransactionCount: 1,
+		Hashes:           []msg.Hash{lost.GetHash()},
+		Flags:            []byte{0x01},
+	}
+	if err := pool.handleMerkleBlock(merkleBlock); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.requested) != 2 || len(pool.blockTxs) != 1 {
+		t.Fatalf("expected 2 requested and 1 block transaction, got %d and %d", len(pool.requested), len(pool.blockTxs))
+	}
+
+	// the peer doesn't have the missing one
+	notFound := msg.NewNotFoundMsg()
+	notFound.AddItem(msg.NewInvVect(msg.InvTypeTransaction, missing.GetHash()))
+	if err := pool.HandleMessage(p, msg.CmdNotFound, bytes.NewReader(notFound.Pack())); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pool.requested[string(missing.GetHash())]; ok {
+		t.Error("the not found transaction is still requested")
+	}
+	// the lost one is never sent, it's requested again once expired
+	pool.requested[string(lost.GetHash())] = time.Now().Add(-time.Second)
+	pool.blockTxs[string(lost.GetHash())].expiresAt = time.Now().Add(-time.Second)
+	p.sent = nil
+	if err := pool.handleInv(p, inv); err != nil {
+		t.Fatal(err)
+	}
+	if len(pool.blockTxs) != 0 || len(p.sent) != 1 || len(p.sent[0].(*msg.GetDataMsg).Items) != 2 {
+		t.Error("the expired requests not pruned")
+	}
+}
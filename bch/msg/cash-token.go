@@ -1,11 +1,46 @@
 package msg
 
+import (
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"math"
+	"strconv"
+)
+
 const (
 	TokenImmutable = 0x00
 	TokenMutable   = 0x01
 	TokenMinting   = 0x02
 )
 
+const (
+	// TokenBitReserved must be unset, it's reserved for future upgrades
+	TokenBitReserved = 0x80
+	// TokenBitHasCommitmentLength the NFT commitment follows the bitfield
+	TokenBitHasCommitmentLength = 0x40
+	// TokenBitHasNft the output holds non-fungible token, capability is in the low nibble
+	TokenBitHasNft = 0x20
+	// TokenBitHasAmount the fungible token amount follows the commitment
+	TokenBitHasAmount = 0x10
+	// tokenCapabilityMask is the low nibble of the bitfield with the NFT capability
+	tokenCapabilityMask = 0x0f
+	// MaxTokenCommitmentLength is the current consensus limit of the NFT commitment
+	MaxTokenCommitmentLength = 40
+	// MaxTokenAmount is the largest fungible token amount of one output
+	MaxTokenAmount = math.MaxInt64
+)
+
+var (
+	ErrTokenReservedBit   = errors.New("token prefix uses the reserved bit")
+	ErrTokenCapability    = errors.New("token prefix has invalid nft capability")
+	ErrTokenNoContent     = errors.New("token prefix has neither nft nor amount")
+	ErrTokenCommitment    = errors.New("token prefix has invalid commitment")
+	ErrTokenAmount        = errors.New("token prefix has invalid amount")
+	ErrTokenNotMinimal    = errors.New("token prefix uses non minimal length encoding")
+	ErrTokenMissingPrefix = errors.New("locking script doesn't start with the token prefix")
+)
+
 // CashToken (s) are ideologically similar to BEP-20 tokens found on BNB Chain or ERC-20 tokens found on Ethereum,
 // in that they allow anybody to deploy tokens that represent practically any type of asset.
 //
@@ -24,3 +59,198 @@ type CashToken struct {
 	Commitment    []byte
 	Amount        uint64
 }
+
+// NewFungibleToken creates the token holding only the fungible amount of the category
+func NewFungibleToken(category Hash, amount uint64) *CashToken {
+	return &CashToken{
+		Category:      category,
+		TokenBitField: TokenBitHasAmount,
+		Amount:        amount,
+	}
+}
+
+// NewNftToken creates the non-fungible token with the capability (TokenImmutable,
+// TokenMutable or TokenMinting) and the commitment, amount can be zero
+func NewNftToken(category Hash, capability int, commitment []byte, amount uint64) *CashToken {
+	var bitField = uint8(TokenBitHasNft) | uint8(capability&tokenCapabilityMask)
+	if len(commitment) > 0 {
+		bitField |= TokenBitHasCommitmentLength
+	}
+	if amount > 0 {
+		bitField |= TokenBitHasAmount
+	}
+	return &CashToken{
+		Category:      category,
+		TokenBitField: bitField,
+		TokenType:     capability,
+		Commitment:    commitment,
+		Amount:        amount,
+	}
+}
+
+// HasNft returns true if the output holds non-fungible token
+func (t *CashToken) HasNft() bool {
+	return t.TokenBitField&TokenBitHasNft != 0
+}
+
+// HasAmount returns true if the output holds fungible tokens
+func (t *CashToken) HasAmount() bool {
+	return t.TokenBitField&TokenBitHasAmount != 0
+}
+
+// Capability returns the NFT capability from the low nibble of the bitfield
+func (t *CashToken) Capability() int {
+	return int(t.TokenBitField & tokenCapabilityMask)
+}
+
+// Validate checks the token against the CashTokens bitfield rules and the consistency
+// of the bitfield with the commitment and amount fields
+func (t *CashToken) Validate() error {
+	if len(t.Category) != 32 {
+		return errors.New("token category must be 32 bytes")
+	}
+	var bitField = t.TokenBitField
+	if bitField&TokenBitReserved != 0 {
+		return ErrTokenReservedBit
+	}
+	if !t.HasNft() && !t.HasAmount() {
+		return ErrTokenNoContent
+	}
+	if t.Capability() > TokenMinting {
+		return ErrTokenCapability
+	}
+	if !t.HasNft() && (t.Capability() != 0 || bitField&TokenBitHasCommitmentLength != 0) {
+		return ErrTokenCapability
+	}
+	if t.HasNft() && t.TokenType != t.Capability() {
+		return ErrTokenCapability
+	}
+	var hasCommitment = bitField&TokenBitHasCommitmentLength != 0
+	if hasCommitment != (len(t.Commitment) > 0) || len(t.Commitment) > MaxTokenCommitmentLength {
+		return ErrTokenCommitment
+	}
+	if t.HasAmount() != (t.Amount > 0) || t.Amount > MaxTokenAmount {
+		return ErrTokenAmount
+	}
+	return nil
+}
+
+// Pack returns the token prefix placed in front of the locking script
+func (t *CashToken) Pack() ([]byte, error) {
+	err := t.Validate()
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	buf.WriteByte(CashTokenPrefix)
+	buf.Write(t.Category)
+	buf.WriteByte(t.TokenBitField)
+	if len(t.Commitment) > 0 {
+		buf.Write(utils.VarIntToByte(uint64(len(t.Commitment))))
+		buf.Write(t.Commitment)
+	}
+	if t.HasAmount() {
+		buf.Write(utils.VarIntToByte(t.Amount))
+	}
+	return buf.Bytes(), nil
+}
+
+// NewTokenLockingScript returns the locking script holding the token, the token
+// prefix followed by the regular locking script (e.g. pay to address)
+func NewTokenLockingScript(token *CashToken, addressScript []byte) (Script, error) {
+	prefix, err := token.Pack()
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, addressScript...), nil
+}
+
+// SetToken replaces the token of the output and rebuilds the locking script,
+// nil token removes it
+func (o *TxOutput) SetToken(token *CashToken) error {
+	addressScript := o.AddressScript()
+	if token == nil {
+		o.LockingScript = addressScript
+		o.Token = nil
+		return nil
+	}
+	script, err := NewTokenLockingScript(token, addressScript)
+	if err != nil {
+		return err
+	}
+	o.LockingScript = script
+	o.Token = token
+	return nil
+}
+
+// DecodeTokenPrefix strictly decodes the token prefix of the locking script,
+// returns the token and the rest of the locking script
+func DecodeTokenPrefix(script []byte) (*CashToken, Script, error) {
+	token, remainingBytes, err := decodeToken(script)
+	if err != nil {
+		return nil, nil, err
+	}
+	return token, script[len(script)-remainingBytes:], nil
+}
+
+// readMinimalVarInt reads the variable length integer and checks it uses the shortest encoding
+func readMinimalVarInt(reader *utils.BinaryReader) (uint64, error) {
+	var before = reader.Len()
+	val, err := reader.ReadVarInt()
+	if err != nil {
+		return 0, err
+	}
+	if before-reader.Len() != len(utils.VarIntToByte(val)) {
+		return 0, ErrTokenNotMinimal
+	}
+	return val, nil
+}
+
+// decodeToken will parse the token structure
+// as described in the https://github.com/cashtokens/cashtokens
+func decodeToken(script []byte) (*CashToken, int, error) {
+	if len(script) == 0 || script[0] != CashTokenPrefix {
+		return nil, 0, ErrTokenMissingPrefix
+	}
+	var reader = utils.NewBinaryReader(bytes.NewReader(script[1:]))
+	tokenId, err := readHash(reader)
+	if err != nil {
+		return nil, 0, err
+	}
+	tokenBitfield, err := reader.ReadUint8()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var ct = &CashToken{
+		Category:      tokenId,
+		TokenBitField: tokenBitfield,
+	}
+	if ct.HasNft() {
+		ct.TokenType = ct.Capability()
+	}
+	if tokenBitfield&TokenBitHasCommitmentLength != 0 {
+		commitmentLen, err := readMinimalVarInt(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+		if commitmentLen == 0 || commitmentLen > MaxTokenCommitmentLength {
+			return nil, 0, errors.New(ErrTokenCommitment.Error() + " length:" + strconv.FormatUint(commitmentLen, 10))
+		}
+		ct.Commitment, err = reader.ReadBytes(int(commitmentLen))
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	if ct.HasAmount() {
+		ct.Amount, err = readMinimalVarInt(reader)
+		if err != nil {
+			return nil, 0, err
+		}
+	}
+	err = ct.Validate()
+	if err != nil {
+		return nil, 0, err
+	}
+	return ct, reader.Len(), nil
+}
@@ -0,0 +1,165 @@
+package msg
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// tokenCategory is the category used by the CashTokens test vectors
+var tokenCategory = strings.Repeat("bb", 32)
+
+// tokenPrefix builds the token prefix of the test vector category
+func tokenPrefix(t *testing.T, fields string) []byte {
+	t.Helper()
+	prefix, err := hex.DecodeString("ef" + tokenCategory + fields)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return prefix
+}
+
+// validTokenPrefixes follow the valid token prefix vectors of the CashTokens
+// specification: the encoding boundaries of the amount, every capability and
+// the commitment lengths
+var validTokenPrefixes = []struct {
+	fields     string
+	nft        bool
+	capability int
+	commitment string
+	amount     uint64
+}{
+	{"1001", false, 0, "", 1},
+	{"10fc", false, 0, "", 252},
+	{"10fdfd00", false, 0, "", 253},
+	{"10fdffff", false, 0, "", 65535},
+	{"10fe00000100", false, 0, "", 65536},
+	{"10feffffffff", false, 0, "", 4294967295},
+	{"10ff0000000001000000", false, 0, "", 4294967296},
+	{"10ffffffffffffffff7f", false, 0, "", 9223372036854775807},
+	{"20", true, TokenImmutable, "", 0},
+	{"21", true, TokenMutable, "", 0},
+	{"22", true, TokenMinting, "", 0},
+	{"3001", true, TokenImmutable, "", 1},
+	{"31fc", true, TokenMutable, "", 252},
+	{"32ffffffffffffffff7f", true, TokenMinting, "", 9223372036854775807},
+	{"6001cc", true, TokenImmutable, "cc", 0},
+	{"6102cccc", true, TokenMutable, "cccc", 0},
+	{"6228" + strings.Repeat("cc", 40), true, TokenMinting, strings.Repeat("cc", 40), 0},
+	{"7001cc01", true, TokenImmutable, "cc", 1},
+	{"7102ccccfdfd00", true, TokenMutable, "cccc", 253},
+	{"7228" + strings.Repeat("cc", 40) + "ffffffffffffffff7f", true, TokenMinting, strings.Repeat("cc", 40), 9223372036854775807},
+}
+
+// invalidTokenPrefixes follow the invalid token prefix vectors of the CashTokens
+// specification, token is set for the vectors that decode to the fields
+// Validate must reject
+var invalidTokenPrefixes = []struct {
+	name   string
+	prefix string
+	token  *CashToken
+	err    error
+}{
+	{name: "no category", prefix: "ef"},
+	{name: "short category", prefix: "ef" + strings.Repeat("bb", 31)},
+	{name: "no bitfield", prefix: "ef" + tokenCategory},
+	{name: "reserved bit", prefix: "ef" + tokenCategory + "9001",
+		token: &CashToken{TokenBitField: 0x90, Amount: 1}, err: ErrTokenReservedBit},
+	{name: "reserved bit with nft", prefix: "ef" + tokenCategory + "a0",
+		token: &CashToken{TokenBitField: 0xa0}, err: ErrTokenReservedBit},
+	{name: "no nft and no amount", prefix: "ef" + tokenCategory + "00",
+		token: &CashToken{TokenBitField: 0x00}, err: ErrTokenNoContent},
+	{name: "capability without nft", prefix: "ef" + tokenCategory + "1101",
+		token: &CashToken{TokenBitField: 0x11, Amount: 1}, err: ErrTokenCapability},
+	{name: "commitment without nft", prefix: "ef" + tokenCategory + "5001cc01",
+		token: &CashToken{TokenBitField: 0x50, Commitment: []byte{0xcc}, Amount: 1}, err: ErrTokenCapability},
+	{name: "unknown capability", prefix: "ef" + tokenCategory + "23",
+		token: &CashToken{TokenBitField: 0x23, TokenType: 3}, err: ErrTokenCapability},
+	{name: "unknown capability 0x0f", prefix: "ef" + tokenCategory + "2f",
+		token: &CashToken{TokenBitField: 0x2f, TokenType: 0x0f}, err: ErrTokenCapability},
+	{name: "zero commitment length", prefix: "ef" + tokenCategory + "6000",
+		token: &CashToken{TokenBitField: 0x60, Commitment: []byte{}}, err: ErrTokenCommitment},
+	{name: "missing commitment", prefix: "ef" + tokenCategory + "6001"},
+	{name: "short commitment", prefix: "ef" + tokenCategory + "6002cc"},
+	{name: "commitment over 40 bytes", prefix: "ef" + tokenCategory + "6029" + strings.Repeat("cc", 41),
+		token: &CashToken{TokenBitField: 0x60, Commitment: bytes.Repeat([]byte{0xcc}, 41)}, err: ErrTokenCommitment},
+	{name: "non minimal commitment length", prefix: "ef" + tokenCategory + "60fd0100cc"},
+	{name: "zero amount", prefix: "ef" + tokenCategory + "1000",
+		token: &CashToken{TokenBitField: 0x10}, err: ErrTokenAmount},
+	{name: "zero amount with nft", prefix: "ef" + tokenCategory + "3000",
+		token: &CashToken{TokenBitField: 0x30}, err: ErrTokenAmount},
+	{name: "missing amount", prefix: "ef" + tokenCategory + "10"},
+	{name: "short amount", prefix: "ef" + tokenCategory + "10fd01"},
+	{name: "non minimal amount", prefix: "ef" + tokenCategory + "10fd0100"},
+	{name: "non minimal amount 0xfe", prefix: "ef" + tokenCategory + "10feffff0000"},
+	{name: "non minimal amount 0xff", prefix: "ef" + tokenCategory + "10ffffffffff00000000"},
+	{name: "amount over max", prefix: "ef" + tokenCategory + "10ff0000000000000080",
+		token: &CashToken{TokenBitField: 0x10, Amount: 1 << 63}, err: ErrTokenAmount},
+	{name: "max uint64 amount", prefix: "ef" + tokenCategory + "10ffffffffffffffffff",
+		token: &CashToken{TokenBitField: 0x10, Amount: 1<<64 - 1}, err: ErrTokenAmount},
+	{name: "wrong prefix byte", prefix: "ee" + tokenCategory + "1001"},
+}
+
+func TestTokenPrefixValidVectors(t *testing.T) {
+	category, _ := hex.DecodeString(tokenCategory)
+	p2pkh, _ := hex.DecodeString("76a914" + strings.Repeat("11", 20) + "88ac")
+	for _, vector := range validTokenPrefixes {
+		prefix := tokenPrefix(t, vector.fields)
+		token, rest, err := DecodeTokenPrefix(append(append([]byte{}, prefix...), p2pkh...))
+		if err != nil {
+			t.Errorf("%s: valid prefix rejected: %v", vector.fields, err)
+			continue
+		}
+		if !bytes.Equal(rest, p2pkh) {
+			t.Errorf("%s: expected the locking script after the prefix, got %x", vector.fields, rest)
+		}
+		commitment, _ := hex.DecodeString(vector.commitment)
+		if !token.Category.IsEqual(category) || token.HasNft() != vector.nft || token.Capability() != vector.capability ||
+			!bytes.Equal(token.Commitment, commitment) || token.Amount != vector.amount {
+			t.Errorf("%s: decoded token %+v does not match the vector", vector.fields, token)
+		}
+		if err := token.Validate(); err != nil {
+			t.Errorf("%s: decoded token is not valid: %v", vector.fields, err)
+		}
+		packed, err := token.Pack()
+		if err != nil || !bytes.Equal(packed, prefix) {
+			t.Errorf("%s: expected %x packed, got %x (%v)", vector.fields, prefix, packed, err)
+		}
+
+		// the token created by the constructors encodes the same way
+		var built *CashToken
+		if vector.nft {
+			built = NewNftToken(category, vector.capability, commitment, vector.amount)
+		} else {
+			built = NewFungibleToken(category, vector.amount)
+		}
+		if packed, err := built.Pack(); err != nil || !bytes.Equal(packed, prefix) {
+			t.Errorf("%s: expected %x from the constructor, got %x (%v)", vector.fields, prefix, packed, err)
+		}
+	}
+}
+
+func TestTokenPrefixInvalidVectors(t *testing.T) {
+	category, _ := hex.DecodeString(tokenCategory)
+	for _, vector := range invalidTokenPrefixes {
+		prefix, err := hex.DecodeString(vector.prefix)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token, _, err := DecodeTokenPrefix(prefix); err == nil {
+			t.Errorf("%s: invalid prefix decoded to %+v", vector.name, token)
+		}
+		if vector.token == nil {
+			continue
+		}
+		vector.token.Category = category
+		if err := vector.token.Validate(); !errors.Is(err, vector.err) {
+			t.Errorf("%s: expected %v from Validate, got %v", vector.name, vector.err, err)
+		}
+		if packed, err := vector.token.Pack(); err == nil {
+			t.Errorf("%s: invalid token packed to %x", vector.name, packed)
+		}
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"strings"
 	"time"
 )
@@ -37,6 +38,8 @@ var (
 	MinConnectedPeersInPool      = 24 // MinConnectedPeersInPool is the number of always connected peers in the PeerService
 	CashTokenPrefix         byte = 0xef
 	BlockFolder                  = "/blocks"
+	BcmrFolder                   = "/bcmr"
+	HeadersFile                  = "/headers.dat"
 	CacheBlocksLocally           = true
 )
 
@@ -199,6 +202,15 @@ func NewHashFromString(strHash string) (Hash, error) {
 	return Hash(hash).Reverse(), nil
 }
 
+// readHash reads 32 byte hash from the reader
+func readHash(reader *utils.BinaryReader) (Hash, error) {
+	hash, err := reader.ReadBytes(32)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read hash: %w", err)
+	}
+	return hash, nil
+}
+
 func (m Hash) MarshalJSON() ([]byte, error) {
 	// note that hash is reversed (little endian)
 	return json.Marshal(hex.EncodeToString(m.Reverse()))
@@ -209,6 +221,17 @@ func (m Hash) ToString() string {
 	return hex.EncodeToString(m.Reverse())
 }
 
+// IsEqual returns true if both hashes have the same bytes
+func (m Hash) IsEqual(other Hash) bool {
+	return bytes.Equal(m, other)
+}
+
+// Compare compares the hashes as 256 bit numbers, that's the order
+// used by the canonical transaction ordering
+func (m Hash) Compare(other Hash) int {
+	return bytes.Compare(m.Reverse(), other.Reverse())
+}
+
 // IsEmpty returns true if the hash is all zero
 func (m Hash) IsEmpty() bool {
 	var sum int = 0
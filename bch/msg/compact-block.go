@@ -0,0 +1,132 @@
+package msg
+
+import (
+	"errors"
+)
+
+var (
+	ErrShortIdCollision = errors.New("short id collision in compact block, request the full block")
+	ErrWrongBlockTxn    = errors.New("blocktxn doesn't match the missing transactions")
+)
+
+// PartialBlock rebuilds the block announced with cmpctblock. Transactions are filled
+// from the prefilled ones and the local mempool, the rest is requested with getblocktxn.
+type PartialBlock struct {
+	cmpct   *CmpctBlockMsg
+	txs     []*Tx
+	missing []uint32
+	// matched is the number of the short ids found in the mempool
+	matched int
+}
+
+// NewPartialBlock places the prefilled transactions and matches the short ids
+// with the mempool transactions
+func NewPartialBlock(cmpct *CmpctBlockMsg, mempool []*Tx) (*PartialBlock, error) {
+	count := cmpct.TransactionCount()
+	b := &PartialBlock{
+		cmpct: cmpct,
+		txs:   make([]*Tx, count),
+	}
+	for _, prefilled := range cmpct.PrefilledTx {
+		if int(prefilled.Index) >= count || b.txs[prefilled.Index] != nil {
+			return nil, errors.New("invalid prefilled transaction index")
+		}
+		b.txs[prefilled.Index] = prefilled.Tx
+	}
+	// short ids are in the order of the remaining slots
+	slots := make(map[uint64]int, len(cmpct.ShortIds))
+	var next = 0
+	for _, id := range cmpct.ShortIds {
+		for b.txs[next] != nil {
+			next++
+		}
+		if _, ok := slots[id]; ok {
+			return nil, ErrShortIdCollision
+		}
+		slots[id] = next
+		next++
+	}
+	k0, k1 := cmpct.ShortIdKeys()
+	var found = make(map[int]bool, len(mempool))
+	for _, tx := range mempool {
+		slot, ok := slots[ShortTxId(k0, k1, tx.GetHash())]
+		if !ok {
+			continue
+		}
+		if found[slot] {
+			// two mempool transactions with the same short id, we can't know which one is in the block
+			b.txs[slot] = nil
+			continue
+		}
+		found[slot] = true
+		b.txs[slot] = tx
+	}
+	for i, tx := range b.txs {
+		if tx == nil {
+			b.missing = append(b.missing, uint32(i))
+		}
+	}
+	b.matched = len(cmpct.ShortIds) - len(b.missing)
+	return b, nil
+}
+
+// HitRate returns the share of the short ids found in the mempool, 1 when
+// the block has only the prefilled transactions
+func (b *PartialBlock) HitRate() float64 {
+	if len(b.cmpct.ShortIds) == 0 {
+		return 1
+	}
+	return float64(b.matched) / float64(len(b.cmpct.ShortIds))
+}
+
+// BlockHash returns the hash of the block being rebuilt
+func (b *PartialBlock) BlockHash() Hash {
+	return b.cmpct.BlockHeader.Hash()
+}
+
+// IsComplete returns true when all transactions are known
+func (b *PartialBlock) IsComplete() bool {
+	return len(b.missing) == 0
+}
+
+// Missing returns the indexes of the transactions not found in the mempool
+func (b *PartialBlock) Missing() []uint32 {
+	return b.missing
+}
+
+// GetBlockTxnMsg returns the request for the missing transactions
+func (b *PartialBlock) GetBlockTxnMsg() *GetBlockTxnMsg {
+	return NewGetBlockTxnMsg(b.BlockHash(), b.missing)
+}
+
+// FillBlockTxn places the transactions received in blocktxn to the missing slots
+func (b *PartialBlock) FillBlockTxn(m *BlockTxnMsg) error {
+	if !m.BlockHash.IsEqual(b.BlockHash()) || len(m.Transactions) != len(b.missing) {
+		return ErrWrongBlockTxn
+	}
+	for i, index := range b.missing {
+		b.txs[index] = m.Transactions[i]
+	}
+	b.missing = nil
+	return nil
+}
+
+// Block returns the rebuilt block, the merkle root is checked so a wrong mempool
+// match (short id collision) is detected and the full block can be requested
+func (b *PartialBlock) Block() (*BlockMsg, error) {
+	if !b.IsComplete() {
+		return nil, errors.New("compact block is missing transactions")
+	}
+	block := &BlockMsg{
+		BlockHeader:      b.cmpct.BlockHeader,
+		TransactionCount: uint64(len(b.txs)),
+		Transactions:     make([]Tx, 0, len(b.txs)),
+	}
+	for _, tx := range b.txs {
+		block.Transactions = append(block.Transactions, *tx)
+	}
+	if !block.CalcMerkleRoot().IsEqual(block.MerkleRoot) {
+		return nil, ErrShortIdCollision
+	}
+	return block, nil
+}
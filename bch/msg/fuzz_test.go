@@ -0,0 +1,280 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// readSample reads the hex encoded wire sample from the testdata, the blocks
+// and the transaction are from the mainnet, the other messages were built
+// from them with the bchd wire package
+func readSample(tb testing.TB, name string) []byte {
+	tb.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name+".hex"))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	sample, err := hex.DecodeString(strings.TrimSpace(string(data)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return sample
+}
+
+func sampleTx(tb testing.TB) *Tx {
+	tb.Helper()
+	tx, err := DecodeTxMsg(bytes.NewReader(readSample(tb, "tx170")))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tx
+}
+
+func sampleBlock(tb testing.TB) *BlockMsg {
+	tb.Helper()
+	block, err := DecodeBlockMsg(bytes.NewReader(readSample(tb, "block1")))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return block
+}
+
+// fuzzDecoder runs the decoder on the seeds and the fuzzed data, the decoder
+// must not panic and must return either the message or the error
+func fuzzDecoder[T any](f *testing.F, decode func(*bytes.Reader) (*T, error), seeds ...[]byte) {
+	for _, seed := range seeds {
+		f.Add(seed)
+		// truncated samples exercise the short read paths
+		if len(seed) > 1 {
+			f.Add(seed[:len(seed)/2])
+		}
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := decode(bytes.NewReader(data))
+		if (m == nil) == (err == nil) {
+			t.Fatalf("decoder returned message %v and error %v", m, err)
+		}
+	})
+}
+
+func TestDecodeSamples(t *testing.T) {
+	tx := sampleTx(t)
+	if hash := Hash(tx.GetHash()).ToString(); hash != "f4184fc596403b9d638783cf57adfe4c75c605f6356fbc91338530e9831e9e16" {
+		t.Errorf("unexpected tx hash %s", hash)
+	}
+	block := sampleBlock(t)
+	if hash := block.BlockHeader.Hash().ToString(); hash != "00000000839a8e6886ab5951d76f411475428afc90947ee320161bbf18eb6048" {
+		t.Errorf("unexpected block hash %s", hash)
+	}
+	if !bytes.Equal(block.Pack(), readSample(t, "block1")) {
+		t.Error("block does not pack back to the sample")
+	}
+	headers, err := DecodeHeadersMsg(bytes.NewReader(readSample(t, "headers0to1")))
+	if err != nil || len(headers.Items) != 2 {
+		t.Fatalf("cannot decode the headers: %v", err)
+	}
+	if !headers.Items[1].PrevBlockHash.IsEqual(headers.Items[0].Hash()) {
+		t.Error("the headers are not linked")
+	}
+}
+
+func TestDecodeErrors(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		decode  func(*bytes.Reader) error
+		payload []byte
+		err     error
+	}{
+		{"inv count", func(r *bytes.Reader) error { _, err := DecodeInvMsg(r); return err },
+			utils.VarIntToByte(MaxInvPerMsg + 1), utils.ErrCountTooLarge},
+		{"inv items", func(r *bytes.Reader) error { _, err := DecodeInvMsg(r); return err },
+			utils.VarIntToByte(2), utils.ErrShortRead},
+		{"merkleblock hash", func(r *bytes.Reader) error { _, err := DecodeMerkleBlockMsg(r); return err },
+			readSample(t, "merkleblock1")[:BlockHeaderSize+20], utils.ErrShortRead},
+	} {
+		if err := test.decode(bytes.NewReader(test.payload)); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+}
+
+func TestDecodeTxLargeLockingScript(t *testing.T) {
+	tx := sampleTx(t)
+	tx.Outputs[0].LockingScript = bytes.Repeat([]byte{0x6a}, MaxScriptSize+1)
+	decoded, err := DecodeTxMsg(bytes.NewReader(tx.Pack()))
+	if err != nil {
+		t.Fatalf("output with %d byte locking script rejected: %v", MaxScriptSize+1, err)
+	}
+	if len(decoded.Outputs[0].LockingScript) != MaxScriptSize+1 {
+		t.Errorf("expected %d byte locking script, got %d", MaxScriptSize+1, len(decoded.Outputs[0].LockingScript))
+	}
+
+	tx.Inputs[0].UnlockingScript = bytes.Repeat([]byte{0x51}, MaxScriptSize+1)
+	if _, err := DecodeTxMsg(bytes.NewReader(tx.Pack())); err == nil {
+		t.Error("input with the oversized unlocking script accepted")
+	}
+}
+
+func FuzzDecodeTxMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeTxMsg, readSample(f, "tx170"), sampleBlock(f).Transactions[0].Pack())
+}
+
+func FuzzDecodeBlockMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeBlockMsg, readSample(f, "block0"), readSample(f, "block1"))
+}
+
+func FuzzDecodeBlockHeader(f *testing.F) {
+	fuzzDecoder(f, DecodeBlockHeader, readSample(f, "block1")[:80])
+}
+
+func FuzzDecodeHeadersMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeHeadersMsg, readSample(f, "headers0to1"))
+}
+
+func FuzzDecodeMerkleBlockMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeMerkleBlockMsg, readSample(f, "merkleblock1"))
+}
+
+func FuzzDecodeCFilterMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeCFilterMsg, readSample(f, "cfilter1"))
+}
+
+func FuzzDecodeCFHeadersMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeCFHeadersMsg, readSample(f, "cfheaders1"))
+}
+
+func FuzzDecodeCFCheckptMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeCFCheckptMsg, readSample(f, "cfcheckpt"))
+}
+
+func FuzzDecodeRejectMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeRejectMsg, readSample(f, "reject"))
+}
+
+func FuzzDecodeSendCmpctMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeSendCmpctMsg, NewSendCmpctMsg(true).Pack())
+}
+
+func FuzzDecodeCmpctBlockMsg(f *testing.F) {
+	block := sampleBlock(f)
+	m := &CmpctBlockMsg{
+		BlockHeader: block.BlockHeader,
+		Nonce:       0x0102030405060708,
+		ShortIds:    []uint64{0x0000a1a2a3a4a5a6, 0x0000b1b2b3b4b5b6},
+		PrefilledTx: []*PrefilledTx{{Index: 0, Tx: &block.Transactions[0]}, {Index: 3, Tx: sampleTx(f)}},
+	}
+	fuzzDecoder(f, DecodeCmpctBlockMsg, m.Pack())
+}
+
+func FuzzDecodeGetBlockTxnMsg(f *testing.F) {
+	fuzzDecoder(f, DecodeGetBlockTxnMsg, NewGetBlockTxnMsg(sampleBlock(f).BlockHeader.Hash(), []uint32{1, 2, 5, 300}).Pack())
+}
+
+func FuzzDecodeBlockTxnMsg(f *testing.F) {
+	m := &BlockTxnMsg{BlockHash: sampleBlock(f).BlockHeader.Hash(), Transactions: []*Tx{sampleTx(f)}}
+	fuzzDecoder(f, DecodeBlockTxnMsg, m.Pack())
+}
+
+func FuzzDecodeDsProofMsg(f *testing.F) {
+	tx := sampleTx(f)
+	spender := func(sequence uint32) *DsProofSpender {
+		return &DsProofSpender{
+			TxVersion:       tx.Version,
+			OutSequence:     sequence,
+			LockTime:        tx.LockTime,
+			HashPrevOutputs: DoubleHashB(tx.Inputs[0].PreviousOutputHash),
+			HashSequence:    DoubleHashB([]byte{0xff, 0xff, 0xff, byte(sequence)}),
+			HashOutputs:     tx.GetHash(),
+			PushData:        [][]byte{tx.Inputs[0].UnlockingScript[1:]},
+		}
+	}
+	m := &DsProofMsg{
+		PrevTxId:     tx.Inputs[0].PreviousOutputHash,
+		PrevOutIndex: tx.Inputs[0].PreviousIndex,
+		Spender1:     spender(0xfe),
+		Spender2:     spender(0xff),
+	}
+	fuzzDecoder(f, DecodeDsProofMsg, m.Pack())
+}
+
+func invSample(f *testing.F) []*InvVect {
+	return []*InvVect{
+		NewInvVect(InvTypeTransaction, sampleTx(f).GetHash()),
+		NewInvVect(InvTypeBlock, sampleBlock(f).BlockHeader.Hash()),
+	}
+}
+
+func FuzzDecodeInvMsg(f *testing.F) {
+	m := NewInvMsg()
+	for _, item := range invSample(f) {
+		m.AddItem(item)
+	}
+	fuzzDecoder(f, DecodeInvMsg, m.Pack())
+}
+
+func FuzzDecodeGetDataMsg(f *testing.F) {
+	m := NewGetDataMsg()
+	for _, item := range invSample(f) {
+		m.AddItem(item)
+	}
+	fuzzDecoder(f, DecodeGetDataMsg, m.Pack())
+}
+
+func FuzzDecodeNotFoundMsg(f *testing.F) {
+	m := NewNotFoundMsg()
+	for _, item := range invSample(f) {
+		m.AddItem(item)
+	}
+	fuzzDecoder(f, DecodeNotFoundMsg, m.Pack())
+}
+
+func FuzzDecodeGetHeadersMsg(f *testing.F) {
+	block := sampleBlock(f)
+	fuzzDecoder(f, DecodeGetHeadersMsg, NewGetHeadersMsg([]Hash{block.BlockHeader.Hash(), block.PrevBlockHash}, NewHash()).Pack())
+}
+
+func FuzzDecodeGetBlocksMsg(f *testing.F) {
+	block := sampleBlock(f)
+	m := NewGetBlocksMsg()
+	m.Items = []Hash{block.BlockHeader.Hash(), block.PrevBlockHash}
+	m.Count = uint64(len(m.Items))
+	fuzzDecoder(f, DecodeGetBlocksMsg, m.Pack())
+}
+
+func FuzzDecodeFilterLoadMsg(f *testing.F) {
+	m := &FilterLoadMsg{Filter: bytes.Repeat([]byte{0x5a}, 64), HashFuncs: 11, Tweak: 0x12345678, Flags: 1}
+	fuzzDecoder(f, DecodeFilterLoadMsg, m.Pack())
+}
+
+func FuzzDecodeFilterAddMsg(f *testing.F) {
+	m, err := NewFilterAddMsg(sampleTx(f).Outputs[0].LockingScript[1:66])
+	if err != nil {
+		f.Fatal(err)
+	}
+	fuzzDecoder(f, DecodeFilterAddMsg, m.Pack())
+}
+
+func FuzzDecodeTokenPrefix(f *testing.F) {
+	category := sampleTx(f).GetHash()
+	p2pkh := []byte{0x76, 0xa9, 0x14}
+	p2pkh = append(append(p2pkh, bytes.Repeat([]byte{0x11}, 20)...), 0x88, 0xac)
+	// fungible amount 1000 and the minting NFT with 2 byte commitment and amount 1
+	f.Add(append(append(append([]byte{CashTokenPrefix}, category...), 0x10, 0xfd, 0xe8, 0x03), p2pkh...))
+	f.Add(append(append(append([]byte{CashTokenPrefix}, category...), 0x72, 0x02, 0xca, 0xfe, 0x01), p2pkh...))
+	f.Add([]byte(sampleTx(f).Outputs[0].LockingScript))
+	f.Fuzz(func(t *testing.T, script []byte) {
+		token, rest, err := DecodeTokenPrefix(script)
+		if (token == nil) == (err == nil) {
+			t.Fatalf("decoder returned token %v and error %v", token, err)
+		}
+		if err == nil && len(rest) > len(script) {
+			t.Fatal("remaining script longer than the input")
+		}
+	})
+}
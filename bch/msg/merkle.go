@@ -0,0 +1,127 @@
+package msg
+
+import (
+	"errors"
+	"strconv"
+)
+
+// HashMerkleBranches returns the parent node of the merkle tree,
+// double sha256 of the left and right child concatenated
+func HashMerkleBranches(left Hash, right Hash) Hash {
+	var buf = make([]byte, 0, 64)
+	buf = append(buf, left...)
+	buf = append(buf, right...)
+	return DoubleHashB(buf)
+}
+
+// CalcMerkleRoot returns the merkle root of the transaction hashes, when
+// a level has odd number of nodes the last one is paired with itself
+func CalcMerkleRoot(hashes []Hash) Hash {
+	if len(hashes) == 0 {
+		return EmptyHash
+	}
+	level := make([]Hash, len(hashes))
+	copy(level, hashes)
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		next := make([]Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, HashMerkleBranches(level[i], level[i+1]))
+		}
+		level = next
+	}
+	return level[0]
+}
+
+// TxHashes returns hashes of all transactions in the block
+func (m *BlockMsg) TxHashes() []Hash {
+	hashes := make([]Hash, 0, len(m.Transactions))
+	for i := range m.Transactions {
+		hash := m.Transactions[i].TxHash
+		if hash == nil || hash.IsEmpty() {
+			hash = m.Transactions[i].GetHash()
+		}
+		hashes = append(hashes, hash)
+	}
+	return hashes
+}
+
+// CalcMerkleRoot returns the merkle root computed from the block transactions
+func (m *BlockMsg) CalcMerkleRoot() Hash {
+	return CalcMerkleRoot(m.TxHashes())
+}
+
+// MerkleProof proves that the transaction is included in the block with the
+// merkle root, Branch are the sibling hashes from the bottom of the tree up
+type MerkleProof struct {
+	TxHash     Hash   `json:"txHash"`
+	Index      uint32 `json:"index"`
+	Branch     []Hash `json:"branch"`
+	MerkleRoot Hash   `json:"merkleRoot"`
+}
+
+// BuildMerkleProof creates the proof for the transaction at the index
+func BuildMerkleProof(hashes []Hash, index int) (*MerkleProof, error) {
+	if index < 0 || index >= len(hashes) {
+		return nil, errors.New("transaction index " + strconv.Itoa(index) + " is out of range")
+	}
+	proof := &MerkleProof{
+		TxHash: hashes[index],
+		Index:  uint32(index),
+		Branch: make([]Hash, 0),
+	}
+	level := make([]Hash, len(hashes))
+	copy(level, hashes)
+	pos := index
+	for len(level) > 1 {
+		if len(level)%2 == 1 {
+			level = append(level, level[len(level)-1])
+		}
+		proof.Branch = append(proof.Branch, level[pos^1])
+		next := make([]Hash, 0, len(level)/2)
+		for i := 0; i < len(level); i += 2 {
+			next = append(next, HashMerkleBranches(level[i], level[i+1]))
+		}
+		level = next
+		pos >>= 1
+	}
+	proof.MerkleRoot = level[0]
+	return proof, nil
+}
+
+// BuildMerkleProof creates the proof for the transaction with the hash
+func (m *BlockMsg) BuildMerkleProof(txHash Hash) (*MerkleProof, error) {
+	hashes := m.TxHashes()
+	for i, hash := range hashes {
+		if hash.IsEqual(txHash) {
+			return BuildMerkleProof(hashes, i)
+		}
+	}
+	return nil, errors.New("transaction " + txHash.ToString() + " is not in the block")
+}
+
+// Verify recomputes the root from the transaction hash and the branch
+func (p *MerkleProof) Verify() bool {
+	if p.TxHash == nil || p.MerkleRoot == nil {
+		return false
+	}
+	current := p.TxHash
+	pos := p.Index
+	for _, sibling := range p.Branch {
+		if pos&1 == 1 {
+			current = HashMerkleBranches(sibling, current)
+		} else {
+			current = HashMerkleBranches(current, sibling)
+		}
+		pos >>= 1
+	}
+	// index pointing past the tree would reuse the last hashes
+	return pos == 0 && current.IsEqual(p.MerkleRoot)
+}
+
+// VerifyMerkleProof verifies the proof against the merkle root of the block header
+func VerifyMerkleProof(proof *MerkleProof, header *BlockHeader) bool {
+	return proof.MerkleRoot.IsEqual(header.MerkleRoot) && proof.Verify()
+}
@@ -0,0 +1,257 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"fmt"
+)
+
+const (
+	// FilterTypeBasic is the BIP158 basic filter, for BCH it contains the output
+	// scripts and the outpoints spent by the block transactions
+	FilterTypeBasic uint8 = 0x00
+	// MaxCFHeadersPerMsg is the maximum number of filter hashes in cfheaders
+	MaxCFHeadersPerMsg = 2000
+	// MaxGetCFiltersReqRange is the maximum number of filters requested with single getcfilters
+	MaxGetCFiltersReqRange = 1000
+	// CFCheckptInterval is the block distance between the filter header checkpoints
+	CFCheckptInterval = 1000
+	// MaxCFilterDataSize is the maximum size of the filter we are willing to decode
+	MaxCFilterDataSize = 256 * 1024 * 1024
+	// maxCFCheckpoints is the upper limit of checkpoints we accept, it's plenty for the next centuries
+	maxCFCheckpoints = 10000
+)
+
+// GetCFiltersMsg requests the compact filters (BIP157) of the blocks from start height to the
+// stop hash, peer responds with one cfilter message per block
+type GetCFiltersMsg struct {
+	FilterType  uint8
+	StartHeight uint32
+	StopHash    Hash
+}
+
+func (m *GetCFiltersMsg) GetCommandString() string {
+	return CmdGetCFilters
+}
+
+// Pack constructs the binary content of the getcfilters message
+func (m *GetCFiltersMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(utils.UInt32ToByte(m.StartHeight))
+	buf.Write(m.StopHash)
+	return buf.Bytes()
+}
+
+func NewGetCFiltersMsg(startHeight uint32, stopHash Hash) *GetCFiltersMsg {
+	return &GetCFiltersMsg{
+		FilterType:  FilterTypeBasic,
+		StartHeight: startHeight,
+		StopHash:    stopHash,
+	}
+}
+
+// CFilterMsg is the compact filter of a single block, Data is N as var int
+// followed by the golomb coded set
+type CFilterMsg struct {
+	FilterType uint8
+	BlockHash  Hash
+	Data       []byte
+}
+
+func (m *CFilterMsg) GetCommandString() string {
+	return CmdCFilter
+}
+
+// Pack constructs the binary content of the cfilter message
+func (m *CFilterMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(m.BlockHash)
+	buf.Write(utils.VarIntToByte(uint64(len(m.Data))))
+	buf.Write(m.Data)
+	return buf.Bytes()
+}
+
+func DecodeCFilterMsg(reader *bytes.Reader) (*CFilterMsg, error) {
+	var err error
+	ver := &CFilterMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.FilterType, err = r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	ver.BlockHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	ver.Data, err = r.ReadVarBytes(MaxCFilterDataSize)
+	if err != nil {
+		return nil, fmt.Errorf("cfilter data is invalid: %w", err)
+	}
+	return ver, nil
+}
+
+// GetCFHeadersMsg requests the filter hashes from start height to stop
+// hash, so we can build the filter header chain
+type GetCFHeadersMsg struct {
+	FilterType  uint8
+	StartHeight uint32
+	StopHash    Hash
+}
+
+func (m *GetCFHeadersMsg) GetCommandString() string {
+	return CmdGetCFHeaders
+}
+
+// Pack constructs the binary content of the getcfheaders message
+func (m *GetCFHeadersMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(utils.UInt32ToByte(m.StartHeight))
+	buf.Write(m.StopHash)
+	return buf.Bytes()
+}
+
+func NewGetCFHeadersMsg(startHeight uint32, stopHash Hash) *GetCFHeadersMsg {
+	return &GetCFHeadersMsg{
+		FilterType:  FilterTypeBasic,
+		StartHeight: startHeight,
+		StopHash:    stopHash,
+	}
+}
+
+// CFHeadersMsg carries the filter hashes and the filter header preceding the first one,
+// the headers are derived as dsha256(filter hash || previous filter header)
+type CFHeadersMsg struct {
+	FilterType       uint8
+	StopHash         Hash
+	PrevFilterHeader Hash
+	FilterHashes     []Hash
+}
+
+func (m *CFHeadersMsg) GetCommandString() string {
+	return CmdCFHeaders
+}
+
+// Pack constructs the binary content of the cfheaders message
+func (m *CFHeadersMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(m.StopHash)
+	buf.Write(m.PrevFilterHeader)
+	buf.Write(utils.VarIntToByte(uint64(len(m.FilterHashes))))
+	for _, hash := range m.FilterHashes {
+		buf.Write(hash)
+	}
+	return buf.Bytes()
+}
+
+func DecodeCFHeadersMsg(reader *bytes.Reader) (*CFHeadersMsg, error) {
+	var err error
+	ver := &CFHeadersMsg{
+		FilterHashes: make([]Hash, 0),
+	}
+	r := utils.NewBinaryReader(reader)
+	ver.FilterType, err = r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	ver.StopHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	ver.PrevFilterHeader, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(MaxCFHeadersPerMsg, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of filter hashes: %w", err)
+	}
+	for i := 0; i < count; i++ {
+		hash, err := readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		ver.FilterHashes = append(ver.FilterHashes, hash)
+	}
+	return ver, nil
+}
+
+// GetCFCheckptMsg requests filter headers at every 1000th block up to the stop hash
+type GetCFCheckptMsg struct {
+	FilterType uint8
+	StopHash   Hash
+}
+
+func (m *GetCFCheckptMsg) GetCommandString() string {
+	return CmdGetCFCheckpt
+}
+
+// Pack constructs the binary content of the getcfcheckpt message
+func (m *GetCFCheckptMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(m.StopHash)
+	return buf.Bytes()
+}
+
+func NewGetCFCheckptMsg(stopHash Hash) *GetCFCheckptMsg {
+	return &GetCFCheckptMsg{
+		FilterType: FilterTypeBasic,
+		StopHash:   stopHash,
+	}
+}
+
+// CFCheckptMsg has the filter headers at heights 1000, 2000, ... up to the stop hash,
+// we ask several peers and compare them before trusting any cfheaders
+type CFCheckptMsg struct {
+	FilterType    uint8
+	StopHash      Hash
+	FilterHeaders []Hash
+}
+
+func (m *CFCheckptMsg) GetCommandString() string {
+	return CmdCFCheckpt
+}
+
+// Pack constructs the binary content of the cfcheckpt message
+func (m *CFCheckptMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(m.FilterType)
+	buf.Write(m.StopHash)
+	buf.Write(utils.VarIntToByte(uint64(len(m.FilterHeaders))))
+	for _, hash := range m.FilterHeaders {
+		buf.Write(hash)
+	}
+	return buf.Bytes()
+}
+
+func DecodeCFCheckptMsg(reader *bytes.Reader) (*CFCheckptMsg, error) {
+	var err error
+	ver := &CFCheckptMsg{
+		FilterHeaders: make([]Hash, 0),
+	}
+	r := utils.NewBinaryReader(reader)
+	ver.FilterType, err = r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	ver.StopHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(maxCFCheckpoints, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of filter checkpoints: %w", err)
+	}
+	for i := 0; i < count; i++ {
+		hash, err := readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		ver.FilterHeaders = append(ver.FilterHeaders, hash)
+	}
+	return ver, nil
+}
@@ -0,0 +1,290 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/dchest/siphash"
+)
+
+const (
+	// CmpctBlockVersion is the compact block version, BCH uses version 1 (txid based short ids)
+	CmpctBlockVersion = 1
+	// ShortTxIdSize is the size of the short transaction id in bytes
+	ShortTxIdSize = 6
+	// maxCmpctBlockTxs is the upper limit of the transactions in the compact
+	// block, the largest block filled with the smallest transactions
+	maxCmpctBlockTxs = 32000000 / 65
+)
+
+// SendCmpctMsg tells the peer we want compact blocks, when Announce is
+// true the new blocks are pushed as cmpctblock instead of inv or headers
+type SendCmpctMsg struct {
+	Announce bool
+	Version  uint64
+}
+
+func (m *SendCmpctMsg) GetCommandString() string {
+	return CmdSendCmpct
+}
+
+// Pack constructs the binary content of the sendcmpct message
+func (m *SendCmpctMsg) Pack() []byte {
+	var buf bytes.Buffer
+	if m.Announce {
+		buf.WriteByte(1)
+	} else {
+		buf.WriteByte(0)
+	}
+	buf.Write(utils.UInt64ToByte(m.Version))
+	return buf.Bytes()
+}
+
+func DecodeSendCmpctMsg(reader *bytes.Reader) (*SendCmpctMsg, error) {
+	ver := &SendCmpctMsg{}
+	r := utils.NewBinaryReader(reader)
+	announce, err := r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	ver.Announce = announce != 0
+	ver.Version, err = r.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
+
+func NewSendCmpctMsg(announce bool) *SendCmpctMsg {
+	return &SendCmpctMsg{
+		Announce: announce,
+		Version:  CmpctBlockVersion,
+	}
+}
+
+// PrefilledTx is the transaction sent in full in the compact block, usually the coinbase
+type PrefilledTx struct {
+	Index uint32
+	Tx    *Tx
+}
+
+// CmpctBlockMsg is the block with transactions replaced by 6 byte short ids,
+// the receiver rebuilds the block from the transactions in its mempool
+type CmpctBlockMsg struct {
+	BlockHeader
+	Nonce       uint64
+	ShortIds    []uint64
+	PrefilledTx []*PrefilledTx
+}
+
+func (m *CmpctBlockMsg) GetCommandString() string {
+	return CmdCmpctBlock
+}
+
+// Pack constructs the binary content of the cmpctblock message
+func (m *CmpctBlockMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(m.BlockHeader.Pack())
+	buf.Write(utils.UInt64ToByte(m.Nonce))
+	buf.Write(utils.VarIntToByte(uint64(len(m.ShortIds))))
+	for _, id := range m.ShortIds {
+		buf.Write(utils.UInt64ToByte(id)[:ShortTxIdSize])
+	}
+	buf.Write(utils.VarIntToByte(uint64(len(m.PrefilledTx))))
+	var last = -1
+	for _, prefilled := range m.PrefilledTx {
+		// indexes are differentially encoded
+		buf.Write(utils.VarIntToByte(uint64(int(prefilled.Index) - last - 1)))
+		buf.Write(prefilled.Tx.Pack())
+		last = int(prefilled.Index)
+	}
+	return buf.Bytes()
+}
+
+func DecodeCmpctBlockMsg(reader *bytes.Reader) (*CmpctBlockMsg, error) {
+	header, err := DecodeBlockHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	ver := &CmpctBlockMsg{BlockHeader: *header}
+	r := utils.NewBinaryReader(reader)
+	ver.Nonce, err = r.ReadUint64()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(maxCmpctBlockTxs, ShortTxIdSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of short ids: %w", err)
+	}
+	ver.ShortIds = make([]uint64, count)
+	var idBytes = make([]byte, 8)
+	for i := range ver.ShortIds {
+		id, err := r.ReadBytes(ShortTxIdSize)
+		if err != nil {
+			return nil, err
+		}
+		copy(idBytes, id)
+		ver.ShortIds[i] = binary.LittleEndian.Uint64(idBytes)
+	}
+	count, err = r.ReadCount(maxCmpctBlockTxs-uint64(len(ver.ShortIds)), minTxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of prefilled transactions: %w", err)
+	}
+	var last int64 = -1
+	for i := 0; i < count; i++ {
+		delta, err := r.ReadVarInt()
+		if err != nil {
+			return nil, err
+		}
+		index := last + int64(delta) + 1
+		if delta > maxCmpctBlockTxs || index >= int64(len(ver.ShortIds)+count) {
+			return nil, errors.New("prefilled transaction index out of range")
+		}
+		tx, err := DecodeTxMsg(reader)
+		if err != nil {
+			return nil, err
+		}
+		ver.PrefilledTx = append(ver.PrefilledTx, &PrefilledTx{Index: uint32(index), Tx: tx})
+		last = index
+	}
+	return ver, nil
+}
+
+// TransactionCount returns the number of transactions in the block
+func (m *CmpctBlockMsg) TransactionCount() int {
+	return len(m.ShortIds) + len(m.PrefilledTx)
+}
+
+// ShortIdKeys returns the siphash keys, first two little endian
+// uint64 of sha256 of the block header followed by the nonce
+func (m *CmpctBlockMsg) ShortIdKeys() (uint64, uint64) {
+	var buf bytes.Buffer
+	buf.Write(m.BlockHeader.Pack())
+	buf.Write(utils.UInt64ToByte(m.Nonce))
+	sum := sha256.Sum256(buf.Bytes())
+	return binary.LittleEndian.Uint64(sum[0:8]), binary.LittleEndian.Uint64(sum[8:16])
+}
+
+// ShortTxId returns the 6 byte short id of the transaction hash
+func ShortTxId(k0, k1 uint64, txHash Hash) uint64 {
+	return siphash.Hash(k0, k1, txHash) & 0xffffffffffff
+}
+
+// encodeIndexes writes the differentially encoded transaction indexes
+func encodeIndexes(buf *bytes.Buffer, indexes []uint32) {
+	buf.Write(utils.VarIntToByte(uint64(len(indexes))))
+	var last = -1
+	for _, index := range indexes {
+		buf.Write(utils.VarIntToByte(uint64(int(index) - last - 1)))
+		last = int(index)
+	}
+}
+
+func decodeIndexes(reader *utils.BinaryReader) ([]uint32, error) {
+	count, err := reader.ReadCount(maxCmpctBlockTxs, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of transaction indexes: %w", err)
+	}
+	indexes := make([]uint32, 0, count)
+	var last int64 = -1
+	for i := 0; i < count; i++ {
+		delta, err := reader.ReadVarInt()
+		if err != nil {
+			return nil, err
+		}
+		index := last + int64(delta) + 1
+		if delta > maxCmpctBlockTxs || index >= maxCmpctBlockTxs {
+			return nil, errors.New("transaction index out of range")
+		}
+		indexes = append(indexes, uint32(index))
+		last = index
+	}
+	return indexes, nil
+}
+
+// GetBlockTxnMsg requests the transactions of the compact block we couldn't find in the mempool
+type GetBlockTxnMsg struct {
+	BlockHash Hash
+	Indexes   []uint32
+}
+
+func (m *GetBlockTxnMsg) GetCommandString() string {
+	return CmdGetBlockTxns
+}
+
+// Pack constructs the binary content of the getblocktxn message
+func (m *GetBlockTxnMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(m.BlockHash)
+	encodeIndexes(&buf, m.Indexes)
+	return buf.Bytes()
+}
+
+func DecodeGetBlockTxnMsg(reader *bytes.Reader) (*GetBlockTxnMsg, error) {
+	var err error
+	ver := &GetBlockTxnMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.BlockHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	ver.Indexes, err = decodeIndexes(r)
+	if err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
+
+func NewGetBlockTxnMsg(blockHash Hash, indexes []uint32) *GetBlockTxnMsg {
+	return &GetBlockTxnMsg{
+		BlockHash: blockHash,
+		Indexes:   indexes,
+	}
+}
+
+// BlockTxnMsg is the reply to getblocktxn, transactions in the requested order
+type BlockTxnMsg struct {
+	BlockHash    Hash
+	Transactions []*Tx
+}
+
+func (m *BlockTxnMsg) GetCommandString() string {
+	return CmdBlockTxns
+}
+
+// Pack constructs the binary content of the blocktxn message
+func (m *BlockTxnMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(m.BlockHash)
+	buf.Write(utils.VarIntToByte(uint64(len(m.Transactions))))
+	for _, tx := range m.Transactions {
+		buf.Write(tx.Pack())
+	}
+	return buf.Bytes()
+}
+
+func DecodeBlockTxnMsg(reader *bytes.Reader) (*BlockTxnMsg, error) {
+	var err error
+	ver := &BlockTxnMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.BlockHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(maxCmpctBlockTxs, minTxSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of block transactions: %w", err)
+	}
+	for i := 0; i < count; i++ {
+		tx, err := DecodeTxMsg(reader)
+		if err != nil {
+			return nil, err
+		}
+		ver.Transactions = append(ver.Transactions, tx)
+	}
+	return ver, nil
+}
@@ -0,0 +1,134 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"fmt"
+)
+
+const (
+	// maxDsProofPushData is the number of push data items in one spender, currently it's always 1
+	maxDsProofPushData = 16
+	// maxDsProofPushSize is the largest push we accept, the signature
+	maxDsProofPushSize = 520
+)
+
+// DsProofSpender is the part of the transaction signature preimage of one of the two
+// conflicting spends, together with the push data (the signature) it allows anyone to
+// verify that the same outpoint has been signed twice
+type DsProofSpender struct {
+	TxVersion       uint32
+	OutSequence     uint32
+	LockTime        uint32
+	HashPrevOutputs Hash
+	HashSequence    Hash
+	HashOutputs     Hash
+	PushData        [][]byte
+}
+
+func (s *DsProofSpender) pack(buf *bytes.Buffer) {
+	buf.Write(utils.UInt32ToByte(s.TxVersion))
+	buf.Write(utils.UInt32ToByte(s.OutSequence))
+	buf.Write(utils.UInt32ToByte(s.LockTime))
+	buf.Write(s.HashPrevOutputs)
+	buf.Write(s.HashSequence)
+	buf.Write(s.HashOutputs)
+	buf.Write(utils.VarIntToByte(uint64(len(s.PushData))))
+	for _, data := range s.PushData {
+		buf.Write(utils.VarIntToByte(uint64(len(data))))
+		buf.Write(data)
+	}
+}
+
+func decodeDsProofSpender(reader *utils.BinaryReader) (*DsProofSpender, error) {
+	var err error
+	s := &DsProofSpender{}
+	s.TxVersion, err = reader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	s.OutSequence, err = reader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	s.LockTime, err = reader.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	s.HashPrevOutputs, err = readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+	s.HashSequence, err = readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+	s.HashOutputs, err = readHash(reader)
+	if err != nil {
+		return nil, err
+	}
+	count, err := reader.ReadCount(maxDsProofPushData, 1)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of dsproof push data: %w", err)
+	}
+	for i := 0; i < count; i++ {
+		data, err := reader.ReadVarBytes(maxDsProofPushSize)
+		if err != nil {
+			return nil, fmt.Errorf("invalid dsproof push data: %w", err)
+		}
+		s.PushData = append(s.PushData, data)
+	}
+	return s, nil
+}
+
+// DsProofMsg is the double spend proof (dsproof-beta), nodes relay it when they see
+// two transactions spending the same outpoint. It's announced with InvTypeDblSpendProof,
+// the hash in the inv is the double sha256 of the message payload.
+type DsProofMsg struct {
+	PrevTxId     Hash
+	PrevOutIndex uint32
+	Spender1     *DsProofSpender
+	Spender2     *DsProofSpender
+}
+
+func (m *DsProofMsg) GetCommandString() string {
+	return CmdDsProof
+}
+
+// Pack constructs the binary content of the dsproof-beta message
+func (m *DsProofMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(m.PrevTxId)
+	buf.Write(utils.UInt32ToByte(m.PrevOutIndex))
+	m.Spender1.pack(&buf)
+	m.Spender2.pack(&buf)
+	return buf.Bytes()
+}
+
+// Hash returns the identifier of the proof used in inv messages
+func (m *DsProofMsg) Hash() Hash {
+	return DoubleHashB(m.Pack())
+}
+
+func DecodeDsProofMsg(reader *bytes.Reader) (*DsProofMsg, error) {
+	var err error
+	ver := &DsProofMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.PrevTxId, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	ver.PrevOutIndex, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	ver.Spender1, err = decodeDsProofSpender(r)
+	if err != nil {
+		return nil, err
+	}
+	ver.Spender2, err = decodeDsProofSpender(r)
+	if err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
@@ -0,0 +1,123 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+const (
+	// MaxFilterLoadFilterSize is the maximum size of bloom filter in bytes
+	MaxFilterLoadFilterSize = 36000
+	// MaxFilterLoadHashFuncs is the maximum number of hash functions of bloom filter
+	MaxFilterLoadHashFuncs = 50
+	// MaxFilterAddDataSize is the maximum size of the element added with filteradd
+	MaxFilterAddDataSize = 520
+)
+
+// FilterLoadMsg sets the bloom filter (BIP37) on the connection, from then on the peer
+// relays only transactions matching the filter and sends merkleblock for filtered block
+// requests. Flags are one of the BloomFilterUpdate* values.
+type FilterLoadMsg struct {
+	Filter    []byte
+	HashFuncs uint32
+	Tweak     uint32
+	Flags     uint8
+}
+
+func (m *FilterLoadMsg) GetCommandString() string {
+	return CmdFilterLoad
+}
+
+// Pack constructs the binary content of the filterload message
+func (m *FilterLoadMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.VarIntToByte(uint64(len(m.Filter))))
+	buf.Write(m.Filter)
+	buf.Write(utils.UInt32ToByte(m.HashFuncs))
+	buf.Write(utils.UInt32ToByte(m.Tweak))
+	buf.WriteByte(m.Flags)
+	return buf.Bytes()
+}
+
+func DecodeFilterLoadMsg(reader *bytes.Reader) (*FilterLoadMsg, error) {
+	var err error
+	ver := &FilterLoadMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.Filter, err = r.ReadVarBytes(MaxFilterLoadFilterSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	ver.HashFuncs, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	if ver.HashFuncs > MaxFilterLoadHashFuncs {
+		return nil, errors.New("too many filter hash functions:" + strconv.Itoa(int(ver.HashFuncs)))
+	}
+	ver.Tweak, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	ver.Flags, err = r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
+
+// FilterAddMsg adds single element to the bloom filter already loaded on the
+// connection, useful when wallet derives new address and doesn't want to reload
+// the whole filter
+type FilterAddMsg struct {
+	Data []byte
+}
+
+func (m *FilterAddMsg) GetCommandString() string {
+	return CmdFilterAdd
+}
+
+// Pack constructs the binary content of the filteradd message
+func (m *FilterAddMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.VarIntToByte(uint64(len(m.Data))))
+	buf.Write(m.Data)
+	return buf.Bytes()
+}
+
+func DecodeFilterAddMsg(reader *bytes.Reader) (*FilterAddMsg, error) {
+	var err error
+	ver := &FilterAddMsg{}
+	ver.Data, err = utils.NewBinaryReader(reader).ReadVarBytes(MaxFilterAddDataSize)
+	if err != nil {
+		return nil, fmt.Errorf("invalid filteradd data: %w", err)
+	}
+	return ver, nil
+}
+
+func NewFilterAddMsg(data []byte) (*FilterAddMsg, error) {
+	if len(data) > MaxFilterAddDataSize {
+		return nil, errors.New("filteradd data too big:" + strconv.Itoa(len(data)))
+	}
+	return &FilterAddMsg{Data: data}, nil
+}
+
+// FilterClearMsg removes the bloom filter from the connection, the
+// peer goes back to relaying all transactions
+type FilterClearMsg struct {
+}
+
+func (m *FilterClearMsg) GetCommandString() string {
+	return CmdFilterClear
+}
+
+// Pack the filterclear message has no payload
+func (m *FilterClearMsg) Pack() []byte {
+	return []byte{}
+}
+
+func NewFilterClearMsg() *FilterClearMsg {
+	return &FilterClearMsg{}
+}
@@ -7,6 +7,13 @@ import (
 	"strconv"
 )
 
+const (
+	// BlockHeaderSize is the size of the serialized block header
+	BlockHeaderSize = 80
+	// MaxBlockPayload is the upper limit of the block message size
+	MaxBlockPayload = 32000000
+)
+
 // GetBlocksMsg request the sequence of blocks that occur after a specific block. If the specified block is on the
 // server's most-work chain, the server responds with a set of up to 500 inv messages identifying the next
 // blocks on that chain. If the specified block is not on the most-work chain, the server uses block information
@@ -35,19 +42,29 @@ func (m *GetBlocksMsg) Pack() []byte {
 }
 
 func DecodeGetBlocksMsg(reader *bytes.Reader) (*GetBlocksMsg, error) {
+	var err error
 	ver := &GetBlocksMsg{}
-	ver.Count = utils.ReadVarInt(reader)
-	for i := 0; i < int(ver.Count); i++ {
-		item := NewHash()
-		n, err := reader.Read(item)
+	r := utils.NewBinaryReader(reader)
+	ver.ProtocolVersion, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(MaxLocatorHashes, 32)
+	if err != nil {
+		return nil, err
+	}
+	ver.Count = uint64(count)
+	for i := 0; i < count; i++ {
+		item, err := readHash(r)
 		if err != nil {
 			return nil, err
 		}
-		if n != 32 {
-			return nil, errors.New("hash should be 32 bytes,read:" + strconv.Itoa(n))
-		}
 		ver.Items = append(ver.Items, item)
 	}
+	ver.StopAtHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
 	return ver, nil
 }
 
@@ -65,11 +82,34 @@ func (b *GetBlocksMsg) AddBlock(hash Hash) {
 	b.Items = append(b.Items, hash)
 }
 
+// CompressedTargetFormat is the compact "nBits" representation of the
+// proof of work target. On the wire it is a little endian uint32, so the
+// three significand bytes come first and the exponent is the last byte.
 type CompressedTargetFormat struct {
 	Exponent    uint8
 	Significand [3]byte
 }
 
+// NewCompressedTargetFormat splits the compact uint32 bits into
+// exponent and significand
+func NewCompressedTargetFormat(bits uint32) CompressedTargetFormat {
+	return CompressedTargetFormat{
+		Exponent:    uint8(bits >> 24),
+		Significand: [3]byte{uint8(bits), uint8(bits >> 8), uint8(bits >> 16)},
+	}
+}
+
+// Bits returns the compact target as uint32, the same
+// value bitcoin nodes show as "bits"
+func (c CompressedTargetFormat) Bits() uint32 {
+	return uint32(c.Exponent)<<24 | uint32(c.Significand[2])<<16 | uint32(c.Significand[1])<<8 | uint32(c.Significand[0])
+}
+
+// Pack returns the 4 bytes as they are serialized in the block header
+func (c CompressedTargetFormat) Pack() []byte {
+	return utils.UInt32ToByte(c.Bits())
+}
+
 /*
 the block structure and encode / decode functions
 */
@@ -92,8 +132,7 @@ func (b *BlockHeader) Pack() []byte {
 	buf.Write(b.PrevBlockHash[:])
 	buf.Write(b.MerkleRoot[:])
 	buf.Write(utils.UInt32ToByte(b.Timestamp))
-	buf.WriteByte(b.HashTarget.Exponent)
-	buf.Write(b.HashTarget.Significand[:])
+	buf.Write(b.HashTarget.Pack())
 	buf.Write(utils.UInt32ToByte(b.Nonce))
 	return buf.Bytes()
 }
@@ -105,8 +144,7 @@ func (b *BlockHeader) Hash() Hash {
 	buf.Write(b.PrevBlockHash[:])
 	buf.Write(b.MerkleRoot[:])
 	buf.Write(utils.UInt32ToByte(b.Timestamp))
-	buf.WriteByte(b.HashTarget.Exponent)
-	buf.Write(b.HashTarget.Significand[:])
+	buf.Write(b.HashTarget.Pack())
 	buf.Write(utils.UInt32ToByte(b.Nonce))
 	return DoubleHashB(buf.Bytes())
 }
@@ -123,25 +161,20 @@ func DecodeBlockHeader(reader *bytes.Reader) (*BlockHeader, error) {
 		},
 		Nonce: 0,
 	}
-	ver.BlockVersion = int32(utils.ReadUint32(reader))
-	_, err := reader.Read(ver.PrevBlockHash[:])
-	if err != nil {
-		return nil, err
-	}
-	_, err = reader.Read(ver.MerkleRoot[:])
-	if err != nil {
-		return nil, err
-	}
-	ver.Timestamp = utils.ReadUint32(reader)
-	ver.HashTarget.Exponent, err = reader.ReadByte()
-	if err != nil {
-		return nil, err
-	}
-	_, err = reader.Read(ver.HashTarget.Significand[:])
-	if err != nil {
-		return nil, err
+	r := utils.NewBinaryReader(reader)
+	if r.Len() < BlockHeaderSize {
+		return nil, errors.New("block header should be 80 bytes,remaining:" + strconv.Itoa(r.Len()))
 	}
-	ver.Nonce = utils.ReadUint32(reader)
+	// the length is checked, the reads below can't fail
+	blockVersion, _ := r.ReadUint32()
+	ver.BlockVersion = int32(blockVersion)
+	ver.PrevBlockHash, _ = readHash(r)
+	ver.MerkleRoot, _ = readHash(r)
+	ver.Timestamp, _ = r.ReadUint32()
+	// bits are little endian, significand first
+	bits, _ := r.ReadUint32()
+	ver.HashTarget = NewCompressedTargetFormat(bits)
+	ver.Nonce, _ = r.ReadUint32()
 	return &ver, nil
 }
 
@@ -177,8 +210,12 @@ func DecodeBlockMsg(reader *bytes.Reader) (*BlockMsg, error) {
 		return nil, err
 	}
 	ver.BlockHeader = *blockHeader
-	ver.TransactionCount = utils.ReadVarInt(reader)
-	for i := 0; i < int(ver.TransactionCount); i++ {
+	count, err := utils.NewBinaryReader(reader).ReadCount(MaxBlockPayload/minTxSize, minTxSize)
+	if err != nil {
+		return nil, err
+	}
+	ver.TransactionCount = uint64(count)
+	for i := 0; i < count; i++ {
 		tx, err := DecodeTxMsg(reader)
 		if err != nil {
 			return nil, err
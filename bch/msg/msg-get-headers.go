@@ -0,0 +1,85 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"fmt"
+)
+
+// MaxLocatorHashes is the maximum number of hashes node accepts in a locator
+const MaxLocatorHashes = 101
+
+// GetHeadersMsg requests a headers message that provides block headers starting from a particular
+// point in the block chain. It has the same layout as getblocks, the node finds the first locator
+// hash that is on its best chain and responds with up to 2000 headers following it, stopping at
+// StopAtHash or the tip when stop hash is empty.
+type GetHeadersMsg struct {
+	ProtocolVersion uint32
+	Count           uint64
+	Items           []Hash
+	StopAtHash      Hash
+}
+
+func (m *GetHeadersMsg) GetCommandString() string {
+	return CmdGetHeaders
+}
+
+// Pack constructs the binary content of the getheaders message
+func (m *GetHeadersMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.UInt32ToByte(m.ProtocolVersion))
+	buf.Write(utils.VarIntToByte(uint64(len(m.Items))))
+	for _, item := range m.Items {
+		buf.Write(item[:])
+	}
+	buf.Write(m.StopAtHash[:])
+	return buf.Bytes()
+}
+
+func DecodeGetHeadersMsg(reader *bytes.Reader) (*GetHeadersMsg, error) {
+	var err error
+	ver := &GetHeadersMsg{
+		Items: make([]Hash, 0),
+	}
+	r := utils.NewBinaryReader(reader)
+	ver.ProtocolVersion, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.ReadCount(MaxLocatorHashes, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of locator hashes: %w", err)
+	}
+	ver.Count = uint64(count)
+	for i := 0; i < count; i++ {
+		item, err := readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		ver.Items = append(ver.Items, item)
+	}
+	ver.StopAtHash, err = readHash(r)
+	if err != nil {
+		return nil, err
+	}
+	return ver, nil
+}
+
+// NewGetHeadersMsg creates the request from the block locator,
+// use empty stop hash to get as many headers as the peer will send
+func NewGetHeadersMsg(locator []Hash, stopAtHash Hash) *GetHeadersMsg {
+	if stopAtHash == nil {
+		stopAtHash = EmptyHash
+	}
+	return &GetHeadersMsg{
+		ProtocolVersion: ProtocolVersion,
+		Count:           uint64(len(locator)),
+		Items:           locator,
+		StopAtHash:      stopAtHash,
+	}
+}
+
+func (m *GetHeadersMsg) AddBlock(hash Hash) {
+	m.Items = append(m.Items, hash)
+	m.Count = uint64(len(m.Items))
+}
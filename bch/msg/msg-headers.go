@@ -5,6 +5,9 @@ import (
 	"bytes"
 )
 
+// MaxHeadersPerMsg is the maximum number of headers in one headers message
+const MaxHeadersPerMsg = 2000
+
 // HeadersMsg provides a contiguous set of block headers.
 // No more than 2000 block headers may be sent at one time. Block headers in this array MUST be sequential, ordered by height and without range gaps.
 type HeadersMsg struct {
@@ -33,15 +36,23 @@ func DecodeHeadersMsg(reader *bytes.Reader) (*HeadersMsg, error) {
 		Count: 0,
 		Items: make([]*BlockHeader, 0),
 	}
-	ver.Count = utils.ReadVarInt(reader)
-	for i := 0; i < int(ver.Count); i++ {
+	r := utils.NewBinaryReader(reader)
+	count, err := r.ReadCount(MaxHeadersPerMsg, 81)
+	if err != nil {
+		return nil, err
+	}
+	ver.Count = uint64(count)
+	for i := 0; i < count; i++ {
 		item, err := DecodeBlockHeader(reader)
 		if err != nil {
 			return nil, err
 		}
 		// there's one depreceated transaction count
 		// field that must be read
-		_ = utils.ReadVarInt(reader)
+		_, err = r.ReadVarInt()
+		if err != nil {
+			return nil, err
+		}
 		ver.Items = append(ver.Items, item)
 	}
 	return ver, nil
@@ -0,0 +1,144 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"fmt"
+)
+
+// MaxInvPerMsg is the maximum number of inventory vectors in inv, getdata and notfound
+const MaxInvPerMsg = 50000
+
+// InvVect identifies the object (transaction, block, double spend proof) by its
+// type, one of the InvType* values, and its hash
+type InvVect struct {
+	Type uint32
+	Hash Hash
+}
+
+func NewInvVect(invType uint32, hash Hash) *InvVect {
+	return &InvVect{
+		Type: invType,
+		Hash: hash,
+	}
+}
+
+// invListMsg is the common layout of inv, getdata and notfound
+type invListMsg struct {
+	Items []*InvVect
+}
+
+func (m *invListMsg) pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.VarIntToByte(uint64(len(m.Items))))
+	for _, item := range m.Items {
+		buf.Write(utils.UInt32ToByte(item.Type))
+		buf.Write(item.Hash)
+	}
+	return buf.Bytes()
+}
+
+func decodeInvList(reader *bytes.Reader) ([]*InvVect, error) {
+	r := utils.NewBinaryReader(reader)
+	count, err := r.ReadCount(MaxInvPerMsg, 36)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of inventory vectors: %w", err)
+	}
+	items := make([]*InvVect, 0, count)
+	for i := 0; i < count; i++ {
+		invType, err := r.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		hash, err := readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, NewInvVect(invType, hash))
+	}
+	return items, nil
+}
+
+// AddItem adds inventory vector to the message
+func (m *invListMsg) AddItem(item *InvVect) {
+	m.Items = append(m.Items, item)
+}
+
+// InvMsg announces transactions, blocks or double spend proofs the peer has,
+// the other side asks for the ones it doesn't know with getdata
+type InvMsg struct {
+	invListMsg
+}
+
+func (m *InvMsg) GetCommandString() string {
+	return CmdInv
+}
+
+// Pack constructs the binary content of the inv message
+func (m *InvMsg) Pack() []byte {
+	return m.pack()
+}
+
+func DecodeInvMsg(reader *bytes.Reader) (*InvMsg, error) {
+	items, err := decodeInvList(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &InvMsg{invListMsg{Items: items}}, nil
+}
+
+func NewInvMsg() *InvMsg {
+	return &InvMsg{invListMsg{Items: make([]*InvVect, 0)}}
+}
+
+// GetDataMsg requests the objects announced in inv
+type GetDataMsg struct {
+	invListMsg
+}
+
+func (m *GetDataMsg) GetCommandString() string {
+	return CmdGetData
+}
+
+// Pack constructs the binary content of the getdata message
+func (m *GetDataMsg) Pack() []byte {
+	return m.pack()
+}
+
+func DecodeGetDataMsg(reader *bytes.Reader) (*GetDataMsg, error) {
+	items, err := decodeInvList(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &GetDataMsg{invListMsg{Items: items}}, nil
+}
+
+func NewGetDataMsg() *GetDataMsg {
+	return &GetDataMsg{invListMsg{Items: make([]*InvVect, 0)}}
+}
+
+// NotFoundMsg is the reply to getdata for the objects the peer doesn't have
+type NotFoundMsg struct {
+	invListMsg
+}
+
+func (m *NotFoundMsg) GetCommandString() string {
+	return CmdNotFound
+}
+
+// Pack constructs the binary content of the notfound message
+func (m *NotFoundMsg) Pack() []byte {
+	return m.pack()
+}
+
+func DecodeNotFoundMsg(reader *bytes.Reader) (*NotFoundMsg, error) {
+	items, err := decodeInvList(reader)
+	if err != nil {
+		return nil, err
+	}
+	return &NotFoundMsg{invListMsg{Items: items}}, nil
+}
+
+func NewNotFoundMsg() *NotFoundMsg {
+	return &NotFoundMsg{invListMsg{Items: make([]*InvVect, 0)}}
+}
@@ -0,0 +1,19 @@
+package msg
+
+// MemPoolMsg asks the peer to announce the transactions in its mempool with inv,
+// when the bloom filter is loaded only the matching ones are announced
+type MemPoolMsg struct {
+}
+
+func (m *MemPoolMsg) GetCommandString() string {
+	return CmdMemPool
+}
+
+// Pack the mempool message has no payload
+func (m *MemPoolMsg) Pack() []byte {
+	return []byte{}
+}
+
+func NewMemPoolMsg() *MemPoolMsg {
+	return &MemPoolMsg{}
+}
@@ -0,0 +1,156 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// MerkleBlockMsg is the reply to getdata with InvTypeFilteredBlock. It contains the block header
+// and the partial merkle tree that proves which transactions matching the loaded bloom filter are
+// in the block. The matched transactions themselves follow as separate tx messages.
+type MerkleBlockMsg struct {
+	BlockHeader
+	TransactionCount uint32
+	Hashes           []Hash
+	Flags            []byte
+}
+
+func (m *MerkleBlockMsg) GetCommandString() string {
+	return CmdMerkleBlock
+}
+
+// Pack constructs the binary content of the merkleblock message
+func (m *MerkleBlockMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(m.BlockHeader.Pack())
+	buf.Write(utils.UInt32ToByte(m.TransactionCount))
+	buf.Write(utils.VarIntToByte(uint64(len(m.Hashes))))
+	for _, hash := range m.Hashes {
+		buf.Write(hash)
+	}
+	buf.Write(utils.VarIntToByte(uint64(len(m.Flags))))
+	buf.Write(m.Flags)
+	return buf.Bytes()
+}
+
+func DecodeMerkleBlockMsg(reader *bytes.Reader) (*MerkleBlockMsg, error) {
+	blockHeader, err := DecodeBlockHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+	ver := &MerkleBlockMsg{
+		BlockHeader: *blockHeader,
+		Hashes:      make([]Hash, 0),
+	}
+	r := utils.NewBinaryReader(reader)
+	ver.TransactionCount, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
+	hashCount, err := r.ReadCount(uint64(ver.TransactionCount), 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid number of merkleblock hashes: %w", err)
+	}
+	for i := 0; i < hashCount; i++ {
+		hash, err := readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		ver.Hashes = append(ver.Hashes, hash)
+	}
+	ver.Flags, err = r.ReadVarBytes(uint64(r.Len()))
+	if err != nil {
+		return nil, fmt.Errorf("invalid merkleblock flags: %w", err)
+	}
+	return ver, nil
+}
+
+// partialMerkleTree walks the tree in depth first order as described in BIP37
+type partialMerkleTree struct {
+	txCount  uint32
+	hashes   []Hash
+	flags    []byte
+	bitsUsed int
+	hashUsed int
+	matched  []Hash
+	bad      bool
+}
+
+// width returns the number of nodes at the height of the tree
+func (t *partialMerkleTree) width(height uint) uint32 {
+	return (t.txCount + (1 << height) - 1) >> height
+}
+
+func (t *partialMerkleTree) traverse(height uint, pos uint32) Hash {
+	if t.bitsUsed >= len(t.flags)*8 {
+		t.bad = true
+		return EmptyHash
+	}
+	parentOfMatch := t.flags[t.bitsUsed/8]>>(t.bitsUsed%8)&1 == 1
+	t.bitsUsed++
+	if height == 0 || !parentOfMatch {
+		if t.hashUsed >= len(t.hashes) {
+			t.bad = true
+			return EmptyHash
+		}
+		hash := t.hashes[t.hashUsed]
+		t.hashUsed++
+		if height == 0 && parentOfMatch {
+			t.matched = append(t.matched, hash)
+		}
+		return hash
+	}
+	left := t.traverse(height-1, pos*2)
+	var right Hash
+	if pos*2+1 < t.width(height-1) {
+		right = t.traverse(height-1, pos*2+1)
+		// CVE-2012-2459, the duplicated hashes are only allowed
+		// when there is odd number of nodes
+		if right.IsEqual(left) {
+			t.bad = true
+		}
+	} else {
+		right = left
+	}
+	return HashMerkleBranches(left, right)
+}
+
+// ExtractMatches verifies the partial merkle tree against the merkle root in the
+// header and returns hashes of the transactions proven to be in the block. The
+// header itself must be verified against the header chain by the caller.
+func (m *MerkleBlockMsg) ExtractMatches() ([]Hash, error) {
+	if m.TransactionCount == 0 {
+		return nil, errors.New("merkleblock has no transactions")
+	}
+	if len(m.Hashes) > int(m.TransactionCount) {
+		return nil, errors.New("merkleblock has more hashes than transactions")
+	}
+	if len(m.Flags)*8 < len(m.Hashes) {
+		return nil, errors.New("merkleblock has less flag bits than hashes")
+	}
+	var tree = &partialMerkleTree{
+		txCount: m.TransactionCount,
+		hashes:  m.Hashes,
+		flags:   m.Flags,
+		matched: make([]Hash, 0),
+	}
+	var height uint = 0
+	for tree.width(height) > 1 {
+		height++
+	}
+	root := tree.traverse(height, 0)
+	if tree.bad {
+		return nil, errors.New("merkleblock partial merkle tree is malformed")
+	}
+	// all the hashes must be consumed and the flags can be
+	// only padded to the byte boundary
+	if tree.hashUsed != len(m.Hashes) || (tree.bitsUsed+7)/8 != len(m.Flags) {
+		return nil, errors.New("merkleblock has unused hashes or flags")
+	}
+	if !root.IsEqual(m.MerkleRoot) {
+		return nil, errors.New("merkleblock root " + root.ToString() + " does not match the header " + m.MerkleRoot.ToString())
+	}
+	return tree.matched, nil
+}
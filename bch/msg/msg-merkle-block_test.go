@@ -0,0 +1,88 @@
+package msg
+
+import (
+	"bytes"
+	"testing"
+)
+
+// the merkleblock samples: the block 100000 filtered for its transactions 1 and
+// 3 by the reference implementation, and the block 1 with no match
+func readMerkleBlock(t *testing.T, name string) *MerkleBlockMsg {
+	t.Helper()
+	raw := readSample(t, name)
+	m, err := DecodeMerkleBlockMsg(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(m.Pack(), raw) {
+		t.Fatalf("%s doesn't round-trip", name)
+	}
+	return m
+}
+
+func TestMerkleBlockExtractMatches(t *testing.T) {
+	for _, test := range []struct {
+		sample  string
+		block   string
+		matched []int
+	}{
+		{"merkleblock100000", "block100000", []int{1, 3}},
+		{"merkleblock1", "block1", nil},
+	} {
+		m := readMerkleBlock(t, test.sample)
+		block, err := DecodeBlockMsg(bytes.NewReader(readSample(t, test.block)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(m.MerkleRoot, block.MerkleRoot) || m.TransactionCount != uint32(len(block.Transactions)) {
+			t.Fatalf("%s: the sample doesn't match the block", test.sample)
+		}
+		matches, err := m.ExtractMatches()
+		if err != nil {
+			t.Errorf("%s: %v", test.sample, err)
+			continue
+		}
+		if len(matches) != len(test.matched) {
+			t.Errorf("%s: expected %d matches, got %d", test.sample, len(test.matched), len(matches))
+			continue
+		}
+		hashes := block.TxHashes()
+		for i, index := range test.matched {
+			if !bytes.Equal(matches[i], hashes[index]) {
+				t.Errorf("%s: match %d is %x", test.sample, i, matches[i])
+			}
+		}
+	}
+}
+
+func TestMerkleBlockTampered(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		tamper func(m *MerkleBlockMsg)
+	}{
+		{"changed hash", func(m *MerkleBlockMsg) {
+			m.Hashes[0] = append(Hash{}, m.Hashes[0]...)
+			m.Hashes[0][0] ^= 1
+		}},
+		{"changed root", func(m *MerkleBlockMsg) {
+			m.MerkleRoot = append(Hash{}, m.MerkleRoot...)
+			m.MerkleRoot[0] ^= 1
+		}},
+		{"missing hash", func(m *MerkleBlockMsg) { m.Hashes = m.Hashes[:len(m.Hashes)-1] }},
+		{"extra hash", func(m *MerkleBlockMsg) { m.Hashes = append(m.Hashes, m.Hashes[0]) }},
+		{"extra flags", func(m *MerkleBlockMsg) { m.Flags = append(m.Flags, 0) }},
+		{"missing flags", func(m *MerkleBlockMsg) { m.Flags = nil }},
+		{"more transactions", func(m *MerkleBlockMsg) { m.TransactionCount++ }},
+		{"no transactions", func(m *MerkleBlockMsg) { m.TransactionCount = 0 }},
+		// CVE-2012-2459, the right node can't duplicate the left one
+		{"duplicated node", func(m *MerkleBlockMsg) {
+			m.Hashes[len(m.Hashes)-1] = m.Hashes[len(m.Hashes)-2]
+		}},
+	} {
+		m := readMerkleBlock(t, "merkleblock100000")
+		test.tamper(m)
+		if matches, err := m.ExtractMatches(); err == nil {
+			t.Errorf("%s: the tampered merkleblock matched %d transactions", test.name, len(matches))
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package msg
+
+import (
+	"bhd/utils"
+	"bytes"
+)
+
+// maxRejectReasonLength is the limit of the reason text, nodes send short messages
+const maxRejectReasonLength = 256
+
+// RejectMsg informs the peer that the message was rejected, Code is one of the
+// Reject* values. For rejected tx and block the hash of the object is included.
+// Nodes are not required to send it, so lack of reject doesn't mean acceptance.
+type RejectMsg struct {
+	Message string
+	Code    uint8
+	Reason  string
+	Hash    Hash
+}
+
+func (m *RejectMsg) GetCommandString() string {
+	return CmdReject
+}
+
+// Pack constructs the binary content of the reject message
+func (m *RejectMsg) Pack() []byte {
+	var buf bytes.Buffer
+	buf.Write(utils.VarStringToByte(m.Message))
+	buf.WriteByte(m.Code)
+	buf.Write(utils.VarStringToByte(m.Reason))
+	if m.Message == CmdTx || m.Message == CmdBlock {
+		buf.Write(m.Hash)
+	}
+	return buf.Bytes()
+}
+
+func DecodeRejectMsg(reader *bytes.Reader) (*RejectMsg, error) {
+	var err error
+	ver := &RejectMsg{}
+	r := utils.NewBinaryReader(reader)
+	ver.Message, err = r.ReadVarString(MaxCommandStringLength)
+	if err != nil {
+		return nil, err
+	}
+	ver.Code, err = r.ReadUint8()
+	if err != nil {
+		return nil, err
+	}
+	ver.Reason, err = r.ReadVarString(maxRejectReasonLength)
+	if err != nil {
+		return nil, err
+	}
+	if (ver.Message == CmdTx || ver.Message == CmdBlock) && r.Len() >= 32 {
+		ver.Hash, err = readHash(r)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return ver, nil
+}
+
+// CodeString returns the reject code as text
+func (m *RejectMsg) CodeString() string {
+	switch m.Code {
+	case RejectMalformed:
+		return "malformed"
+	case RejectInvalid:
+		return "invalid"
+	case RejectObsolete:
+		return "obsolete"
+	case RejectDuplicate:
+		return "duplicate"
+	case RejectNonstandard:
+		return "nonstandard"
+	case RejectDust:
+		return "dust"
+	case RejectInsufficientFee:
+		return "insufficient fee"
+	case RejectCheckpoint:
+		return "checkpoint"
+	}
+	return "unknown"
+}
@@ -6,11 +6,28 @@ import (
 	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"math"
+
 	"github.com/gcash/bchd/chaincfg"
 	"github.com/gcash/bchd/txscript"
 	"github.com/gcash/bchutil"
 )
 
+const (
+	// MaxTxSize is the consensus limit of the transaction size
+	MaxTxSize = 1000000
+	// MaxScriptSize is the limit of the unlocking script size, the unlocking script
+	// over it fails the execution. The locking scripts have no size limit, they
+	// are bounded only by the transaction size.
+	MaxScriptSize = 10000
+	// minTxInputSize is the size of the input with empty script
+	minTxInputSize = 41
+	// minTxOutputSize is the size of the output with empty script
+	minTxOutputSize = 9
+	// minTxSize is the size of the transaction with one empty input and output
+	minTxSize = 4 + 1 + minTxInputSize + 1 + minTxOutputSize + 4
+)
+
 type Script []byte
 
 func (m Script) MarshalJSON() ([]byte, error) {
@@ -46,6 +63,19 @@ func (o *TxOutput) Size() int {
 	return 8 + len(o.LockingScript)
 }
 
+// AddressScript returns the locking script without the token
+// prefix, for regular outputs it's the whole locking script
+func (o *TxOutput) AddressScript() Script {
+	if len(o.LockingScript) == 0 || o.LockingScript[0] != CashTokenPrefix {
+		return o.LockingScript
+	}
+	_, remainingBytes, err := decodeToken(o.LockingScript)
+	if err != nil {
+		return o.LockingScript
+	}
+	return o.LockingScript[len(o.LockingScript)-remainingBytes:]
+}
+
 // Tx provides the contents of a transaction.
 type Tx struct {
 	TxHash   Hash
@@ -120,60 +150,21 @@ func (m *Tx) Pack() []byte {
 	return buf.Bytes()
 }
 
+// IsCoinBase returns true for the transaction creating new coins, it has
+// single input that spends the empty hash with index 0xffffffff
+func (m *Tx) IsCoinBase() bool {
+	if len(m.Inputs) != 1 {
+		return false
+	}
+	return m.Inputs[0].PreviousIndex == math.MaxUint32 && m.Inputs[0].PreviousOutputHash.IsEmpty()
+}
+
 // GetHash  the hash of the tx, it has to serialize
 // transaction to get its proper hash
 func (m *Tx) GetHash() Hash {
 	return DoubleHashB(m.Pack())
 }
 
-// decodeToken will parse the token structure
-// as described in the https://github.com/cashtokens/cashtokens
-func decodeToken(script []byte) (*CashToken, int, error) {
-	var reader = bytes.NewReader(script)
-	_, _ = reader.ReadByte()
-	var tokenId = NewHash()
-	_, err := reader.Read(tokenId)
-	if err != nil {
-		return nil, 0, err
-	}
-	tokenBitfield, err := reader.ReadByte()
-	if err != nil {
-		return nil, 0, err
-	}
-
-	var ct = &CashToken{
-		Category:      tokenId,
-		TokenBitField: tokenBitfield,
-	}
-	var hasCommitmentLength = tokenBitfield&0x40 == 0x040
-	var hasNft = tokenBitfield&0x20 == 0x20
-	if hasNft {
-		if tokenBitfield&0x00 == 0x00 {
-			ct.TokenType = TokenImmutable
-		}
-		if tokenBitfield&0x01 == 0x01 {
-			ct.TokenType = TokenMutable
-		}
-		if tokenBitfield&0x02 == 0x02 {
-			ct.TokenType = TokenMinting
-		}
-	}
-	var hasAmount = tokenBitfield&0x10 == 0x10
-	if hasCommitmentLength {
-		var commitmentLen = utils.ReadVarInt(reader)
-		ct.Commitment = make([]byte, commitmentLen)
-		_, err = reader.Read(ct.Commitment)
-		if err != nil {
-			return nil, 0, err
-		}
-	}
-	if hasAmount {
-		ct.Amount = utils.ReadVarInt(reader)
-	}
-
-	return ct, reader.Len(), nil
-}
-
 func DecodeTxMsg(reader *bytes.Reader) (*Tx, error) {
 	ver := &Tx{
 		Version:  0,
@@ -181,9 +172,17 @@ func DecodeTxMsg(reader *bytes.Reader) (*Tx, error) {
 		Outputs:  make([]TxOutput, 0),
 		LockTime: 0,
 	}
-	ver.Version = utils.ReadUint32(reader)
+	var err error
+	r := utils.NewBinaryReader(reader)
+	ver.Version, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
 	// read input count
-	inputCount := int(utils.ReadVarInt(reader))
+	inputCount, err := r.ReadCount(MaxTxSize/minTxInputSize, minTxInputSize)
+	if err != nil {
+		return nil, err
+	}
 	// read inputs
 	for i := 0; i < inputCount; i++ {
 		var in = TxInput{
@@ -192,20 +191,24 @@ func DecodeTxMsg(reader *bytes.Reader) (*Tx, error) {
 			UnlockingScript:    nil,
 			SequenceNumber:     0,
 		}
-		_, err := reader.Read(in.PreviousOutputHash)
+		in.PreviousOutputHash, err = readHash(r)
+		if err != nil {
+			return nil, err
+		}
+		in.PreviousIndex, err = r.ReadUint32()
+		if err != nil {
+			return nil, err
+		}
+		in.UnlockingScript, err = r.ReadVarBytes(MaxScriptSize)
 		if err != nil {
 			return nil, err
 		}
-		in.PreviousIndex = utils.ReadUint32(reader)
-		var scriptLen = utils.ReadVarInt(reader)
-		in.UnlockingScript = make([]byte, scriptLen)
-		_, err = reader.Read(in.UnlockingScript)
+		in.SequenceNumber, err = r.ReadUint32()
 		if err != nil {
 			return nil, err
 		}
-		in.SequenceNumber = utils.ReadUint32(reader)
 		// parse address
-		dataElements, err := txscript.ExtractDataElements(in.UnlockingScript)
+		dataElements, _ := txscript.ExtractDataElements(in.UnlockingScript)
 		if len(dataElements) >= 2 {
 			var pubAddress = dataElements[1]
 			addr, err := bchutil.NewAddressPubKeyHash(bchutil.Hash160(pubAddress), &chaincfg.MainNetParams)
@@ -219,16 +222,20 @@ func DecodeTxMsg(reader *bytes.Reader) (*Tx, error) {
 		ver.Inputs = append(ver.Inputs, in)
 	}
 	// read outputs
-	var outputLen = int(utils.ReadVarInt(reader))
+	outputLen, err := r.ReadCount(MaxTxSize/minTxOutputSize, minTxOutputSize)
+	if err != nil {
+		return nil, err
+	}
 	for i := 0; i < outputLen; i++ {
 		var out = TxOutput{
 			Value:         0,
 			LockingScript: nil,
 		}
-		out.Value = utils.ReadUint64(reader)
-		var scriptLen = utils.ReadVarInt(reader)
-		out.LockingScript = make([]byte, scriptLen)
-		_, err := reader.Read(out.LockingScript)
+		out.Value, err = r.ReadUint64()
+		if err != nil {
+			return nil, err
+		}
+		out.LockingScript, err = r.ReadVarBytes(MaxTxSize)
 		if err != nil {
 			return nil, err
 		}
@@ -262,7 +269,10 @@ func DecodeTxMsg(reader *bytes.Reader) (*Tx, error) {
 		}
 		ver.Outputs = append(ver.Outputs, out)
 	}
-	ver.LockTime = utils.ReadUint32(reader)
+	ver.LockTime, err = r.ReadUint32()
+	if err != nil {
+		return nil, err
+	}
 	ver.TxHash = ver.GetHash()
 	return ver, nil
 }
@@ -0,0 +1,23 @@
+// Package peer defines what the protocol services (broadcast, mempool, sync) need from
+// the connection to the bitcoin cash node. The connection itself, handshake, pings and
+// reading the messages from the socket, is done by the connection manager.
+package peer
+
+import (
+	"bhd/bch/msg"
+	"bytes"
+)
+
+// Peer is the connection to one bitcoin cash node
+type Peer interface {
+	// Id returns unique identifier of the peer, usually address:port
+	Id() string
+	// Send packs the message with msg.Pack and writes it to the connection
+	Send(pdu msg.ProtocolPdu) error
+}
+
+// MessageHandler processes the message received from the peer, command is the
+// command string from the message header and payload the message content
+type MessageHandler interface {
+	HandleMessage(p Peer, command string, payload *bytes.Reader) error
+}
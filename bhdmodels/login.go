@@ -0,0 +1,349 @@
+package bhdmodels
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gcash/bchutil"
+)
+
+/*
+	the login handshake, the client asks for the challenge, signs the login message
+	with the key of its address and gets the session token for the next requests:
+
+	LoginChallengeRequest  -> LoginChallengeResponse (nonce)
+	LoginRequest (signature, address, public key) -> LoginResponse (session token)
+
+	the next addresses are registered to the session the same way, the challenge is
+	asked for the new address and the RegisterAddressMessage is signed with its key:
+
+	LoginChallengeRequest  -> LoginChallengeResponse (nonce)
+	RegisterBchAddressRequest (session token, nonce, signature)
+*/
+
+const (
+	BhdLoginChallengeRequestType  = 21
+	BhdLoginChallengeResponseType = 22
+	BhdLoginRequestType           = 23
+	BhdLoginResponseType          = 24
+	// LoginNonceSize is the number of the random bytes of the challenge nonce
+	LoginNonceSize = 32
+	// SessionTokenSize is the number of the random bytes of the session token
+	SessionTokenSize = 32
+	// DefaultChallengeTTL is how long the client has to answer the challenge
+	DefaultChallengeTTL = 2 * time.Minute
+	// DefaultSessionTTL is how long the session token is valid
+	DefaultSessionTTL = 24 * time.Hour
+	// DefaultMaxChallenges is the limit of the outstanding challenges of all players
+	DefaultMaxChallenges = 10000
+	// DefaultMaxPlayerChallenges is the limit of the outstanding challenges of the player or the address
+	DefaultMaxPlayerChallenges = 5
+	// expireInterval is how often the expired challenges and sessions are removed
+	// when the verifier is used, the new challenge removes them every time
+	expireInterval = 10 * time.Second
+)
+
+var (
+	ErrLoginChallenge    = errors.New("unknown or expired login challenge")
+	ErrLoginSignature    = errors.New("login signature doesn't match the address")
+	ErrSession           = errors.New("unknown or expired session")
+	ErrNotAuthorized     = errors.New("the session is not authorized for the address")
+	ErrTooManyChallenges = errors.New("too many outstanding login challenges")
+)
+
+// LoginChallengeRequest asks the server for the nonce to sign
+type LoginChallengeRequest struct {
+	PlayerId   string `json:"playerId"`
+	BchAddress string `json:"bchAddress"`
+	BasePdu
+}
+
+func (r *LoginChallengeRequest) Pack() []byte {
+	return structToByte(r, BhdLoginChallengeRequestType, r.PduId)
+}
+
+// LoginChallengeResponse is the nonce the client signs, ExpiresAt is unix time
+type LoginChallengeResponse struct {
+	Nonce     string `json:"nonce"`
+	ExpiresAt int64  `json:"expiresAt"`
+	BasePdu
+}
+
+func (r *LoginChallengeResponse) Pack() []byte {
+	return structToByte(r, BhdLoginChallengeResponseType, r.PduId)
+}
+
+// LoginRequest is the answer to the challenge, Signature is the signed
+// message (base64) of the LoginMessage, PubKey is the hex public key
+type LoginRequest struct {
+	PlayerId   string `json:"playerId"`
+	BchAddress string `json:"bchAddress"`
+	PubKey     string `json:"pubKey"`
+	Nonce      string `json:"nonce"`
+	Signature  string `json:"signature"`
+	BasePdu
+}
+
+func (r *LoginRequest) Pack() []byte {
+	return structToByte(r, BhdLoginRequestType, r.PduId)
+}
+
+// LoginResponse returns the session token sent with the next requests
+type LoginResponse struct {
+	SessionToken string `json:"sessionToken"`
+	ExpiresAt    int64  `json:"expiresAt"`
+	BasePdu
+}
+
+func (r *LoginResponse) Pack() []byte {
+	return structToByte(r, BhdLoginResponseType, r.PduId)
+}
+
+// LoginMessage is the message signed by the client, it binds the nonce
+// to the player and the address
+func LoginMessage(playerId string, address string, nonce string) string {
+	return "BHD login\nplayer: " + playerId + "\naddress: " + address + "\nnonce: " + nonce
+}
+
+// RegisterAddressMessage is the message signed with the key of the address
+// added to the session, it binds the nonce to the player and the session
+func RegisterAddressMessage(playerId string, sessionToken string, address string, nonce string) string {
+	return "BHD register address\nplayer: " + playerId + "\nsession: " + sessionToken + "\naddress: " + address + "\nnonce: " + nonce
+}
+
+// Session is the authenticated player, the addresses are keyed by the hex hash160
+type Session struct {
+	Token     string
+	PlayerId  string
+	ExpiresAt time.Time
+	addresses map[string]bool
+}
+
+type loginChallenge struct {
+	playerId    string
+	addressHash []byte
+	expiresAt   time.Time
+}
+
+// LoginVerifier is the server side of the login, it issues the challenges,
+// verifies the answers and keeps the sessions. It's safe for concurrent use.
+type LoginVerifier struct {
+	sync.Mutex
+	ChallengeTTL time.Duration
+	SessionTTL   time.Duration
+	// MaxChallenges and MaxPlayerChallenges limit the outstanding challenges, of all
+	// players and of the player or the address, so the challenges can't fill the memory
+	MaxChallenges       int
+	MaxPlayerChallenges int
+	// expiredAt is the time the expired challenges and sessions were removed
+	expiredAt time.Time
+	// keyed by the nonce
+	challenges map[string]*loginChallenge
+	// keyed by the token
+	sessions map[string]*Session
+}
+
+// NewLoginVerifier creates the verifier with the default expiration times
+func NewLoginVerifier() *LoginVerifier {
+	return &LoginVerifier{
+		ChallengeTTL:        DefaultChallengeTTL,
+		SessionTTL:          DefaultSessionTTL,
+		MaxChallenges:       DefaultMaxChallenges,
+		MaxPlayerChallenges: DefaultMaxPlayerChallenges,
+		challenges:          make(map[string]*loginChallenge),
+		sessions:            make(map[string]*Session),
+	}
+}
+
+func randomHex(size int) (string, error) {
+	content := make([]byte, size)
+	_, err := rand.Read(content)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(content), nil
+}
+
+// Challenge creates the nonce the player has to sign with the key of the address, it
+// fails with ErrTooManyChallenges when the player or the address has MaxPlayerChallenges
+// unanswered ones or all players have MaxChallenges
+func (v *LoginVerifier) Challenge(rq *LoginChallengeRequest) (*LoginChallengeResponse, error) {
+	addressHash, err := AddressHash(rq.BchAddress)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := randomHex(LoginNonceSize)
+	if err != nil {
+		return nil, err
+	}
+	v.Lock()
+	defer v.Unlock()
+	v.removeExpired()
+	if len(v.challenges) >= v.MaxChallenges {
+		return nil, ErrTooManyChallenges
+	}
+	var playerPending, addressPending = 0, 0
+	for _, challenge := range v.challenges {
+		if challenge.playerId == rq.PlayerId {
+			playerPending++
+		}
+		if bytes.Equal(challenge.addressHash, addressHash) {
+			addressPending++
+		}
+	}
+	if playerPending >= v.MaxPlayerChallenges || addressPending >= v.MaxPlayerChallenges {
+		return nil, ErrTooManyChallenges
+	}
+	challenge := &loginChallenge{
+		playerId:    rq.PlayerId,
+		addressHash: addressHash,
+		expiresAt:   time.Now().Add(v.ChallengeTTL),
+	}
+	v.challenges[nonce] = challenge
+	rsp := &LoginChallengeResponse{
+		Nonce:     nonce,
+		ExpiresAt: challenge.expiresAt.Unix(),
+	}
+	rsp.PduId = rq.PduId
+	return rsp, nil
+}
+
+// Login verifies the answer to the challenge and creates the session, every
+// challenge can be answered only once
+func (v *LoginVerifier) Login(rq *LoginRequest) (*LoginResponse, error) {
+	addressHash, err := v.takeChallenge(rq.Nonce, rq.PlayerId, rq.BchAddress)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := hex.DecodeString(rq.PubKey)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(bchutil.Hash160(pubKey), addressHash) {
+		return nil, ErrLoginSignature
+	}
+	signer, err := RecoverMessagePubKey(LoginMessage(rq.PlayerId, rq.BchAddress, rq.Nonce), rq.Signature)
+	if err != nil || !bytes.Equal(signer, pubKey) {
+		return nil, ErrLoginSignature
+	}
+	token, err := randomHex(SessionTokenSize)
+	if err != nil {
+		return nil, err
+	}
+	session := &Session{
+		Token:     token,
+		PlayerId:  rq.PlayerId,
+		ExpiresAt: time.Now().Add(v.SessionTTL),
+		addresses: map[string]bool{hex.EncodeToString(addressHash): true},
+	}
+	v.Lock()
+	v.sessions[token] = session
+	v.Unlock()
+	rsp := &LoginResponse{
+		SessionToken: token,
+		ExpiresAt:    session.ExpiresAt.Unix(),
+	}
+	rsp.PduId = rq.PduId
+	return rsp, nil
+}
+
+// takeChallenge removes the challenge of the nonce and checks it was issued
+// to the player for the address, returns the hash160 of the address
+func (v *LoginVerifier) takeChallenge(nonce string, playerId string, address string) ([]byte, error) {
+	v.Lock()
+	v.expireIfDue()
+	challenge, ok := v.challenges[nonce]
+	delete(v.challenges, nonce)
+	v.Unlock()
+	if !ok || time.Now().After(challenge.expiresAt) || challenge.playerId != playerId {
+		return nil, ErrLoginChallenge
+	}
+	addressHash, err := AddressHash(address)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(addressHash, challenge.addressHash) {
+		return nil, ErrLoginChallenge
+	}
+	return addressHash, nil
+}
+
+// Authorize checks the session token of the player's request and that the
+// addresses of the request belong to the session
+func (v *LoginVerifier) Authorize(playerId string, token string, addresses ...string) (*Session, error) {
+	v.Lock()
+	defer v.Unlock()
+	v.expireIfDue()
+	session, ok := v.sessions[token]
+	if !ok || time.Now().After(session.ExpiresAt) || session.PlayerId != playerId {
+		return nil, ErrSession
+	}
+	for _, address := range addresses {
+		addressHash, err := AddressHash(address)
+		if err != nil {
+			return nil, err
+		}
+		if !session.addresses[hex.EncodeToString(addressHash)] {
+			return nil, ErrNotAuthorized
+		}
+	}
+	return session, nil
+}
+
+// RegisterAddress adds the address to the session of the request, the
+// signature of the RegisterAddressMessage proves the player owns the address,
+// the nonce is the challenge issued for the address and is used only once
+func (v *LoginVerifier) RegisterAddress(rq *RegisterBchAddressRequest) error {
+	session, err := v.Authorize(rq.PlayerId, rq.SessionToken)
+	if err != nil {
+		return err
+	}
+	addressHash, err := v.takeChallenge(rq.Nonce, rq.PlayerId, rq.BchAddress)
+	if err != nil {
+		return err
+	}
+	message := RegisterAddressMessage(rq.PlayerId, rq.SessionToken, rq.BchAddress, rq.Nonce)
+	err = VerifySignedMessage(rq.BchAddress, message, rq.Signature)
+	if err != nil {
+		return err
+	}
+	v.Lock()
+	defer v.Unlock()
+	session.addresses[hex.EncodeToString(addressHash)] = true
+	return nil
+}
+
+// Logout ends the session
+func (v *LoginVerifier) Logout(token string) {
+	v.Lock()
+	defer v.Unlock()
+	delete(v.sessions, token)
+}
+
+// removeExpired drops the expired challenges and sessions, the lock must be held
+func (v *LoginVerifier) removeExpired() {
+	now := time.Now()
+	v.expiredAt = now
+	for nonce, challenge := range v.challenges {
+		if now.After(challenge.expiresAt) {
+			delete(v.challenges, nonce)
+		}
+	}
+	for token, session := range v.sessions {
+		if now.After(session.ExpiresAt) {
+			delete(v.sessions, token)
+		}
+	}
+}
+
+// expireIfDue removes the expired challenges and sessions when they weren't
+// removed for the expireInterval, the lock must be held
+func (v *LoginVerifier) expireIfDue() {
+	if time.Since(v.expiredAt) >= expireInterval {
+		v.removeExpired()
+	}
+}
@@ -0,0 +1,216 @@
+package bhdmodels
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchutil"
+)
+
+// testKey is the key of the cash address signing the test messages
+type testKey struct {
+	private *bchec.PrivateKey
+	address string
+}
+
+func newTestKey(t *testing.T, seed byte) *testKey {
+	t.Helper()
+	private, _ := bchec.PrivKeyFromBytes(bchec.S256(), bytes.Repeat([]byte{seed}, 32))
+	addr, err := bchutil.NewAddressPubKeyHash(bchutil.Hash160(private.PubKey().SerializeCompressed()), &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testKey{private: private, address: addr.EncodeAddress()}
+}
+
+func (k *testKey) sign(t *testing.T, message string) string {
+	t.Helper()
+	hash, err := SignedMessageHash(message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig, err := bchec.SignCompact(bchec.S256(), k.private, hash, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// login creates the session of the player with the key of the first address
+func login(t *testing.T, v *LoginVerifier, playerId string, key *testKey) string {
+	t.Helper()
+	challenge, err := v.Challenge(&LoginChallengeRequest{PlayerId: playerId, BchAddress: key.address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsp, err := v.Login(&LoginRequest{
+		PlayerId:   playerId,
+		BchAddress: key.address,
+		PubKey:     hex.EncodeToString(key.private.PubKey().SerializeCompressed()),
+		Nonce:      challenge.Nonce,
+		Signature:  key.sign(t, LoginMessage(playerId, key.address, challenge.Nonce)),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return rsp.SessionToken
+}
+
+// registerRequest answers the challenge issued to the player for the address
+func registerRequest(t *testing.T, v *LoginVerifier, playerId string, token string, key *testKey) *RegisterBchAddressRequest {
+	t.Helper()
+	challenge, err := v.Challenge(&LoginChallengeRequest{PlayerId: playerId, BchAddress: key.address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq := &RegisterBchAddressRequest{
+		PlayerId:     playerId,
+		SessionToken: token,
+		BchAddress:   key.address,
+		Nonce:        challenge.Nonce,
+	}
+	rq.Signature = key.sign(t, RegisterAddressMessage(rq.PlayerId, rq.SessionToken, rq.BchAddress, rq.Nonce))
+	return rq
+}
+
+func TestRegisterAddress(t *testing.T) {
+	v := NewLoginVerifier()
+	first, second := newTestKey(t, 1), newTestKey(t, 2)
+	token := login(t, v, "player", first)
+	if _, err := v.Authorize("player", token, second.address); !errors.Is(err, ErrNotAuthorized) {
+		t.Fatalf("expected the unregistered address not authorized, got %v", err)
+	}
+	rq := registerRequest(t, v, "player", token, second)
+	if err := v.RegisterAddress(rq); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Authorize("player", token, first.address, second.address); err != nil {
+		t.Errorf("registered address not authorized: %v", err)
+	}
+
+	// the nonce is used only once
+	if err := v.RegisterAddress(rq); !errors.Is(err, ErrLoginChallenge) {
+		t.Errorf("expected ErrLoginChallenge for the replayed request, got %v", err)
+	}
+}
+
+func TestRegisterAddressRejected(t *testing.T) {
+	v := NewLoginVerifier()
+	first, second := newTestKey(t, 1), newTestKey(t, 2)
+	token := login(t, v, "player", first)
+	other := login(t, v, "other", newTestKey(t, 3))
+
+	for _, test := range []struct {
+		name   string
+		modify func(rq *RegisterBchAddressRequest)
+		err    error
+	}{
+		// the request signed for the session of the other player
+		{"other session", func(rq *RegisterBchAddressRequest) {
+			rq.SessionToken = other
+		}, ErrSession},
+		{"other player", func(rq *RegisterBchAddressRequest) {
+			rq.PlayerId = "other"
+			rq.SessionToken = other
+		}, ErrLoginChallenge},
+		{"unknown nonce", func(rq *RegisterBchAddressRequest) {
+			rq.Nonce = "00"
+		}, ErrLoginChallenge},
+		// the signature of the address alone, without the session and the nonce
+		{"address signature", func(rq *RegisterBchAddressRequest) {
+			rq.Signature = second.sign(t, rq.BchAddress)
+		}, ErrMessageSignature},
+		{"other session signature", func(rq *RegisterBchAddressRequest) {
+			rq.Signature = second.sign(t, RegisterAddressMessage(rq.PlayerId, other, rq.BchAddress, rq.Nonce))
+		}, ErrMessageSignature},
+		{"other key", func(rq *RegisterBchAddressRequest) {
+			rq.Signature = first.sign(t, RegisterAddressMessage(rq.PlayerId, rq.SessionToken, rq.BchAddress, rq.Nonce))
+		}, ErrMessageSignature},
+	} {
+		rq := registerRequest(t, v, "player", token, second)
+		test.modify(rq)
+		if err := v.RegisterAddress(rq); !errors.Is(err, test.err) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+		}
+	}
+	if _, err := v.Authorize("player", token, second.address); !errors.Is(err, ErrNotAuthorized) {
+		t.Errorf("expected the rejected address not authorized, got %v", err)
+	}
+
+	// the challenge of the other address doesn't register this one
+	rq := registerRequest(t, v, "player", token, first)
+	rq.BchAddress = second.address
+	rq.Signature = second.sign(t, RegisterAddressMessage(rq.PlayerId, rq.SessionToken, rq.BchAddress, rq.Nonce))
+	if err := v.RegisterAddress(rq); !errors.Is(err, ErrLoginChallenge) {
+		t.Errorf("expected ErrLoginChallenge for the challenge of the other address, got %v", err)
+	}
+}
+
+func TestChallengeLimits(t *testing.T) {
+	v := NewLoginVerifier()
+	v.MaxPlayerChallenges = 2
+	v.MaxChallenges = 4
+	first, second, third := newTestKey(t, 1), newTestKey(t, 2), newTestKey(t, 3)
+	for _, test := range []struct {
+		playerId string
+		key      *testKey
+		err      error
+	}{
+		{"player", first, nil},
+		{"player", second, nil},
+		// the player has the limit of the unanswered challenges
+		{"player", third, ErrTooManyChallenges},
+		// the address has the limit too, whoever asks
+		{"other", first, nil},
+		{"another", first, ErrTooManyChallenges},
+		{"other", third, nil},
+		// all players have the limit
+		{"last", newTestKey(t, 4), ErrTooManyChallenges},
+	} {
+		_, err := v.Challenge(&LoginChallengeRequest{PlayerId: test.playerId, BchAddress: test.key.address})
+		if !errors.Is(err, test.err) {
+			t.Fatalf("%s %s: expected %v, got %v", test.playerId, test.key.address, test.err, err)
+		}
+	}
+}
+
+func TestChallengeExpiry(t *testing.T) {
+	v := NewLoginVerifier()
+	v.MaxPlayerChallenges = 1
+	key := newTestKey(t, 1)
+	token := login(t, v, "player", key)
+	v.ChallengeTTL = 100 * time.Millisecond
+	if _, err := v.Challenge(&LoginChallengeRequest{PlayerId: "player", BchAddress: key.address}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Challenge(&LoginChallengeRequest{PlayerId: "player", BchAddress: key.address}); !errors.Is(err, ErrTooManyChallenges) {
+		t.Fatalf("expected ErrTooManyChallenges, got %v", err)
+	}
+	time.Sleep(v.ChallengeTTL)
+
+	// the expired challenges are removed by the other requests too, not only by the new challenge
+	if _, err := v.Authorize("player", token); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.challenges) != 1 {
+		t.Fatalf("expected the challenge kept until the expire interval, got %d", len(v.challenges))
+	}
+	v.expiredAt = time.Now().Add(-expireInterval)
+	if _, err := v.Authorize("player", token); err != nil {
+		t.Fatal(err)
+	}
+	if len(v.challenges) != 0 {
+		t.Fatalf("expected the expired challenge removed, got %d", len(v.challenges))
+	}
+
+	// the expired challenge doesn't count to the limit
+	if _, err := v.Challenge(&LoginChallengeRequest{PlayerId: "player", BchAddress: key.address}); err != nil {
+		t.Fatal(err)
+	}
+}
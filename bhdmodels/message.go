@@ -0,0 +1,89 @@
+package bhdmodels
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	// MessageMagic is the prefix of the signed messages, the same as used by
+	// the other bitcoin cash wallets, so the signature can't sign a transaction
+	MessageMagic = "Bitcoin Signed Message:\n"
+)
+
+var (
+	ErrMessageSignature = errors.New("the message isn't signed by the key of the address")
+)
+
+// SignedMessageHash returns the double sha256 of the prefixed message, the hash
+// signed by the compact recoverable signature
+func SignedMessageHash(message string) ([]byte, error) {
+	var buf bytes.Buffer
+	err := wire.WriteVarString(&buf, 0, MessageMagic)
+	if err != nil {
+		return nil, err
+	}
+	err = wire.WriteVarString(&buf, 0, message)
+	if err != nil {
+		return nil, err
+	}
+	return chainhash.DoubleHashB(buf.Bytes()), nil
+}
+
+// RecoverMessagePubKey returns the serialized public key which made the base64
+// signature of the message, compressed when the signature says so
+func RecoverMessagePubKey(message string, signature string) ([]byte, error) {
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil {
+		return nil, err
+	}
+	hash, err := SignedMessageHash(message)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, compressed, err := bchec.RecoverCompact(bchec.S256(), sig, hash)
+	if err != nil {
+		return nil, ErrMessageSignature
+	}
+	if compressed {
+		return pubKey.SerializeCompressed(), nil
+	}
+	return pubKey.SerializeUncompressed(), nil
+}
+
+// AddressHash returns the hash160 of the pay to public key hash cash address
+func AddressHash(address string) ([]byte, error) {
+	addr, err := bchutil.DecodeAddress(address, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+	pubKeyHash, ok := addr.(*bchutil.AddressPubKeyHash)
+	if !ok {
+		return nil, errors.New("only pay to public key hash addresses can sign messages")
+	}
+	return pubKeyHash.Hash160()[:], nil
+}
+
+// VerifySignedMessage checks the base64 signature of the message was made by the key
+// of the pay to public key hash address, ErrMessageSignature is returned when it wasn't
+func VerifySignedMessage(address string, message string, signature string) error {
+	addressHash, err := AddressHash(address)
+	if err != nil {
+		return err
+	}
+	pubKey, err := RecoverMessagePubKey(message, signature)
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(bchutil.Hash160(pubKey), addressHash) {
+		return ErrMessageSignature
+	}
+	return nil
+}
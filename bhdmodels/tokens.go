@@ -0,0 +1,206 @@
+package bhdmodels
+
+import (
+	"bhd/bch/msg"
+	"encoding/hex"
+	"errors"
+)
+
+const (
+	TokenCapabilityNone    = "none"
+	TokenCapabilityMutable = "mutable"
+	TokenCapabilityMinting = "minting"
+)
+
+// TokenData is the CashToken held by the output, Category is the hex string
+// in the transaction id order, Commitment is hex encoded
+type TokenData struct {
+	Category   string `json:"category"`
+	Amount     int64  `json:"amount"`
+	HasNft     bool   `json:"hasNft"`
+	Capability string `json:"capability,omitempty"`
+	Commitment string `json:"commitment,omitempty"`
+}
+
+// NewTokenData converts the parsed token prefix to the model
+func NewTokenData(token *msg.CashToken) *TokenData {
+	data := &TokenData{
+		Category: token.Category.ToString(),
+		Amount:   int64(token.Amount),
+		HasNft:   token.HasNft(),
+	}
+	if token.HasNft() {
+		switch token.Capability() {
+		case msg.TokenMutable:
+			data.Capability = TokenCapabilityMutable
+		case msg.TokenMinting:
+			data.Capability = TokenCapabilityMinting
+		default:
+			data.Capability = TokenCapabilityNone
+		}
+		data.Commitment = hex.EncodeToString(token.Commitment)
+	}
+	return data
+}
+
+// ToCashToken converts the model to the token prefix structure
+func (t *TokenData) ToCashToken() (*msg.CashToken, error) {
+	category, err := msg.NewHashFromString(t.Category)
+	if err != nil {
+		return nil, err
+	}
+	if t.Amount < 0 {
+		return nil, errors.New("token amount can't be negative")
+	}
+	var token *msg.CashToken
+	if t.HasNft {
+		commitment, err := hex.DecodeString(t.Commitment)
+		if err != nil {
+			return nil, err
+		}
+		var capability int
+		switch t.Capability {
+		case TokenCapabilityNone, "":
+			capability = msg.TokenImmutable
+		case TokenCapabilityMutable:
+			capability = msg.TokenMutable
+		case TokenCapabilityMinting:
+			capability = msg.TokenMinting
+		default:
+			return nil, errors.New("unknown token capability " + t.Capability)
+		}
+		token = msg.NewNftToken(category, capability, commitment, uint64(t.Amount))
+	} else {
+		token = msg.NewFungibleToken(category, uint64(t.Amount))
+	}
+	err = token.Validate()
+	if err != nil {
+		return nil, err
+	}
+	return token, nil
+}
+
+// Prefix returns the token prefix of the locking script
+func (t *TokenData) Prefix() ([]byte, error) {
+	token, err := t.ToCashToken()
+	if err != nil {
+		return nil, err
+	}
+	return token.Pack()
+}
+
+// SplitTokenScript splits the locking script to the token and the address
+// script, the token is nil for the regular outputs
+func SplitTokenScript(script []byte) (*TokenData, []byte, error) {
+	if len(script) == 0 || script[0] != msg.CashTokenPrefix {
+		return nil, script, nil
+	}
+	token, addressScript, err := msg.DecodeTokenPrefix(script)
+	if err != nil {
+		return nil, nil, err
+	}
+	return NewTokenData(token), addressScript, nil
+}
+
+// tokenLockingScript returns the full locking script, the token prefix followed
+// by the address script. When the script already has the prefix it's kept as is.
+func tokenLockingScript(pkScript string, token *TokenData) ([]byte, error) {
+	script, err := hex.DecodeString(pkScript)
+	if err != nil {
+		return nil, err
+	}
+	if token == nil || (len(script) > 0 && script[0] == msg.CashTokenPrefix) {
+		return script, nil
+	}
+	prefix, err := token.Prefix()
+	if err != nil {
+		return nil, err
+	}
+	return append(prefix, script...), nil
+}
+
+// LockingScript returns the locking script of the output including the token prefix
+func (o *TxOut) LockingScript() ([]byte, error) {
+	return tokenLockingScript(o.PkScript, o.Token)
+}
+
+// LockingScript returns the locking script of the output spent by the input
+// including the token prefix
+func (in *TxIn) LockingScript() ([]byte, error) {
+	return tokenLockingScript(in.PubScript, in.Token)
+}
+
+// SpentScript returns the address script and the token prefix of the output spent
+// by the input, the prefix is nil for the regular outputs
+func (in *TxIn) SpentScript() ([]byte, []byte, error) {
+	script, err := hex.DecodeString(in.PubScript)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(script) > 0 && script[0] == msg.CashTokenPrefix {
+		_, addressScript, err := msg.DecodeTokenPrefix(script)
+		if err != nil {
+			return nil, nil, err
+		}
+		return addressScript, script[:len(script)-len(addressScript)], nil
+	}
+	if in.Token == nil {
+		return script, nil, nil
+	}
+	prefix, err := in.Token.Prefix()
+	if err != nil {
+		return nil, nil, err
+	}
+	return script, prefix, nil
+}
+
+// TokenTransferRequest sends fungible tokens of the category and/or the NFT held
+// by the uxto NftHash:NftIndex to the destination address, FeeRate is in
+// satoshis per byte, the default is used when zero
+type TokenTransferRequest struct {
+	DestinationAddress string `json:"destinationAddress"`
+	Category           string `json:"category"`
+	Amount             int64  `json:"amount"`
+	NftHash            string `json:"nftHash,omitempty"`
+	NftIndex           int32  `json:"nftIndex"`
+	FeeRate            int64  `json:"feeRate"`
+}
+
+// TokenGenesisRequest creates the new token category. Amount is the whole fungible
+// supply, it can't be minted later. When Capability is set the genesis output also
+// holds the NFT with the Commitment, use TokenCapabilityMinting to mint more NFTs.
+// The output goes to the wallet when DestinationAddress is empty.
+type TokenGenesisRequest struct {
+	DestinationAddress string `json:"destinationAddress,omitempty"`
+	Amount             int64  `json:"amount"`
+	Capability         string `json:"capability,omitempty"`
+	Commitment         string `json:"commitment,omitempty"`
+	FeeRate            int64  `json:"feeRate"`
+}
+
+// NftMint is the NFT created by the minting NFT
+type NftMint struct {
+	DestinationAddress string `json:"destinationAddress,omitempty"`
+	Capability         string `json:"capability"`
+	Commitment         string `json:"commitment"`
+}
+
+// TokenMintRequest mints the NFTs of the category with the minting NFT held
+// by the uxto MintingHash:MintingIndex, the minting NFT returns to the wallet
+type TokenMintRequest struct {
+	Category     string     `json:"category"`
+	MintingHash  string     `json:"mintingHash"`
+	MintingIndex int32      `json:"mintingIndex"`
+	Nfts         []*NftMint `json:"nfts"`
+	FeeRate      int64      `json:"feeRate"`
+}
+
+// TokenCommitmentRequest replaces the commitment of the mutable (or minting)
+// NFT held by the uxto NftHash:NftIndex, the NFT stays in the wallet
+type TokenCommitmentRequest struct {
+	Category   string `json:"category"`
+	NftHash    string `json:"nftHash"`
+	NftIndex   int32  `json:"nftIndex"`
+	Commitment string `json:"commitment"`
+	FeeRate    int64  `json:"feeRate"`
+}
@@ -37,21 +37,25 @@ const (
 */
 
 type TxIn struct {
-	Sequence  uint32 `json:"sequence"`
-	Value     int64  `json:"value"`
-	PrevHash  string `json:"prevHash"`
-	PrevIndex uint32 `json:"prevIndex"`
-	PubScript string `json:"pubScript"`
-	Signature string `json:"signature"`
+	Sequence  uint32     `json:"sequence"`
+	Value     int64      `json:"value"`
+	PrevHash  string     `json:"prevHash"`
+	PrevIndex uint32     `json:"prevIndex"`
+	PubScript string     `json:"pubScript"`
+	Signature string     `json:"signature"`
+	Token     *TokenData `json:"token,omitempty"`
+	// HashType is the signature hash type of the input, zero is SIGHASH_ALL
+	HashType uint32 `json:"hashType,omitempty"`
 }
 
 type TxOut struct {
-	Value      int64  `json:"value"`
-	Spent      bool   `json:"spent"`
-	PkScript   string `json:"pkScript"`
-	Address    string `json:"address"`
-	IsCashback bool   `json:"-"`
-	AddressRaw []byte `json:"-"`
+	Value      int64      `json:"value"`
+	Spent      bool       `json:"spent"`
+	PkScript   string     `json:"pkScript"`
+	Address    string     `json:"address"`
+	Token      *TokenData `json:"token,omitempty"`
+	IsCashback bool       `json:"-"`
+	AddressRaw []byte     `json:"-"`
 }
 
 type Tx struct {
@@ -170,7 +174,7 @@ func ToBCHDWireFormat(tx *Tx) (*wire.MsgTx, error) {
 	}
 	// outputs
 	for _, txOut := range tx.Outputs {
-		payScript, err := hex.DecodeString(txOut.PkScript)
+		payScript, err := txOut.LockingScript()
 		if err != nil {
 			return nil, err
 		}
@@ -208,7 +212,7 @@ func (tx *Tx) ToWireFormat() (*msg.Tx, error) {
 	}
 	// outputs
 	for _, txOut := range tx.Outputs {
-		payScript, err := hex.DecodeString(txOut.PkScript)
+		payScript, err := txOut.LockingScript()
 		if err != nil {
 			return nil, err
 		}
@@ -220,12 +224,46 @@ func (tx *Tx) ToWireFormat() (*msg.Tx, error) {
 	return bchTx, nil
 }
 
+// NewTxFromMsg converts the transaction of the block at the height to the internal
+// model, the values and the scripts of the spent outputs aren't known from the block
+func NewTxFromMsg(m *msg.Tx, height int32) *Tx {
+	tx := &Tx{
+		Hash:     m.GetHash().ToString(),
+		Height:   height,
+		Version:  int32(m.Version),
+		LockTime: m.LockTime,
+	}
+	for _, in := range m.Inputs {
+		tx.Inputs = append(tx.Inputs, &TxIn{
+			Sequence:  in.SequenceNumber,
+			PrevHash:  in.PreviousOutputHash.ToString(),
+			PrevIndex: in.PreviousIndex,
+			Signature: hex.EncodeToString(in.UnlockingScript),
+		})
+	}
+	for _, out := range m.Outputs {
+		txOut := &TxOut{
+			Value:      int64(out.Value),
+			PkScript:   hex.EncodeToString(out.AddressScript()),
+			Address:    out.AddressStr,
+			AddressRaw: out.AddressRaw,
+		}
+		if out.Token != nil {
+			txOut.Token = NewTokenData(out.Token)
+		}
+		tx.Outputs = append(tx.Outputs, txOut)
+		tx.OutputVal += txOut.Value
+	}
+	return tx
+}
+
 // MemPoolFilterRequest is a list of addresses
 // for which the server will inform client
 // that there is new transaction in the pool
 type MemPoolFilterRequest struct {
-	PlayerId string   `json:"playerId"`
-	Address  []string `json:"address"`
+	PlayerId     string   `json:"playerId"`
+	SessionToken string   `json:"sessionToken,omitempty"`
+	Address      []string `json:"address"`
 	BasePdu
 }
 
@@ -255,18 +293,20 @@ func (r *TransactionInMemPoolRequest) Pack() []byte {
 
 // Uxto is non spent transaction output
 type Uxto struct {
-	Hash     string `json:"hash"`
-	Index    int32  `json:"index"`
-	PkScript string `json:"pkScript"`
-	Value    int64  `json:"value"`
+	Hash     string     `json:"hash"`
+	Index    int32      `json:"index"`
+	PkScript string     `json:"pkScript"`
+	Value    int64      `json:"value"`
+	Token    *TokenData `json:"token,omitempty"`
 	BasePdu
 }
 
 type ProvideUxtoRequest struct {
-	PlayerId string   `json:"playerId"`
-	Address  []string `json:"address"`
-	Skip     int      `json:"skip"`
-	PageSize int      `json:"pageSize"`
+	PlayerId     string   `json:"playerId"`
+	SessionToken string   `json:"sessionToken,omitempty"`
+	Address      []string `json:"address"`
+	Skip         int      `json:"skip"`
+	PageSize     int      `json:"pageSize"`
 	BasePdu
 }
 
@@ -285,6 +325,7 @@ func (r *ProvideUxtoResponse) Pack() []byte { return structToByte(r, BhdGetUxtos
 // BroadcastTransactionRequest is request to broadcast transaction
 type BroadcastTransactionRequest struct {
 	PlayerId          string `json:"playerId"`
+	SessionToken      string `json:"sessionToken,omitempty"`
 	SignedTransaction *Tx    `json:"signedTransaction"`
 	BasePdu
 }
@@ -306,8 +347,9 @@ func (r *BroadcastTransactionResponse) Pack() []byte {
 // ProvideTransactionRequest is request to provide transactions
 // for the addresses in the list
 type ProvideTransactionRequest struct {
-	PlayerId string `json:"playerId"`
-	Hash     string `json:"hash"`
+	PlayerId     string `json:"playerId"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	Hash         string `json:"hash"`
 	BasePdu
 }
 
@@ -329,10 +371,11 @@ func (r *ProvideTransactionResponse) Pack() []byte {
 // ProvideTransactionsRequest is request to provide transactions
 // for the addresses in the list
 type ProvideTransactionsRequest struct {
-	PlayerId string   `json:"playerId"`
-	Address  []string `json:"address"`
-	Skip     int      `json:"skip"`
-	PageSize int      `json:"pageSize"`
+	PlayerId     string   `json:"playerId"`
+	SessionToken string   `json:"sessionToken,omitempty"`
+	Address      []string `json:"address"`
+	Skip         int      `json:"skip"`
+	PageSize     int      `json:"pageSize"`
 	BasePdu
 }
 
@@ -354,8 +397,9 @@ func (r *ProvideTransactionsResponse) Pack() []byte {
 
 // GetBalanceRequest is request to provide balance of the wallet
 type GetBalanceRequest struct {
-	PlayerId string   `json:"playerId"`
-	Address  []string `json:"address"`
+	PlayerId     string   `json:"playerId"`
+	SessionToken string   `json:"sessionToken,omitempty"`
+	Address      []string `json:"address"`
 	BasePdu
 }
 
@@ -378,6 +422,7 @@ func (r *GetBalanceResponse) Pack() []byte {
 // to another
 type SendCoinsRequest struct {
 	PlayerId              string `json:"playerId"`
+	SessionToken          string `json:"sessionToken,omitempty"`
 	OriginBchAddress      string `json:"originBchAddress"`
 	DestinationBchAddress string `json:"destinationBchAddress"`
 	AmountToTransfer      int64  `json:"amountToTransfer"`
@@ -407,7 +452,14 @@ func (r *SendCoinsResponse) Pack() []byte {
 // player address so that system can start collecting
 // relevant transactions
 type RegisterBchAddressRequest struct {
-	BchAddress string `json:"bchAddress"`
+	PlayerId     string `json:"playerId"`
+	SessionToken string `json:"sessionToken,omitempty"`
+	BchAddress   string `json:"bchAddress"`
+	// Nonce is the login challenge issued for the BchAddress
+	Nonce string `json:"nonce,omitempty"`
+	// Signature is the signed message (base64, "Bitcoin Signed Message")
+	// of the RegisterAddressMessage, proves the player owns the address
+	Signature string `json:"signature,omitempty"`
 	BasePdu
 }
 
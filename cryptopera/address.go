@@ -136,9 +136,20 @@ func ToBytes(chars string) ([]byte, error) {
 }
 
 
+// cash address version bytes of the 160 bit hashes, type is in the bits 3-6
+const (
+	CashAddrP2PKH byte = 0x00
+	CashAddrP2SH  byte = 0x08
+)
+
 func GetBech32Address(prefix string, hash []byte) (string, error){
+	return GetCashAddress(prefix, CashAddrP2PKH, hash)
+}
+
+// GetCashAddress encodes the hash with the version byte (CashAddrP2PKH, CashAddrP2SH)
+func GetCashAddress(prefix string, version byte, hash []byte) (string, error){
 	var address string = ""
-	fiveBitArr, err := convertTo5Bits(append([]byte{0},hash...))
+	fiveBitArr, err := convertTo5Bits(append([]byte{version},hash...))
 	if err != nil {
 		return address,err
 	}
@@ -3,7 +3,6 @@ package bip44
 import (
 	"encoding/hex"
 	"github.com/gcash/bchutil/hdkeychain"
-	"github.com/tyler-smith/go-bip39"
 )
 
 type ExtendedKey struct {
@@ -28,9 +27,12 @@ func NewKeyFromSeedHex(seed string, net Network) (*ExtendedKey, error) {
 	return NewKeyFromSeedBytes(pk, net)
 }
 
+// NewKeyFromMnemonic creates the master key of the mnemonic, password is the
+// optional BIP39 passphrase. Use ValidateMnemonic first, the seed is computed
+// for any text.
 func NewKeyFromMnemonic(mnemonic, password string, net Network) (*ExtendedKey, error) {
-	seed := bip39.NewSeed(mnemonic, password)
-	return NewKeyFromSeedBytes(seed, MAINNET)
+	seed := MnemonicSeed(mnemonic, password)
+	return NewKeyFromSeedBytes(seed, net)
 }
 
 func NewKeyFromSeedBytes(seed []byte, net Network) (*ExtendedKey, error) {
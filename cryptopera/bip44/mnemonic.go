@@ -0,0 +1,252 @@
+package bip44
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/tyler-smith/go-bip39/wordlists"
+	"golang.org/x/crypto/pbkdf2"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Language is the BIP39 word list of the mnemonic
+type Language string
+
+const (
+	English            Language = "english"
+	ChineseSimplified  Language = "chinese_simplified"
+	ChineseTraditional Language = "chinese_traditional"
+	Czech              Language = "czech"
+	French             Language = "french"
+	Italian            Language = "italian"
+	Japanese           Language = "japanese"
+	Korean             Language = "korean"
+	Spanish            Language = "spanish"
+)
+
+const (
+	// DefaultMnemonicWords is the number of the words of the generated mnemonic
+	DefaultMnemonicWords = 24
+	seedIterations       = 2048
+	seedSize             = 64
+)
+
+// Languages are the supported word lists, English first as it's the default
+var Languages = []Language{English, ChineseSimplified, ChineseTraditional, Czech, French, Italian, Japanese, Korean, Spanish}
+
+var (
+	ErrMnemonicChecksum = errors.New("mnemonic checksum doesn't match, some word is wrong or the words are in the wrong order")
+)
+
+// MnemonicWordError is returned for the word which isn't in the word list,
+// Position starts at 1 as shown to the user
+type MnemonicWordError struct {
+	Position int
+	Word     string
+	Language Language
+}
+
+func (e *MnemonicWordError) Error() string {
+	return "word " + strconv.Itoa(e.Position) + " \"" + e.Word + "\" is not in the " + string(e.Language) + " word list"
+}
+
+type wordList struct {
+	words []string
+	index map[string]int
+}
+
+var (
+	wordListsLock sync.Mutex
+	wordListCache = make(map[Language]*wordList)
+)
+
+// getWordList returns the word list of the language with the index of the words
+func getWordList(lang Language) (*wordList, error) {
+	if lang == "" {
+		lang = English
+	}
+	wordListsLock.Lock()
+	defer wordListsLock.Unlock()
+	if list, ok := wordListCache[lang]; ok {
+		return list, nil
+	}
+	var words []string
+	switch lang {
+	case English:
+		words = wordlists.English
+	case ChineseSimplified:
+		words = wordlists.ChineseSimplified
+	case ChineseTraditional:
+		words = wordlists.ChineseTraditional
+	case Czech:
+		words = wordlists.Czech
+	case French:
+		words = wordlists.French
+	case Italian:
+		words = wordlists.Italian
+	case Japanese:
+		words = wordlists.Japanese
+	case Korean:
+		words = wordlists.Korean
+	case Spanish:
+		words = wordlists.Spanish
+	default:
+		return nil, errors.New("unsupported mnemonic language " + string(lang))
+	}
+	list := &wordList{words: words, index: make(map[string]int, len(words))}
+	for i, word := range words {
+		// the lists with the accents are matched in the decomposed form
+		list.index[norm.NFKD.String(word)] = i
+	}
+	wordListCache[lang] = list
+	return list, nil
+}
+
+// mnemonicWords splits the mnemonic, any white space (the ideographic space
+// of the japanese mnemonics included) separates the words
+func mnemonicWords(mnemonic string) []string {
+	return strings.Fields(norm.NFKD.String(mnemonic))
+}
+
+// checkWordCount checks the number of the words is 12, 15, 18, 21 or 24
+func checkWordCount(count int) error {
+	if count < 12 || count > 24 || count%3 != 0 {
+		return errors.New("mnemonic must have 12, 15, 18, 21 or 24 words, it has " + strconv.Itoa(count))
+	}
+	return nil
+}
+
+// DetectLanguage returns the language of the word list containing the most words
+// of the mnemonic, so the misspelled word is reported in the list of the others.
+// English is returned when no list contains any word.
+func DetectLanguage(mnemonic string) Language {
+	words := mnemonicWords(mnemonic)
+	var detected = English
+	var most = 0
+	for _, lang := range Languages {
+		list, err := getWordList(lang)
+		if err != nil {
+			continue
+		}
+		var found = 0
+		for _, word := range words {
+			if _, ok := list.index[word]; ok {
+				found++
+			}
+		}
+		if found > most {
+			detected, most = lang, found
+		}
+	}
+	return detected
+}
+
+// ValidateMnemonic checks the words and the checksum of the mnemonic, the language
+// is detected when empty. MnemonicWordError points at the word not in the list.
+func ValidateMnemonic(mnemonic string, lang Language) error {
+	_, err := MnemonicEntropy(mnemonic, lang)
+	return err
+}
+
+// MnemonicEntropy returns the entropy encoded by the mnemonic after checking its
+// words and checksum, the language is detected when empty
+func MnemonicEntropy(mnemonic string, lang Language) ([]byte, error) {
+	if lang == "" {
+		lang = DetectLanguage(mnemonic)
+	}
+	list, err := getWordList(lang)
+	if err != nil {
+		return nil, err
+	}
+	words := mnemonicWords(mnemonic)
+	err = checkWordCount(len(words))
+	if err != nil {
+		return nil, err
+	}
+	var bits = new(big.Int)
+	for i, word := range words {
+		index, ok := list.index[word]
+		if !ok {
+			return nil, &MnemonicWordError{Position: i + 1, Word: word, Language: lang}
+		}
+		bits.Lsh(bits, 11)
+		bits.Or(bits, big.NewInt(int64(index)))
+	}
+	// every 3 words are 32 bits of the entropy and 1 bit of the checksum
+	checksumBits := uint(len(words) / 3)
+	entropySize := len(words) * 11 / 33 * 4
+	checksum := new(big.Int).And(bits, big.NewInt(1<<checksumBits-1))
+	entropy := new(big.Int).Rsh(bits, checksumBits).FillBytes(make([]byte, entropySize))
+	if mnemonicChecksum(entropy, checksumBits) != checksum.Int64() {
+		return nil, ErrMnemonicChecksum
+	}
+	return entropy, nil
+}
+
+// mnemonicChecksum returns the first bits of the sha256 of the entropy
+func mnemonicChecksum(entropy []byte, bits uint) int64 {
+	hash := sha256.Sum256(entropy)
+	return int64(hash[0] >> (8 - bits))
+}
+
+// NewMnemonicWords generates the mnemonic of the words count (12, 15, 18, 21
+// or 24) in the language, English when empty
+func NewMnemonicWords(count int, lang Language) (string, error) {
+	err := checkWordCount(count)
+	if err != nil {
+		return "", err
+	}
+	entropy := make([]byte, count/3*4)
+	_, err = rand.Read(entropy)
+	if err != nil {
+		return "", err
+	}
+	return EntropyMnemonic(entropy, lang)
+}
+
+// EntropyMnemonic encodes the entropy of 16, 20, 24, 28 or 32 bytes as the
+// mnemonic in the language, English when empty
+func EntropyMnemonic(entropy []byte, lang Language) (string, error) {
+	count := len(entropy) / 4 * 3
+	if len(entropy)%4 != 0 {
+		count = 0
+	}
+	err := checkWordCount(count)
+	if err != nil {
+		return "", err
+	}
+	list, err := getWordList(lang)
+	if err != nil {
+		return "", err
+	}
+	checksumBits := uint(count / 3)
+	bits := new(big.Int).SetBytes(entropy)
+	bits.Lsh(bits, checksumBits)
+	bits.Or(bits, big.NewInt(mnemonicChecksum(entropy, checksumBits)))
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		words[i] = list.words[new(big.Int).And(bits, mask).Int64()]
+		bits.Rsh(bits, 11)
+	}
+	separator := " "
+	if lang == Japanese {
+		separator = "　"
+	}
+	return strings.Join(words, separator), nil
+}
+
+// MnemonicSeed returns the BIP39 seed of the mnemonic and the passphrase
+// ("25th word"), both are NFKD normalized as required for the non-English lists.
+// The white space is kept as typed, the seeds of the existing wallets were made
+// from the mnemonic text as is.
+func MnemonicSeed(mnemonic string, passphrase string) []byte {
+	salt := "mnemonic" + norm.NFKD.String(passphrase)
+	return pbkdf2.Key([]byte(norm.NFKD.String(mnemonic)), []byte(salt), seedIterations, seedSize, sha512.New)
+}
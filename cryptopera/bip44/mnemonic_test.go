@@ -0,0 +1,172 @@
+package bip44
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/tyler-smith/go-bip39"
+)
+
+const (
+	zeroMnemonic  = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+	legalMnemonic = "legal winner thank year wave sausage worth useful legal winner thank yellow"
+)
+
+// the vectors of the BIP39 reference implementation, the English ones with the
+// passphrase "TREZOR" and the Japanese one from the Japanese test vectors
+var seedVectors = []struct {
+	entropy    string
+	lang       Language
+	mnemonic   string
+	passphrase string
+	seed       string
+}{
+	{"00000000000000000000000000000000", English, zeroMnemonic, "TREZOR",
+		"c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04"},
+	{"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f", English, legalMnemonic, "TREZOR",
+		"2e8905819b8723fe2c1d161860e5ee1830318dbf49a83bd451cfb8440c28bd6fa457fe1296106559a3c80937a1c1069be3a3a5bd381ee6260e8d9739fce1f607"},
+	{"00000000000000000000000000000000", Japanese, strings.Repeat("あいこくしん　", 11) + "あおぞら", "㍍ガバヴァぱばぐゞちぢ十人十色",
+		"a262d6fb6122ecf45be09c50492b31f92e9beb7d9a845987a02cefda57a15f9c467a17872029a9e92299b5cbdf306e3a0ee620245cbd508959b6cb7ca637bd55"},
+}
+
+func TestMnemonicSeedVectors(t *testing.T) {
+	for _, test := range seedVectors {
+		entropy, err := MnemonicEntropy(test.mnemonic, "")
+		if err != nil || hex.EncodeToString(entropy) != test.entropy {
+			t.Errorf("%s: expected the entropy %s, got %x (%v)", test.lang, test.entropy, entropy, err)
+		}
+		if lang := DetectLanguage(test.mnemonic); lang != test.lang {
+			t.Errorf("%s: detected %s", test.lang, lang)
+		}
+		seed := MnemonicSeed(test.mnemonic, test.passphrase)
+		if hex.EncodeToString(seed) != test.seed {
+			t.Errorf("%s: unexpected seed %x", test.lang, seed)
+		}
+		// the passphrase changes the seed
+		if bytes.Equal(seed, MnemonicSeed(test.mnemonic, "")) {
+			t.Errorf("%s: the passphrase doesn't change the seed", test.lang)
+		}
+	}
+}
+
+func TestMnemonicSeedWhiteSpace(t *testing.T) {
+	// the seeds of the existing wallets were made by go-bip39 from the text as typed
+	for _, mnemonic := range []string{
+		zeroMnemonic,
+		"  " + strings.ReplaceAll(zeroMnemonic, " ", "  ") + "\n",
+		strings.ReplaceAll(legalMnemonic, " ", "\t"),
+	} {
+		for _, passphrase := range []string{"", "TREZOR"} {
+			if !bytes.Equal(MnemonicSeed(mnemonic, passphrase), bip39.NewSeed(mnemonic, passphrase)) {
+				t.Errorf("%q %q: the seed differs from go-bip39", mnemonic, passphrase)
+			}
+		}
+	}
+	// the ideographic space is the ascii space after NFKD
+	japanese := seedVectors[2]
+	ascii := strings.ReplaceAll(japanese.mnemonic, "　", " ")
+	if !bytes.Equal(MnemonicSeed(ascii, japanese.passphrase), MnemonicSeed(japanese.mnemonic, japanese.passphrase)) {
+		t.Error("the ideographic space isn't normalized")
+	}
+}
+
+func TestValidateMnemonicErrors(t *testing.T) {
+	words := strings.Fields(zeroMnemonic)
+	for _, test := range []struct {
+		name     string
+		mnemonic string
+		lang     Language
+		position int
+		checksum bool
+	}{
+		{"valid", zeroMnemonic, English, 0, false},
+		{"last word", strings.Repeat("abandon ", 11) + "abandon", English, 0, true},
+		{"swapped words", strings.Join(append([]string{"about"}, words[:11]...), " "), English, 0, true},
+		{"misspelled", strings.Replace(zeroMnemonic, "abandon", "abandn", 1), English, 1, false},
+		{"fifth word", strings.Join(append(append(append([]string{}, words[:4]...), "bitcoinx"), words[5:]...), " "), English, 5, false},
+		{"last word unknown", strings.Repeat("abandon ", 11) + "zzz", English, 12, false},
+		{"other language", legalMnemonic, French, 1, false},
+	} {
+		err := ValidateMnemonic(test.mnemonic, test.lang)
+		var wordErr *MnemonicWordError
+		switch {
+		case test.checksum:
+			if !errors.Is(err, ErrMnemonicChecksum) {
+				t.Errorf("%s: expected ErrMnemonicChecksum, got %v", test.name, err)
+			}
+		case test.position > 0:
+			if !errors.As(err, &wordErr) || wordErr.Position != test.position || wordErr.Language != test.lang {
+				t.Errorf("%s: expected the word %d error, got %v", test.name, test.position, err)
+			}
+		case err != nil:
+			t.Errorf("%s: %v", test.name, err)
+		}
+	}
+	for _, count := range []int{0, 11, 13, 27} {
+		if err := ValidateMnemonic(strings.Repeat("abandon ", count), English); err == nil {
+			t.Errorf("%d words accepted", count)
+		}
+	}
+}
+
+func TestDetectLanguageMisspelled(t *testing.T) {
+	entropy, _ := hex.DecodeString(seedVectors[1].entropy)
+	spanish, err := EntropyMnemonic(entropy, Spanish)
+	if err != nil {
+		t.Fatal(err)
+	}
+	words := strings.Fields(spanish)
+	for _, last := range []string{words[11] + "x", "abandon"} {
+		mnemonic := strings.Join(append(words[:11:11], last), " ")
+		if lang := DetectLanguage(mnemonic); lang != Spanish {
+			t.Errorf("%q: detected %s", last, lang)
+		}
+		// the typo is reported in the Spanish list, not the first word in the English one
+		var wordErr *MnemonicWordError
+		err := ValidateMnemonic(mnemonic, "")
+		if !errors.As(err, &wordErr) || wordErr.Position != 12 || wordErr.Word != last || wordErr.Language != Spanish {
+			t.Errorf("%q: expected the word 12 error in the Spanish list, got %v", last, err)
+		}
+	}
+	if lang := DetectLanguage("bitcoinx cashx"); lang != English {
+		t.Errorf("unknown words detected as %s", lang)
+	}
+}
+
+func TestMnemonicLanguages(t *testing.T) {
+	entropy, _ := hex.DecodeString("9e885d952ad362caeb4efe34a8e91bd2b9e885d952ad362caeb4efe34a8e91bd")
+	for _, lang := range Languages {
+		for _, size := range []int{16, 20, 24, 28, 32} {
+			mnemonic, err := EntropyMnemonic(entropy[:size], lang)
+			if err != nil {
+				t.Fatalf("%s: %v", lang, err)
+			}
+			if count := len(mnemonicWords(mnemonic)); count != size/4*3 {
+				t.Errorf("%s: expected %d words, got %d", lang, size/4*3, count)
+			}
+			decoded, err := MnemonicEntropy(mnemonic, lang)
+			if err != nil || !bytes.Equal(decoded, entropy[:size]) {
+				t.Errorf("%s: expected the entropy %x, got %x (%v)", lang, entropy[:size], decoded, err)
+			}
+		}
+		// the words of the other list are rejected
+		if lang != English {
+			var wordErr *MnemonicWordError
+			if err := ValidateMnemonic(zeroMnemonic, lang); !errors.As(err, &wordErr) {
+				t.Errorf("%s: expected the English words rejected, got %v", lang, err)
+			}
+		}
+	}
+	for _, count := range []int{12, 15, 18, 21, 24} {
+		mnemonic, err := NewMnemonicWords(count, Spanish)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := ValidateMnemonic(mnemonic, Spanish); err != nil {
+			t.Errorf("generated %d Spanish words not valid: %v", count, err)
+		}
+	}
+}
@@ -0,0 +1,102 @@
+package cryptopera
+
+import (
+	"bhd/bch/bloom"
+	"bhd/bch/cfilter"
+	"bhd/bch/chain"
+	"bhd/bch/mempool"
+	"bhd/bch/msg"
+	"bhd/bhdmodels"
+	"crypto/rand"
+	"encoding/binary"
+
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+// DefaultBloomFalsePositiveRate gives the peer enough unrelated
+// transactions so it can't easily tell which ones are ours
+const DefaultBloomFalsePositiveRate = 0.0005
+
+// NewBloomFilter creates the BIP37 filter seeded with the wallet address and the
+// outpoints of the uxtos, so peer relays payments to us and spends of our coins
+func (w *Wallet) NewBloomFilter(uxtos []*bhdmodels.Uxto, fpRate float64) (*bloom.Filter, error) {
+	var tweak = make([]byte, 4)
+	_, err := rand.Read(tweak)
+	if err != nil {
+		return nil, err
+	}
+	addresses := w.GetAddresses()
+	filter := bloom.NewFilter(uint32(len(addresses)+len(uxtos)), binary.LittleEndian.Uint32(tweak), fpRate, msg.BloomFilterUpdateAll)
+	for _, addr := range addresses {
+		err = filter.AddAddress(addr, w.NetParams)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, uxto := range uxtos {
+		hash, err := msg.NewHashFromString(uxto.Hash)
+		if err != nil {
+			return nil, err
+		}
+		filter.AddOutPoint(hash, uint32(uxto.Index))
+	}
+	return filter, nil
+}
+
+// GetLockingScripts returns the locking scripts of all wallet addresses
+func (w *Wallet) GetLockingScripts() ([][]byte, error) {
+	var scripts [][]byte
+	for _, address := range w.GetAddresses() {
+		addr, err := bchutil.DecodeAddress(address, w.NetParams)
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		scripts = append(scripts, script)
+	}
+	return scripts, nil
+}
+
+// NewFilterMatcher creates the compact block filter matcher with the wallet
+// scripts and the outpoints of the uxtos
+func (w *Wallet) NewFilterMatcher(uxtos []*bhdmodels.Uxto) (*cfilter.Matcher, error) {
+	scripts, err := w.GetLockingScripts()
+	if err != nil {
+		return nil, err
+	}
+	matcher := cfilter.NewMatcher(scripts)
+	for _, uxto := range uxtos {
+		hash, err := msg.NewHashFromString(uxto.Hash)
+		if err != nil {
+			return nil, err
+		}
+		matcher.AddOutPoint(hash, uint32(uxto.Index))
+	}
+	return matcher, nil
+}
+
+// NewMemPool creates the mempool tracker with the wallet bloom filter and scripts,
+// the uxtos are the confirmed coins of the wallet
+func (w *Wallet) NewMemPool(headerChain *chain.HeaderChain, uxtos []*bhdmodels.Uxto) (*mempool.Pool, error) {
+	filter, err := w.NewBloomFilter(uxtos, DefaultBloomFalsePositiveRate)
+	if err != nil {
+		return nil, err
+	}
+	scripts, err := w.GetLockingScripts()
+	if err != nil {
+		return nil, err
+	}
+	pool := mempool.NewPool(headerChain, filter, scripts)
+	for _, uxto := range uxtos {
+		hash, err := msg.NewHashFromString(uxto.Hash)
+		if err != nil {
+			return nil, err
+		}
+		pool.AddConfirmedCoin(hash, uint32(uxto.Index), uxto.Value)
+	}
+	return pool, nil
+}
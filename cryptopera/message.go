@@ -0,0 +1,130 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+var (
+	ErrMessageSignature = bhdmodels.ErrMessageSignature
+)
+
+// signMessage returns the base64 compact recoverable signature of the message
+func signMessage(key *bchec.PrivateKey, message string) (string, error) {
+	hash, err := bhdmodels.SignedMessageHash(message)
+	if err != nil {
+		return "", err
+	}
+	sig, err := bchec.SignCompact(bchec.S256(), key, hash, true)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(sig), nil
+}
+
+// VerifyMessage checks the base64 signature of the message was made by the key of
+// the pay to public key hash address, ErrMessageSignature is returned when it wasn't
+func VerifyMessage(address string, message string, signature string) error {
+	return bhdmodels.VerifySignedMessage(address, message, signature)
+}
+
+// messageKey returns the key of the pay to public key hash script, the key
+// must be derived by the signer
+func (s *HDSigner) messageKey(script []byte) (*derivedKey, error) {
+	key := s.keys.lookup(script)
+	if key == nil {
+		return nil, errors.New("the wallet has no key for the address")
+	}
+	return key, nil
+}
+
+// SignMessage signs the message with the key of the pay to public key hash
+// script, the key must be derived by the signer
+func (s *HDSigner) SignMessage(script []byte, message string) (string, error) {
+	key, err := s.messageKey(script)
+	if err != nil {
+		return "", err
+	}
+	return signMessage(key.private, message)
+}
+
+// messageKey returns the key of the address derived by the wallet, the wallet
+// address is used when empty
+func (w *Wallet) messageKey(address string) (*derivedKey, error) {
+	signer, ok := w.Signer.(*HDSigner)
+	if !ok {
+		if w.IsWatchOnly() {
+			return nil, ErrWatchOnly
+		}
+		return nil, errors.New("the signer of the wallet can't sign messages")
+	}
+	if address == "" {
+		address = w.PubAddress
+	}
+	addr, err := bchutil.DecodeAddress(address, w.NetParams)
+	if err != nil {
+		return nil, err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return nil, err
+	}
+	return signer.messageKey(script)
+}
+
+// SignMessage signs the message with the key of the address derived by the
+// wallet, the wallet address is used when empty
+func (w *Wallet) SignMessage(address string, message string) (string, error) {
+	key, err := w.messageKey(address)
+	if err != nil {
+		return "", err
+	}
+	return signMessage(key.private, message)
+}
+
+// NewLoginRequest answers the login challenge of the BHD server, the login
+// message is signed by the key of the wallet address
+func (w *Wallet) NewLoginRequest(playerId string, challenge *bhdmodels.LoginChallengeResponse) (*bhdmodels.LoginRequest, error) {
+	key, err := w.messageKey(w.PubAddress)
+	if err != nil {
+		return nil, err
+	}
+	rq := &bhdmodels.LoginRequest{
+		PlayerId:   playerId,
+		BchAddress: w.PubAddress,
+		PubKey:     hex.EncodeToString(key.pubKey),
+		Nonce:      challenge.Nonce,
+	}
+	rq.Signature, err = signMessage(key.private, bhdmodels.LoginMessage(rq.PlayerId, rq.BchAddress, rq.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	return rq, nil
+}
+
+// NewRegisterAddressRequest answers the challenge issued for the address
+// derived by the wallet, the RegisterAddressMessage binds the nonce to the
+// player and the session and is signed by the key of the address
+func (w *Wallet) NewRegisterAddressRequest(playerId string, sessionToken string, address string, challenge *bhdmodels.LoginChallengeResponse) (*bhdmodels.RegisterBchAddressRequest, error) {
+	key, err := w.messageKey(address)
+	if err != nil {
+		return nil, err
+	}
+	rq := &bhdmodels.RegisterBchAddressRequest{
+		PlayerId:     playerId,
+		SessionToken: sessionToken,
+		BchAddress:   address,
+		Nonce:        challenge.Nonce,
+	}
+	rq.Signature, err = signMessage(key.private, bhdmodels.RegisterAddressMessage(rq.PlayerId, rq.SessionToken, rq.BchAddress, rq.Nonce))
+	if err != nil {
+		return nil, err
+	}
+	return rq, nil
+}
@@ -0,0 +1,102 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"testing"
+
+	"github.com/gcash/bchutil"
+)
+
+func TestSignMessageVector(t *testing.T) {
+	// the vector of the Bitcoin Core signmessage test, Electron Cash signs the same
+	// "Bitcoin Signed Message" hash with the deterministic compact signature
+	wif, err := bchutil.DecodeWIF("cUeKHd5orzT3mz8P9pxyREHfsWtVfgsfDjiZZBcjUBAaGk1BTj7N")
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := "This is just a test message"
+	expected := "INbVnW4e6PeRmsv2Qgu8NuopvrVjkcxob+sX8OcZG0SALhWybUjzMLPdAsXI46YZGb0KQTRii+wWIQzRpG/U+S0="
+	signature, err := signMessage(wif.PrivKey, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signature != expected {
+		t.Fatalf("unexpected signature %s", signature)
+	}
+	// the cash address of the key hash of the testnet address mpLQjfK79b7CCV4VMJWEWAj5Mpx8Up5zxB
+	if err := VerifyMessage("bitcoincash:qpst4g85jjecec7fgr02vlecqnw9950mjsf0678ndh", message, expected); err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage("bitcoincash:qpst4g85jjecec7fgr02vlecqnw9950mjsf0678ndh", message+".", expected); err != ErrMessageSignature {
+		t.Errorf("the signature of the other message verified: %v", err)
+	}
+}
+
+func TestSignMessage(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	message := "bhd message"
+	signature, err := w.SignMessage("", message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(w.PubAddress, message, signature); err != nil {
+		t.Fatal(err)
+	}
+
+	// the signature of the other address of the wallet
+	address, err := w.DeriveAddress(bip44.ExternalChangeType, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(address, message, signature); err != ErrMessageSignature {
+		t.Errorf("the signature verified with the wrong address: %v", err)
+	}
+	signature, err = w.SignMessage(address, message)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := VerifyMessage(address, message, signature); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.SignMessage("bitcoincash:qpst4g85jjecec7fgr02vlecqnw9950mjsf0678ndh", message); err == nil {
+		t.Error("the message signed for the address without the key")
+	}
+}
+
+func TestRegisterAddressRequest(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	v := bhdmodels.NewLoginVerifier()
+	challenge, err := v.Challenge(&bhdmodels.LoginChallengeRequest{PlayerId: "player", BchAddress: w.PubAddress})
+	if err != nil {
+		t.Fatal(err)
+	}
+	login, err := w.NewLoginRequest("player", challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := v.Login(login)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	address, err := w.DeriveAddress(bip44.ExternalChangeType, 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err = v.Challenge(&bhdmodels.LoginChallengeRequest{PlayerId: "player", BchAddress: address})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rq, err := w.NewRegisterAddressRequest("player", session.SessionToken, address, challenge)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = v.RegisterAddress(rq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := v.Authorize("player", session.SessionToken, w.PubAddress, address); err != nil {
+		t.Errorf("registered wallet address not authorized: %v", err)
+	}
+}
@@ -0,0 +1,253 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+	"github.com/gcash/bchutil/hdkeychain"
+)
+
+const (
+	// MultisigAccountPath is the BIP48 style path of the cosigner account key,
+	// the last level is the script type, 0 is used for the P2SH multisig
+	MultisigAccountPath = "m/48'/145'/0'/0'"
+	// MaxMultisigKeys is the standard limit of the P2SH multisig with compressed keys
+	MaxMultisigKeys = 15
+	// MultisigAddressIndex is the index of the external address used by the multisig account
+	MultisigAddressIndex = 0
+)
+
+// RedeemScript is the multisig script of the account address, PubKeys are
+// sorted the same way as in the script (BIP67)
+type RedeemScript struct {
+	Script  []byte
+	Change  bip44.ChangeType
+	Index   uint32
+	PubKeys [][]byte
+}
+
+// ScriptHash returns the hash160 of the redeem script
+func (r *RedeemScript) ScriptHash() []byte {
+	return bchutil.Hash160(r.Script)
+}
+
+// LockingScript returns the P2SH locking script paying to the redeem script
+func (r *RedeemScript) LockingScript() ([]byte, error) {
+	return txscript.NewScriptBuilder().AddOp(txscript.OP_HASH160).AddData(r.ScriptHash()).
+		AddOp(txscript.OP_EQUAL).Script()
+}
+
+// MultisigAccount is the m-of-n account of the cosigner xpubs. The xpubs are the
+// account keys (BIP48 MultisigAccountPath or the BIP45 cosigner keys m/45'/cosigner),
+// the address keys are change/index under each of them.
+type MultisigAccount struct {
+	sync.Mutex
+	Required int
+	XPubs    []string
+	keys     []*hdkeychain.ExtendedKey
+	// keyed by the hex hash160 of the redeem script
+	scripts map[string]*RedeemScript
+}
+
+// NewMultisigAccount creates the account requiring the signatures of required of the xpubs
+func NewMultisigAccount(required int, xpubs []string) (*MultisigAccount, error) {
+	if len(xpubs) == 0 || len(xpubs) > MaxMultisigKeys {
+		return nil, errors.New("multisig needs 1 to " + strconv.Itoa(MaxMultisigKeys) + " keys")
+	}
+	if required < 1 || required > len(xpubs) {
+		return nil, errors.New("required signatures must be between 1 and the number of keys")
+	}
+	m := &MultisigAccount{
+		Required: required,
+		XPubs:    xpubs,
+		scripts:  make(map[string]*RedeemScript),
+	}
+	var known = make(map[string]bool)
+	for _, xpub := range xpubs {
+		key, err := hdkeychain.NewKeyFromString(xpub)
+		if err != nil {
+			return nil, err
+		}
+		if key.IsPrivate() {
+			return nil, errors.New("multisig account needs the public keys of the cosigners")
+		}
+		if known[xpub] {
+			return nil, errors.New("the cosigner key is repeated")
+		}
+		known[xpub] = true
+		m.keys = append(m.keys, key)
+	}
+	return m, nil
+}
+
+// MultisigAccountKey derives the cosigner account key at MultisigAccountPath
+func MultisigAccountKey(key *bip44.ExtendedKey) (*bip44.AccountKey, error) {
+	var k = key.HdKey
+	var err error
+	for _, index := range []uint32{48, uint32(bip44.BchCoinType), 0, 0} {
+		k, err = k.Child(hdkeychain.HardenedKeyStart + index)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &bip44.AccountKey{ExKey: k}, nil
+}
+
+// Derive creates the redeem script of the address and remembers it for signing
+func (m *MultisigAccount) Derive(change bip44.ChangeType, index uint32) (*RedeemScript, error) {
+	var pubKeys [][]byte
+	for _, key := range m.keys {
+		changeKey, err := key.Child(uint32(change))
+		if err != nil {
+			return nil, err
+		}
+		addressKey, err := changeKey.Child(index)
+		if err != nil {
+			return nil, err
+		}
+		pubKey, err := addressKey.ECPubKey()
+		if err != nil {
+			return nil, err
+		}
+		pubKeys = append(pubKeys, pubKey.SerializeCompressed())
+	}
+	sort.Slice(pubKeys, func(i, j int) bool { return bytes.Compare(pubKeys[i], pubKeys[j]) < 0 })
+	var addresses []*bchutil.AddressPubKey
+	for _, pubKey := range pubKeys {
+		address, err := bchutil.NewAddressPubKey(pubKey, &chaincfg.MainNetParams)
+		if err != nil {
+			return nil, err
+		}
+		addresses = append(addresses, address)
+	}
+	script, err := txscript.MultiSigScript(addresses, m.Required)
+	if err != nil {
+		return nil, err
+	}
+	redeem := &RedeemScript{
+		Script:  script,
+		Change:  change,
+		Index:   index,
+		PubKeys: pubKeys,
+	}
+	m.Lock()
+	defer m.Unlock()
+	m.scripts[hex.EncodeToString(redeem.ScriptHash())] = redeem
+	return redeem, nil
+}
+
+// Address returns the P2SH cash address (type 1) of the account address
+func (m *MultisigAccount) Address(change bip44.ChangeType, index uint32) (string, error) {
+	redeem, err := m.Derive(change, index)
+	if err != nil {
+		return "", err
+	}
+	return GetCashAddress(bhdmodels.BchAddressPrefixForGeneration, CashAddrP2SH, redeem.ScriptHash())
+}
+
+// lookup returns the redeem script of the P2SH locking script, nil when it's not ours
+func (m *MultisigAccount) lookup(script []byte) *RedeemScript {
+	if txscript.GetScriptClass(script) != txscript.ScriptHashTy {
+		return nil
+	}
+	m.Lock()
+	defer m.Unlock()
+	return m.scripts[hex.EncodeToString(script[2:22])]
+}
+
+// multisigUnlockingScript returns the unlocking script of the P2SH multisig input,
+// signatures are in the order of the public keys in the redeem script. The dummy
+// is empty for the ECDSA signatures and the bitfield of the signing keys for schnorr.
+func multisigUnlockingScript(redeemScript []byte, dummy []byte, sigs [][]byte) ([]byte, error) {
+	builder := txscript.NewScriptBuilder().AddData(dummy)
+	for _, sig := range sigs {
+		builder.AddData(sig)
+	}
+	return builder.AddData(redeemScript).Script()
+}
+
+// isSchnorrSignature returns true for the 64 byte schnorr signature followed by the hash type
+func isSchnorrSignature(sig []byte) bool {
+	return len(sig) == 65
+}
+
+// multisigSignatureType returns the type of the signatures already collected,
+// the multisig can't mix schnorr and ECDSA
+func multisigSignatureType(partialSigs map[string]string, preferred SignatureType) SignatureType {
+	for _, sigHex := range partialSigs {
+		sig, err := hex.DecodeString(sigHex)
+		if err != nil {
+			continue
+		}
+		if isSchnorrSignature(sig) {
+			return SignatureSchnorr
+		}
+		return SignatureECDSA
+	}
+	return preferred
+}
+
+// finalizeMultisig selects the required signatures from the partial ones, the
+// schnorr signatures use the bitfield dummy of the 2019 schnorr multisig upgrade
+func finalizeMultisig(redeemScript []byte, partialSigs map[string]string) ([]byte, error) {
+	class, addresses, required, err := txscript.ExtractPkScriptAddrs(redeemScript, &chaincfg.MainNetParams)
+	if err != nil {
+		return nil, err
+	}
+	if class != txscript.MultiSigTy {
+		return nil, errors.New("redeem script is not multisig")
+	}
+	var collected = 0
+	for _, schnorr := range []bool{true, false} {
+		var sigs [][]byte
+		var bitfield = make([]byte, (len(addresses)+7)/8)
+		for i, address := range addresses {
+			sigHex, ok := partialSigs[hex.EncodeToString(address.ScriptAddress())]
+			if !ok {
+				continue
+			}
+			sig, err := hex.DecodeString(sigHex)
+			if err != nil {
+				return nil, err
+			}
+			if isSchnorrSignature(sig) != schnorr {
+				continue
+			}
+			sigs = append(sigs, sig)
+			bitfield[i/8] |= 1 << (i % 8)
+			if len(sigs) == required {
+				if !schnorr {
+					bitfield = nil
+				}
+				return multisigUnlockingScript(redeemScript, bitfield, sigs)
+			}
+		}
+		if len(sigs) > collected {
+			collected = len(sigs)
+		}
+	}
+	return nil, errors.New("multisig has " + strconv.Itoa(collected) + " of " + strconv.Itoa(required) + " signatures of the same type")
+}
+
+// checkMultisigSignature checks the public key is in the redeem script
+func checkMultisigSignature(redeemScript []byte, pubKey []byte) error {
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(redeemScript, &chaincfg.MainNetParams)
+	if err != nil {
+		return err
+	}
+	for _, address := range addresses {
+		if bytes.Equal(address.ScriptAddress(), pubKey) {
+			return nil
+		}
+	}
+	return errors.New("public key is not in the redeem script")
+}
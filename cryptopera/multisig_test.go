@@ -0,0 +1,291 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+)
+
+const thirdTestMnemonic = "letter advice cage absurd amount doctor acoustic avoid letter advice cage above"
+
+// newTestCosigners returns the three wallets of the 2-of-3 multisig account with its address
+func newTestCosigners(t *testing.T) ([]*Wallet, string) {
+	t.Helper()
+	var wallets []*Wallet
+	var xpubs []string
+	for _, mnemonic := range []string{testMnemonic, otherTestMnemonic, thirdTestMnemonic} {
+		w := newTestWallet(t, mnemonic)
+		xpub, err := w.GetMultisigXPub()
+		if err != nil {
+			t.Fatal(err)
+		}
+		wallets = append(wallets, w)
+		xpubs = append(xpubs, xpub)
+	}
+	var address string
+	for _, w := range wallets {
+		a, err := w.SetMultisigAccount(2, xpubs)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if address != "" && a != address {
+			t.Fatalf("the cosigners have the different addresses %s and %s", address, a)
+		}
+		address = a
+	}
+	return wallets, address
+}
+
+// newMultisigPartialTx returns the transaction spending the output of the multisig address
+func newMultisigPartialTx(t *testing.T, w *Wallet) *PartialTx {
+	t.Helper()
+	redeem, err := w.Multisig.Derive(0, MultisigAddressIndex)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lockingScript, err := redeem.LockingScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bhdmodels.Tx{
+		Inputs: []*bhdmodels.TxIn{{PrevHash: strings.Repeat("01", 32), PrevIndex: 0, Sequence: 0xffffffff,
+			Value: 50000, PubScript: hex.EncodeToString(lockingScript)}},
+		Outputs: []*bhdmodels.TxOut{{Value: 49000, PkScript: script, Address: w.PubAddress}},
+	}
+	p, err := w.NewPartialTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Inputs[0].RedeemScript != hex.EncodeToString(redeem.Script) || len(p.Inputs[0].Derivations) != 3 {
+		t.Fatalf("the multisig input without the redeem script or derivations %+v", p.Inputs[0])
+	}
+	return p
+}
+
+// runScriptEngine runs the bchd script engine on the inputs of the transaction
+func runScriptEngine(tx *bhdmodels.Tx) error {
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return err
+	}
+	for i, in := range tx.Inputs {
+		pubScript, err := hex.DecodeString(in.PubScript)
+		if err != nil {
+			return err
+		}
+		vm, err := txscript.NewEngine(pubScript, msgTx, i, ValidateTxFlags, nil, nil, nil, in.Value)
+		if err != nil {
+			return err
+		}
+		err = vm.Execute()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// withUnlockingScript returns the copy of the transaction with the multisig unlocking script of the input 0
+func withUnlockingScript(t *testing.T, tx *bhdmodels.Tx, redeemScript []byte, dummy []byte, sigs ...[]byte) *bhdmodels.Tx {
+	t.Helper()
+	script, err := multisigUnlockingScript(redeemScript, dummy, sigs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inCopy := *tx.Inputs[0]
+	inCopy.Signature = hex.EncodeToString(script)
+	txCopy := *tx
+	txCopy.Inputs = []*bhdmodels.TxIn{&inCopy}
+	return &txCopy
+}
+
+// multisigSignatures returns the redeem script with the partial signatures of the input in the order of its keys
+// and the positions of the signing keys
+func multisigSignatures(t *testing.T, in *PartialInput) ([]byte, [][]byte, []int) {
+	t.Helper()
+	redeemScript, _ := hex.DecodeString(in.RedeemScript)
+	_, addresses, _, err := txscript.ExtractPkScriptAddrs(redeemScript, &chaincfg.MainNetParams)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var sigs [][]byte
+	var positions []int
+	for i, address := range addresses {
+		if sigHex, ok := in.PartialSigs[hex.EncodeToString(address.ScriptAddress())]; ok {
+			sig, _ := hex.DecodeString(sigHex)
+			sigs = append(sigs, sig)
+			positions = append(positions, i)
+		}
+	}
+	return redeemScript, sigs, positions
+}
+
+func TestMultisigSigning(t *testing.T) {
+	wallets, _ := newTestCosigners(t)
+	for _, sigType := range []SignatureType{SignatureECDSA, SignatureSchnorr} {
+		for _, pair := range [][2]int{{0, 1}, {0, 2}, {2, 1}} {
+			p := newMultisigPartialTx(t, wallets[0])
+			for _, idx := range pair {
+				w := wallets[idx]
+				err := w.SetSignatureType(sigType)
+				if err != nil {
+					t.Fatal(err)
+				}
+				c := copyPartialTx(t, p)
+				signed, err := w.SignPartial(c)
+				if err != nil || signed != 1 {
+					t.Fatalf("the cosigner %d signed %d inputs: %v", idx, signed, err)
+				}
+				err = p.Combine(c)
+				if err != nil {
+					t.Fatal(err)
+				}
+			}
+			if len(p.Inputs[0].PartialSigs) != 2 {
+				t.Fatalf("expected 2 signatures, got %d", len(p.Inputs[0].PartialSigs))
+			}
+			redeemScript, sigs, positions := multisigSignatures(t, p.Inputs[0])
+			tx, err := wallets[pair[0]].FinalizePartial(p)
+			if err != nil {
+				t.Fatalf("type %d cosigners %v: %v", sigType, pair, err)
+			}
+			if err := runScriptEngine(tx); err != nil {
+				t.Fatalf("type %d cosigners %v: the script engine failed: %v", sigType, pair, err)
+			}
+
+			// the ECDSA dummy is empty and the schnorr one is the bitfield of the signing
+			// keys, pushed as the small number, the signatures are in the order of the keys
+			unlocking, _ := hex.DecodeString(tx.Inputs[0].Signature)
+			dummyOp := byte(txscript.OP_0)
+			var dummy []byte
+			if sigType == SignatureSchnorr {
+				dummy = []byte{byte(1<<positions[0] | 1<<positions[1])}
+				dummyOp = txscript.OP_1 - 1 + dummy[0]
+			}
+			expected := withUnlockingScript(t, tx, redeemScript, dummy, sigs...)
+			if unlocking[0] != dummyOp || tx.Inputs[0].Signature != expected.Inputs[0].Signature {
+				t.Errorf("type %d cosigners %v: unexpected unlocking script %x", sigType, pair, unlocking)
+			}
+			for _, sig := range sigs {
+				if isSchnorrSignature(sig) != (sigType == SignatureSchnorr) {
+					t.Errorf("type %d cosigners %v: unexpected signature type", sigType, pair)
+				}
+			}
+
+			// the signatures out of the key order fail in the legacy mode
+			if sigType == SignatureECDSA {
+				swapped := withUnlockingScript(t, tx, redeemScript, nil, sigs[1], sigs[0])
+				if runScriptEngine(swapped) == nil || wallets[0].ValidateTx(swapped) == nil {
+					t.Error("the ECDSA signatures out of the key order passed")
+				}
+				continue
+			}
+			// the schnorr signatures need the bitfield dummy of the signing keys
+			for _, bitfield := range [][]byte{nil, {0x07}, {dummy[0] ^ 0x07}} {
+				broken := withUnlockingScript(t, tx, redeemScript, bitfield, sigs...)
+				if runScriptEngine(broken) == nil || wallets[0].ValidateTx(broken) == nil {
+					t.Errorf("the schnorr signatures with the bitfield %x passed", bitfield)
+				}
+			}
+		}
+	}
+}
+
+func TestMultisigMixedSignatures(t *testing.T) {
+	wallets, _ := newTestCosigners(t)
+	p := newMultisigPartialTx(t, wallets[0])
+	err := wallets[0].SetSignatureType(SignatureSchnorr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = wallets[0].SignPartial(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the cosigner preferring ECDSA follows the schnorr signature already made
+	err = wallets[1].SetSignatureType(SignatureECDSA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := copyPartialTx(t, p)
+	_, err = wallets[1].SignPartial(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, sigHex := range c.Inputs[0].PartialSigs {
+		sig, _ := hex.DecodeString(sigHex)
+		if !isSchnorrSignature(sig) {
+			t.Fatal("the multisig signed with the mixed signature types")
+		}
+	}
+
+	// the ECDSA signature added next to the schnorr one can't be finalized
+	mixed := copyPartialTx(t, p)
+	key := wallets[2].Signer.(*HDSigner).multisig
+	for pubKeyHex := range mixed.Inputs[0].PartialSigs {
+		pubKey, _ := hex.DecodeString(pubKeyHex)
+		if key.lookupPubKey(pubKey) != nil {
+			t.Fatal("the third cosigner signed")
+		}
+	}
+	var ecdsaSig []byte
+	for _, d := range mixed.Inputs[0].Derivations {
+		pubKey, _ := hex.DecodeString(d.PubKey)
+		k := key.lookupPubKey(pubKey)
+		if k == nil {
+			continue
+		}
+		hash, hashType, err := mixed.SigHash(0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signature, err := SignatureECDSA.sign(k.private, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ecdsaSig = append(signature.Serialize(), byte(hashType))
+		err = mixed.AddSignature(0, k.pubKey, ecdsaSig)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ecdsaSig == nil {
+		t.Fatal("the third cosigner key not found")
+	}
+	if err := mixed.Finalize(); err == nil {
+		t.Fatal("the multisig finalized with the mixed signature types")
+	}
+
+	// neither mode of the script engine accepts the mixed signatures
+	redeemScript, sigs, positions := multisigSignatures(t, mixed.Inputs[0])
+	tx := mixed.Tx()
+	bitfield := []byte{byte(1<<positions[0] | 1<<positions[1])}
+	for _, dummy := range [][]byte{nil, bitfield} {
+		broken := withUnlockingScript(t, tx, redeemScript, dummy, sigs...)
+		if runScriptEngine(broken) == nil || wallets[0].ValidateTx(broken) == nil {
+			t.Errorf("the mixed signatures with the dummy %x passed", dummy)
+		}
+	}
+
+	// the schnorr signatures of the two cosigners finalize
+	err = p.Combine(c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed, err := wallets[1].FinalizePartial(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := runScriptEngine(signed); err != nil {
+		t.Fatalf("the script engine failed: %v", err)
+	}
+}
@@ -0,0 +1,478 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"strconv"
+
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	// PartialTxVersion is the version of the partially signed transaction format
+	PartialTxVersion = 1
+)
+
+var (
+	ErrPartialTxMismatch   = errors.New("partially signed transactions spend different inputs or pay different outputs")
+	ErrPartialTxIncomplete = errors.New("partially signed transaction is not finalized")
+)
+
+// KeyDerivation tells which key of the wallet belongs to the input or the output
+type KeyDerivation struct {
+	PubKey string `json:"pubKey"`
+	Path   string `json:"path"`
+}
+
+// PartialInput is the input with everything the signers need, PubScript is
+// the locking script of the spent output, RedeemScript is set for the P2SH
+// inputs and PartialSigs maps the hex public key to the signature with the
+// hash type byte
+type PartialInput struct {
+	PrevHash     string               `json:"prevHash"`
+	PrevIndex    uint32               `json:"prevIndex"`
+	Sequence     uint32               `json:"sequence"`
+	Value        int64                `json:"value"`
+	PubScript    string               `json:"pubScript"`
+	Token        *bhdmodels.TokenData `json:"token,omitempty"`
+	RedeemScript string               `json:"redeemScript,omitempty"`
+	HashType     uint32               `json:"hashType"`
+	Derivations  []*KeyDerivation     `json:"derivations,omitempty"`
+	PartialSigs  map[string]string    `json:"partialSigs,omitempty"`
+	FinalScript  string               `json:"finalScript,omitempty"`
+}
+
+// PartialOutput is the output, Derivations are set for the change of the wallet
+type PartialOutput struct {
+	Value       int64                `json:"value"`
+	PkScript    string               `json:"pkScript"`
+	Address     string               `json:"address,omitempty"`
+	Token       *bhdmodels.TokenData `json:"token,omitempty"`
+	Derivations []*KeyDerivation     `json:"derivations,omitempty"`
+}
+
+// PartialTx is the partially signed transaction passed between the signers, each
+// adds its signatures, the copies are combined and the finalized transaction extracted
+type PartialTx struct {
+	Version int              `json:"version"`
+	Inputs  []*PartialInput  `json:"inputs"`
+	Outputs []*PartialOutput `json:"outputs"`
+}
+
+// NewPartialTx creates the partially signed transaction, the inputs which
+// are already signed are taken as finalized
+func NewPartialTx(tx *bhdmodels.Tx) (*PartialTx, error) {
+	p := &PartialTx{Version: PartialTxVersion}
+	for i, in := range tx.Inputs {
+		hashType, err := InputHashType(in.HashType)
+		if err != nil {
+			return nil, errors.New("input " + strconv.Itoa(i) + " " + err.Error())
+		}
+		p.Inputs = append(p.Inputs, &PartialInput{
+			PrevHash:    in.PrevHash,
+			PrevIndex:   in.PrevIndex,
+			Sequence:    in.Sequence,
+			Value:       in.Value,
+			PubScript:   in.PubScript,
+			Token:       in.Token,
+			HashType:    uint32(hashType),
+			PartialSigs: make(map[string]string),
+			FinalScript: in.Signature,
+		})
+	}
+	for _, out := range tx.Outputs {
+		p.Outputs = append(p.Outputs, &PartialOutput{
+			Value:    out.Value,
+			PkScript: out.PkScript,
+			Address:  out.Address,
+			Token:    out.Token,
+		})
+	}
+	return p, nil
+}
+
+// ParsePartialTx parses the serialized partially signed transaction
+func ParsePartialTx(content []byte) (*PartialTx, error) {
+	p := &PartialTx{}
+	err := json.Unmarshal(content, p)
+	if err != nil {
+		return nil, err
+	}
+	if p.Version < 1 || p.Version > PartialTxVersion {
+		return nil, errors.New("unsupported partially signed transaction version " + strconv.Itoa(p.Version))
+	}
+	for i, in := range p.Inputs {
+		if in.PartialSigs == nil {
+			in.PartialSigs = make(map[string]string)
+		}
+		if in.HashType > 0xff || checkHashType(txscript.SigHashType(in.HashType)) != nil {
+			return nil, errors.New("input " + strconv.Itoa(i) + " has invalid hash type " + strconv.Itoa(int(in.HashType)))
+		}
+	}
+	return p, nil
+}
+
+// Serialize returns the versioned json of the partially signed transaction
+func (p *PartialTx) Serialize() ([]byte, error) {
+	return json.Marshal(p)
+}
+
+// Tx returns the transaction model, the inputs have the final scripts
+func (p *PartialTx) Tx() *bhdmodels.Tx {
+	tx := &bhdmodels.Tx{Version: 1}
+	for _, in := range p.Inputs {
+		tx.Inputs = append(tx.Inputs, &bhdmodels.TxIn{
+			Sequence:  in.Sequence,
+			Value:     in.Value,
+			PrevHash:  in.PrevHash,
+			PrevIndex: in.PrevIndex,
+			PubScript: in.PubScript,
+			Signature: in.FinalScript,
+			Token:     in.Token,
+			HashType:  in.HashType,
+		})
+		tx.InputVal += in.Value
+	}
+	for _, out := range p.Outputs {
+		tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+			Value:    out.Value,
+			PkScript: out.PkScript,
+			Address:  out.Address,
+			Token:    out.Token,
+		})
+		tx.OutputVal += out.Value
+	}
+	tx.NetworkFee = tx.InputVal - tx.OutputVal
+	return tx
+}
+
+// UnsignedHash identifies the transaction regardless of the signatures
+func (p *PartialTx) UnsignedHash() (string, error) {
+	tx := p.Tx()
+	for _, in := range tx.Inputs {
+		in.Signature = ""
+	}
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return "", err
+	}
+	return msgTx.TxHash().String(), nil
+}
+
+// spentScript returns the script code signed by the input and the token
+// prefix, the script code of the P2SH input is the redeem script
+func (in *PartialInput) spentScript() ([]byte, []byte, error) {
+	txIn := &bhdmodels.TxIn{PubScript: in.PubScript, Token: in.Token}
+	script, tokenPrefix, err := txIn.SpentScript()
+	if err != nil || in.RedeemScript == "" {
+		return script, tokenPrefix, err
+	}
+	redeemScript, err := hex.DecodeString(in.RedeemScript)
+	if err != nil {
+		return nil, nil, err
+	}
+	if txscript.GetScriptClass(script) != txscript.ScriptHashTy || !bytes.Equal(bchutil.Hash160(redeemScript), script[2:22]) {
+		return nil, nil, errors.New("redeem script doesn't match the spent output")
+	}
+	return redeemScript, tokenPrefix, nil
+}
+
+// SigHash returns the hash the input signature commits to
+func (p *PartialTx) SigHash(idx int) ([]byte, txscript.SigHashType, error) {
+	if idx < 0 || idx >= len(p.Inputs) {
+		return nil, 0, errors.New("input index out of range")
+	}
+	in := p.Inputs[idx]
+	scriptCode, tokenPrefix, err := in.spentScript()
+	if err != nil {
+		return nil, 0, err
+	}
+	tx := p.Tx()
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	utxos, err := spentOutputs(tx)
+	if err != nil {
+		return nil, 0, err
+	}
+	hashType := txscript.SigHashType(in.HashType)
+	hash, err := calcSignatureHash(msgTx, idx, utxos, scriptCode, tokenPrefix, in.Value, hashType)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hash, hashType, nil
+}
+
+// AddSignature verifies the signature (hash type included) of the public key
+// and adds it to the input
+func (p *PartialTx) AddSignature(idx int, pubKey []byte, sig []byte) error {
+	if idx < 0 || idx >= len(p.Inputs) {
+		return errors.New("input index out of range")
+	}
+	in := p.Inputs[idx]
+	if len(sig) == 0 || uint32(sig[len(sig)-1]) != in.HashType {
+		return errors.New("signature hash type doesn't match the input")
+	}
+	scriptCode, tokenPrefix, err := in.spentScript()
+	if err != nil {
+		return err
+	}
+	if txscript.GetScriptClass(scriptCode) == txscript.MultiSigTy {
+		err = checkMultisigSignature(scriptCode, pubKey)
+		if err != nil {
+			return err
+		}
+	}
+	tx := p.Tx()
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return err
+	}
+	utxos, err := spentOutputs(tx)
+	if err != nil {
+		return err
+	}
+	err = verifyTxSignature(msgTx, idx, utxos, in.Value, scriptCode, tokenPrefix, sig, pubKey)
+	if err != nil {
+		return err
+	}
+	in.PartialSigs[hex.EncodeToString(pubKey)] = hex.EncodeToString(sig)
+	return nil
+}
+
+// Combine merges the signatures and derivations of the other copy of the transaction,
+// the signatures are verified first and the ones already added are never replaced
+func (p *PartialTx) Combine(other *PartialTx) error {
+	hash, err := p.UnsignedHash()
+	if err != nil {
+		return err
+	}
+	otherHash, err := other.UnsignedHash()
+	if err != nil {
+		return err
+	}
+	if hash != otherHash || len(p.Inputs) != len(other.Inputs) {
+		return ErrPartialTxMismatch
+	}
+	merged := p.clone()
+	for i, in := range merged.Inputs {
+		otherIn := other.Inputs[i]
+		if in.Value != otherIn.Value || in.PubScript != otherIn.PubScript || in.HashType != otherIn.HashType {
+			return ErrPartialTxMismatch
+		}
+		if in.RedeemScript == "" {
+			in.RedeemScript = otherIn.RedeemScript
+		}
+		for pubKey, sig := range otherIn.PartialSigs {
+			if _, ok := in.PartialSigs[pubKey]; !ok {
+				in.PartialSigs[pubKey] = sig
+			}
+		}
+		in.Derivations = mergeDerivations(in.Derivations, otherIn.Derivations)
+		if in.FinalScript == "" {
+			in.FinalScript = otherIn.FinalScript
+		}
+	}
+	for i, out := range merged.Outputs {
+		out.Derivations = mergeDerivations(out.Derivations, other.Outputs[i].Derivations)
+	}
+	err = merged.checkSignatures()
+	if err != nil {
+		return err
+	}
+	p.Inputs, p.Outputs = merged.Inputs, merged.Outputs
+	return nil
+}
+
+// clone copies the inputs and outputs so they can be changed without touching p
+func (p *PartialTx) clone() *PartialTx {
+	c := &PartialTx{Version: p.Version}
+	for _, in := range p.Inputs {
+		inCopy := *in
+		inCopy.Derivations = append([]*KeyDerivation(nil), in.Derivations...)
+		inCopy.PartialSigs = make(map[string]string, len(in.PartialSigs))
+		for pubKey, sig := range in.PartialSigs {
+			inCopy.PartialSigs[pubKey] = sig
+		}
+		c.Inputs = append(c.Inputs, &inCopy)
+	}
+	for _, out := range p.Outputs {
+		outCopy := *out
+		outCopy.Derivations = append([]*KeyDerivation(nil), out.Derivations...)
+		c.Outputs = append(c.Outputs, &outCopy)
+	}
+	return c
+}
+
+// Append adds the inputs and outputs of the other transaction, this way the players
+// complete the transaction signed with ANYONECANPAY, SINGLE or NONE. It fails when
+// the new inputs or outputs break the signatures already made.
+func (p *PartialTx) Append(other *PartialTx) error {
+	known := make(map[string]bool, len(p.Inputs))
+	for _, in := range p.Inputs {
+		known[in.PrevHash+":"+strconv.Itoa(int(in.PrevIndex))] = true
+	}
+	for _, in := range other.Inputs {
+		if known[in.PrevHash+":"+strconv.Itoa(int(in.PrevIndex))] {
+			return errors.New("the output " + in.PrevHash + ":" + strconv.Itoa(int(in.PrevIndex)) + " is already spent by the tx")
+		}
+	}
+	inputs, outputs := len(p.Inputs), len(p.Outputs)
+	p.Inputs = append(p.Inputs, other.Inputs...)
+	p.Outputs = append(p.Outputs, other.Outputs...)
+	err := p.checkSignatures()
+	if err != nil {
+		p.Inputs, p.Outputs = p.Inputs[:inputs], p.Outputs[:outputs]
+		return err
+	}
+	return nil
+}
+
+// checkSignatures verifies the partial signatures and the final scripts of all inputs
+func (p *PartialTx) checkSignatures() error {
+	tx := p.Tx()
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return err
+	}
+	utxos, err := spentOutputs(tx)
+	if err != nil {
+		return err
+	}
+	for i, in := range p.Inputs {
+		scriptCode, tokenPrefix, err := in.spentScript()
+		if err != nil {
+			return err
+		}
+		for pubKeyHex, sigHex := range in.PartialSigs {
+			pubKey, err := hex.DecodeString(pubKeyHex)
+			if err != nil {
+				return err
+			}
+			sig, err := hex.DecodeString(sigHex)
+			if err != nil {
+				return err
+			}
+			if len(sig) == 0 || uint32(sig[len(sig)-1]) != in.HashType {
+				return errors.New("input " + strconv.Itoa(i) + " signature hash type doesn't match the input")
+			}
+			if txscript.GetScriptClass(scriptCode) == txscript.MultiSigTy {
+				err = checkMultisigSignature(scriptCode, pubKey)
+				if err != nil {
+					return errors.New("input " + strconv.Itoa(i) + " " + err.Error())
+				}
+			}
+			err = verifyTxSignature(msgTx, i, utxos, in.Value, scriptCode, tokenPrefix, sig, pubKey)
+			if err != nil {
+				return errors.New("input " + strconv.Itoa(i) + " signature is broken: " + err.Error())
+			}
+		}
+		if in.FinalScript == "" {
+			continue
+		}
+		lockingScript, _, err := tx.Inputs[i].SpentScript()
+		if err != nil {
+			return err
+		}
+		switch txscript.GetScriptClass(lockingScript) {
+		case txscript.PubKeyHashTy:
+			err = verifyP2PKHInput(msgTx, i, utxos, in.Value, lockingScript, tokenPrefix)
+		case txscript.ScriptHashTy:
+			err = verifyMultisigInput(msgTx, i, utxos, in.Value, lockingScript, tokenPrefix)
+		default:
+			err = errors.New("unsupported script")
+		}
+		if err != nil {
+			return errors.New("input " + strconv.Itoa(i) + " final script is broken: " + err.Error())
+		}
+	}
+	return nil
+}
+
+func mergeDerivations(derivations []*KeyDerivation, other []*KeyDerivation) []*KeyDerivation {
+	known := make(map[string]bool, len(derivations))
+	for _, d := range derivations {
+		known[d.PubKey] = true
+	}
+	for _, d := range other {
+		if !known[d.PubKey] {
+			derivations = append(derivations, d)
+			known[d.PubKey] = true
+		}
+	}
+	return derivations
+}
+
+// Finalize builds the unlocking scripts of the inputs from the partial signatures
+func (p *PartialTx) Finalize() error {
+	for i, in := range p.Inputs {
+		if in.FinalScript != "" {
+			continue
+		}
+		scriptCode, _, err := in.spentScript()
+		if err != nil {
+			return err
+		}
+		if txscript.GetScriptClass(scriptCode) == txscript.MultiSigTy {
+			script, err := finalizeMultisig(scriptCode, in.PartialSigs)
+			if err != nil {
+				return errors.New("input " + strconv.Itoa(i) + " " + err.Error())
+			}
+			in.FinalScript = hex.EncodeToString(script)
+			continue
+		}
+		if txscript.GetScriptClass(scriptCode) != txscript.PubKeyHashTy {
+			return errors.New("input " + strconv.Itoa(i) + " has unsupported script")
+		}
+		for pubKeyHex, sigHex := range in.PartialSigs {
+			pubKey, err := hex.DecodeString(pubKeyHex)
+			if err != nil || !bytes.Equal(bchutil.Hash160(pubKey), scriptCode[3:23]) {
+				continue
+			}
+			sig, err := hex.DecodeString(sigHex)
+			if err != nil {
+				return err
+			}
+			script, err := p2pkhUnlockingScript(sig, pubKey)
+			if err != nil {
+				return err
+			}
+			in.FinalScript = hex.EncodeToString(script)
+			break
+		}
+		if in.FinalScript == "" {
+			return errors.New("input " + strconv.Itoa(i) + " is not signed")
+		}
+	}
+	return nil
+}
+
+// IsComplete returns true when all inputs are finalized
+func (p *PartialTx) IsComplete() bool {
+	for _, in := range p.Inputs {
+		if in.FinalScript == "" {
+			return false
+		}
+	}
+	return true
+}
+
+// Extract returns the signed transaction, all inputs must be finalized
+func (p *PartialTx) Extract() (*bhdmodels.Tx, error) {
+	if !p.IsComplete() {
+		return nil, ErrPartialTxIncomplete
+	}
+	tx := p.Tx()
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return nil, err
+	}
+	tx.Hash = msgTx.TxHash().String()
+	tx.Size = int32(msgTx.SerializeSize())
+	return tx, nil
+}
@@ -0,0 +1,220 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/gcash/bchd/txscript"
+)
+
+const otherTestMnemonic = "legal winner thank year wave sausage worth useful legal winner thank yellow"
+
+// testPartialTxs returns the transaction of the first wallet which pays the second one with its
+// input signed as SINGLE|ANYONECANPAY, and the transaction of the second wallet which completes it
+func testPartialTxs(t *testing.T, w, other *Wallet) (*PartialTx, *PartialTx) {
+	t.Helper()
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherScript, err := other.addressScript(other.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bhdmodels.Tx{
+		Inputs: []*bhdmodels.TxIn{{PrevHash: strings.Repeat("01", 32), PrevIndex: 0, Sequence: 0xffffffff,
+			Value: 20000, PubScript: script,
+			HashType: uint32(txscript.SigHashSingle | txscript.SigHashAnyOneCanPay)}},
+		Outputs: []*bhdmodels.TxOut{{Value: 10000, PkScript: otherScript, Address: other.PubAddress}},
+	}
+	p, err := w.NewPartialTx(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherTx := &bhdmodels.Tx{
+		Inputs: []*bhdmodels.TxIn{{PrevHash: strings.Repeat("02", 32), PrevIndex: 1, Sequence: 0xffffffff,
+			Value: 15000, PubScript: otherScript}},
+		Outputs: []*bhdmodels.TxOut{{Value: 24000, PkScript: script, Address: w.PubAddress}},
+	}
+	otherP, err := other.NewPartialTx(otherTx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p, otherP
+}
+
+// signPartialInput signs the input with the wallet key of its script and adds the signature
+func signPartialInput(t *testing.T, w *Wallet, p *PartialTx, idx int) {
+	t.Helper()
+	scriptCode, _, err := p.Inputs[idx].spentScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := w.Signer.(*HDSigner).keys.lookup(scriptCode)
+	if key == nil {
+		t.Fatalf("the wallet has no key for the input %d", idx)
+	}
+	hash, hashType, err := p.SigHash(idx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature, err := SignatureSchnorr.sign(key.private, hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.AddSignature(idx, key.pubKey, append(signature.Serialize(), byte(hashType)))
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+// copyPartialTx passes the transaction through its serialized form like the other signer gets it
+func copyPartialTx(t *testing.T, p *PartialTx) *PartialTx {
+	t.Helper()
+	content, err := p.Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := ParsePartialTx(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func TestPartialTxRoundTrip(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	other := newTestWallet(t, otherTestMnemonic)
+	p, otherP := testPartialTxs(t, w, other)
+	if len(p.Inputs[0].Derivations) != 1 || p.Inputs[0].Derivations[0].Path != DefaultAccountPath+"/0/255" {
+		t.Fatalf("unexpected input derivations %+v", p.Inputs[0].Derivations)
+	}
+	signPartialInput(t, w, p, 0)
+
+	// the SINGLE|ANYONECANPAY signature stays valid when the other input and output are appended
+	err := p.Append(otherP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Append(otherP); err == nil {
+		t.Error("the same input appended twice")
+	}
+	if _, err := p.Extract(); err != ErrPartialTxIncomplete {
+		t.Fatalf("the partially signed transaction extracted: %v", err)
+	}
+
+	otherCopy := copyPartialTx(t, p)
+	signed, err := other.SignPartial(otherCopy)
+	if err != nil || signed != 1 {
+		t.Fatalf("the other wallet signed %d inputs: %v", signed, err)
+	}
+	err = p.Combine(otherCopy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(p.Inputs[1].PartialSigs) != 1 {
+		t.Fatalf("the signature of the other wallet not combined")
+	}
+	err = p.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tx.Inputs) != 2 || len(tx.Outputs) != 2 || tx.NetworkFee != 1000 {
+		t.Fatalf("unexpected extracted transaction %+v", tx)
+	}
+	if err := w.ValidateTx(tx); err != nil {
+		t.Fatalf("the extracted transaction is not valid: %v", err)
+	}
+}
+
+func TestPartialTxCombineMismatch(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	other := newTestWallet(t, otherTestMnemonic)
+	p, otherP := testPartialTxs(t, w, other)
+	tests := []struct {
+		name   string
+		change func(c *PartialTx)
+	}{
+		{"output value", func(c *PartialTx) { c.Outputs[0].Value++ }},
+		{"input value", func(c *PartialTx) { c.Inputs[0].Value++ }},
+		{"hash type", func(c *PartialTx) { c.Inputs[0].HashType = uint32(DefaultHashType) }},
+		{"other tx", func(c *PartialTx) { *c = *copyPartialTx(t, otherP) }},
+	}
+	for _, test := range tests {
+		c := copyPartialTx(t, p)
+		test.change(c)
+		if err := p.Combine(c); err != ErrPartialTxMismatch {
+			t.Errorf("%s: expected the mismatch, got %v", test.name, err)
+		}
+	}
+}
+
+func TestPartialTxCombineTampered(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	other := newTestWallet(t, otherTestMnemonic)
+	p, otherP := testPartialTxs(t, w, other)
+	signPartialInput(t, w, p, 0)
+	err := p.Append(otherP)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := copyPartialTx(t, p)
+	signPartialInput(t, other, signed, 1)
+	var pubKey, sig string
+	for pubKey, sig = range signed.Inputs[1].PartialSigs {
+	}
+
+	// the broken signature of the new key is refused and nothing is merged
+	tampered := copyPartialTx(t, signed)
+	sigBytes, _ := hex.DecodeString(sig)
+	sigBytes[0] ^= 1
+	tampered.Inputs[1].PartialSigs[pubKey] = hex.EncodeToString(sigBytes)
+	tampered.Inputs[1].Derivations = append(tampered.Inputs[1].Derivations, &KeyDerivation{PubKey: "02", Path: "m/0"})
+	if err := p.Combine(tampered); err == nil {
+		t.Fatal("the tampered signature combined")
+	}
+	if len(p.Inputs[1].PartialSigs) != 0 || len(p.Inputs[1].Derivations) != 1 {
+		t.Fatal("the tampered transaction partially combined")
+	}
+
+	// the signature of the other hash type is refused
+	hashType := signed.Inputs[1].HashType
+	sigBytes[0] ^= 1
+	sigBytes[len(sigBytes)-1] = byte(txscript.SigHashNone | txscript.SigHashForkID)
+	tampered.Inputs[1].PartialSigs[pubKey] = hex.EncodeToString(sigBytes)
+	if err := p.Combine(tampered); err == nil {
+		t.Fatal("the signature of the other hash type combined")
+	}
+	sigBytes[len(sigBytes)-1] = byte(hashType)
+
+	// the valid signature already added is never replaced
+	var firstKey, firstSig string
+	for firstKey, firstSig = range p.Inputs[0].PartialSigs {
+	}
+	replaced := copyPartialTx(t, signed)
+	replaced.Inputs[0].PartialSigs[firstKey] = hex.EncodeToString(sigBytes)
+	err = p.Combine(replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.Inputs[0].PartialSigs[firstKey] != firstSig || p.Inputs[1].PartialSigs[pubKey] != sig {
+		t.Fatal("unexpected signatures after the combine")
+	}
+	err = p.Finalize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ValidateTx(tx); err != nil {
+		t.Fatalf("the extracted transaction is not valid: %v", err)
+	}
+}
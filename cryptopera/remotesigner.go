@@ -0,0 +1,218 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bhd/log"
+	"bufio"
+	"encoding/json"
+	"errors"
+	"net"
+	"time"
+)
+
+/*
+The remote signer protocol keeps the keys in the other process or device. The client
+connects to the local socket (unix or tcp on localhost) and writes one json request
+per line, the signer answers with one json response per line:
+
+	{"id":1,"method":"sign","tx":{UnsignedTx}}
+	{"id":1,"signatures":[{InputSignature},...]}
+
+On failure the response has the error field instead of the signatures. The signer
+computes the sig hashes from the transaction itself, the ones sent are only a hint.
+The protocol has no authentication, so both sides only accept the unix sockets and
+the loopback tcp addresses, and the signer asks the user to approve every transaction.
+*/
+
+const (
+	RemoteMethodSign = "sign"
+	// DefaultRemoteSignerTimeout covers the user confirming the transaction on the device
+	DefaultRemoteSignerTimeout = 2 * time.Minute
+	// maxRemoteMessageSize limits the line read from the socket
+	maxRemoteMessageSize = 4 * 1024 * 1024
+)
+
+var (
+	ErrSignerNotLocal = errors.New("remote signer must use the unix socket or the loopback address")
+	ErrSignRejected   = errors.New("the transaction was rejected by the user")
+)
+
+// RemoteRequest is the request sent to the remote signer
+type RemoteRequest struct {
+	Id     uint64      `json:"id"`
+	Method string      `json:"method"`
+	Tx     *UnsignedTx `json:"tx,omitempty"`
+}
+
+// RemoteResponse is the reply of the remote signer
+type RemoteResponse struct {
+	Id         uint64            `json:"id"`
+	Signatures []*InputSignature `json:"signatures,omitempty"`
+	Error      string            `json:"error,omitempty"`
+}
+
+// RemoteSigner asks the signer listening on the local socket to sign
+type RemoteSigner struct {
+	Network string
+	Address string
+	Timeout time.Duration
+	lastId  uint64
+}
+
+// checkLocalAddress returns ErrSignerNotLocal unless the address is the unix
+// socket or the tcp address of the loopback interface
+func checkLocalAddress(network string, address string) error {
+	switch network {
+	case "unix":
+		return nil
+	case "tcp", "tcp4", "tcp6":
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return err
+		}
+		if host == "localhost" {
+			return nil
+		}
+		ip := net.ParseIP(host)
+		if ip != nil && ip.IsLoopback() {
+			return nil
+		}
+	}
+	return ErrSignerNotLocal
+}
+
+// NewRemoteSigner creates the signer connecting to the address, network is
+// unix or tcp, the tcp address must be on the loopback interface
+func NewRemoteSigner(network string, address string) (*RemoteSigner, error) {
+	err := checkLocalAddress(network, address)
+	if err != nil {
+		return nil, err
+	}
+	return &RemoteSigner{
+		Network: network,
+		Address: address,
+		Timeout: DefaultRemoteSignerTimeout,
+	}, nil
+}
+
+func (s *RemoteSigner) SignTransaction(tx *bhdmodels.Tx) error {
+	err := checkLocalAddress(s.Network, s.Address)
+	if err != nil {
+		return err
+	}
+	_, inputs, err := signableInputs(tx)
+	if err != nil {
+		return err
+	}
+	s.lastId++
+	rq := &RemoteRequest{
+		Id:     s.lastId,
+		Method: RemoteMethodSign,
+		Tx:     &UnsignedTx{Version: UnsignedTxVersion, Tx: tx, Inputs: inputs},
+	}
+	conn, err := net.DialTimeout(s.Network, s.Address, s.Timeout)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+	err = writeRemoteMessage(conn, rq)
+	if err != nil {
+		return err
+	}
+	var rsp RemoteResponse
+	err = readRemoteMessage(bufio.NewReaderSize(conn, 4096), &rsp)
+	if err != nil {
+		return err
+	}
+	if rsp.Id != rq.Id {
+		return errors.New("remote signer answered other request")
+	}
+	if rsp.Error != "" {
+		return errors.New("remote signer failed: " + rsp.Error)
+	}
+	if len(rsp.Signatures) != len(tx.Inputs) {
+		return errors.New("remote signer didn't sign all inputs")
+	}
+	return ApplySignatures(tx, rsp.Signatures)
+}
+
+func writeRemoteMessage(conn net.Conn, v interface{}) error {
+	content, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	_, err = conn.Write(append(content, '\n'))
+	return err
+}
+
+func readRemoteMessage(reader *bufio.Reader, v interface{}) error {
+	var line []byte
+	for {
+		part, isPrefix, err := reader.ReadLine()
+		if err != nil {
+			return err
+		}
+		line = append(line, part...)
+		if len(line) > maxRemoteMessageSize {
+			return errors.New("remote signer message is too large")
+		}
+		if !isPrefix {
+			break
+		}
+	}
+	return json.Unmarshal(line, v)
+}
+
+// ServeSigner answers the remote signer requests with the HD signer until the
+// listener is closed, it runs in the process holding the keys. The listener must
+// be the unix socket or on the loopback interface. approve shows the transaction
+// to the user and returns true when it may be signed, it's called for every request.
+func ServeSigner(listener net.Listener, signer *HDSigner, approve func(*UnsignedTx) bool) error {
+	addr := listener.Addr()
+	err := checkLocalAddress(addr.Network(), addr.String())
+	if err != nil {
+		return err
+	}
+	if approve == nil {
+		return errors.New("remote signer needs the approve callback")
+	}
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			return err
+		}
+		go serveSignerConn(conn, signer, approve)
+	}
+}
+
+func serveSignerConn(conn net.Conn, signer *HDSigner, approve func(*UnsignedTx) bool) {
+	defer conn.Close()
+	reader := bufio.NewReaderSize(conn, 4096)
+	for {
+		var rq RemoteRequest
+		err := readRemoteMessage(reader, &rq)
+		if err != nil {
+			return
+		}
+		rsp := &RemoteResponse{Id: rq.Id}
+		switch {
+		case rq.Method != RemoteMethodSign:
+			rsp.Error = "unknown method " + rq.Method
+		case rq.Tx == nil || rq.Tx.Tx == nil:
+			rsp.Error = "no transaction to sign"
+		case !approve(rq.Tx):
+			rsp.Error = ErrSignRejected.Error()
+		default:
+			rsp.Signatures, err = signer.SignInputs(rq.Tx.Tx)
+			if err != nil {
+				rsp.Error = err.Error()
+			}
+		}
+		err = writeRemoteMessage(conn, rsp)
+		if err != nil {
+			log.Warn("Cannot answer the remote signer request:", err.Error())
+			return
+		}
+	}
+}
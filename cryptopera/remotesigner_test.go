@@ -0,0 +1,101 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"errors"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"github.com/gcash/bchd/wire"
+)
+
+func TestCheckLocalAddress(t *testing.T) {
+	for _, test := range []struct {
+		network string
+		address string
+		local   bool
+	}{
+		{"unix", "/tmp/signer.sock", true},
+		{"tcp", "127.0.0.1:9000", true},
+		{"tcp", "127.1.2.3:9000", true},
+		{"tcp", "localhost:9000", true},
+		{"tcp6", "[::1]:9000", true},
+		{"tcp", "0.0.0.0:9000", false},
+		{"tcp", "[::]:9000", false},
+		{"tcp", "192.168.1.10:9000", false},
+		{"tcp", "signer.example.com:9000", false},
+		{"tcp", ":9000", false},
+		{"udp", "127.0.0.1:9000", false},
+	} {
+		err := checkLocalAddress(test.network, test.address)
+		if (err == nil) != test.local {
+			t.Errorf("%s %s: expected local %v, got %v", test.network, test.address, test.local, err)
+		}
+	}
+	if _, err := NewRemoteSigner("tcp", "10.0.0.1:9000"); !errors.Is(err, ErrSignerNotLocal) {
+		t.Errorf("expected ErrSignerNotLocal for the remote address, got %v", err)
+	}
+}
+
+func TestServeSignerApprove(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	hdSigner := w.Signer.(*HDSigner)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	var approved atomic.Bool
+	var requests atomic.Int32
+	go ServeSigner(listener, hdSigner, func(tx *UnsignedTx) bool {
+		requests.Add(1)
+		return approved.Load()
+	})
+
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{Value: 10000, PkScript: script, Address: w.PubAddress})
+	uxtos := []*bhdmodels.Uxto{{Hash: strings.Repeat("01", 32), Index: 0, PkScript: script, Value: 50000}}
+	err = w.fundTransaction(tx, uxtos, DefaultFeeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	remote, err := NewRemoteSigner("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = remote.SignTransaction(tx)
+	if err == nil || !strings.Contains(err.Error(), ErrSignRejected.Error()) {
+		t.Errorf("expected the rejected transaction, got %v", err)
+	}
+	approved.Store(true)
+	err = remote.SignTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := w.ValidateTx(tx); err != nil {
+		t.Errorf("remotely signed transaction is not valid: %v", err)
+	}
+	if requests.Load() != 2 {
+		t.Errorf("expected the approval of 2 requests, got %d", requests.Load())
+	}
+}
+
+func TestServeSignerNotLocal(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	listener, err := net.Listen("tcp", "0.0.0.0:0")
+	if err != nil {
+		t.Skip("cannot listen on all interfaces:", err)
+	}
+	defer listener.Close()
+	err = ServeSigner(listener, w.Signer.(*HDSigner), func(*UnsignedTx) bool { return true })
+	if !errors.Is(err, ErrSignerNotLocal) {
+		t.Errorf("expected ErrSignerNotLocal for the listener on all interfaces, got %v", err)
+	}
+}
@@ -0,0 +1,619 @@
+package cryptopera
+
+import (
+	"bhd/bch/blockstore"
+	"bhd/bch/cfilter"
+	"bhd/bch/msg"
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"bhd/utils"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	// DefaultGapLimit is the number of the unused addresses in a row after which
+	// the chain is taken as scanned (BIP44)
+	DefaultGapLimit = 20
+	// DefaultRestorePageSize is the page size of the requests to the BHD server
+	DefaultRestorePageSize = 100
+)
+
+// HistorySource answers which transactions and uxtos the addresses have, it's
+// the BHD server (ProvideTransactionsRequest / ProvideUxtoRequest) or the SPV layer
+type HistorySource interface {
+	ProvideTransactions(rq *bhdmodels.ProvideTransactionsRequest) (*bhdmodels.ProvideTransactionsResponse, error)
+	ProvideUxtos(rq *bhdmodels.ProvideUxtoRequest) (*bhdmodels.ProvideUxtoResponse, error)
+}
+
+// RestoreProgress is reported after every answered request, Index is the
+// next address index to scan on the Change chain
+type RestoreProgress struct {
+	Change       bip44.ChangeType `json:"change"`
+	Index        uint32           `json:"index"`
+	Used         int              `json:"used"`
+	Transactions int              `json:"transactions"`
+	Uxtos        int              `json:"uxtos"`
+	Done         bool             `json:"done"`
+}
+
+// RestoreResult is the rebuilt wallet state, NextExternal and NextInternal
+// are the first unused indexes of the chains
+type RestoreResult struct {
+	Addresses    []string          `json:"addresses"`
+	Transactions []*bhdmodels.Tx   `json:"transactions"`
+	Uxtos        []*bhdmodels.Uxto `json:"uxtos"`
+	NextExternal uint32            `json:"nextExternal"`
+	NextInternal uint32            `json:"nextInternal"`
+}
+
+// restoreAddress is the derived address of the scanned chains, wallet is
+// set for the wallet address which doesn't move the gap
+type restoreAddress struct {
+	address string
+	change  bip44.ChangeType
+	index   uint32
+	used    bool
+	wallet  bool
+}
+
+// Restorer discovers the used addresses of the wallet walking the external and
+// the internal chain with the gap limit, then it collects the uxtos. It's driven
+// by the requests: send TransactionsRequest until it returns nil, then UxtoRequest,
+// and pass the answers back. Run does it with the HistorySource.
+type Restorer struct {
+	GapLimit     int
+	PageSize     int
+	PlayerId     string
+	SessionToken string
+	OnProgress   func(progress *RestoreProgress)
+
+	wallet *Wallet
+	change bip44.ChangeType
+	next   uint32
+	// the last used index of the chains, -1 when none is used
+	lastUsed map[bip44.ChangeType]int64
+	batch    []string
+	skip     int
+	// keyed by the hex locking script
+	scripts    map[string]*restoreAddress
+	txs        map[string]*bhdmodels.Tx
+	uxtos      []*bhdmodels.Uxto
+	discovered bool
+	done       bool
+}
+
+// NewRestorer creates the restorer of the wallet, DefaultGapLimit is used
+// when the gap limit isn't positive
+func (w *Wallet) NewRestorer(gapLimit int) (*Restorer, error) {
+	if w.Account == nil {
+		return nil, errors.New("the wallet has no account key")
+	}
+	if gapLimit <= 0 {
+		gapLimit = DefaultGapLimit
+	}
+	r := &Restorer{
+		GapLimit: gapLimit,
+		PageSize: DefaultRestorePageSize,
+		wallet:   w,
+		change:   bip44.ExternalChangeType,
+		lastUsed: map[bip44.ChangeType]int64{bip44.ExternalChangeType: -1, bip44.InternalChangeType: -1},
+		scripts:  make(map[string]*restoreAddress),
+		txs:      make(map[string]*bhdmodels.Tx),
+	}
+	_, err := r.derive(bip44.ExternalChangeType, WalletAddressIndex)
+	if err != nil {
+		return nil, err
+	}
+	r.scripts[r.walletScript()].wallet = true
+	return r, nil
+}
+
+// walletScript returns the hex locking script of the wallet address
+func (r *Restorer) walletScript() string {
+	for script, address := range r.scripts {
+		if address.change == bip44.ExternalChangeType && address.index == WalletAddressIndex {
+			return script
+		}
+	}
+	return ""
+}
+
+// derive returns the address of the chain and remembers its locking script
+func (r *Restorer) derive(change bip44.ChangeType, index uint32) (string, error) {
+	address, err := r.wallet.Account.DeriveP2PKAddress(change, index, bip44.MAINNET)
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(address.PubAddress)
+	if err != nil {
+		return "", err
+	}
+	cashAddress, err := GetCashAddress(bhdmodels.BchAddressPrefixForGeneration, CashAddrP2PKH, address.PubAddress.Hash160()[:])
+	if err != nil {
+		return "", err
+	}
+	key := hex.EncodeToString(script)
+	if r.scripts[key] == nil {
+		r.scripts[key] = &restoreAddress{address: cashAddress, change: change, index: index}
+	}
+	return cashAddress, nil
+}
+
+// TransactionsRequest returns the request of the transactions of the next addresses,
+// nil when the discovery is finished
+func (r *Restorer) TransactionsRequest() (*bhdmodels.ProvideTransactionsRequest, error) {
+	if r.discovered {
+		return nil, nil
+	}
+	if r.batch == nil {
+		for i := 0; i < r.GapLimit; i++ {
+			address, err := r.derive(r.change, r.next+uint32(i))
+			if err != nil {
+				return nil, err
+			}
+			r.batch = append(r.batch, address)
+		}
+		// the history of the wallet address comes with the first batch
+		if r.change == bip44.ExternalChangeType && r.next == 0 {
+			r.batch = append(r.batch, r.wallet.PubAddress)
+		}
+	}
+	return &bhdmodels.ProvideTransactionsRequest{
+		PlayerId:     r.PlayerId,
+		SessionToken: r.SessionToken,
+		Address:      r.batch,
+		Skip:         r.skip,
+		PageSize:     r.PageSize,
+	}, nil
+}
+
+// AddTransactions processes the answer to the TransactionsRequest
+func (r *Restorer) AddTransactions(rsp *bhdmodels.ProvideTransactionsResponse) error {
+	if r.discovered || r.batch == nil {
+		return errors.New("no transactions were requested")
+	}
+	for _, tx := range rsp.Transactions {
+		err := r.markUsed(tx)
+		if err != nil {
+			return err
+		}
+		r.txs[tx.Hash] = tx
+	}
+	// the next page of the same addresses
+	if len(rsp.Transactions) > 0 && r.skip+len(rsp.Transactions) < rsp.TransactionCount {
+		r.skip += len(rsp.Transactions)
+		r.reportProgress()
+		return nil
+	}
+	r.next += uint32(r.GapLimit)
+	r.batch = nil
+	r.skip = 0
+	// the whole batch is unused, that's the gap limit
+	if r.lastUsed[r.change] < int64(r.next)-int64(r.GapLimit) {
+		if r.change == bip44.ExternalChangeType {
+			r.change = bip44.InternalChangeType
+			r.next = 0
+		} else {
+			r.discovered = true
+		}
+	}
+	r.reportProgress()
+	return nil
+}
+
+// markUsed marks the derived addresses the transaction pays to or spends from
+func (r *Restorer) markUsed(tx *bhdmodels.Tx) error {
+	var scripts []string
+	for _, in := range tx.Inputs {
+		if in.PubScript == "" {
+			continue
+		}
+		script, _, err := in.SpentScript()
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, hex.EncodeToString(script))
+	}
+	for _, out := range tx.Outputs {
+		script, err := out.LockingScript()
+		if err != nil {
+			return err
+		}
+		_, addressScript, err := bhdmodels.SplitTokenScript(script)
+		if err != nil {
+			return err
+		}
+		scripts = append(scripts, hex.EncodeToString(addressScript))
+	}
+	for _, script := range scripts {
+		address, ok := r.scripts[script]
+		if !ok {
+			continue
+		}
+		address.used = true
+		if !address.wallet && int64(address.index) > r.lastUsed[address.change] {
+			r.lastUsed[address.change] = int64(address.index)
+		}
+	}
+	return nil
+}
+
+// usedAddresses returns the used addresses ordered by the chain and the index
+func (r *Restorer) usedAddresses() []*restoreAddress {
+	var used []*restoreAddress
+	for _, address := range r.scripts {
+		if address.used {
+			used = append(used, address)
+		}
+	}
+	sort.Slice(used, func(i, j int) bool {
+		if used[i].change != used[j].change {
+			return used[i].change < used[j].change
+		}
+		return used[i].index < used[j].index
+	})
+	return used
+}
+
+// addresses returns the wallet address and the used addresses
+func (r *Restorer) addresses() []string {
+	var addresses = []string{r.wallet.PubAddress}
+	for _, address := range r.usedAddresses() {
+		if address.address != r.wallet.PubAddress {
+			addresses = append(addresses, address.address)
+		}
+	}
+	return addresses
+}
+
+// UxtoRequest returns the request of the uxtos of the used addresses, nil
+// before the discovery is finished and after all uxtos are received
+func (r *Restorer) UxtoRequest() *bhdmodels.ProvideUxtoRequest {
+	if !r.discovered || r.done {
+		return nil
+	}
+	return &bhdmodels.ProvideUxtoRequest{
+		PlayerId:     r.PlayerId,
+		SessionToken: r.SessionToken,
+		Address:      r.addresses(),
+		Skip:         len(r.uxtos),
+		PageSize:     r.PageSize,
+	}
+}
+
+// AddUxtos processes the answer to the UxtoRequest, after the last page the
+// used addresses are added to the wallet
+func (r *Restorer) AddUxtos(rsp *bhdmodels.ProvideUxtoResponse) error {
+	if !r.discovered || r.done {
+		return errors.New("no uxtos were requested")
+	}
+	r.uxtos = append(r.uxtos, rsp.Inputs...)
+	if len(rsp.Inputs) == 0 || len(r.uxtos) >= rsp.InputCount {
+		r.done = true
+		err := r.apply()
+		if err != nil {
+			return err
+		}
+	}
+	r.reportProgress()
+	return nil
+}
+
+// apply derives the keys of the used addresses, so the wallet watches
+// them and signs their uxtos
+func (r *Restorer) apply() error {
+	var addresses []string
+	for _, address := range r.usedAddresses() {
+		_, err := r.wallet.DeriveAddress(address.change, address.index)
+		if err != nil {
+			return err
+		}
+		addresses = append(addresses, address.address)
+	}
+	r.wallet.addAddresses(addresses)
+	return nil
+}
+
+// Done returns true when the uxtos of the discovered addresses are received
+func (r *Restorer) Done() bool {
+	return r.done
+}
+
+// Progress returns the state of the restore
+func (r *Restorer) Progress() *RestoreProgress {
+	return &RestoreProgress{
+		Change:       r.change,
+		Index:        r.next,
+		Used:         len(r.usedAddresses()),
+		Transactions: len(r.txs),
+		Uxtos:        len(r.uxtos),
+		Done:         r.done,
+	}
+}
+
+func (r *Restorer) reportProgress() {
+	if r.OnProgress != nil {
+		r.OnProgress(r.Progress())
+	}
+}
+
+// Result returns the rebuilt history and uxtos, the transactions are ordered by
+// the height with the unconfirmed ones (height 0) at the end
+func (r *Restorer) Result() *RestoreResult {
+	result := &RestoreResult{
+		Addresses:    r.addresses(),
+		Uxtos:        r.uxtos,
+		NextExternal: uint32(r.lastUsed[bip44.ExternalChangeType] + 1),
+		NextInternal: uint32(r.lastUsed[bip44.InternalChangeType] + 1),
+	}
+	for _, tx := range r.txs {
+		result.Transactions = append(result.Transactions, tx)
+	}
+	sort.SliceStable(result.Transactions, func(i, j int) bool {
+		a, b := result.Transactions[i], result.Transactions[j]
+		if (a.Height == 0) != (b.Height == 0) {
+			return b.Height == 0
+		}
+		if a.Height != b.Height {
+			return a.Height < b.Height
+		}
+		return a.Hash < b.Hash
+	})
+	return result
+}
+
+// Run restores the wallet asking the source
+func (r *Restorer) Run(source HistorySource) (*RestoreResult, error) {
+	for {
+		rq, err := r.TransactionsRequest()
+		if err != nil {
+			return nil, err
+		}
+		if rq == nil {
+			break
+		}
+		rsp, err := source.ProvideTransactions(rq)
+		if err != nil {
+			return nil, err
+		}
+		err = r.AddTransactions(rsp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for rq := r.UxtoRequest(); rq != nil; rq = r.UxtoRequest() {
+		rsp, err := source.ProvideUxtos(rq)
+		if err != nil {
+			return nil, err
+		}
+		err = r.AddUxtos(rsp)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return r.Result(), nil
+}
+
+// FilterSource gives the hash and the BIP158 basic filter of the block at the
+// height, the filters are checked by FilterHeaderChain.VerifyFilter
+type FilterSource interface {
+	BlockFilter(height int32) (msg.Hash, *cfilter.Filter, error)
+}
+
+// MissingBlocksError lists the blocks which match the filters of the wallet
+// but aren't in the store, download them and ask again
+type MissingBlocksError struct {
+	Hashes []msg.Hash
+}
+
+func (e *MissingBlocksError) Error() string {
+	return strconv.Itoa(len(e.Hashes)) + " blocks matching the wallet filters are not downloaded"
+}
+
+// BlockSource is the HistorySource of the SPV layer, the basic filters of the blocks
+// between the heights select the blocks paying to the addresses or spending their
+// outputs, only those are read from the local store. The token outputs are in the
+// filters with the token prefix, they're found only in the blocks selected otherwise.
+type BlockSource struct {
+	Store   *blockstore.Store
+	Filters FilterSource
+	From    int32
+	To      int32
+	// the transactions of the read blocks keyed by the height
+	blocks map[int32][]*bhdmodels.Tx
+}
+
+// NewBlockSource creates the source of the stored blocks from the height to the height
+func NewBlockSource(store *blockstore.Store, filters FilterSource, from int32, to int32) *BlockSource {
+	return &BlockSource{Store: store, Filters: filters, From: from, To: to}
+}
+
+// block reads the transactions of the stored block once
+func (s *BlockSource) block(hash msg.Hash, height int32) ([]*bhdmodels.Tx, error) {
+	if txs, ok := s.blocks[height]; ok {
+		return txs, nil
+	}
+	block, err := s.Store.GetByHash(hash)
+	if err != nil {
+		return nil, err
+	}
+	if s.blocks == nil {
+		s.blocks = make(map[int32][]*bhdmodels.Tx)
+	}
+	var txs []*bhdmodels.Tx
+	for i := range block.Transactions {
+		txs = append(txs, bhdmodels.NewTxFromMsg(&block.Transactions[i], height))
+	}
+	s.blocks[height] = txs
+	return txs, nil
+}
+
+// transactions returns the transactions of the blocks matching the scripts, the
+// outpoints of the outputs paying to the scripts are added to the matcher on the
+// way, so the later blocks spending them match too
+func (s *BlockSource) transactions(scripts map[string]bool) ([]*bhdmodels.Tx, error) {
+	var items [][]byte
+	for script := range scripts {
+		item, err := hex.DecodeString(script)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+	matcher := cfilter.NewMatcher(items)
+	var txs []*bhdmodels.Tx
+	var missing []msg.Hash
+	for height := s.From; height <= s.To; height++ {
+		hash, filter, err := s.Filters.BlockFilter(height)
+		if err != nil {
+			return nil, err
+		}
+		if !matcher.MatchBlock(hash, filter) {
+			continue
+		}
+		blockTxs, err := s.block(hash, height)
+		if errors.Is(err, blockstore.ErrBlockNotFound) {
+			missing = append(missing, hash)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, tx := range blockTxs {
+			for i, out := range tx.Outputs {
+				if !scripts[out.PkScript] {
+					continue
+				}
+				txHash, err := msg.NewHashFromString(tx.Hash)
+				if err != nil {
+					return nil, err
+				}
+				matcher.AddOutPoint(txHash, uint32(i))
+			}
+		}
+		txs = append(txs, blockTxs...)
+	}
+	if len(missing) > 0 {
+		return nil, &MissingBlocksError{Hashes: missing}
+	}
+	return txs, nil
+}
+
+// addressScripts returns the hex locking scripts of the addresses
+func addressScripts(addresses []string) (map[string]bool, error) {
+	scripts := make(map[string]bool, len(addresses))
+	for _, address := range addresses {
+		addr, err := bchutil.DecodeAddress(address, GetCryptoNetworkParams())
+		if err != nil {
+			return nil, err
+		}
+		script, err := txscript.PayToAddrScript(addr)
+		if err != nil {
+			return nil, err
+		}
+		scripts[hex.EncodeToString(script)] = true
+	}
+	return scripts, nil
+}
+
+// outputs returns the outputs of the transactions paying to the scripts and the spent outpoints
+func outputs(txs []*bhdmodels.Tx, scripts map[string]bool) (map[string]*bhdmodels.Tx, []*bhdmodels.Uxto, map[string]bool) {
+	var related = make(map[string]*bhdmodels.Tx)
+	var outputs []*bhdmodels.Uxto
+	var spent = make(map[string]bool)
+	var owned = make(map[string]*bhdmodels.Uxto)
+	for _, tx := range txs {
+		for i, out := range tx.Outputs {
+			if !scripts[out.PkScript] {
+				continue
+			}
+			related[tx.Hash] = tx
+			uxto := &bhdmodels.Uxto{Hash: tx.Hash, Index: int32(i), PkScript: out.PkScript, Value: out.Value, Token: out.Token}
+			outputs = append(outputs, uxto)
+			owned[outpointKey(tx.Hash, uint32(i))] = uxto
+		}
+	}
+	for _, tx := range txs {
+		for _, in := range tx.Inputs {
+			key := outpointKey(in.PrevHash, in.PrevIndex)
+			spent[key] = true
+			// the spends of our outputs are the history too
+			if owned[key] != nil {
+				related[tx.Hash] = tx
+			}
+		}
+	}
+	return related, outputs, spent
+}
+
+func outpointKey(hash string, index uint32) string {
+	return hash + ":" + strconv.Itoa(int(index))
+}
+
+// page returns the page of the items
+func page[T any](items []T, skip int, pageSize int) []T {
+	if skip >= len(items) {
+		return []T{}
+	}
+	items = items[skip:]
+	if pageSize > 0 && len(items) > pageSize {
+		items = items[:pageSize]
+	}
+	return items
+}
+
+func (s *BlockSource) ProvideTransactions(rq *bhdmodels.ProvideTransactionsRequest) (*bhdmodels.ProvideTransactionsResponse, error) {
+	scripts, err := addressScripts(rq.Address)
+	if err != nil {
+		return nil, err
+	}
+	blockTxs, err := s.transactions(scripts)
+	if err != nil {
+		return nil, err
+	}
+	related, _, _ := outputs(blockTxs, scripts)
+	var txs []*bhdmodels.Tx
+	for _, tx := range blockTxs {
+		if related[tx.Hash] != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return &bhdmodels.ProvideTransactionsResponse{
+		Transactions:     page(txs, rq.Skip, rq.PageSize),
+		TransactionCount: len(txs),
+		BasePdu:          rq.BasePdu,
+	}, nil
+}
+
+func (s *BlockSource) ProvideUxtos(rq *bhdmodels.ProvideUxtoRequest) (*bhdmodels.ProvideUxtoResponse, error) {
+	scripts, err := addressScripts(rq.Address)
+	if err != nil {
+		return nil, err
+	}
+	blockTxs, err := s.transactions(scripts)
+	if err != nil {
+		return nil, err
+	}
+	_, owned, spent := outputs(blockTxs, scripts)
+	var uxtos []*bhdmodels.Uxto
+	var balance int64
+	for _, uxto := range owned {
+		if !spent[outpointKey(uxto.Hash, uint32(uxto.Index))] {
+			uxtos = append(uxtos, uxto)
+			balance += uxto.Value
+		}
+	}
+	return &bhdmodels.ProvideUxtoResponse{
+		BalanceSat: balance,
+		BalanceBch: utils.SatoshiToMonetary(balance),
+		Inputs:     page(uxtos, rq.Skip, rq.PageSize),
+		InputCount: len(uxtos),
+		BasePdu:    rq.BasePdu,
+	}, nil
+}
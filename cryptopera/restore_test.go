@@ -0,0 +1,364 @@
+package cryptopera
+
+import (
+	"bhd/bch/blockstore"
+	"bhd/bch/cfilter"
+	"bhd/bch/msg"
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/bits"
+	"reflect"
+	"sort"
+	"testing"
+
+	"github.com/dchest/siphash"
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/wire"
+)
+
+// testHistory is the BHD server answering from the transactions in memory
+type testHistory struct {
+	txs       []*bhdmodels.Tx
+	txSkips   []int
+	uxtoSkips []int
+}
+
+func (h *testHistory) ProvideTransactions(rq *bhdmodels.ProvideTransactionsRequest) (*bhdmodels.ProvideTransactionsResponse, error) {
+	h.txSkips = append(h.txSkips, rq.Skip)
+	scripts, err := addressScripts(rq.Address)
+	if err != nil {
+		return nil, err
+	}
+	related, _, _ := outputs(h.txs, scripts)
+	var txs []*bhdmodels.Tx
+	for _, tx := range h.txs {
+		if related[tx.Hash] != nil {
+			txs = append(txs, tx)
+		}
+	}
+	return &bhdmodels.ProvideTransactionsResponse{Transactions: page(txs, rq.Skip, rq.PageSize), TransactionCount: len(txs)}, nil
+}
+
+func (h *testHistory) ProvideUxtos(rq *bhdmodels.ProvideUxtoRequest) (*bhdmodels.ProvideUxtoResponse, error) {
+	h.uxtoSkips = append(h.uxtoSkips, rq.Skip)
+	scripts, err := addressScripts(rq.Address)
+	if err != nil {
+		return nil, err
+	}
+	_, owned, _ := outputs(h.txs, scripts)
+	return &bhdmodels.ProvideUxtoResponse{Inputs: page(owned, rq.Skip, rq.PageSize), InputCount: len(owned)}, nil
+}
+
+// testAddress returns the cash address and the hex locking script of the account address
+func testAddress(t *testing.T, w *Wallet, change bip44.ChangeType, index uint32) (string, string) {
+	t.Helper()
+	address, err := w.Account.DeriveP2PKAddress(change, index, bip44.MAINNET)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cashAddress, err := GetCashAddress(bhdmodels.BchAddressPrefixForGeneration, CashAddrP2PKH, address.PubAddress.Hash160()[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := w.addressScript(cashAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cashAddress, script
+}
+
+// add adds the transaction paying to the script
+func (h *testHistory) add(script string, value int64) {
+	n := len(h.txs) + 1
+	h.txs = append(h.txs, &bhdmodels.Tx{
+		Hash:    fmt.Sprintf("%064x", n),
+		Height:  int32(n),
+		Outputs: []*bhdmodels.TxOut{{Value: value, PkScript: script}},
+	})
+}
+
+func TestRestoreGapLimit(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	history := &testHistory{}
+	var expected = []string{w.PubAddress}
+	walletScript, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	history.add(walletScript, 1000)
+	for _, used := range []struct {
+		change bip44.ChangeType
+		index  uint32
+		found  bool
+	}{
+		{bip44.ExternalChangeType, 0, true},
+		{bip44.ExternalChangeType, 3, true},
+		// within the gap of the index 3
+		{bip44.ExternalChangeType, 8, true},
+		// the batch from 10 is unused, the scan stops
+		{bip44.ExternalChangeType, 16, false},
+		{bip44.InternalChangeType, 2, true},
+		// the batch from 5 is unused
+		{bip44.InternalChangeType, 11, false},
+	} {
+		address, script := testAddress(t, w, used.change, used.index)
+		history.add(script, 2000)
+		if used.found {
+			expected = append(expected, address)
+		}
+	}
+	r, err := w.NewRestorer(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var progress []*RestoreProgress
+	r.OnProgress = func(p *RestoreProgress) { progress = append(progress, p) }
+	result, err := r.Run(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(result.Addresses, expected) {
+		t.Errorf("restored addresses %v, expected %v", result.Addresses, expected)
+	}
+	if result.NextExternal != 9 || result.NextInternal != 3 {
+		t.Errorf("next indexes %d %d, expected 9 3", result.NextExternal, result.NextInternal)
+	}
+	if len(result.Transactions) != 5 || len(result.Uxtos) != 5 {
+		t.Errorf("restored %d transactions and %d uxtos, expected 5", len(result.Transactions), len(result.Uxtos))
+	}
+	// external batches from 0, 5 and 10, internal from 0 and 5
+	if len(history.txSkips) != 5 || len(history.uxtoSkips) != 1 {
+		t.Errorf("unexpected requests %v %v", history.txSkips, history.uxtoSkips)
+	}
+	if last := progress[len(progress)-1]; !last.Done || last.Used != 5 || !r.Done() {
+		t.Errorf("unexpected progress %+v", last)
+	}
+	if !reflect.DeepEqual(w.GetAddresses(), expected) {
+		t.Errorf("the wallet watches %v, expected %v", w.GetAddresses(), expected)
+	}
+	if _, err := w.messageKey(expected[len(expected)-1]); err != nil {
+		t.Errorf("the key of the restored address not derived: %v", err)
+	}
+}
+
+func TestRestorePaging(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	history := &testHistory{}
+	_, first := testAddress(t, w, bip44.ExternalChangeType, 1)
+	_, second := testAddress(t, w, bip44.ExternalChangeType, 7)
+	for i := 0; i < 5; i++ {
+		history.add(first, int64(1000+i))
+	}
+	history.add(second, 3000)
+	r, err := w.NewRestorer(6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.PageSize = 2
+	result, err := r.Run(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the pages of the first external batch, the second and the empty third one, then
+	// the empty internal batch
+	if expected := []int{0, 2, 4, 0, 0, 0}; !reflect.DeepEqual(history.txSkips, expected) {
+		t.Errorf("transactions skips %v, expected %v", history.txSkips, expected)
+	}
+	if expected := []int{0, 2, 4}; !reflect.DeepEqual(history.uxtoSkips, expected) {
+		t.Errorf("uxtos skips %v, expected %v", history.uxtoSkips, expected)
+	}
+	if len(result.Transactions) != 6 || len(result.Uxtos) != 6 || result.NextExternal != 8 {
+		t.Fatalf("restored %d transactions, %d uxtos, next %d", len(result.Transactions), len(result.Uxtos), result.NextExternal)
+	}
+	for i, tx := range result.Transactions {
+		if tx.Hash != history.txs[i].Hash {
+			t.Errorf("transaction %d is %s, expected %s", i, tx.Hash, history.txs[i].Hash)
+		}
+	}
+	if rq, err := r.TransactionsRequest(); rq != nil || err != nil {
+		t.Fatalf("the transactions requested after the discovery (%v)", err)
+	}
+	if err := r.AddTransactions(&bhdmodels.ProvideTransactionsResponse{}); err == nil {
+		t.Error("the transactions added after the discovery")
+	}
+}
+
+// testFilters are the basic filters of the test blocks
+type testFilters struct {
+	hashes  map[int32]msg.Hash
+	filters map[int32]*cfilter.Filter
+}
+
+func (f *testFilters) BlockFilter(height int32) (msg.Hash, *cfilter.Filter, error) {
+	filter, ok := f.filters[height]
+	if !ok {
+		return nil, nil, errors.New("no filter")
+	}
+	return f.hashes[height], filter, nil
+}
+
+// basicFilter encodes the BCH basic filter of the block, the outpoints spent by the
+// inputs after the coinbase and the output scripts, in the N prefixed golomb coded set
+func basicFilter(t *testing.T, block *wire.MsgBlock) []byte {
+	t.Helper()
+	var items = make(map[string]bool)
+	for i, tx := range block.Transactions {
+		for _, in := range tx.TxIn {
+			if i > 0 {
+				var buf bytes.Buffer
+				err := in.PreviousOutPoint.Serialize(&buf)
+				if err != nil {
+					t.Fatal(err)
+				}
+				items[string(buf.Bytes())] = true
+			}
+		}
+		for _, out := range tx.TxOut {
+			items[string(out.PkScript)] = true
+		}
+	}
+	hash := block.BlockHash()
+	k0, k1 := binary.LittleEndian.Uint64(hash[0:8]), binary.LittleEndian.Uint64(hash[8:16])
+	var values []uint64
+	for item := range items {
+		value, _ := bits.Mul64(siphash.Hash(k0, k1, []byte(item)), uint64(len(items))*cfilter.BasicM)
+		values = append(values, value)
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i] < values[j] })
+	var buf bytes.Buffer
+	err := wire.WriteVarInt(&buf, 0, uint64(len(values)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data []byte
+	var used uint
+	writeBit := func(bit uint64) {
+		if used%8 == 0 {
+			data = append(data, 0)
+		}
+		data[len(data)-1] |= byte(bit << (7 - used%8))
+		used++
+	}
+	var last uint64
+	for _, value := range values {
+		delta := value - last
+		last = value
+		for q := delta >> cfilter.BasicP; q > 0; q-- {
+			writeBit(1)
+		}
+		writeBit(0)
+		for i := cfilter.BasicP - 1; i >= 0; i-- {
+			writeBit(delta >> uint(i) & 1)
+		}
+	}
+	return append(buf.Bytes(), data...)
+}
+
+// add builds the block of the transactions after the coinbase with its basic
+// filter, it returns the serialized block
+func (f *testFilters) add(t *testing.T, height int32, txs ...*wire.MsgTx) []byte {
+	t.Helper()
+	coinbase := wire.NewMsgTx(1)
+	coinbase.AddTxIn(wire.NewTxIn(&wire.OutPoint{Index: 0xffffffff}, []byte{byte(height)}))
+	coinbase.AddTxOut(wire.NewTxOut(50, []byte{0x51}))
+	block := wire.NewMsgBlock(&wire.BlockHeader{Version: 1, Nonce: uint32(height)})
+	block.Transactions = append([]*wire.MsgTx{coinbase}, txs...)
+	var err error
+	f.filters[height], err = cfilter.DecodeBasicFilter(basicFilter(t, block))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	err = block.BchEncode(&buf, 0, wire.BaseEncoding)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash := block.BlockHash()
+	f.hashes[height] = hash[:]
+	return buf.Bytes()
+}
+
+// testWireTx returns the transaction spending the outpoint and paying the value to the hex script
+func testWireTx(t *testing.T, prev *wire.OutPoint, value int64, script string) *wire.MsgTx {
+	t.Helper()
+	pkScript, err := hex.DecodeString(script)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := wire.NewMsgTx(1)
+	tx.AddTxIn(wire.NewTxIn(prev, nil))
+	tx.AddTxOut(wire.NewTxOut(value, pkScript))
+	return tx
+}
+
+func TestBlockSource(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	address, script := testAddress(t, w, bip44.ExternalChangeType, 0)
+	walletScript, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherScript := "76a914" + hex.EncodeToString(bytes.Repeat([]byte{0xee}, 20)) + "88ac"
+	filters := &testFilters{hashes: make(map[int32]msg.Hash), filters: make(map[int32]*cfilter.Filter)}
+
+	paid := testWireTx(t, &wire.OutPoint{Hash: chainhash.Hash{1}}, 5000, script)
+	paidHash := paid.TxHash()
+	// only the outpoint of the spent output is in the filter
+	spent := testWireTx(t, &wire.OutPoint{Hash: paidHash, Index: 0}, 4000, otherScript)
+	received := testWireTx(t, &wire.OutPoint{Hash: chainhash.Hash{2}}, 7000, walletScript)
+	blocks := [][]byte{
+		filters.add(t, 1, paid),
+		filters.add(t, 2, testWireTx(t, &wire.OutPoint{Hash: chainhash.Hash{3}}, 1000, otherScript)),
+		filters.add(t, 3, spent),
+		filters.add(t, 4, received),
+	}
+	store, err := blockstore.NewStore(t.TempDir(), 0, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the unrelated block 2 is never downloaded
+	for _, height := range []int32{1, 4} {
+		err = store.PutRaw(filters.hashes[height], height, blocks[height-1])
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	source := NewBlockSource(store, filters, 1, 4)
+	rq := &bhdmodels.ProvideTransactionsRequest{Address: []string{address, w.PubAddress}}
+	var missing *MissingBlocksError
+	if _, err := source.ProvideTransactions(rq); !errors.As(err, &missing) || len(missing.Hashes) != 1 || !missing.Hashes[0].IsEqual(filters.hashes[3]) {
+		t.Fatalf("expected the block 3 spending the output missing, got %v", err)
+	}
+	err = store.PutRaw(filters.hashes[3], 3, blocks[2])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := w.NewRestorer(5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := r.Run(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var hashes []string
+	for _, tx := range result.Transactions {
+		hashes = append(hashes, tx.Hash)
+	}
+	expected := []string{paidHash.String(), spent.TxHash().String(), received.TxHash().String()}
+	if !reflect.DeepEqual(hashes, expected) {
+		t.Errorf("restored transactions %v, expected %v", hashes, expected)
+	}
+	if len(result.Uxtos) != 1 || result.Uxtos[0].Hash != received.TxHash().String() || result.Uxtos[0].Value != 7000 {
+		t.Errorf("unexpected uxtos %+v", result.Uxtos)
+	}
+	if _, ok := source.blocks[2]; ok {
+		t.Error("the block not matching the filters was read")
+	}
+}
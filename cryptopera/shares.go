@@ -0,0 +1,59 @@
+package cryptopera
+
+import (
+	"bhd/cryptopera/bip44"
+	"bhd/cryptopera/slip39"
+	"bytes"
+	"errors"
+)
+
+var (
+	ErrMnemonicNotRecoverable = errors.New("the mnemonic isn't in the standard form, the wallet recovered from the shares would have the other seed")
+)
+
+// NewSeedShares splits the mnemonic of the wallet into the SLIP-39 shares, the
+// shared secret is the BIP39 entropy encrypted by the BIP39 passphrase of the
+// wallet, so the same passphrase restores it. groupThreshold of the groups, each
+// with its member threshold of the shares, recover the wallet. ErrMnemonicNotRecoverable
+// is returned when the mnemonic recovered from the shares wouldn't give the same seed.
+func (w *Wallet) NewSeedShares(groupThreshold int, groups []slip39.Group) ([][]string, error) {
+	if w.Mnemonic == "" {
+		if w.IsWatchOnly() {
+			return nil, ErrWatchOnly
+		}
+		return nil, errors.New("the wallet has no mnemonic")
+	}
+	lang := bip44.DetectLanguage(w.Mnemonic)
+	entropy, err := bip44.MnemonicEntropy(w.Mnemonic, lang)
+	if err != nil {
+		return nil, err
+	}
+	// the shares keep only the entropy, the seed is made from the mnemonic text
+	// and the extra white space of the wallet mnemonic would be lost
+	recovered, err := bip44.EntropyMnemonic(entropy, lang)
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(bip44.MnemonicSeed(recovered, w.passphrase), bip44.MnemonicSeed(w.Mnemonic, w.passphrase)) {
+		return nil, ErrMnemonicNotRecoverable
+	}
+	return slip39.GenerateShares(groupThreshold, groups, entropy, w.passphrase, true, slip39.DefaultIterationExponent)
+}
+
+// NewWalletFromShares creates the wallet from the SLIP-39 shares of NewSeedShares,
+// the recovered mnemonic is encoded in the language of the original mnemonic,
+// it's required as the BIP39 seed depends on the words and the shares don't keep it
+func NewWalletFromShares(shares []string, passphrase string, lang bip44.Language) error {
+	if lang == "" {
+		return errors.New("the language of the original mnemonic is required")
+	}
+	entropy, err := slip39.CombineShares(shares, passphrase)
+	if err != nil {
+		return err
+	}
+	mnemonic, err := bip44.EntropyMnemonic(entropy, lang)
+	if err != nil {
+		return err
+	}
+	return NewWalletFromMnemonic(mnemonic, passphrase, lang, 0)
+}
@@ -0,0 +1,74 @@
+package cryptopera
+
+import (
+	"bhd/cryptopera/bip44"
+	"bhd/cryptopera/slip39"
+	"strings"
+	"testing"
+)
+
+func TestWalletFromShares(t *testing.T) {
+	for _, test := range []struct {
+		lang       bip44.Language
+		passphrase string
+	}{
+		{bip44.English, ""},
+		{bip44.English, "TREZOR"},
+		{bip44.Japanese, "TREZOR"},
+	} {
+		mnemonic, err := bip44.NewMnemonicWords(24, test.lang)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = NewWalletFromMnemonic(mnemonic, test.passphrase, test.lang, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		original := Service
+		xpub, err := original.GetAccountXPub()
+		if err != nil {
+			t.Fatal(err)
+		}
+		shares, err := original.NewSeedShares(1, []slip39.Group{{Threshold: 2, Count: 3}})
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		err = NewWalletFromShares([]string{shares[0][2], shares[0][0]}, test.passphrase, test.lang)
+		if err != nil {
+			t.Fatalf("%s: %v", test.lang, err)
+		}
+		restoredXPub, err := Service.GetAccountXPub()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if Service.PubAddress != original.PubAddress || restoredXPub != xpub || Service.Mnemonic != mnemonic {
+			t.Errorf("%s %q: the restored wallet %s differs from %s", test.lang, test.passphrase, Service.PubAddress, original.PubAddress)
+		}
+	}
+
+	// the language of the original mnemonic is required
+	if err := NewWalletFromShares(nil, "", ""); err == nil {
+		t.Error("shares restored without the language")
+	}
+}
+
+func TestSeedSharesWhiteSpace(t *testing.T) {
+	// the seed of the mnemonic typed with the double space differs from the
+	// seed of the words recovered from the shares
+	mnemonic := strings.Replace(testMnemonic, " ", "  ", 1)
+	for _, passphrase := range []string{"", "TREZOR"} {
+		err := NewWalletFromMnemonic(mnemonic, passphrase, bip44.English, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		w := Service
+		if _, err := w.NewSeedShares(1, []slip39.Group{{Threshold: 1, Count: 1}}); err != ErrMnemonicNotRecoverable {
+			t.Errorf("%q: expected ErrMnemonicNotRecoverable, got %v", passphrase, err)
+		}
+	}
+	w := newTestWallet(t, testMnemonic)
+	if _, err := w.NewSeedShares(1, []slip39.Group{{Threshold: 1, Count: 1}}); err != nil {
+		t.Fatal(err)
+	}
+}
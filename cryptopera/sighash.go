@@ -0,0 +1,352 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"strconv"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+// sigHashMask extracts the base type (ALL, NONE, SINGLE) from the hash type
+const sigHashMask = 0x1f
+
+const (
+	// SigHashUtxos commits the signature to all outputs spent by the transaction
+	// (value, locking script and token), added by the 2023 upgrade
+	SigHashUtxos txscript.SigHashType = 0x20
+	// DefaultHashType is used for the inputs which don't set the hash type
+	DefaultHashType = txscript.SigHashAll | txscript.SigHashForkID
+)
+
+// InputHashType returns the hash type of the input, zero is the DefaultHashType
+// and the fork id is always added. ANYONECANPAY, NONE and SINGLE let the other
+// players add their inputs or outputs without breaking the signature.
+func InputHashType(hashType uint32) (txscript.SigHashType, error) {
+	if hashType == 0 {
+		return DefaultHashType, nil
+	}
+	if hashType > 0xff {
+		return 0, errors.New("hash type " + strconv.Itoa(int(hashType)) + " doesn't fit the byte")
+	}
+	fullHashType := txscript.SigHashType(hashType) | txscript.SigHashForkID
+	return fullHashType, checkHashType(fullHashType)
+}
+
+// checkHashType checks the hash type of the signature is the valid one with the fork id
+func checkHashType(hashType txscript.SigHashType) error {
+	if hashType&txscript.SigHashForkID == 0 {
+		return errors.New("signature doesn't use the fork id")
+	}
+	baseType := hashType & sigHashMask
+	if baseType < txscript.SigHashAll || baseType > txscript.SigHashSingle {
+		return errors.New("unknown signature hash type " + strconv.Itoa(int(hashType)))
+	}
+	if hashType&SigHashUtxos != 0 && hashType&txscript.SigHashAnyOneCanPay != 0 {
+		return errors.New("the UTXOS hash type can't be combined with ANYONECANPAY")
+	}
+	return nil
+}
+
+// spentOutputs returns the outputs spent by the inputs of the transaction, the
+// locking scripts have the token prefix. They are signed by the UTXOS hash type.
+func spentOutputs(tx *bhdmodels.Tx) ([]*wire.TxOut, error) {
+	var utxos []*wire.TxOut
+	for _, in := range tx.Inputs {
+		script, err := in.LockingScript()
+		if err != nil {
+			return nil, err
+		}
+		utxos = append(utxos, &wire.TxOut{Value: in.Value, PkScript: script})
+	}
+	return utxos, nil
+}
+
+// calcSignatureHash computes the BIP143 style signature hash used by bitcoin cash, the
+// token prefix of the spent output (nil for regular outputs) is serialized in front
+// of the script code as required by the CashTokens upgrade. The txscript package
+// of bchd predates the upgrade, so it can't sign token inputs. The utxos are the
+// spent outputs of all inputs, needed only by the UTXOS hash type.
+func calcSignatureHash(tx *wire.MsgTx, idx int, utxos []*wire.TxOut, scriptCode []byte, tokenPrefix []byte, amount int64, hashType txscript.SigHashType) ([]byte, error) {
+	if idx >= len(tx.TxIn) {
+		return nil, errors.New("input index out of range")
+	}
+	var zeroHash chainhash.Hash
+	var buf bytes.Buffer
+	var baseType = hashType & sigHashMask
+	var anyoneCanPay = hashType&txscript.SigHashAnyOneCanPay != 0
+
+	var u32 [4]byte
+	binary.LittleEndian.PutUint32(u32[:], uint32(tx.Version))
+	buf.Write(u32[:])
+
+	if !anyoneCanPay {
+		var prevOuts bytes.Buffer
+		for _, in := range tx.TxIn {
+			prevOuts.Write(in.PreviousOutPoint.Hash[:])
+			binary.Write(&prevOuts, binary.LittleEndian, in.PreviousOutPoint.Index)
+		}
+		buf.Write(chainhash.DoubleHashB(prevOuts.Bytes()))
+	} else {
+		buf.Write(zeroHash[:])
+	}
+
+	if hashType&SigHashUtxos != 0 {
+		if anyoneCanPay || len(utxos) != len(tx.TxIn) {
+			return nil, errors.New("the UTXOS hash type needs the spent outputs of all inputs")
+		}
+		var spent bytes.Buffer
+		for _, utxo := range utxos {
+			err := wire.WriteTxOut(&spent, 0, 0, utxo)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(chainhash.DoubleHashB(spent.Bytes()))
+	}
+
+	if !anyoneCanPay && baseType != txscript.SigHashSingle && baseType != txscript.SigHashNone {
+		var sequences bytes.Buffer
+		for _, in := range tx.TxIn {
+			binary.Write(&sequences, binary.LittleEndian, in.Sequence)
+		}
+		buf.Write(chainhash.DoubleHashB(sequences.Bytes()))
+	} else {
+		buf.Write(zeroHash[:])
+	}
+
+	in := tx.TxIn[idx]
+	buf.Write(in.PreviousOutPoint.Hash[:])
+	binary.LittleEndian.PutUint32(u32[:], in.PreviousOutPoint.Index)
+	buf.Write(u32[:])
+	buf.Write(tokenPrefix)
+	err := wire.WriteVarBytes(&buf, 0, scriptCode)
+	if err != nil {
+		return nil, err
+	}
+	var u64 [8]byte
+	binary.LittleEndian.PutUint64(u64[:], uint64(amount))
+	buf.Write(u64[:])
+	binary.LittleEndian.PutUint32(u32[:], in.Sequence)
+	buf.Write(u32[:])
+
+	if baseType != txscript.SigHashSingle && baseType != txscript.SigHashNone {
+		var outputs bytes.Buffer
+		for _, out := range tx.TxOut {
+			err = wire.WriteTxOut(&outputs, 0, 0, out)
+			if err != nil {
+				return nil, err
+			}
+		}
+		buf.Write(chainhash.DoubleHashB(outputs.Bytes()))
+	} else if baseType == txscript.SigHashSingle && idx < len(tx.TxOut) {
+		var output bytes.Buffer
+		err = wire.WriteTxOut(&output, 0, 0, tx.TxOut[idx])
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(chainhash.DoubleHashB(output.Bytes()))
+	} else {
+		buf.Write(zeroHash[:])
+	}
+
+	binary.LittleEndian.PutUint32(u32[:], tx.LockTime)
+	buf.Write(u32[:])
+	binary.LittleEndian.PutUint32(u32[:], uint32(hashType))
+	buf.Write(u32[:])
+	return chainhash.DoubleHashB(buf.Bytes()), nil
+}
+
+// p2pkhSigHash returns the hash signed by the pay to public key hash input and the
+// full hash type of the input (InputHashType), which must follow the signature
+func p2pkhSigHash(tx *wire.MsgTx, idx int, utxos []*wire.TxOut, amount int64, scriptCode []byte, tokenPrefix []byte,
+	inputHashType uint32) ([]byte, txscript.SigHashType, error) {
+	hashType, err := InputHashType(inputHashType)
+	if err != nil {
+		return nil, 0, err
+	}
+	hash, err := calcSignatureHash(tx, idx, utxos, scriptCode, tokenPrefix, amount, hashType)
+	if err != nil {
+		return nil, 0, err
+	}
+	return hash, hashType, nil
+}
+
+// minSignatureSize is the size of the smallest DER encoded ECDSA signature with the
+// hash type, the shorter pushes of the multisig unlocking script are the dummy
+const minSignatureSize = 9
+
+// usesUtxosHashType returns true when any signature of the unlocking script has the
+// UTXOS hash type, it's the last byte of the signature push. The signature is the
+// first push of the P2PKH and P2PK inputs, the P2SH multisig signatures are between
+// the dummy and the redeem script.
+func usesUtxosHashType(sigScript []byte, class txscript.ScriptClass) (bool, error) {
+	pushes, err := txscript.PushedData(sigScript)
+	if err != nil {
+		return false, err
+	}
+	var sigs [][]byte
+	switch class {
+	case txscript.PubKeyHashTy, txscript.PubKeyTy:
+		if len(pushes) > 0 {
+			sigs = pushes[:1]
+		}
+	case txscript.ScriptHashTy:
+		if len(pushes) > 1 {
+			sigs = pushes[:len(pushes)-1]
+		}
+	}
+	for _, sig := range sigs {
+		if len(sig) >= minSignatureSize && txscript.SigHashType(sig[len(sig)-1])&SigHashUtxos != 0 {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// p2pkhUnlockingScript returns the unlocking script with the signature (hash
+// type included) and the compressed public key
+func p2pkhUnlockingScript(sig []byte, pubKey []byte) ([]byte, error) {
+	return txscript.NewScriptBuilder().AddData(sig).AddData(pubKey).Script()
+}
+
+// verifyP2PKHInput checks the unlocking script of the pay to public key hash input
+// against the spent output, used for the inputs the bchd script engine can't verify
+func verifyP2PKHInput(tx *wire.MsgTx, idx int, utxos []*wire.TxOut, amount int64, scriptCode []byte, tokenPrefix []byte) error {
+	if txscript.GetScriptClass(scriptCode) != txscript.PubKeyHashTy {
+		return errors.New("only pay to public key hash token inputs can be verified")
+	}
+	pushes, err := txscript.PushedData(tx.TxIn[idx].SignatureScript)
+	if err != nil {
+		return err
+	}
+	if len(pushes) != 2 || len(pushes[0]) == 0 {
+		return errors.New("unlocking script is not signature and public key")
+	}
+	sigBytes, pkBytes := pushes[0], pushes[1]
+	if !bytes.Equal(bchutil.Hash160(pkBytes), scriptCode[3:23]) {
+		return errors.New("public key doesn't match the spent output")
+	}
+	return verifyTxSignature(tx, idx, utxos, amount, scriptCode, tokenPrefix, sigBytes, pkBytes)
+}
+
+// verifyTxSignature checks the signature (hash type included) of the input made by
+// the public key, schnorr and DER encoded ECDSA signatures are accepted
+func verifyTxSignature(tx *wire.MsgTx, idx int, utxos []*wire.TxOut, amount int64, scriptCode []byte, tokenPrefix []byte, sigBytes []byte, pkBytes []byte) error {
+	if len(sigBytes) == 0 {
+		return errors.New("empty signature")
+	}
+	pubKey, err := bchec.ParsePubKey(pkBytes, bchec.S256())
+	if err != nil {
+		return err
+	}
+	hashType := txscript.SigHashType(sigBytes[len(sigBytes)-1])
+	err = checkHashType(hashType)
+	if err != nil {
+		return err
+	}
+	hash, err := calcSignatureHash(tx, idx, utxos, scriptCode, tokenPrefix, amount, hashType)
+	if err != nil {
+		return err
+	}
+	var signature *bchec.Signature
+	if len(sigBytes)-1 == 64 {
+		signature, err = bchec.ParseSchnorrSignature(sigBytes[:64])
+	} else {
+		signature, err = bchec.ParseDERSignature(sigBytes[:len(sigBytes)-1], bchec.S256())
+	}
+	if err != nil {
+		return err
+	}
+	if !signature.Verify(hash, pubKey) {
+		return errors.New("signature verification failed")
+	}
+	return nil
+}
+
+// verifyMultisigInput checks the unlocking script of the P2SH multisig input against
+// the spent output, both the legacy (dummy OP_0, ECDSA) and the schnorr (bitfield
+// dummy) multisig are accepted. Used for the inputs the bchd script engine can't verify.
+func verifyMultisigInput(tx *wire.MsgTx, idx int, utxos []*wire.TxOut, amount int64, lockingScript []byte, tokenPrefix []byte) error {
+	sigScript := tx.TxIn[idx].SignatureScript
+	if len(sigScript) == 0 {
+		return errors.New("empty unlocking script")
+	}
+	// the dummy can be the small number opcode which isn't the data push
+	var dummy []byte
+	var rest []byte
+	switch op := sigScript[0]; {
+	case op == txscript.OP_0:
+		rest = sigScript[1:]
+	case op >= txscript.OP_1 && op <= txscript.OP_16:
+		dummy = []byte{op - txscript.OP_1 + 1}
+		rest = sigScript[1:]
+	case op >= txscript.OP_DATA_1 && op <= txscript.OP_DATA_75 && len(sigScript) > int(op):
+		dummy = sigScript[1 : 1+int(op)]
+		rest = sigScript[1+int(op):]
+	default:
+		return errors.New("unlocking script has no multisig dummy")
+	}
+	pushes, err := txscript.PushedData(rest)
+	if err != nil {
+		return err
+	}
+	if len(pushes) < 2 {
+		return errors.New("unlocking script has no signatures")
+	}
+	redeemScript := pushes[len(pushes)-1]
+	sigs := pushes[:len(pushes)-1]
+	if txscript.GetScriptClass(lockingScript) != txscript.ScriptHashTy || !bytes.Equal(bchutil.Hash160(redeemScript), lockingScript[2:22]) {
+		return errors.New("redeem script doesn't match the spent output")
+	}
+	_, addresses, required, err := txscript.ExtractPkScriptAddrs(redeemScript, &chaincfg.MainNetParams)
+	if err != nil {
+		return err
+	}
+	if len(sigs) != required {
+		return errors.New("wrong number of multisig signatures")
+	}
+	// legacy mode matches the signatures to the keys in order
+	var keys = addresses
+	if len(dummy) > 0 {
+		if len(dummy) != (len(addresses)+7)/8 {
+			return errors.New("wrong size of the multisig bitfield")
+		}
+		keys = nil
+		for i, address := range addresses {
+			if dummy[i/8]&(1<<(i%8)) != 0 {
+				keys = append(keys, address)
+			}
+		}
+		if len(keys) != required {
+			return errors.New("multisig bitfield doesn't select the required keys")
+		}
+	}
+	var next = 0
+	for _, sig := range sigs {
+		if len(dummy) > 0 && !isSchnorrSignature(sig) {
+			return errors.New("schnorr multisig with the ECDSA signature")
+		}
+		if len(dummy) == 0 && isSchnorrSignature(sig) {
+			return errors.New("legacy multisig with the schnorr signature")
+		}
+		for ; next < len(keys); next++ {
+			err = verifyTxSignature(tx, idx, utxos, amount, redeemScript, tokenPrefix, sig, keys[next].ScriptAddress())
+			if err == nil || len(dummy) > 0 {
+				break
+			}
+		}
+		if err != nil || next == len(keys) {
+			return errors.New("multisig signature verification failed")
+		}
+		next++
+	}
+	return nil
+}
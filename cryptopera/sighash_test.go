@@ -0,0 +1,209 @@
+package cryptopera
+
+import (
+	"bhd/bch/msg"
+	"bhd/bhdmodels"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/gcash/bchd/chaincfg/chainhash"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+)
+
+// newSignedTestTx creates the transaction spending the wallet uxto signed with the hash type
+func newSignedTestTx(t *testing.T, w *Wallet, hashType txscript.SigHashType) *bhdmodels.Tx {
+	t.Helper()
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{Value: 10000, PkScript: script, Address: w.PubAddress})
+	uxtos := []*bhdmodels.Uxto{{Hash: strings.Repeat("01", 32), Index: 0, PkScript: script, Value: 50000}}
+	err = w.fundTransaction(tx, uxtos, DefaultFeeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx.Inputs[0].HashType = uint32(hashType)
+	err = w.SignTransaction(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
+
+// setSignatureHashType replaces the hash type byte of the P2PKH input signature
+func setSignatureHashType(t *testing.T, in *bhdmodels.TxIn, hashType txscript.SigHashType) {
+	t.Helper()
+	script, err := hex.DecodeString(in.Signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the first byte is the size of the signature push
+	script[script[0]] = byte(hashType)
+	in.Signature = hex.EncodeToString(script)
+}
+
+func TestValidateTxSignatureHashType(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	utxosAll := SigHashUtxos | txscript.SigHashAll | txscript.SigHashForkID
+
+	// the requested hash type doesn't decide how the signature is verified
+	tx := newSignedTestTx(t, w, utxosAll)
+	tx.Inputs[0].HashType = 0
+	if err := w.ValidateTx(tx); err != nil {
+		t.Errorf("UTXOS signature with the default input hash type rejected: %v", err)
+	}
+
+	tx = newSignedTestTx(t, w, 0)
+	tx.Inputs[0].HashType = uint32(utxosAll)
+	if err := w.ValidateTx(tx); err != nil {
+		t.Errorf("ALL signature with the UTXOS input hash type rejected: %v", err)
+	}
+
+	// the hash type byte changed after signing breaks the signature either way
+	tx = newSignedTestTx(t, w, utxosAll)
+	setSignatureHashType(t, tx.Inputs[0], txscript.SigHashAll|txscript.SigHashForkID)
+	if err := w.ValidateTx(tx); err == nil {
+		t.Error("UTXOS signature with the ALL hash type byte accepted")
+	}
+	tx = newSignedTestTx(t, w, 0)
+	setSignatureHashType(t, tx.Inputs[0], utxosAll)
+	if err := w.ValidateTx(tx); err == nil {
+		t.Error("ALL signature with the UTXOS hash type byte accepted")
+	}
+}
+
+func TestUsesUtxosHashType(t *testing.T) {
+	sig := func(hashType txscript.SigHashType) []byte {
+		return append(make([]byte, 64), byte(hashType))
+	}
+	pubKey := make([]byte, 33)
+	redeem := make([]byte, 71)
+	utxos := SigHashUtxos | txscript.SigHashAll | txscript.SigHashForkID
+	all := txscript.SigHashAll | txscript.SigHashForkID
+	for _, test := range []struct {
+		name   string
+		class  txscript.ScriptClass
+		pushes [][]byte
+		utxos  bool
+	}{
+		{"p2pkh utxos", txscript.PubKeyHashTy, [][]byte{sig(utxos), pubKey}, true},
+		{"p2pkh all", txscript.PubKeyHashTy, [][]byte{sig(all), pubKey}, false},
+		{"multisig second utxos", txscript.ScriptHashTy, [][]byte{{0x03}, sig(all), sig(utxos), redeem}, true},
+		{"multisig all", txscript.ScriptHashTy, [][]byte{{0x03}, sig(all), sig(all), redeem}, false},
+		// the redeem script ending with the byte of the UTXOS flag is not the signature
+		{"multisig redeem script", txscript.ScriptHashTy, [][]byte{{0x03}, sig(all), sig(all), append(redeem, 0x61)}, false},
+		{"empty", txscript.PubKeyHashTy, nil, false},
+	} {
+		builder := txscript.NewScriptBuilder()
+		for _, push := range test.pushes {
+			builder.AddData(push)
+		}
+		script, err := builder.Script()
+		if err != nil {
+			t.Fatal(err)
+		}
+		found, err := usesUtxosHashType(script, test.class)
+		if err != nil || found != test.utxos {
+			t.Errorf("%s: expected %v, got %v (%v)", test.name, test.utxos, found, err)
+		}
+	}
+}
+
+// sigHashVectorTx is the transaction of the signature hash vectors, the first input
+// spends the mutable NFT with the commitment and fungible amount, the first output
+// carries the fungible tokens. The expected hashes were computed independently
+// from the BIP143 and CashTokens signing serialization.
+func sigHashVectorTx(t *testing.T) (*wire.MsgTx, []*wire.TxOut, [][]byte) {
+	t.Helper()
+	p2pkh := func(b byte) []byte {
+		return append(append([]byte{0x76, 0xa9, 0x14}, bytes.Repeat([]byte{b}, 20)...), 0x88, 0xac)
+	}
+	category := msg.Hash(bytes.Repeat([]byte{0x33}, 32))
+	nftPrefix, err := msg.NewNftToken(category, msg.TokenMutable, []byte{0xca, 0xfe}, 100).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ftPrefix, err := msg.NewFungibleToken(category, 40).Pack()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(nftPrefix) != "ef"+strings.Repeat("33", 32)+"7102cafe64" || hex.EncodeToString(ftPrefix) != "ef"+strings.Repeat("33", 32)+"1028" {
+		t.Fatalf("unexpected token prefixes %x %x", nftPrefix, ftPrefix)
+	}
+	tx := wire.NewMsgTx(2)
+	tx.LockTime = 100
+	for i, b := range []byte{0x11, 0x22} {
+		var hash chainhash.Hash
+		copy(hash[:], bytes.Repeat([]byte{b}, 32))
+		in := wire.NewTxIn(wire.NewOutPoint(&hash, uint32(1-i)), nil)
+		in.Sequence = 0xfffffffe + uint32(i)
+		tx.AddTxIn(in)
+	}
+	tx.AddTxOut(wire.NewTxOut(1000, append(ftPrefix, p2pkh(0xcd)...)))
+	tx.AddTxOut(wire.NewTxOut(5000, p2pkh(0xef)))
+	utxos := []*wire.TxOut{
+		wire.NewTxOut(2000, append(nftPrefix, p2pkh(0xab)...)),
+		wire.NewTxOut(10000, p2pkh(0xab)),
+	}
+	return tx, utxos, [][]byte{nftPrefix, nil}
+}
+
+func TestCalcSignatureHashVectors(t *testing.T) {
+	tx, utxos, prefixes := sigHashVectorTx(t)
+	for _, test := range []struct {
+		idx      int
+		hashType txscript.SigHashType
+		hash     string
+	}{
+		{0, 0x41, "10cfbda1e235ec57cab9cc5755f7d97cbcd5c2ae8053773f31617e3ddb5e395e"},
+		{0, 0x42, "54ecf856469aeafc381ea95e241de601e837ca6445557a71c77344fc1104d2d3"},
+		{0, 0xc3, "22d7a18b875d6bd2ef3ac3794473188e5e69a3400b46588f6fa063a11ea41b8a"},
+		{1, 0x41, "338d88c8b2adde4a7126c4d98e28b93fc9edcaabee793acc8a4c4e433934f5ac"},
+		// UTXOS puts the hash of all spent outputs, token prefixes included,
+		// right after the hash of the previous outputs
+		{0, 0x61, "cabb7f759c3e0892bafba2573887054c2e83f0408a39df58cfe2c30a0d6f2340"},
+		{1, 0x61, "3299e3e7e01b82fb12ec7b03802002d494f57dbeeef41852af697347c5676404"},
+		{0, 0x63, "a3cd25862f1c2e28b7e0454b75c157ea822a6fa1fc934d1d91e8c8130459e191"},
+		{1, 0x62, "141599aeefa5f1472b4c6d66cc9c77ca63af8d8fd9a5fd4924ac5a9431cb82fe"},
+	} {
+		scriptCode := utxos[test.idx].PkScript[len(prefixes[test.idx]):]
+		hash, err := calcSignatureHash(tx, test.idx, utxos, scriptCode, prefixes[test.idx], utxos[test.idx].Value, test.hashType)
+		if err != nil || hex.EncodeToString(hash) != test.hash {
+			t.Errorf("input %d hash type %x: expected %s, got %x (%v)", test.idx, test.hashType, test.hash, hash, err)
+		}
+	}
+	// UTXOS needs the spent outputs of all inputs
+	if _, err := calcSignatureHash(tx, 0, utxos[:1], utxos[0].PkScript[len(prefixes[0]):], prefixes[0], 2000, 0x61); err == nil {
+		t.Error("UTXOS signature hash without all spent outputs")
+	}
+}
+
+func TestCalcSignatureHashMatchesBchd(t *testing.T) {
+	tx, utxos, _ := sigHashVectorTx(t)
+	// the bchd signature hash doesn't know the tokens
+	tx.TxOut[0].PkScript = tx.TxOut[1].PkScript
+	tx.AddTxIn(wire.NewTxIn(wire.NewOutPoint(&chainhash.Hash{0x44}, 7), nil))
+	scriptCode := utxos[1].PkScript
+	sigHashes := txscript.NewTxSigHashes(tx)
+	for _, baseType := range []txscript.SigHashType{txscript.SigHashAll, txscript.SigHashNone, txscript.SigHashSingle} {
+		for _, anyoneCanPay := range []txscript.SigHashType{0, txscript.SigHashAnyOneCanPay} {
+			hashType := baseType | anyoneCanPay | txscript.SigHashForkID
+			// the third input has no output of its own for SINGLE
+			for idx := range tx.TxIn {
+				expected, err := txscript.CalcSignatureHash(scriptCode, sigHashes, hashType, tx, idx, 12345, true)
+				if err != nil {
+					t.Fatal(err)
+				}
+				hash, err := calcSignatureHash(tx, idx, nil, scriptCode, nil, 12345, hashType)
+				if err != nil || !bytes.Equal(hash, expected) {
+					t.Errorf("input %d hash type %x: expected %x, got %x (%v)", idx, hashType, expected, hash, err)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/gcash/bchd/bchec"
+	"github.com/gcash/bchd/chaincfg"
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	// DefaultAccountPath is the derivation path of the wallet account
+	DefaultAccountPath = "m/44'/145'/0'"
+	// WalletAddressIndex is the index of the external address used by the wallet
+	WalletAddressIndex = 255
+	// UnsignedTxVersion is the version of the exported unsigned transaction
+	UnsignedTxVersion = 1
+)
+
+var (
+	ErrWatchOnly = errors.New("watch-only wallet can't sign, export the unsigned transaction instead")
+)
+
+// SignatureType is the signature algorithm used by the wallet
+type SignatureType int
+
+const (
+	// SignatureSchnorr signs with the 64 byte schnorr signatures, smaller and batch verifiable
+	SignatureSchnorr SignatureType = iota
+	// SignatureECDSA signs with the DER encoded ECDSA signatures
+	SignatureECDSA
+)
+
+// ParseSignatureType converts the name (schnorr or ecdsa) to the signature type
+func ParseSignatureType(name string) (SignatureType, error) {
+	switch strings.ToLower(name) {
+	case "schnorr", "":
+		return SignatureSchnorr, nil
+	case "ecdsa":
+		return SignatureECDSA, nil
+	}
+	return 0, errors.New("unknown signature type " + name)
+}
+
+func (t SignatureType) sign(key *bchec.PrivateKey, hash []byte) (*bchec.Signature, error) {
+	if t == SignatureECDSA {
+		return key.SignECDSA(hash)
+	}
+	return key.SignSchnorr(hash)
+}
+
+// Signer signs the inputs of the transaction, the keys can be in memory, in the
+// other process or not available at all (watch-only)
+type Signer interface {
+	// SignTransaction sets the unlocking script of every input
+	SignTransaction(tx *bhdmodels.Tx) error
+}
+
+// UnsignedInput is what the external signer needs to sign the input, SigHash
+// is the hash to sign, the signature must end with the HashType byte
+type UnsignedInput struct {
+	Index       int    `json:"index"`
+	Value       int64  `json:"value"`
+	PubScript   string `json:"pubScript"`
+	TokenPrefix string `json:"tokenPrefix,omitempty"`
+	HashType    uint32 `json:"hashType"`
+	SigHash     string `json:"sigHash"`
+	PubKey      string `json:"pubKey,omitempty"`
+	Path        string `json:"path,omitempty"`
+}
+
+// UnsignedTx is the transaction with the data the offline signer needs,
+// Path of the inputs is relative to the AccountPath
+type UnsignedTx struct {
+	Version     int              `json:"version"`
+	AccountPath string           `json:"accountPath,omitempty"`
+	Tx          *bhdmodels.Tx    `json:"tx"`
+	Inputs      []*UnsignedInput `json:"inputs"`
+}
+
+// InputSignature is the signature of the input made by the external signer,
+// Signature includes the hash type byte, PubKey is compressed
+type InputSignature struct {
+	Index     int    `json:"index"`
+	Signature string `json:"signature"`
+	PubKey    string `json:"pubKey"`
+}
+
+// derivedKey is the address key of the account, private is nil for watch-only
+type derivedKey struct {
+	change  bip44.ChangeType
+	index   uint32
+	pubKey  []byte
+	private *bchec.PrivateKey
+}
+
+func (k *derivedKey) path() string {
+	return strconv.Itoa(int(k.change)) + "/" + strconv.Itoa(int(k.index))
+}
+
+// accountKeys derives the address keys of the account and finds
+// the key of the locking script, it's safe for concurrent use
+type accountKeys struct {
+	sync.Mutex
+	account *bip44.AccountKey
+	// keyed by the hex hash160 of the public key
+	keys map[string]*derivedKey
+}
+
+func newAccountKeys(account *bip44.AccountKey) (*accountKeys, error) {
+	a := &accountKeys{
+		account: account,
+		keys:    make(map[string]*derivedKey),
+	}
+	_, err := a.Derive(bip44.ExternalChangeType, WalletAddressIndex)
+	if err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// Derive derives the key of the address and remembers it for signing
+func (a *accountKeys) Derive(change bip44.ChangeType, index uint32) (*derivedKey, error) {
+	address, err := a.account.DeriveP2PKAddress(change, index, bip44.MAINNET)
+	if err != nil {
+		return nil, err
+	}
+	pubKey, err := address.PrivateKey.ECPubKey()
+	if err != nil {
+		return nil, err
+	}
+	key := &derivedKey{
+		change: change,
+		index:  index,
+		pubKey: pubKey.SerializeCompressed(),
+	}
+	if address.PrivateKey.IsPrivate() {
+		key.private, err = address.PrivateKey.ECPrivKey()
+		if err != nil {
+			return nil, err
+		}
+	}
+	a.Lock()
+	defer a.Unlock()
+	a.keys[hex.EncodeToString(bchutil.Hash160(key.pubKey))] = key
+	return key, nil
+}
+
+// lookup returns the key of the pay to public key hash script, nil when it's not ours
+func (a *accountKeys) lookup(script []byte) *derivedKey {
+	if txscript.GetScriptClass(script) != txscript.PubKeyHashTy {
+		return nil
+	}
+	a.Lock()
+	defer a.Unlock()
+	return a.keys[hex.EncodeToString(script[3:23])]
+}
+
+// lookupPubKey returns the key of the compressed public key, nil when it's not ours
+func (a *accountKeys) lookupPubKey(pubKey []byte) *derivedKey {
+	a.Lock()
+	defer a.Unlock()
+	return a.keys[hex.EncodeToString(bchutil.Hash160(pubKey))]
+}
+
+// derivation returns the derivation of the key for the partially signed transaction
+func (k *derivedKey) derivation(accountPath string) *KeyDerivation {
+	return &KeyDerivation{
+		PubKey: hex.EncodeToString(k.pubKey),
+		Path:   accountPath + "/" + k.path(),
+	}
+}
+
+// signableInputs checks all inputs are pay to public key hash, the only ones the
+// signers support, and returns their sig hashes (of the input hash type) with the
+// wire transaction
+func signableInputs(tx *bhdmodels.Tx) (*wire.MsgTx, []*UnsignedInput, error) {
+	msgTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	utxos, err := spentOutputs(tx)
+	if err != nil {
+		return nil, nil, err
+	}
+	var inputs []*UnsignedInput
+	for i, el := range tx.Inputs {
+		// token inputs have the token prefix in front of the regular script
+		pubScript, tokenPrefix, err := el.SpentScript()
+		if err != nil {
+			return nil, nil, err
+		}
+		if len(pubScript) == 0 {
+			return nil, nil, errors.New("the input has empty pub script")
+		}
+		scriptClass, _, _, err := txscript.ExtractPkScriptAddrs(pubScript, &chaincfg.MainNetParams)
+		if err != nil || scriptClass != txscript.PubKeyHashTy {
+			return nil, nil, errors.New("not all inputs can be signed, some are not supported by this function")
+		}
+		hash, fullHashType, err := p2pkhSigHash(msgTx, i, utxos, el.Value, pubScript, tokenPrefix, el.HashType)
+		if err != nil {
+			return nil, nil, err
+		}
+		inputs = append(inputs, &UnsignedInput{
+			Index:       i,
+			Value:       el.Value,
+			PubScript:   hex.EncodeToString(pubScript),
+			TokenPrefix: hex.EncodeToString(tokenPrefix),
+			HashType:    uint32(fullHashType),
+			SigHash:     hex.EncodeToString(hash),
+		})
+	}
+	return msgTx, inputs, nil
+}
+
+// ApplySignatures sets the unlocking scripts of the inputs from the signatures
+// made by the external signer
+func ApplySignatures(tx *bhdmodels.Tx, signatures []*InputSignature) error {
+	for _, s := range signatures {
+		if s.Index < 0 || s.Index >= len(tx.Inputs) {
+			return errors.New("signature of the input " + strconv.Itoa(s.Index) + " which isn't in the tx")
+		}
+		sig, err := hex.DecodeString(s.Signature)
+		if err != nil {
+			return err
+		}
+		pubKey, err := hex.DecodeString(s.PubKey)
+		if err != nil {
+			return err
+		}
+		script, err := p2pkhUnlockingScript(sig, pubKey)
+		if err != nil {
+			return err
+		}
+		tx.Inputs[s.Index].Signature = hex.EncodeToString(script)
+	}
+	return nil
+}
+
+// HDSigner signs with the account key held in memory, multisig are the
+// keys of the cosigner account when the wallet takes part in the multisig
+type HDSigner struct {
+	SignatureType SignatureType
+	keys          *accountKeys
+	multisig      *accountKeys
+}
+
+// NewHDSigner creates the signer of the private account key
+func NewHDSigner(account *bip44.AccountKey) (*HDSigner, error) {
+	if !account.ExKey.IsPrivate() {
+		return nil, errors.New("the account key is not private")
+	}
+	keys, err := newAccountKeys(account)
+	if err != nil {
+		return nil, err
+	}
+	return &HDSigner{keys: keys}, nil
+}
+
+// Derive adds the address key to the keys used for signing
+func (s *HDSigner) Derive(change bip44.ChangeType, index uint32) error {
+	_, err := s.keys.Derive(change, index)
+	return err
+}
+
+// SetMultisigAccount sets the cosigner account key used to sign the multisig inputs
+func (s *HDSigner) SetMultisigAccount(account *bip44.AccountKey) error {
+	keys, err := newAccountKeys(account)
+	if err != nil {
+		return err
+	}
+	s.multisig = keys
+	return nil
+}
+
+// DeriveMultisig adds the cosigner key of the multisig address
+func (s *HDSigner) DeriveMultisig(change bip44.ChangeType, index uint32) error {
+	if s.multisig == nil {
+		return errors.New("the wallet has no multisig account")
+	}
+	_, err := s.multisig.Derive(change, index)
+	return err
+}
+
+// SignInputs returns the signatures of all inputs, it fails
+// when some input isn't locked by the key of the account
+func (s *HDSigner) SignInputs(tx *bhdmodels.Tx) ([]*InputSignature, error) {
+	_, inputs, err := signableInputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	var signatures []*InputSignature
+	for _, in := range inputs {
+		script, _ := hex.DecodeString(in.PubScript)
+		key := s.keys.lookup(script)
+		if key == nil {
+			return nil, errors.New("the wallet has no key for the input " + strconv.Itoa(in.Index))
+		}
+		hash, _ := hex.DecodeString(in.SigHash)
+		signature, err := s.SignatureType.sign(key.private, hash)
+		if err != nil {
+			return nil, err
+		}
+		signatures = append(signatures, &InputSignature{
+			Index:     in.Index,
+			Signature: hex.EncodeToString(append(signature.Serialize(), byte(in.HashType))),
+			PubKey:    hex.EncodeToString(key.pubKey),
+		})
+	}
+	return signatures, nil
+}
+
+func (s *HDSigner) SignTransaction(tx *bhdmodels.Tx) error {
+	signatures, err := s.SignInputs(tx)
+	if err != nil {
+		return err
+	}
+	return ApplySignatures(tx, signatures)
+}
+
+// SignPartial adds the signatures of the inputs locked by the keys of the account,
+// the other inputs are left for the other signers. It returns the number of the signed inputs.
+func (s *HDSigner) SignPartial(p *PartialTx) (int, error) {
+	var signed = 0
+	for i, in := range p.Inputs {
+		if in.FinalScript != "" {
+			continue
+		}
+		scriptCode, _, err := in.spentScript()
+		if err != nil {
+			return signed, err
+		}
+		var keys []*derivedKey
+		if key := s.keys.lookup(scriptCode); key != nil {
+			keys = append(keys, key)
+		}
+		for _, d := range in.Derivations {
+			pubKey, err := hex.DecodeString(d.PubKey)
+			if err != nil {
+				return signed, err
+			}
+			if key := s.keys.lookupPubKey(pubKey); key != nil {
+				keys = append(keys, key)
+			} else if s.multisig != nil {
+				if key := s.multisig.lookupPubKey(pubKey); key != nil {
+					keys = append(keys, key)
+				}
+			}
+		}
+		for _, key := range keys {
+			if _, ok := in.PartialSigs[hex.EncodeToString(key.pubKey)]; ok {
+				continue
+			}
+			hash, hashType, err := p.SigHash(i)
+			if err != nil {
+				return signed, err
+			}
+			// all signatures of the multisig must be of the same type
+			sigType := s.SignatureType
+			if txscript.GetScriptClass(scriptCode) == txscript.MultiSigTy {
+				sigType = multisigSignatureType(in.PartialSigs, sigType)
+			}
+			signature, err := sigType.sign(key.private, hash)
+			if err != nil {
+				return signed, err
+			}
+			err = p.AddSignature(i, key.pubKey, append(signature.Serialize(), byte(hashType)))
+			if err != nil {
+				return signed, err
+			}
+			signed++
+		}
+	}
+	return signed, nil
+}
+
+// WatchOnlySigner knows only the public account key, it can't sign but it exports
+// the unsigned transaction with the key paths for the offline signer
+type WatchOnlySigner struct {
+	keys        *accountKeys
+	accountPath string
+}
+
+// NewWatchOnlySigner creates the signer of the account xpub, accountPath is the
+// derivation path of the account, DefaultAccountPath when empty
+func NewWatchOnlySigner(xpub string, accountPath string) (*WatchOnlySigner, error) {
+	account, err := bip44.NewAccountKeyFromXPubKey(xpub)
+	if err != nil {
+		return nil, err
+	}
+	if account.ExKey.IsPrivate() {
+		return nil, errors.New("watch-only wallet needs the public account key")
+	}
+	keys, err := newAccountKeys(account)
+	if err != nil {
+		return nil, err
+	}
+	if accountPath == "" {
+		accountPath = DefaultAccountPath
+	}
+	return &WatchOnlySigner{keys: keys, accountPath: accountPath}, nil
+}
+
+// Derive adds the address key to the keys exported with the unsigned transaction
+func (s *WatchOnlySigner) Derive(change bip44.ChangeType, index uint32) error {
+	_, err := s.keys.Derive(change, index)
+	return err
+}
+
+func (s *WatchOnlySigner) SignTransaction(tx *bhdmodels.Tx) error {
+	return ErrWatchOnly
+}
+
+// ExportUnsigned returns the transaction with the sig hashes, public keys and
+// derivation paths of the inputs, sign it offline and use ApplySignatures
+func (s *WatchOnlySigner) ExportUnsigned(tx *bhdmodels.Tx) (*UnsignedTx, error) {
+	_, inputs, err := signableInputs(tx)
+	if err != nil {
+		return nil, err
+	}
+	for _, in := range inputs {
+		script, _ := hex.DecodeString(in.PubScript)
+		key := s.keys.lookup(script)
+		if key == nil {
+			return nil, errors.New("the wallet has no key for the input " + strconv.Itoa(in.Index))
+		}
+		in.PubKey = hex.EncodeToString(key.pubKey)
+		in.Path = key.path()
+	}
+	return &UnsignedTx{
+		Version:     UnsignedTxVersion,
+		AccountPath: s.accountPath,
+		Tx:          tx,
+		Inputs:      inputs,
+	}, nil
+}
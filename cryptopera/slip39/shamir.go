@@ -0,0 +1,151 @@
+package slip39
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"errors"
+)
+
+const (
+	// the x coordinates of the shared secret and its digest
+	secretIndex = 255
+	digestIndex = 254
+	digestSize  = 4
+	// MaxShareCount is the maximum number of the groups and of the members of a group
+	MaxShareCount = 16
+)
+
+// share is the point of the polynomial, the value is evaluated byte by byte
+type share struct {
+	x     byte
+	value []byte
+}
+
+// the exp and log tables of GF(256) with the Rijndael polynomial
+// x^8 + x^4 + x^3 + x + 1, 3 is the generator
+var expTable, logTable = gfTables()
+
+func gfTables() ([255]byte, [256]byte) {
+	var exp [255]byte
+	var log [256]byte
+	var poly = 1
+	for i := 0; i < 255; i++ {
+		exp[i] = byte(poly)
+		log[poly] = byte(i)
+		// multiply by 3 = x + 1
+		poly = (poly << 1) ^ poly
+		if poly&0x100 != 0 {
+			poly ^= 0x11b
+		}
+	}
+	return exp, log
+}
+
+// interpolate returns the value of the polynomial passing the shares at x,
+// the shares must have distinct x and values of the same length
+func interpolate(shares []share, x byte) ([]byte, error) {
+	if len(shares) == 0 {
+		return nil, errors.New("no shares to interpolate")
+	}
+	size := len(shares[0].value)
+	for _, s := range shares {
+		if s.x == x {
+			return append([]byte(nil), s.value...), nil
+		}
+		if len(s.value) != size {
+			return nil, errors.New("share values have different lengths")
+		}
+	}
+	// log of the product of (x - x_k) over all shares, subtraction is xor
+	var logProd int
+	for _, s := range shares {
+		logProd += int(logTable[s.x^x])
+	}
+	result := make([]byte, size)
+	for i, s := range shares {
+		// the Lagrange basis of the share, the (x - x_i) term cancels out
+		var logSum = logProd - int(logTable[s.x^x])
+		for j, other := range shares {
+			if i != j {
+				logSum -= int(logTable[s.x^other.x])
+			}
+		}
+		logBasis := ((logSum % 255) + 255) % 255
+		for k, v := range s.value {
+			if v != 0 {
+				result[k] ^= expTable[(int(logTable[v])+logBasis)%255]
+			}
+		}
+	}
+	return result, nil
+}
+
+// secretDigest returns the first bytes of the HMAC of the secret keyed by the random part
+func secretDigest(random []byte, secret []byte) []byte {
+	mac := hmac.New(sha256.New, random)
+	mac.Write(secret)
+	return mac.Sum(nil)[:digestSize]
+}
+
+// splitSecret creates count shares of the secret, any threshold of them recover it
+func splitSecret(threshold int, count int, secret []byte) ([]share, error) {
+	if threshold < 1 || threshold > count {
+		return nil, errors.New("threshold must be between 1 and the share count")
+	}
+	if count > MaxShareCount {
+		return nil, errors.New("too many shares")
+	}
+	var shares = make([]share, 0, count)
+	if threshold == 1 {
+		for i := 0; i < count; i++ {
+			shares = append(shares, share{x: byte(i), value: append([]byte(nil), secret...)})
+		}
+		return shares, nil
+	}
+	// threshold-2 random shares, the digest and the secret define the polynomial
+	var base = make([]share, 0, threshold)
+	for i := 0; i < threshold-2; i++ {
+		value := make([]byte, len(secret))
+		_, err := rand.Read(value)
+		if err != nil {
+			return nil, err
+		}
+		base = append(base, share{x: byte(i), value: value})
+	}
+	random := make([]byte, len(secret)-digestSize)
+	_, err := rand.Read(random)
+	if err != nil {
+		return nil, err
+	}
+	digest := append(secretDigest(random, secret), random...)
+	base = append(base, share{x: digestIndex, value: digest}, share{x: secretIndex, value: secret})
+	shares = append(shares, base[:threshold-2]...)
+	for i := threshold - 2; i < count; i++ {
+		value, err := interpolate(base, byte(i))
+		if err != nil {
+			return nil, err
+		}
+		shares = append(shares, share{x: byte(i), value: value})
+	}
+	return shares, nil
+}
+
+// recoverSecret recovers the secret from the threshold shares and checks its digest
+func recoverSecret(threshold int, shares []share) ([]byte, error) {
+	if threshold == 1 {
+		return shares[0].value, nil
+	}
+	secret, err := interpolate(shares, secretIndex)
+	if err != nil {
+		return nil, err
+	}
+	digest, err := interpolate(shares, digestIndex)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(digest[:digestSize], secretDigest(digest[digestSize:], secret)) {
+		return nil, ErrShareDigest
+	}
+	return secret, nil
+}
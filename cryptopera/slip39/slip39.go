@@ -0,0 +1,350 @@
+// Package slip39 splits the master secret into the SLIP-39 mnemonic shares,
+// the shares are organized in groups: group threshold of the groups, each
+// with its member threshold of the shares, recover the secret.
+package slip39
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strconv"
+	"strings"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+const (
+	// DefaultIterationExponent sets the PBKDF2 iterations of the encryption to 20000
+	DefaultIterationExponent = 1
+	// MinSecretSize is the minimum master secret size in bytes
+	MinSecretSize = 16
+	radixBits     = 10
+	// the identifier, the extendable flag and the iteration exponent are 2 words,
+	// the group and member parameters 2 words and the checksum 3 words
+	idWords        = 2
+	paramsWords    = 2
+	checksumWords  = 3
+	metadataWords  = idWords + paramsWords + checksumWords
+	minShareWords  = metadataWords + (MinSecretSize*8+radixBits-1)/radixBits
+	baseIterations = 10000
+	roundCount     = 4
+)
+
+var (
+	ErrShareChecksum = errors.New("share checksum doesn't match, some word is wrong or the words are in the wrong order")
+	ErrShareDigest   = errors.New("shares don't recover the secret, some share belongs to another backup")
+	ErrShareCount    = errors.New("not enough shares to recover the secret")
+	ErrShareMismatch = errors.New("shares belong to different backups")
+)
+
+// ShareWordError is returned for the word which isn't in the word list,
+// Position starts at 1 as shown to the user
+type ShareWordError struct {
+	Position int
+	Word     string
+}
+
+func (e *ShareWordError) Error() string {
+	return "word " + strconv.Itoa(e.Position) + " \"" + e.Word + "\" is not in the SLIP-39 word list"
+}
+
+// Group is the member threshold and the share count of the group
+type Group struct {
+	Threshold int `json:"threshold"`
+	Count     int `json:"count"`
+}
+
+// Share is the decoded mnemonic share, the thresholds and counts start at 1
+// and the indexes at 0
+type Share struct {
+	Identifier        uint16
+	Extendable        bool
+	IterationExponent int
+	GroupIndex        int
+	GroupThreshold    int
+	GroupCount        int
+	MemberIndex       int
+	MemberThreshold   int
+	Value             []byte
+}
+
+var wordIndex = func() map[string]int {
+	index := make(map[string]int, len(wordList))
+	for i, word := range wordList {
+		index[word] = i
+	}
+	return index
+}()
+
+// customization is the checksum customization string
+func customization(extendable bool) string {
+	if extendable {
+		return "shamir_extendable"
+	}
+	return "shamir"
+}
+
+// rs1024Polymod is the Reed-Solomon checksum over GF(1024) of the words
+func rs1024Polymod(values []int) int {
+	var gen = [10]int{
+		0xe0e040, 0x1c1c080, 0x3838100, 0x7070200, 0xe0e0009,
+		0x1c0c2412, 0x38086c24, 0x3090fc48, 0x21b1f890, 0x3f3f120,
+	}
+	var chk = 1
+	for _, v := range values {
+		b := chk >> 20
+		chk = (chk&0xfffff)<<10 ^ v
+		for i := 0; i < 10; i++ {
+			if (b>>i)&1 != 0 {
+				chk ^= gen[i]
+			}
+		}
+	}
+	return chk
+}
+
+func customizationValues(extendable bool) []int {
+	var values []int
+	for _, c := range []byte(customization(extendable)) {
+		values = append(values, int(c))
+	}
+	return values
+}
+
+// Words encodes the share as the mnemonic words
+func (s *Share) Words() []string {
+	var ext int
+	if s.Extendable {
+		ext = 1
+	}
+	id := int(s.Identifier)<<5 | ext<<4 | s.IterationExponent
+	params := s.GroupIndex<<16 | (s.GroupThreshold-1)<<12 | (s.GroupCount-1)<<8 | s.MemberIndex<<4 | (s.MemberThreshold - 1)
+	values := []int{id >> 10, id & 1023, params >> 10, params & 1023}
+	valueWords := (len(s.Value)*8 + radixBits - 1) / radixBits
+	value := new(big.Int).SetBytes(s.Value)
+	for i := valueWords - 1; i >= 0; i-- {
+		word := new(big.Int).Rsh(value, uint(i*radixBits))
+		values = append(values, int(word.Int64()&1023))
+	}
+	polymod := rs1024Polymod(append(append(customizationValues(s.Extendable), values...), 0, 0, 0)) ^ 1
+	for i := checksumWords - 1; i >= 0; i-- {
+		values = append(values, (polymod>>(i*radixBits))&1023)
+	}
+	words := make([]string, len(values))
+	for i, v := range values {
+		words[i] = wordList[v]
+	}
+	return words
+}
+
+// Mnemonic returns the share as the space separated words
+func (s *Share) Mnemonic() string {
+	return strings.Join(s.Words(), " ")
+}
+
+// ParseShare decodes the mnemonic share and checks its checksum
+func ParseShare(mnemonic string) (*Share, error) {
+	words := strings.Fields(strings.ToLower(mnemonic))
+	if len(words) < minShareWords {
+		return nil, errors.New("share must have at least " + strconv.Itoa(minShareWords) + " words, it has " + strconv.Itoa(len(words)))
+	}
+	values := make([]int, len(words))
+	for i, word := range words {
+		index, ok := wordIndex[word]
+		if !ok {
+			return nil, &ShareWordError{Position: i + 1, Word: word}
+		}
+		values[i] = index
+	}
+	valueWords := len(words) - metadataWords
+	padding := radixBits * valueWords % 16
+	if padding > 8 {
+		return nil, errors.New("share has invalid number of words")
+	}
+	id := values[0]<<10 | values[1]
+	s := &Share{
+		Identifier:        uint16(id >> 5),
+		Extendable:        (id>>4)&1 == 1,
+		IterationExponent: id & 15,
+	}
+	if rs1024Polymod(append(customizationValues(s.Extendable), values...)) != 1 {
+		return nil, ErrShareChecksum
+	}
+	params := values[2]<<10 | values[3]
+	s.GroupIndex = params >> 16
+	s.GroupThreshold = (params>>12)&15 + 1
+	s.GroupCount = (params>>8)&15 + 1
+	s.MemberIndex = (params >> 4) & 15
+	s.MemberThreshold = params&15 + 1
+	if s.GroupThreshold > s.GroupCount {
+		return nil, errors.New("share group threshold is greater than the group count")
+	}
+	value := new(big.Int)
+	for _, v := range values[idWords+paramsWords : len(values)-checksumWords] {
+		value.Lsh(value, radixBits)
+		value.Or(value, big.NewInt(int64(v)))
+	}
+	size := (radixBits*valueWords - padding) / 8
+	if value.BitLen() > size*8 {
+		return nil, errors.New("share has invalid padding")
+	}
+	s.Value = value.FillBytes(make([]byte, size))
+	return s, nil
+}
+
+// feistel runs the 4 round Feistel network of the SLIP-39 encryption keyed by
+// the passphrase, the rounds go backwards for the decryption
+func feistel(secret []byte, passphrase string, exponent int, identifier uint16, extendable bool, encrypt bool) []byte {
+	half := len(secret) / 2
+	l := append([]byte(nil), secret[:half]...)
+	r := append([]byte(nil), secret[half:]...)
+	var salt []byte
+	if !extendable {
+		salt = binary.BigEndian.AppendUint16([]byte(customization(false)), identifier)
+	}
+	iterations := (baseIterations << exponent) / roundCount
+	for i := 0; i < roundCount; i++ {
+		round := i
+		if !encrypt {
+			round = roundCount - 1 - i
+		}
+		password := append([]byte{byte(round)}, passphrase...)
+		f := pbkdf2.Key(password, append(append([]byte(nil), salt...), r...), iterations, len(r), sha256.New)
+		for j := range f {
+			f[j] ^= l[j]
+		}
+		l, r = r, f
+	}
+	return append(r, l...)
+}
+
+// checkPassphrase allows only the printable ASCII characters
+func checkPassphrase(passphrase string) error {
+	for _, c := range passphrase {
+		if c < 32 || c > 126 {
+			return errors.New("passphrase must contain only printable ASCII characters")
+		}
+	}
+	return nil
+}
+
+// GenerateShares splits the master secret encrypted by the passphrase into the
+// groups of the mnemonic shares, groupThreshold of the groups are needed to
+// recover it. The extendable backups can get more groups later.
+func GenerateShares(groupThreshold int, groups []Group, secret []byte, passphrase string, extendable bool, exponent int) ([][]string, error) {
+	if len(secret) < MinSecretSize || len(secret)%2 != 0 {
+		return nil, errors.New("master secret must be at least " + strconv.Itoa(MinSecretSize) + " bytes and of even length")
+	}
+	if groupThreshold < 1 || groupThreshold > len(groups) {
+		return nil, errors.New("group threshold must be between 1 and the group count")
+	}
+	if exponent < 0 || exponent > 15 {
+		return nil, errors.New("iteration exponent must be between 0 and 15")
+	}
+	for _, group := range groups {
+		if group.Threshold == 1 && group.Count > 1 {
+			return nil, errors.New("group with the member threshold 1 must have 1 share")
+		}
+	}
+	err := checkPassphrase(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var id [2]byte
+	_, err = rand.Read(id[:])
+	if err != nil {
+		return nil, err
+	}
+	identifier := binary.BigEndian.Uint16(id[:]) & 0x7fff
+	encrypted := feistel(secret, passphrase, exponent, identifier, extendable, true)
+	groupShares, err := splitSecret(groupThreshold, len(groups), encrypted)
+	if err != nil {
+		return nil, err
+	}
+	var mnemonics = make([][]string, len(groups))
+	for i, group := range groups {
+		memberShares, err := splitSecret(group.Threshold, group.Count, groupShares[i].value)
+		if err != nil {
+			return nil, err
+		}
+		for _, member := range memberShares {
+			s := &Share{
+				Identifier:        identifier,
+				Extendable:        extendable,
+				IterationExponent: exponent,
+				GroupIndex:        i,
+				GroupThreshold:    groupThreshold,
+				GroupCount:        len(groups),
+				MemberIndex:       int(member.x),
+				MemberThreshold:   group.Threshold,
+				Value:             member.value,
+			}
+			mnemonics[i] = append(mnemonics[i], s.Mnemonic())
+		}
+	}
+	return mnemonics, nil
+}
+
+// CombineShares recovers the master secret from the mnemonic shares decrypting
+// it with the passphrase. The wrong passphrase gives the different secret.
+func CombineShares(mnemonics []string, passphrase string) ([]byte, error) {
+	err := checkPassphrase(passphrase)
+	if err != nil {
+		return nil, err
+	}
+	var first *Share
+	var groups = make(map[int][]share)
+	var memberThresholds = make(map[int]int)
+	for _, mnemonic := range mnemonics {
+		s, err := ParseShare(mnemonic)
+		if err != nil {
+			return nil, err
+		}
+		if first == nil {
+			first = s
+		} else if s.Identifier != first.Identifier || s.Extendable != first.Extendable ||
+			s.IterationExponent != first.IterationExponent || s.GroupThreshold != first.GroupThreshold ||
+			s.GroupCount != first.GroupCount || len(s.Value) != len(first.Value) {
+			return nil, ErrShareMismatch
+		}
+		if threshold, ok := memberThresholds[s.GroupIndex]; ok && threshold != s.MemberThreshold {
+			return nil, ErrShareMismatch
+		}
+		memberThresholds[s.GroupIndex] = s.MemberThreshold
+		var duplicate bool
+		for _, member := range groups[s.GroupIndex] {
+			if int(member.x) == s.MemberIndex {
+				duplicate = true
+			}
+		}
+		if !duplicate {
+			groups[s.GroupIndex] = append(groups[s.GroupIndex], share{x: byte(s.MemberIndex), value: s.Value})
+		}
+	}
+	if first == nil {
+		return nil, ErrShareCount
+	}
+	var groupShares []share
+	for index := 0; index < first.GroupCount && len(groupShares) < first.GroupThreshold; index++ {
+		members := groups[index]
+		threshold := memberThresholds[index]
+		if len(members) == 0 || len(members) < threshold {
+			continue
+		}
+		value, err := recoverSecret(threshold, members[:threshold])
+		if err != nil {
+			return nil, err
+		}
+		groupShares = append(groupShares, share{x: byte(index), value: value})
+	}
+	if len(groupShares) < first.GroupThreshold {
+		return nil, ErrShareCount
+	}
+	encrypted, err := recoverSecret(first.GroupThreshold, groupShares)
+	if err != nil {
+		return nil, err
+	}
+	return feistel(encrypted, passphrase, first.IterationExponent, first.Identifier, first.Extendable, false), nil
+}
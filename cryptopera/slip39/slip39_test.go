@@ -0,0 +1,131 @@
+package slip39
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// vectorPassphrase is the passphrase of the SLIP-39 test vectors
+const vectorPassphrase = "TREZOR"
+
+// the SLIP-39 test vectors, secret is empty for the invalid shares
+var shareVectors = []struct {
+	name      string
+	mnemonics []string
+	secret    string
+	err       error
+}{
+	{"valid mnemonic without sharing (128 bits)", []string{
+		"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision keyboard",
+	}, "bb54aac4b89dc868ba37d9cc21b2cece", nil},
+	{"mnemonic with invalid checksum (128 bits)", []string{
+		"duckling enlarge academic academic agency result length solution fridge kidney coal piece deal husband erode duke ajar critical decision kidney",
+	}, "", ErrShareChecksum},
+	{"basic sharing 2-of-3 (128 bits)", []string{
+		"shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed",
+		"shadow pistol academic acid actress prayer class unknown daughter sweater depict flip twice unkind craft early superior advocate guest smoking",
+	}, "b43ceb7e57a0ea8766221624d01b0864", nil},
+	{"basic sharing 2-of-3 with 1 share (128 bits)", []string{
+		"shadow pistol academic always adequate wildlife fancy gross oasis cylinder mustang wrist rescue view short owner flip making coding armed",
+	}, "", ErrShareCount},
+}
+
+func TestShareVectors(t *testing.T) {
+	for _, test := range shareVectors {
+		secret, err := CombineShares(test.mnemonics, vectorPassphrase)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil || hex.EncodeToString(secret) != test.secret {
+			t.Errorf("%s: expected %s, got %x (%v)", test.name, test.secret, secret, err)
+		}
+		// the share order doesn't matter
+		reversed := make([]string, len(test.mnemonics))
+		for i, mnemonic := range test.mnemonics {
+			reversed[len(reversed)-1-i] = mnemonic
+		}
+		if secret, err := CombineShares(reversed, vectorPassphrase); err != nil || hex.EncodeToString(secret) != test.secret {
+			t.Errorf("%s reversed: expected %s, got %x (%v)", test.name, test.secret, secret, err)
+		}
+		// the shares encode back to the same words
+		for _, mnemonic := range test.mnemonics {
+			s, err := ParseShare(mnemonic)
+			if err != nil || s.Mnemonic() != mnemonic {
+				t.Errorf("%s: the share doesn't round-trip (%v)", test.name, err)
+			}
+		}
+	}
+}
+
+func TestParseShareErrors(t *testing.T) {
+	valid := shareVectors[0].mnemonics[0]
+	words := strings.Fields(valid)
+	words[6] = "lenght"
+	var wordErr *ShareWordError
+	if _, err := ParseShare(strings.Join(words, " ")); !errors.As(err, &wordErr) || wordErr.Position != 7 {
+		t.Errorf("expected the word 7 error, got %v", err)
+	}
+	if _, err := ParseShare(strings.Join(strings.Fields(valid)[:10], " ")); err == nil {
+		t.Error("short share accepted")
+	}
+	// the upper case and the extra white space are accepted
+	if _, err := ParseShare("  " + strings.ToUpper(strings.ReplaceAll(valid, " ", "\t"))); err != nil {
+		t.Errorf("upper case share rejected: %v", err)
+	}
+}
+
+func TestGroupShares(t *testing.T) {
+	secret, _ := hex.DecodeString("bb54aac4b89dc868ba37d9cc21b2cece")
+	groups := []Group{{Threshold: 1, Count: 1}, {Threshold: 2, Count: 3}, {Threshold: 3, Count: 5}}
+	shares, err := GenerateShares(2, groups, secret, vectorPassphrase, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, group := range groups {
+		if len(shares[i]) != group.Count {
+			t.Fatalf("group %d: expected %d shares, got %d", i, group.Count, len(shares[i]))
+		}
+	}
+	for _, test := range []struct {
+		name   string
+		shares []string
+		err    error
+	}{
+		{"groups 0 and 1", []string{shares[0][0], shares[1][2], shares[1][0]}, nil},
+		{"groups 1 and 2", []string{shares[1][1], shares[2][4], shares[1][2], shares[2][0], shares[2][2]}, nil},
+		{"group 2 short", []string{shares[0][0], shares[2][0], shares[2][1]}, ErrShareCount},
+		{"one group", []string{shares[1][0], shares[1][1], shares[1][2]}, ErrShareCount},
+		{"duplicate share", []string{shares[0][0], shares[1][0], shares[1][0]}, ErrShareCount},
+	} {
+		recovered, err := CombineShares(test.shares, vectorPassphrase)
+		if test.err != nil {
+			if !errors.Is(err, test.err) {
+				t.Errorf("%s: expected %v, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil || !bytes.Equal(recovered, secret) {
+			t.Errorf("%s: expected %x, got %x (%v)", test.name, secret, recovered, err)
+		}
+	}
+
+	// the wrong passphrase gives the other secret
+	recovered, err := CombineShares([]string{shares[0][0], shares[1][0], shares[1][1]}, "")
+	if err != nil || bytes.Equal(recovered, secret) {
+		t.Errorf("the wrong passphrase recovered the secret (%v)", err)
+	}
+	// the shares of the other backup don't mix
+	other, err := GenerateShares(2, groups, secret, vectorPassphrase, true, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := CombineShares([]string{shares[0][0], other[1][0], other[1][1]}, vectorPassphrase); !errors.Is(err, ErrShareMismatch) {
+		t.Errorf("expected ErrShareMismatch, got %v", err)
+	}
+}
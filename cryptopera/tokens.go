@@ -0,0 +1,590 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"strconv"
+
+	"github.com/gcash/bchd/txscript"
+	"github.com/gcash/bchd/wire"
+	"github.com/gcash/bchutil"
+)
+
+const (
+	// DustLimit is the smallest value of the pay to public key hash output,
+	// the limit of the other outputs is computed by dustValue
+	DustLimit = 546
+	// DefaultFeeRate is the fee in satoshis per byte
+	DefaultFeeRate = 1
+	// p2pkhInputSize is the size of the signed pay to public key hash input,
+	// the dust rule prices every output by the cost of spending it this way
+	p2pkhInputSize = 148
+	// dustRelayFee is the relay fee in satoshis per kB the dust limit is derived from
+	dustRelayFee = 1000
+	// maxSignatureSize is the largest signature with the hash type byte, the DER
+	// encoded ECDSA one, the schnorr signatures are 65 bytes
+	maxSignatureSize = 73
+	// compressedPubKeySize is the size of the public keys of the wallet
+	compressedPubKeySize = 33
+)
+
+// FungibleBalance is the amount of the fungible tokens of the category
+type FungibleBalance struct {
+	Category string `json:"category"`
+	Amount   int64  `json:"amount"`
+	Outputs  int    `json:"outputs"`
+}
+
+// NftItem is the non-fungible token held by the wallet and the uxto holding it
+type NftItem struct {
+	Category   string `json:"category"`
+	Capability string `json:"capability"`
+	Commitment string `json:"commitment"`
+	Amount     int64  `json:"amount"`
+	Hash       string `json:"hash"`
+	Index      int32  `json:"index"`
+	Value      int64  `json:"value"`
+}
+
+// TokenInventory lists the fungible balances per category and the NFTs of the wallet
+type TokenInventory struct {
+	Fungible []*FungibleBalance `json:"fungible"`
+	Nfts     []*NftItem         `json:"nfts"`
+}
+
+// fillUxtoTokens sets the token of the uxtos from the token prefix of the
+// locking script when the server didn't provide it
+func fillUxtoTokens(uxtos []*bhdmodels.Uxto) error {
+	for _, uxto := range uxtos {
+		if uxto.Token != nil {
+			continue
+		}
+		script, err := hex.DecodeString(uxto.PkScript)
+		if err != nil {
+			return err
+		}
+		token, _, err := bhdmodels.SplitTokenScript(script)
+		if err != nil {
+			return errors.New("uxto " + uxto.Hash + ":" + strconv.Itoa(int(uxto.Index)) + " " + err.Error())
+		}
+		uxto.Token = token
+	}
+	return nil
+}
+
+// NewTokenInventory sums the fungible tokens per category and lists the NFTs of the uxtos
+func NewTokenInventory(uxtos []*bhdmodels.Uxto) (*TokenInventory, error) {
+	err := fillUxtoTokens(uxtos)
+	if err != nil {
+		return nil, err
+	}
+	inventory := &TokenInventory{
+		Fungible: make([]*FungibleBalance, 0),
+		Nfts:     make([]*NftItem, 0),
+	}
+	balances := make(map[string]*FungibleBalance)
+	for _, uxto := range uxtos {
+		token := uxto.Token
+		if token == nil {
+			continue
+		}
+		if token.Amount > 0 {
+			balance, ok := balances[token.Category]
+			if !ok {
+				balance = &FungibleBalance{Category: token.Category}
+				balances[token.Category] = balance
+				inventory.Fungible = append(inventory.Fungible, balance)
+			}
+			balance.Amount += token.Amount
+			balance.Outputs++
+		}
+		if token.HasNft {
+			inventory.Nfts = append(inventory.Nfts, &NftItem{
+				Category:   token.Category,
+				Capability: token.Capability,
+				Commitment: token.Commitment,
+				Amount:     token.Amount,
+				Hash:       uxto.Hash,
+				Index:      uxto.Index,
+				Value:      uxto.Value,
+			})
+		}
+	}
+	return inventory, nil
+}
+
+// newTxIn creates the unsigned input spending the uxto
+func newTxIn(uxto *bhdmodels.Uxto) *bhdmodels.TxIn {
+	return &bhdmodels.TxIn{
+		Sequence:  wire.MaxTxInSequenceNum,
+		Value:     uxto.Value,
+		PrevHash:  uxto.Hash,
+		PrevIndex: uint32(uxto.Index),
+		PubScript: uxto.PkScript,
+		Token:     uxto.Token,
+	}
+}
+
+// findUxto returns the uxto of the outpoint, nil when the wallet doesn't have it
+func findUxto(uxtos []*bhdmodels.Uxto, hash string, index int32) *bhdmodels.Uxto {
+	for _, uxto := range uxtos {
+		if uxto.Hash == hash && uxto.Index == index {
+			return uxto
+		}
+	}
+	return nil
+}
+
+// addressScript returns the locking script paying to the cash address
+func (w *Wallet) addressScript(address string) (string, error) {
+	addr, err := bchutil.DecodeAddress(address, w.NetParams)
+	if err != nil {
+		return "", err
+	}
+	script, err := txscript.PayToAddrScript(addr)
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(script), nil
+}
+
+// dustValue returns the smallest value the output needs to be relayed, three
+// times the fee of the output and the input spending it, as the nodes compute it.
+// The token prefix makes the output larger, so the token outputs need more.
+func dustValue(out *bhdmodels.TxOut) (int64, error) {
+	script, err := out.LockingScript()
+	if err != nil {
+		return 0, err
+	}
+	size := 8 + int64(wire.VarIntSerializeSize(uint64(len(script)))) + int64(len(script))
+	return 3 * (size + p2pkhInputSize) * dustRelayFee / 1000, nil
+}
+
+// pushSize returns the size of the data push of n bytes
+func pushSize(n int) int64 {
+	switch {
+	case n <= txscript.OP_DATA_75:
+		return 1 + int64(n)
+	case n <= 0xff:
+		return 2 + int64(n)
+	case n <= 0xffff:
+		return 3 + int64(n)
+	}
+	return 5 + int64(n)
+}
+
+// unlockingScriptSize returns the largest size of the unlocking script of the
+// locking script (without the token prefix), the signatures are counted as the
+// ECDSA ones. The redeem script of the P2SH input must be of the multisig account.
+func (w *Wallet) unlockingScriptSize(script []byte) (int64, error) {
+	class := txscript.GetScriptClass(script)
+	switch class {
+	case txscript.PubKeyHashTy:
+		return pushSize(maxSignatureSize) + pushSize(compressedPubKeySize), nil
+	case txscript.PubKeyTy:
+		return pushSize(maxSignatureSize), nil
+	case txscript.ScriptHashTy:
+		var redeem *RedeemScript
+		if w.Multisig != nil {
+			redeem = w.Multisig.lookup(script)
+		}
+		if redeem == nil {
+			return 0, errors.New("the redeem script of " + hex.EncodeToString(script) + " is not known")
+		}
+		// the dummy is the bitfield of the keys for the schnorr multisig
+		size := pushSize((len(redeem.PubKeys) + 7) / 8)
+		size += int64(w.Multisig.Required) * pushSize(maxSignatureSize)
+		return size + pushSize(len(redeem.Script)), nil
+	}
+	return 0, errors.New("cannot estimate the unlocking script of the " + class.String() + " output")
+}
+
+// inputSize returns the size of the signed input
+func (w *Wallet) inputSize(in *bhdmodels.TxIn) (int64, error) {
+	script, _, err := in.SpentScript()
+	if err != nil {
+		return 0, err
+	}
+	unlocking, err := w.unlockingScriptSize(script)
+	if err != nil {
+		return 0, err
+	}
+	// outpoint, sequence and the unlocking script
+	return 36 + 4 + int64(wire.VarIntSerializeSize(uint64(unlocking))) + unlocking, nil
+}
+
+// estimateTxSize returns the size of the signed transaction, the inputs are
+// sized by the type of the spent output
+func (w *Wallet) estimateTxSize(tx *bhdmodels.Tx) (int64, error) {
+	// version, lock time and the counts
+	var size int64 = 4 + 4
+	size += int64(wire.VarIntSerializeSize(uint64(len(tx.Inputs))))
+	size += int64(wire.VarIntSerializeSize(uint64(len(tx.Outputs))))
+	for _, in := range tx.Inputs {
+		inSize, err := w.inputSize(in)
+		if err != nil {
+			return 0, err
+		}
+		size += inSize
+	}
+	for _, out := range tx.Outputs {
+		script, err := out.LockingScript()
+		if err != nil {
+			return 0, err
+		}
+		size += 8 + int64(wire.VarIntSerializeSize(uint64(len(script)))) + int64(len(script))
+	}
+	return size, nil
+}
+
+// setTokenDust raises the value of the token outputs to their dust limit, the
+// builders leave it zero
+func setTokenDust(tx *bhdmodels.Tx) error {
+	for _, out := range tx.Outputs {
+		if out.Token == nil {
+			continue
+		}
+		dust, err := dustValue(out)
+		if err != nil {
+			return err
+		}
+		if out.Value < dust {
+			out.Value = dust
+		}
+	}
+	return nil
+}
+
+// fundTransaction sets the dust value of the token outputs and adds the regular uxtos
+// until the inputs pay the outputs and the fee, change above the dust limit goes back
+// to the wallet. Uxtos already spent by the transaction, the token uxtos and the uxtos
+// the wallet can't size are skipped, so tokens are never burned.
+func (w *Wallet) fundTransaction(tx *bhdmodels.Tx, uxtos []*bhdmodels.Uxto, feeRate int64) error {
+	if feeRate <= 0 {
+		feeRate = DefaultFeeRate
+	}
+	err := setTokenDust(tx)
+	if err != nil {
+		return err
+	}
+	changeScript, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		return err
+	}
+	used := make(map[string]bool, len(tx.Inputs))
+	for _, in := range tx.Inputs {
+		used[in.PrevHash+":"+strconv.Itoa(int(in.PrevIndex))] = true
+	}
+	var available []*bhdmodels.Uxto
+	for _, uxto := range uxtos {
+		if uxto.Token != nil || used[uxto.Hash+":"+strconv.Itoa(int(uxto.Index))] {
+			continue
+		}
+		if _, err := w.inputSize(newTxIn(uxto)); err == nil {
+			available = append(available, uxto)
+		}
+	}
+	// largest first keeps the number of inputs low
+	sort.Slice(available, func(i, j int) bool {
+		return available[i].Value > available[j].Value
+	})
+
+	var inputVal, outputVal int64
+	for _, in := range tx.Inputs {
+		inputVal += in.Value
+	}
+	for _, out := range tx.Outputs {
+		outputVal += out.Value
+	}
+	change := &bhdmodels.TxOut{PkScript: changeScript, Address: w.PubAddress, IsCashback: true}
+	for {
+		// the fee is estimated with the change output, it's dropped when it's dust
+		tx.Outputs = append(tx.Outputs, change)
+		size, err := w.estimateTxSize(tx)
+		tx.Outputs = tx.Outputs[:len(tx.Outputs)-1]
+		if err != nil {
+			return err
+		}
+		fee := size * feeRate
+		if inputVal >= outputVal+fee {
+			change.Value = inputVal - outputVal - fee
+			dust, err := dustValue(change)
+			if err != nil {
+				return err
+			}
+			if change.Value >= dust {
+				tx.Outputs = append(tx.Outputs, change)
+				tx.CashBack = change.Value
+			} else {
+				fee += change.Value
+			}
+			tx.InputVal = inputVal
+			tx.OutputVal = inputVal - fee
+			tx.NetworkFee = fee
+			return nil
+		}
+		if len(available) == 0 {
+			return errors.New("not enough funds to pay " + strconv.FormatInt(outputVal+fee, 10) + " satoshis")
+		}
+		tx.Inputs = append(tx.Inputs, newTxIn(available[0]))
+		inputVal += available[0].Value
+		available = available[1:]
+	}
+}
+
+// signAndHash signs all inputs of the transaction and sets its hash, the
+// watch-only wallet returns the transaction unsigned, use ExportUnsigned
+func (w *Wallet) signAndHash(tx *bhdmodels.Tx) error {
+	if w.IsWatchOnly() {
+		return nil
+	}
+	err := w.SignTransaction(tx)
+	if err != nil {
+		return err
+	}
+	wireTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		return err
+	}
+	tx.Hash = wireTx.TxHash().String()
+	tx.Size = int32(wireTx.SerializeSize())
+	return nil
+}
+
+// BuildTokenTransfer creates the signed transaction sending the tokens of the
+// request, the token outputs carry the dust limit of their size. Fungible change
+// returns to the wallet and the fee is paid by the regular uxtos.
+func (w *Wallet) BuildTokenTransfer(rq *bhdmodels.TokenTransferRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+	err := fillUxtoTokens(uxtos)
+	if err != nil {
+		return nil, err
+	}
+	if rq.Amount < 0 {
+		return nil, errors.New("token amount can't be negative")
+	}
+	if rq.Amount == 0 && rq.NftHash == "" {
+		return nil, errors.New("nothing to send, set the amount or the NFT")
+	}
+	destScript, err := w.addressScript(rq.DestinationAddress)
+	if err != nil {
+		return nil, err
+	}
+	ownScript, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		return nil, err
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	sent := &bhdmodels.TokenData{Category: rq.Category, Amount: rq.Amount}
+	var collected int64
+
+	if rq.NftHash != "" {
+		nft := findUxto(uxtos, rq.NftHash, rq.NftIndex)
+		if nft == nil || nft.Token == nil || !nft.Token.HasNft || nft.Token.Category != rq.Category {
+			return nil, errors.New("the wallet doesn't hold the NFT of the category at " + rq.NftHash + ":" + strconv.Itoa(int(rq.NftIndex)))
+		}
+		tx.Inputs = append(tx.Inputs, newTxIn(nft))
+		collected += nft.Token.Amount
+		sent.HasNft = true
+		sent.Capability = nft.Token.Capability
+		sent.Commitment = nft.Token.Commitment
+	}
+	for _, uxto := range uxtos {
+		if collected >= rq.Amount {
+			break
+		}
+		// NFT uxtos are only spent when they're requested
+		if uxto.Token == nil || uxto.Token.HasNft || uxto.Token.Category != rq.Category {
+			continue
+		}
+		tx.Inputs = append(tx.Inputs, newTxIn(uxto))
+		collected += uxto.Token.Amount
+	}
+	if collected < rq.Amount {
+		return nil, errors.New("not enough tokens, the wallet holds " + strconv.FormatInt(collected, 10))
+	}
+
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+		PkScript: destScript,
+		Address:  rq.DestinationAddress,
+		Token:    sent,
+	})
+	if collected > rq.Amount {
+		tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+			PkScript:   ownScript,
+			Address:    w.PubAddress,
+			Token:      &bhdmodels.TokenData{Category: rq.Category, Amount: collected - rq.Amount},
+			IsCashback: true,
+		})
+	}
+	err = w.fundTransaction(tx, uxtos, rq.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	err = w.signAndHash(tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// destination returns the locking script of the address, the wallet address when it's empty
+func (w *Wallet) destination(address string) (string, string, error) {
+	if address == "" {
+		address = w.PubAddress
+	}
+	script, err := w.addressScript(address)
+	if err != nil {
+		return "", "", err
+	}
+	return address, script, nil
+}
+
+// BuildTokenGenesis creates the signed transaction of the new token category. The
+// category id is the transaction id of the outpoint spent by the first input, which
+// must be the output 0 of its transaction, so a regular uxto with index 0 is needed.
+func (w *Wallet) BuildTokenGenesis(rq *bhdmodels.TokenGenesisRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+	err := fillUxtoTokens(uxtos)
+	if err != nil {
+		return nil, err
+	}
+	if rq.Amount < 0 {
+		return nil, errors.New("token amount can't be negative")
+	}
+	if rq.Amount == 0 && rq.Capability == "" {
+		return nil, errors.New("the genesis needs the fungible amount or the NFT")
+	}
+	address, script, err := w.destination(rq.DestinationAddress)
+	if err != nil {
+		return nil, err
+	}
+	var genesis *bhdmodels.Uxto
+	for _, uxto := range uxtos {
+		// the largest one, it will likely pay the whole fee
+		if uxto.Index == 0 && uxto.Token == nil && (genesis == nil || uxto.Value > genesis.Value) {
+			genesis = uxto
+		}
+	}
+	if genesis == nil {
+		return nil, errors.New("token genesis needs the uxto with index 0, send some coins to the wallet first")
+	}
+	token := &bhdmodels.TokenData{
+		Category: genesis.Hash,
+		Amount:   rq.Amount,
+	}
+	if rq.Capability != "" {
+		token.HasNft = true
+		token.Capability = rq.Capability
+		token.Commitment = rq.Commitment
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Inputs = append(tx.Inputs, newTxIn(genesis))
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+		PkScript: script,
+		Address:  address,
+		Token:    token,
+	})
+	err = w.fundTransaction(tx, uxtos, rq.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	err = w.signAndHash(tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// BuildTokenMint creates the signed transaction minting the NFTs of the request,
+// the minting NFT (with its fungible tokens) is sent back to the wallet
+func (w *Wallet) BuildTokenMint(rq *bhdmodels.TokenMintRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+	err := fillUxtoTokens(uxtos)
+	if err != nil {
+		return nil, err
+	}
+	if len(rq.Nfts) == 0 {
+		return nil, errors.New("nothing to mint")
+	}
+	minting := findUxto(uxtos, rq.MintingHash, rq.MintingIndex)
+	if minting == nil || minting.Token == nil || minting.Token.Category != rq.Category ||
+		minting.Token.Capability != bhdmodels.TokenCapabilityMinting {
+		return nil, errors.New("the wallet doesn't hold the minting NFT of the category at " + rq.MintingHash + ":" + strconv.Itoa(int(rq.MintingIndex)))
+	}
+	_, ownScript, err := w.destination("")
+	if err != nil {
+		return nil, err
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Inputs = append(tx.Inputs, newTxIn(minting))
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+		PkScript:   ownScript,
+		Address:    w.PubAddress,
+		Token:      minting.Token,
+		IsCashback: true,
+	})
+	for _, nft := range rq.Nfts {
+		address, script, err := w.destination(nft.DestinationAddress)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+			PkScript: script,
+			Address:  address,
+			Token: &bhdmodels.TokenData{
+				Category:   rq.Category,
+				HasNft:     true,
+				Capability: nft.Capability,
+				Commitment: nft.Commitment,
+			},
+		})
+	}
+	err = w.fundTransaction(tx, uxtos, rq.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	err = w.signAndHash(tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// BuildCommitmentUpdate creates the signed transaction replacing the commitment of
+// the mutable NFT, the capability and the fungible tokens of the NFT uxto are kept
+func (w *Wallet) BuildCommitmentUpdate(rq *bhdmodels.TokenCommitmentRequest, uxtos []*bhdmodels.Uxto) (*bhdmodels.Tx, error) {
+	err := fillUxtoTokens(uxtos)
+	if err != nil {
+		return nil, err
+	}
+	nft := findUxto(uxtos, rq.NftHash, rq.NftIndex)
+	if nft == nil || nft.Token == nil || !nft.Token.HasNft || nft.Token.Category != rq.Category {
+		return nil, errors.New("the wallet doesn't hold the NFT of the category at " + rq.NftHash + ":" + strconv.Itoa(int(rq.NftIndex)))
+	}
+	if nft.Token.Capability != bhdmodels.TokenCapabilityMutable && nft.Token.Capability != bhdmodels.TokenCapabilityMinting {
+		return nil, errors.New("the commitment of the immutable NFT can't be changed")
+	}
+	_, ownScript, err := w.destination("")
+	if err != nil {
+		return nil, err
+	}
+	updated := *nft.Token
+	updated.Commitment = rq.Commitment
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Inputs = append(tx.Inputs, newTxIn(nft))
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{
+		PkScript:   ownScript,
+		Address:    w.PubAddress,
+		Token:      &updated,
+		IsCashback: true,
+	})
+	err = w.fundTransaction(tx, uxtos, rq.FeeRate)
+	if err != nil {
+		return nil, err
+	}
+	err = w.signAndHash(tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
@@ -0,0 +1,293 @@
+package cryptopera
+
+import (
+	"bhd/bch/msg"
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"bytes"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/gcash/bchd/wire"
+)
+
+const testMnemonic = "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about"
+
+func newTestWallet(t *testing.T, mnemonic string) *Wallet {
+	t.Helper()
+	err := NewWalletFromMnemonic(mnemonic, "", bip44.English, bip44.DefaultMnemonicWords)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return Service
+}
+
+func TestDustValue(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dust, err := dustValue(&bhdmodels.TxOut{PkScript: script})
+	if err != nil || dust != DustLimit {
+		t.Errorf("expected the P2PKH dust limit %d, got %d (%v)", DustLimit, dust, err)
+	}
+
+	out := &bhdmodels.TxOut{PkScript: script, Token: &bhdmodels.TokenData{
+		Category:   strings.Repeat("bb", 32),
+		Amount:     1 << 62,
+		HasNft:     true,
+		Capability: bhdmodels.TokenCapabilityMinting,
+		Commitment: strings.Repeat("cc", 40),
+	}}
+	locking, err := out.LockingScript()
+	if err != nil {
+		t.Fatal(err)
+	}
+	dust, err = dustValue(out)
+	if expected := 3 * (8 + 1 + int64(len(locking)) + p2pkhInputSize); err != nil || dust != expected {
+		t.Errorf("expected the token output dust limit %d, got %d (%v)", expected, dust, err)
+	}
+}
+
+func TestTokenTransferSize(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category := strings.Repeat("aa", 32)
+	uxtos := []*bhdmodels.Uxto{
+		{Hash: strings.Repeat("01", 32), Index: 0, PkScript: script, Value: 3000,
+			Token: &bhdmodels.TokenData{Category: category, Amount: 500}},
+		{Hash: strings.Repeat("02", 32), Index: 1, PkScript: script, Value: 1500},
+		{Hash: strings.Repeat("03", 32), Index: 2, PkScript: script, Value: 100000},
+	}
+	tx, err := w.BuildTokenTransfer(&bhdmodels.TokenTransferRequest{
+		DestinationAddress: w.PubAddress,
+		Category:           category,
+		Amount:             200,
+		FeeRate:            2,
+	}, uxtos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, out := range tx.Outputs {
+		dust, err := dustValue(out)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out.Token != nil && out.Value != dust {
+			t.Errorf("expected the token output value %d, got %d", dust, out.Value)
+		}
+	}
+	wireTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	estimate, err := w.estimateTxSize(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the estimate counts the largest signatures, the schnorr ones are 8 bytes smaller
+	size := int64(wireTx.SerializeSize())
+	if estimate < size || estimate > size+8*int64(len(tx.Inputs)) {
+		t.Errorf("estimated %d bytes for the %d byte transaction", estimate, size)
+	}
+	if tx.NetworkFee < 2*size {
+		t.Errorf("fee %d doesn't pay 2 satoshis per byte of %d bytes", tx.NetworkFee, size)
+	}
+}
+
+func TestMultisigInputSize(t *testing.T) {
+	cosigner := newTestWallet(t, "legal winner thank year wave sausage worth useful legal winner thank yellow")
+	cosignerXPub, err := cosigner.GetMultisigXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := newTestWallet(t, testMnemonic)
+	ownXPub, err := w.GetMultisigXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := w.SetMultisigAccount(2, []string{ownXPub, cosignerXPub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	script, err := w.addressScript(address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	size, err := w.inputSize(&bhdmodels.TxIn{PubScript: script})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the bitfield, 2 signatures and the 71 byte 2-of-2 redeem script
+	unlocking := int64(2 + 2*(1+maxSignatureSize) + 1 + 71)
+	if expected := 36 + 4 + int64(wire.VarIntSerializeSize(uint64(unlocking))) + unlocking; size != expected {
+		t.Errorf("expected the multisig input size %d, got %d", expected, size)
+	}
+
+	// the uxtos of the unknown scripts can't be sized
+	other := &bhdmodels.TxIn{PubScript: "a914" + strings.Repeat("00", 20) + "87"}
+	if _, err := w.inputSize(other); err == nil {
+		t.Error("the input spending the unknown P2SH script was sized")
+	}
+}
+
+// wireTokens returns the tokens of the serialized outputs of the signed transaction
+func wireTokens(t *testing.T, w *Wallet, tx *bhdmodels.Tx) (*wire.MsgTx, []*msg.CashToken) {
+	t.Helper()
+	if err := w.ValidateTx(tx); err != nil {
+		t.Fatalf("the built transaction is not valid: %v", err)
+	}
+	wireTx, err := bhdmodels.ToBCHDWireFormat(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tokens []*msg.CashToken
+	for _, out := range wireTx.TxOut {
+		token, _, err := msg.DecodeTokenPrefix(out.PkScript)
+		if err != nil {
+			token = nil
+		}
+		tokens = append(tokens, token)
+	}
+	return wireTx, tokens
+}
+
+func TestBuildTokenGenesis(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	uxtos := []*bhdmodels.Uxto{
+		{Hash: strings.Repeat("01", 32), Index: 1, PkScript: script, Value: 900000},
+		{Hash: strings.Repeat("02", 32), Index: 0, PkScript: script, Value: 3000},
+		{Hash: strings.Repeat("03", 32), Index: 0, PkScript: script, Value: 2000,
+			Token: &bhdmodels.TokenData{Category: strings.Repeat("aa", 32), Amount: 10}},
+	}
+	tx, err := w.BuildTokenGenesis(&bhdmodels.TokenGenesisRequest{
+		Amount:     1000,
+		Capability: bhdmodels.TokenCapabilityMinting,
+		Commitment: "abcd",
+	}, uxtos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wireTx, tokens := wireTokens(t, w, tx)
+	// the category is the txid of the output 0 spent by the first input
+	genesis := wireTx.TxIn[0].PreviousOutPoint
+	if genesis.Index != 0 || genesis.Hash.String() != strings.Repeat("02", 32) {
+		t.Fatalf("unexpected genesis input %s", genesis.String())
+	}
+	token := tokens[0]
+	if token == nil || !bytes.Equal(token.Category, genesis.Hash[:]) || token.Amount != 1000 ||
+		token.Capability() != msg.TokenMinting || hex.EncodeToString(token.Commitment) != "abcd" {
+		t.Errorf("unexpected genesis token %+v", token)
+	}
+	for i, token := range tokens[1:] {
+		if token != nil {
+			t.Errorf("output %d holds the token", i+1)
+		}
+	}
+	// the token uxto is not spent by the genesis
+	for _, in := range tx.Inputs {
+		if in.Token != nil {
+			t.Error("the token uxto spent by the genesis")
+		}
+	}
+
+	// no regular uxto with the index 0
+	if _, err := w.BuildTokenGenesis(&bhdmodels.TokenGenesisRequest{Amount: 1000}, []*bhdmodels.Uxto{uxtos[0], uxtos[2]}); err == nil {
+		t.Error("the genesis built without the output 0 uxto")
+	}
+}
+
+func TestBuildTokenMint(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category := strings.Repeat("aa", 32)
+	minting := &bhdmodels.TokenData{Category: category, Amount: 500, HasNft: true,
+		Capability: bhdmodels.TokenCapabilityMinting, Commitment: "01"}
+	uxtos := []*bhdmodels.Uxto{
+		{Hash: strings.Repeat("01", 32), Index: 3, PkScript: script, Value: 1000, Token: minting},
+		{Hash: strings.Repeat("02", 32), Index: 0, PkScript: script, Value: 100000},
+	}
+	rq := &bhdmodels.TokenMintRequest{
+		Category:     category,
+		MintingHash:  uxtos[0].Hash,
+		MintingIndex: 3,
+		Nfts: []*bhdmodels.NftMint{
+			{Capability: bhdmodels.TokenCapabilityNone, Commitment: "aa"},
+			{Capability: bhdmodels.TokenCapabilityMutable, Commitment: "bb"},
+		},
+	}
+	tx, err := w.BuildTokenMint(rq, uxtos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tokens := wireTokens(t, w, tx)
+	// the minting NFT with its fungible tokens goes back to the wallet
+	kept := tokens[0]
+	if tx.Outputs[0].Address != w.PubAddress || kept == nil || kept.Capability() != msg.TokenMinting ||
+		kept.Amount != 500 || hex.EncodeToString(kept.Commitment) != "01" {
+		t.Errorf("the minting NFT not kept %+v", kept)
+	}
+	for i, expected := range []struct {
+		capability int
+		commitment string
+	}{{msg.TokenImmutable, "aa"}, {msg.TokenMutable, "bb"}} {
+		token := tokens[i+1]
+		if token == nil || token.Category.ToString() != category || token.Capability() != expected.capability ||
+			hex.EncodeToString(token.Commitment) != expected.commitment || token.Amount != 0 {
+			t.Errorf("unexpected minted NFT %d %+v", i, token)
+		}
+	}
+
+	// the mutable NFT can't mint
+	uxtos[0].Token = &bhdmodels.TokenData{Category: category, HasNft: true, Capability: bhdmodels.TokenCapabilityMutable}
+	if _, err := w.BuildTokenMint(rq, uxtos); err == nil {
+		t.Error("the NFT minted by the mutable NFT")
+	}
+}
+
+func TestBuildCommitmentUpdate(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	category := strings.Repeat("aa", 32)
+	uxtos := []*bhdmodels.Uxto{
+		{Hash: strings.Repeat("01", 32), Index: 1, PkScript: script, Value: 1000, Token: &bhdmodels.TokenData{
+			Category: category, Amount: 7, HasNft: true, Capability: bhdmodels.TokenCapabilityMutable, Commitment: "01"}},
+		{Hash: strings.Repeat("02", 32), Index: 2, PkScript: script, Value: 1000, Token: &bhdmodels.TokenData{
+			Category: category, HasNft: true, Capability: bhdmodels.TokenCapabilityNone, Commitment: "02"}},
+		{Hash: strings.Repeat("03", 32), Index: 0, PkScript: script, Value: 100000},
+	}
+	rq := &bhdmodels.TokenCommitmentRequest{Category: category, NftHash: uxtos[0].Hash, NftIndex: 1, Commitment: "cafe"}
+	tx, err := w.BuildCommitmentUpdate(rq, uxtos)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, tokens := wireTokens(t, w, tx)
+	token := tokens[0]
+	if token == nil || token.Capability() != msg.TokenMutable || token.Amount != 7 || hex.EncodeToString(token.Commitment) != "cafe" {
+		t.Errorf("unexpected updated NFT %+v", token)
+	}
+	if uxtos[0].Token.Commitment != "01" {
+		t.Error("the uxto token changed by the update")
+	}
+
+	// the immutable NFT keeps its commitment
+	rq.NftHash, rq.NftIndex = uxtos[1].Hash, 2
+	if _, err := w.BuildCommitmentUpdate(rq, uxtos); err == nil {
+		t.Error("the commitment of the immutable NFT updated")
+	}
+}
@@ -8,7 +8,7 @@ import (
 	"github.com/gcash/bchd/txscript"
 	hd "github.com/gcash/bchutil/hdkeychain"
 	"github.com/pkg/errors"
-	"github.com/tyler-smith/go-bip39"
+	"strconv"
 )
 
 type KeyGenInfo struct {
@@ -18,6 +18,9 @@ type KeyGenInfo struct {
 
 var (
 	Service *Wallet
+	// ValidateTxFlags are the script flags of ValidateTx, schnorr signatures
+	// and the schnorr multisig are enabled since the 2019 upgrades
+	ValidateTxFlags = txscript.StandardVerifyFlags | txscript.ScriptVerifySchnorr | txscript.ScriptVerifySchnorrMultisig
 )
 
 type Wallet struct {
@@ -25,6 +28,15 @@ type Wallet struct {
 	PubAddress string
 	Key        *bip44.ExtendedKey
 	Mnemonic   string
+	Signer     Signer
+	// Account is the BIP44 account key, public for the watch-only wallet
+	Account *bip44.AccountKey
+	// Multisig is the m-of-n account the wallet takes part in, nil when there's none
+	Multisig *MultisigAccount
+	// passphrase is the BIP39 passphrase of the mnemonic
+	passphrase string
+	// addresses are the used addresses found by the restore
+	addresses []string
 }
 
 // GetCryptoNetworkParams returns target blockchain network
@@ -35,43 +47,319 @@ func GetCryptoNetworkParams() *chaincfg.Params {
 // NewWalletFromBip39Seed generates private key
 // from the mnemonic string
 func NewWalletFromBip39Seed(mnemonic string) error {
+	return NewWalletFromMnemonic(mnemonic, "", "", bip44.DefaultMnemonicWords)
+}
+
+// NewWalletFromMnemonic creates the wallet from the mnemonic of the language (detected
+// when empty), passphrase is the optional BIP39 passphrase. The mnemonic of the words
+// count is generated when it's empty, otherwise its words and checksum are validated.
+func NewWalletFromMnemonic(mnemonic string, passphrase string, lang bip44.Language, words int) error {
 	var networkParams *chaincfg.Params
 	var bip44NetType bip44.Network
 	networkParams = GetCryptoNetworkParams()
 	bip44NetType = bip44.MAINNET
+	var err error
 	if mnemonic == "" {
-		entropy, err := bip39.NewEntropy(256)
+		mnemonic, err = bip44.NewMnemonicWords(words, lang)
 		if err != nil {
-			return errors.Wrap(err, "")
+			return err
 		}
-		mnemonic, err = bip39.NewMnemonic(entropy)
+	} else {
+		err = bip44.ValidateMnemonic(mnemonic, lang)
 		if err != nil {
-			return errors.Wrap(err, "")
+			return err
 		}
 	}
 
-	xKey, err := bip44.NewKeyFromMnemonic(mnemonic, "", bip44NetType)
+	xKey, err := bip44.NewKeyFromMnemonic(mnemonic, passphrase, bip44NetType)
 	if err != nil {
-		panic(err)
+		return err
 	}
 
 	// convert to hex
 	wl := &Wallet{
-		NetParams: networkParams,
+		NetParams:  networkParams,
+		passphrase: passphrase,
 	}
 
 	addr, err := wl.GenerateWalletAddress(xKey)
 	wl.PubAddress = addr
 	wl.Mnemonic = mnemonic
+	wl.Account, err = xKey.BIP44AccountKey(bip44.BchCoinType, 0, true)
+	if err != nil {
+		return err
+	}
+	wl.Signer, err = NewHDSigner(wl.Account)
+	if err != nil {
+		return err
+	}
 	Service = wl
 	return nil
 }
 
+// NewWatchOnlyWallet creates the wallet from the account xpub, it derives the
+// addresses and tracks the balance but the transactions can't be signed
+func NewWatchOnlyWallet(xpub string) error {
+	account, err := bip44.NewAccountKeyFromXPubKey(xpub)
+	if err != nil {
+		return err
+	}
+	wl := &Wallet{
+		NetParams: GetCryptoNetworkParams(),
+		Account:   account,
+	}
+	wl.Signer, err = NewWatchOnlySigner(xpub, DefaultAccountPath)
+	if err != nil {
+		return err
+	}
+	wl.PubAddress, err = wl.DeriveAddress(bip44.ExternalChangeType, WalletAddressIndex)
+	if err != nil {
+		return err
+	}
+	Service = wl
+	return nil
+}
+
+// SetSignatureType selects the signature algorithm used by the wallet signer
+func (w *Wallet) SetSignatureType(sigType SignatureType) error {
+	signer, ok := w.Signer.(*HDSigner)
+	if !ok {
+		return errors.New("the signer of the wallet doesn't support the signature type setting")
+	}
+	signer.SignatureType = sigType
+	return nil
+}
+
+// IsWatchOnly returns true when the wallet has no keys to sign
+func (w *Wallet) IsWatchOnly() bool {
+	_, ok := w.Signer.(*WatchOnlySigner)
+	return ok
+}
+
+// GetAccountXPub returns the extended public key of the account, it
+// creates the watch-only copy of the wallet
+func (w *Wallet) GetAccountXPub() (string, error) {
+	if w.Account == nil {
+		return "", errors.New("the wallet has no account key")
+	}
+	pub, err := w.Account.ExKey.Neuter()
+	if err != nil {
+		return "", err
+	}
+	return pub.String(), nil
+}
+
+// DeriveAddress returns the cash address of the account key, the key is added
+// to the signer so the coins received on the address can be spent
+func (w *Wallet) DeriveAddress(change bip44.ChangeType, index uint32) (string, error) {
+	if w.Account == nil {
+		return "", errors.New("the wallet has no account key")
+	}
+	address, err := w.Account.DeriveP2PKAddress(change, index, bip44.MAINNET)
+	if err != nil {
+		return "", err
+	}
+	switch signer := w.Signer.(type) {
+	case *HDSigner:
+		err = signer.Derive(change, index)
+	case *WatchOnlySigner:
+		err = signer.Derive(change, index)
+	}
+	if err != nil {
+		return "", err
+	}
+	return GetBech32Address(bhdmodels.BchAddressPrefixForGeneration, address.PubAddress.Hash160()[:])
+}
+
+// ExportUnsigned returns the unsigned transaction with the data
+// the offline signer needs, only for the watch-only wallet
+func (w *Wallet) ExportUnsigned(tx *bhdmodels.Tx) (*UnsignedTx, error) {
+	signer, ok := w.Signer.(*WatchOnlySigner)
+	if !ok {
+		return nil, errors.New("only the watch-only wallet exports unsigned transactions")
+	}
+	return signer.ExportUnsigned(tx)
+}
+
+// NewPartialTx creates the partially signed transaction with the derivations
+// of the wallet keys, so the offline signer knows which keys to use
+func (w *Wallet) NewPartialTx(tx *bhdmodels.Tx) (*PartialTx, error) {
+	p, err := NewPartialTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	var keys *accountKeys
+	switch signer := w.Signer.(type) {
+	case *HDSigner:
+		keys = signer.keys
+	case *WatchOnlySigner:
+		keys = signer.keys
+	default:
+		return p, nil
+	}
+	for _, in := range p.Inputs {
+		scriptCode, _, err := in.spentScript()
+		if err != nil {
+			return nil, err
+		}
+		if key := keys.lookup(scriptCode); key != nil {
+			in.Derivations = append(in.Derivations, key.derivation(DefaultAccountPath))
+		}
+		if w.Multisig == nil {
+			continue
+		}
+		if redeem := w.Multisig.lookup(scriptCode); redeem != nil {
+			in.RedeemScript = hex.EncodeToString(redeem.Script)
+			path := MultisigAccountPath + "/" + strconv.Itoa(int(redeem.Change)) + "/" + strconv.Itoa(int(redeem.Index))
+			for _, pubKey := range redeem.PubKeys {
+				in.Derivations = append(in.Derivations, &KeyDerivation{PubKey: hex.EncodeToString(pubKey), Path: path})
+			}
+		}
+	}
+	for _, out := range p.Outputs {
+		script, err := hex.DecodeString(out.PkScript)
+		if err != nil {
+			return nil, err
+		}
+		if key := keys.lookup(script); key != nil {
+			out.Derivations = append(out.Derivations, key.derivation(DefaultAccountPath))
+		}
+	}
+	return p, nil
+}
+
+// SignPartial adds the wallet signatures to the partially signed transaction
+// and returns the number of the signed inputs
+func (w *Wallet) SignPartial(p *PartialTx) (int, error) {
+	signer, ok := w.Signer.(*HDSigner)
+	if !ok {
+		if w.IsWatchOnly() {
+			return 0, ErrWatchOnly
+		}
+		return 0, errors.New("the signer of the wallet can't sign partially signed transactions")
+	}
+	return signer.SignPartial(p)
+}
+
+// FinalizePartial finalizes the partially signed transaction and returns
+// the signed transaction ready for broadcasting
+func (w *Wallet) FinalizePartial(p *PartialTx) (*bhdmodels.Tx, error) {
+	err := p.Finalize()
+	if err != nil {
+		return nil, err
+	}
+	tx, err := p.Extract()
+	if err != nil {
+		return nil, err
+	}
+	err = w.ValidateTx(tx)
+	if err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// masterKey returns the master key of the wallet mnemonic
+func (w *Wallet) masterKey() (*bip44.ExtendedKey, error) {
+	if w.Mnemonic == "" {
+		return nil, errors.New("the wallet has no mnemonic")
+	}
+	return bip44.NewKeyFromMnemonic(w.Mnemonic, w.passphrase, bip44.MAINNET)
+}
+
+// GetMultisigXPub returns the cosigner xpub (MultisigAccountPath) of the wallet,
+// it's shared with the other cosigners to create the multisig account
+func (w *Wallet) GetMultisigXPub() (string, error) {
+	xKey, err := w.masterKey()
+	if err != nil {
+		return "", err
+	}
+	account, err := MultisigAccountKey(xKey)
+	if err != nil {
+		return "", err
+	}
+	pub, err := account.ExKey.Neuter()
+	if err != nil {
+		return "", err
+	}
+	return pub.String(), nil
+}
+
+// SetMultisigAccount creates the m-of-n account of the cosigner xpubs and returns its
+// first address. When the wallet has the keys, its own cosigner xpub must be one of them.
+func (w *Wallet) SetMultisigAccount(required int, xpubs []string) (string, error) {
+	account, err := NewMultisigAccount(required, xpubs)
+	if err != nil {
+		return "", err
+	}
+	if signer, ok := w.Signer.(*HDSigner); ok {
+		xpub, err := w.GetMultisigXPub()
+		if err != nil {
+			return "", err
+		}
+		var isCosigner = false
+		for _, cosigner := range xpubs {
+			isCosigner = isCosigner || cosigner == xpub
+		}
+		if !isCosigner {
+			return "", errors.New("the wallet is not the cosigner of the multisig account")
+		}
+		xKey, err := w.masterKey()
+		if err != nil {
+			return "", err
+		}
+		ownAccount, err := MultisigAccountKey(xKey)
+		if err != nil {
+			return "", err
+		}
+		err = signer.SetMultisigAccount(ownAccount)
+		if err != nil {
+			return "", err
+		}
+	}
+	w.Multisig = account
+	return w.GetMultisigAddress(bip44.ExternalChangeType, MultisigAddressIndex)
+}
+
+// GetMultisigAddress returns the P2SH address of the multisig account, the
+// redeem script and the cosigner key are remembered for signing
+func (w *Wallet) GetMultisigAddress(change bip44.ChangeType, index uint32) (string, error) {
+	if w.Multisig == nil {
+		return "", errors.New("the wallet has no multisig account")
+	}
+	if signer, ok := w.Signer.(*HDSigner); ok {
+		err := signer.DeriveMultisig(change, index)
+		if err != nil {
+			return "", err
+		}
+	}
+	return w.Multisig.Address(change, index)
+}
+
 // GetAddress returns the address to use
 func (w *Wallet) GetAddress() string {
 	return w.PubAddress
 }
 
+// GetAddresses returns all the addresses the wallet is watching
+func (w *Wallet) GetAddresses() []string {
+	return append([]string{w.PubAddress}, w.addresses...)
+}
+
+// addAddresses adds the addresses to the watched ones, the known are skipped
+func (w *Wallet) addAddresses(addresses []string) {
+	known := make(map[string]bool)
+	for _, address := range w.GetAddresses() {
+		known[address] = true
+	}
+	for _, address := range addresses {
+		if !known[address] {
+			w.addresses = append(w.addresses, address)
+			known[address] = true
+		}
+	}
+}
+
 // GenerateWalletAddress will create number (cnt) of addresses
 // that will be used by the wallet as destination address
 func (w *Wallet) GenerateWalletAddress(key *bip44.ExtendedKey) (string, error) {
@@ -97,58 +385,16 @@ func (w *Wallet) GenerateWalletAddress(key *bip44.ExtendedKey) (string, error) {
 }
 
 // SignTransaction will sign the transaction, every input
-// with proper key
-func (w *Wallet) SignTransaction(mnemonic string, tx *bhdmodels.Tx) error {
-	// sign the transactions by signing each input point
-	_msg, err := bhdmodels.ToBCHDWireFormat(tx)
-	if err != nil {
-		return err
+// with proper key, using the signer of the wallet
+func (w *Wallet) SignTransaction(tx *bhdmodels.Tx) error {
+	if w.Signer == nil {
+		return errors.New("the wallet has no signer")
 	}
-
-	xKey, err := bip44.NewKeyFromMnemonic(mnemonic, "", bip44.MAINNET)
+	err := w.Signer.SignTransaction(tx)
 	if err != nil {
 		return err
 	}
 
-	ak, err := xKey.BIP44AccountKey(bip44.BchCoinType, 0, true)
-	addrRet, err := ak.DeriveP2PKAddress(bip44.ExternalChangeType, uint32(255), bip44.MAINNET)
-
-	ecpPKey, err := addrRet.PrivateKey.ECPrivKey()
-
-	var allSigned = true
-	for i, el := range tx.Inputs {
-		pubScript, err := hex.DecodeString(el.PubScript)
-		if len(pubScript) == 0 {
-			return errors.New("the input has empty pub script")
-		}
-		if err != nil {
-			return err
-		}
-		scriptClass, _, _, err := txscript.ExtractPkScriptAddrs(pubScript, &chaincfg.MainNetParams)
-		switch scriptClass {
-		case txscript.NonStandardTy:
-			allSigned = false
-			continue
-		case txscript.MultiSigTy:
-			allSigned = false
-			continue
-		case txscript.NullDataTy:
-			allSigned = false
-			continue
-		case txscript.ScriptHashTy:
-			allSigned = false
-			continue
-		}
-		sigScript, err := txscript.SignatureScript(_msg, i, el.Value, pubScript, txscript.SigHashAll, ecpPKey, true)
-		if err != nil {
-			return err
-		}
-		el.Signature = hex.EncodeToString(sigScript)
-	}
-	if !allSigned {
-		return errors.New("not all inputs have been signed, some are not supported by this function")
-	}
-
 	err = w.ValidateTx(tx)
 	if err != nil {
 		return err
@@ -164,13 +410,37 @@ func (w *Wallet) ValidateTx(tx *bhdmodels.Tx) error {
 		return err
 	}
 
-	flags := txscript.StandardVerifyFlags
+	utxos, err := spentOutputs(tx)
+	if err != nil {
+		return err
+	}
+
+	flags := ValidateTxFlags
 	var isOkay = true
 	for i, e := range tx.Inputs {
-		pubScript, err := hex.DecodeString(e.PubScript)
+		pubScript, tokenPrefix, err := e.SpentScript()
 		if err != nil {
 			return err
 		}
+		// the script engine doesn't know the token prefix and the UTXOS hash type, the
+		// hash type is taken from the signatures, they can differ from the requested one
+		class := txscript.GetScriptClass(pubScript)
+		utxosHashType, err := usesUtxosHashType(msg.TxIn[i].SignatureScript, class)
+		if err != nil {
+			isOkay = false
+			continue
+		}
+		if tokenPrefix != nil || utxosHashType {
+			if class == txscript.ScriptHashTy {
+				err = verifyMultisigInput(msg, i, utxos, e.Value, pubScript, tokenPrefix)
+			} else {
+				err = verifyP2PKHInput(msg, i, utxos, e.Value, pubScript, tokenPrefix)
+			}
+			if err != nil {
+				isOkay = false
+			}
+			continue
+		}
 		vm, err := txscript.NewEngine(pubScript, msg, i, flags, nil, nil, nil, e.Value)
 		if err != nil {
 			return err
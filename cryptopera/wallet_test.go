@@ -0,0 +1,120 @@
+package cryptopera
+
+import (
+	"bhd/bhdmodels"
+	"bhd/cryptopera/bip44"
+	"encoding/hex"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/gcash/bchd/wire"
+)
+
+func TestWatchOnlyWallet(t *testing.T) {
+	w := newTestWallet(t, testMnemonic)
+	xpub, err := w.GetAccountXPub()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = NewWatchOnlyWallet(xpub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	watchOnly := Service
+	if !watchOnly.IsWatchOnly() || watchOnly.PubAddress != w.PubAddress {
+		t.Fatalf("the watch-only address %s, expected %s", watchOnly.PubAddress, w.PubAddress)
+	}
+	if _, err := w.ExportUnsigned(&bhdmodels.Tx{}); err == nil {
+		t.Error("the unsigned transaction exported by the wallet with keys")
+	}
+	if err := NewWatchOnlyWallet(w.Account.ExKey.String()); err == nil {
+		t.Error("the watch-only wallet created from the private key")
+	}
+
+	// the change address is derived by both wallets
+	change, err := watchOnly.DeriveAddress(bip44.InternalChangeType, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected, _ := w.DeriveAddress(bip44.InternalChangeType, 3); change != expected {
+		t.Fatalf("the watch-only change address %s, expected %s", change, expected)
+	}
+	script, err := w.addressScript(w.PubAddress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changeScript, err := w.addressScript(change)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx := &bhdmodels.Tx{Version: wire.TxVersion}
+	tx.Outputs = append(tx.Outputs, &bhdmodels.TxOut{Value: 10000, PkScript: script, Address: w.PubAddress})
+	uxtos := []*bhdmodels.Uxto{
+		{Hash: strings.Repeat("01", 32), Index: 0, PkScript: script, Value: 6000},
+		{Hash: strings.Repeat("02", 32), Index: 1, PkScript: changeScript, Value: 8000},
+	}
+	err = watchOnly.fundTransaction(tx, uxtos, DefaultFeeRate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watchOnly.SignTransaction(tx); err != ErrWatchOnly {
+		t.Fatalf("the watch-only wallet signed the transaction: %v", err)
+	}
+	unsigned, err := watchOnly.ExportUnsigned(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(unsigned.Inputs) != 2 || unsigned.AccountPath != DefaultAccountPath {
+		t.Fatalf("unexpected unsigned transaction %+v", unsigned)
+	}
+
+	// the offline signer derives the keys of the paths from the account key
+	var signatures []*InputSignature
+	for _, in := range unsigned.Inputs {
+		path := strings.Split(in.Path, "/")
+		if len(path) != 2 {
+			t.Fatalf("unexpected path %s", in.Path)
+		}
+		change, _ := strconv.Atoi(path[0])
+		index, _ := strconv.Atoi(path[1])
+		address, err := w.Account.DeriveP2PKAddress(bip44.ChangeType(change), uint32(index), bip44.MAINNET)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key, err := address.PrivateKey.ECPrivKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if pubKey := hex.EncodeToString(key.PubKey().SerializeCompressed()); pubKey != in.PubKey {
+			t.Fatalf("the key of the path %s is %s, expected %s", in.Path, pubKey, in.PubKey)
+		}
+		hash, _ := hex.DecodeString(in.SigHash)
+		signature, err := SignatureSchnorr.sign(key, hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signatures = append(signatures, &InputSignature{
+			Index:     in.Index,
+			Signature: hex.EncodeToString(append(signature.Serialize(), byte(in.HashType))),
+			PubKey:    in.PubKey,
+		})
+	}
+	err = ApplySignatures(unsigned.Tx, signatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watchOnly.ValidateTx(unsigned.Tx); err != nil {
+		t.Fatalf("the transaction signed offline is not valid: %v", err)
+	}
+
+	// the signature of the other input doesn't pass
+	signatures[0].Index, signatures[1].Index = 1, 0
+	err = ApplySignatures(unsigned.Tx, signatures)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := watchOnly.ValidateTx(unsigned.Tx); err == nil {
+		t.Error("the swapped signatures passed the validation")
+	}
+}
@@ -3,11 +3,14 @@ module bhd
 go 1.19
 
 require (
+	github.com/dchest/siphash v1.2.3
 	github.com/gcash/bchd v0.19.0
 	github.com/gcash/bchutil v0.0.0-20210113190856-6ea28dff4000
 	github.com/pkg/errors v0.9.1
 	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/tyler-smith/go-bip39 v1.1.0
+	golang.org/x/crypto v0.8.0
+	golang.org/x/text v0.9.0
 )
 
 require (
@@ -15,10 +18,3 @@ require (
 	github.com/google/uuid v1.3.0 // indirect
 	golang.org/x/sys v0.7.0 // indirect
 )
-
-require (
-	github.com/dchest/siphash v1.2.3 // indirect
-	golang.org/x/crypto v0.8.0 // indirect
-)
-
-
@@ -49,6 +49,181 @@ func Call(methodName string, data string) *C.char {
 		json.Unmarshal([]byte(data), &rq)
 		methodResult := app.GetBchAddressQrCode(rq.Param1, rq.Param2)
 		return C.CString(methodResult.ToJsonString())
+	case "M6":
+		methodResult := app.GetEvents()
+		return C.CString(methodResult.ToJsonString())
+	case "M7":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeMemPool(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M8":
+		methodResult := app.GetWalletBalance()
+		return C.CString(methodResult.ToJsonString())
+	case "M9":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.GetWalletTransactions(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M10":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.GetTokenInventory(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M11":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.SendTokens(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M12":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.CreateTokenCategory(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M13":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.MintTokens(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M14":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.UpdateTokenCommitment(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M15":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeTokenMetadata(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M16":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.LoadTokenRegistry(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M17":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.ResolveTokenMetadata(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M18":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.UseRemoteSigner(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M19":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeWatchOnlyWallet(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M20":
+		methodResult := app.GetAccountXPub()
+		return C.CString(methodResult.ToJsonString())
+	case "M21":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.ExportUnsignedTransaction(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M22":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.CreatePartialTransaction(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M23":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.SignPartialTransaction(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M24":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.CombinePartialTransactions(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M25":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.FinalizePartialTransaction(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M26":
+		methodResult := app.GetMultisigXPub()
+		return C.CString(methodResult.ToJsonString())
+	case "M27":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.CreateMultisigAccount(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M28":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.GetMultisigAddress(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M29":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.SetSignatureType(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M30":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.AppendToPartialTransaction(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M31":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.SignMessage(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M32":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.VerifyMessage(rq.Param1, rq.Param2, rq.Param3)
+		return C.CString(methodResult.ToJsonString())
+	case "M33":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.AnswerLoginChallenge(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M34":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeWalletWithOptions(rq.Param1, rq.Param2, rq.Param3, rq.Param4)
+		return C.CString(methodResult.ToJsonString())
+	case "M35":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.ValidateMnemonic(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M36":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.StartWalletRestore(rq.Param1, rq.Param2, rq.Param3)
+		return C.CString(methodResult.ToJsonString())
+	case "M37":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.ContinueWalletRestore(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M38":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.CreateSeedShares(rq.Param1, rq.Param2)
+		return C.CString(methodResult.ToJsonString())
+	case "M39":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeWalletFromShares(rq.Param1, rq.Param2, rq.Param3)
+		return C.CString(methodResult.ToJsonString())
+	case "M40":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.AnswerRegisterAddressChallenge(rq.Param1, rq.Param2, rq.Param3, rq.Param4)
+		return C.CString(methodResult.ToJsonString())
+	case "M41":
+		var rq BackendParams
+		json.Unmarshal([]byte(data), &rq)
+		methodResult := app.InitializeHeaderChain(rq.Param1)
+		return C.CString(methodResult.ToJsonString())
+	case "M42":
+		methodResult := app.SaveHeaderChain()
+		return C.CString(methodResult.ToJsonString())
 	default:
 		result := &app.ApiReturnStruct{ErrorID: 2, ErrorDescription: "Error: Unknown method name:" + methodName + " (Not implemented))"}
 		content, _ := json.Marshal(result)
@@ -116,6 +116,8 @@ func ByteToInt(val []byte) int {
 }
 
 // ReadUint8 reads uint8 from bytes reader
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadUint8(reader *bytes.Reader) uint8 {
 	val, err := reader.ReadByte()
 	if err != nil {
@@ -125,6 +127,8 @@ func ReadUint8(reader *bytes.Reader) uint8 {
 }
 
 // ReadUint16 reads uint16 from bytes reader
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadUint16(reader *bytes.Reader) uint16 {
 	var tempBuff = []byte{0x00, 0x00}
 	_, err := reader.Read(tempBuff)
@@ -134,6 +138,9 @@ func ReadUint16(reader *bytes.Reader) uint16 {
 	return ByteToUInt16(tempBuff)
 }
 
+// ReadIp reads 16 byte ip address
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadIp(reader *bytes.Reader) string {
 	// if stats with 0x00000000000000000000FFFF then its ipv4
 	var ip = make([]byte, 16)
@@ -145,6 +152,8 @@ func ReadIp(reader *bytes.Reader) string {
 }
 
 // ReadUint16Be reads uint16 from bytes reader in big endian order
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadUint16Be(reader *bytes.Reader) uint16 {
 	var tempBuff = []byte{0x00, 0x00}
 	_, err := reader.Read(tempBuff)
@@ -155,6 +164,8 @@ func ReadUint16Be(reader *bytes.Reader) uint16 {
 }
 
 // ReadUint32 reads uint32 from bytes reader
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadUint32(reader *bytes.Reader) uint32 {
 	var tempBuff = []byte{0x00, 0x00, 0x00, 0x00}
 	_, err := reader.Read(tempBuff)
@@ -165,6 +176,8 @@ func ReadUint32(reader *bytes.Reader) uint32 {
 }
 
 // ReadUint64 reads uint64 from bytes reader
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadUint64(reader *bytes.Reader) uint64 {
 	var tempBuff = []byte{0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00}
 	_, err := reader.Read(tempBuff)
@@ -204,6 +217,9 @@ func VarStringToByte(str string) []byte {
 	return append(buff, []byte(str)...)
 }
 
+// ReadVarInt reads the variable length integer
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadVarInt(reader *bytes.Reader) uint64 {
 	firstByte, err := reader.ReadByte()
 	if err != nil {
@@ -224,6 +240,9 @@ func ReadVarInt(reader *bytes.Reader) uint64 {
 	panic("why am I here?")
 }
 
+// ReadVarString reads the string prefixed with its length
+//
+// Deprecated: panics on short input, use BinaryReader for the untrusted data
 func ReadVarString(reader *bytes.Reader) string {
 	// read the first byte, that will tell us the length
 	var strLength = ReadVarInt(reader)
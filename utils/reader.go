@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+)
+
+var (
+	// ErrShortRead is returned when the data ends before the value is read
+	ErrShortRead = errors.New("unexpected end of data")
+	// ErrCountTooLarge is returned when the element count or length is over the limit
+	ErrCountTooLarge = errors.New("count is over the limit")
+)
+
+// BinaryReader reads the little endian values from the untrusted data, unlike the
+// Read* functions it returns error instead of panic when the data is too short
+type BinaryReader struct {
+	*bytes.Reader
+}
+
+// NewBinaryReader wraps the reader, position is shared with the wrapped reader
+func NewBinaryReader(reader *bytes.Reader) *BinaryReader {
+	return &BinaryReader{reader}
+}
+
+// ReadBytes reads exactly n bytes
+func (r *BinaryReader) ReadBytes(n int) ([]byte, error) {
+	if n < 0 || n > r.Len() {
+		return nil, ErrShortRead
+	}
+	var buff = make([]byte, n)
+	if n == 0 {
+		return buff, nil
+	}
+	_, err := r.Read(buff)
+	if err != nil {
+		return nil, ErrShortRead
+	}
+	return buff, nil
+}
+
+// ReadUint8 reads uint8
+func (r *BinaryReader) ReadUint8() (uint8, error) {
+	val, err := r.ReadByte()
+	if err != nil {
+		return 0, ErrShortRead
+	}
+	return val, nil
+}
+
+// ReadUint16 reads little endian uint16
+func (r *BinaryReader) ReadUint16() (uint16, error) {
+	buff, err := r.ReadBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint16(buff), nil
+}
+
+// ReadUint16Be reads big endian uint16
+func (r *BinaryReader) ReadUint16Be() (uint16, error) {
+	buff, err := r.ReadBytes(2)
+	if err != nil {
+		return 0, err
+	}
+	return binary.BigEndian.Uint16(buff), nil
+}
+
+// ReadUint32 reads little endian uint32
+func (r *BinaryReader) ReadUint32() (uint32, error) {
+	buff, err := r.ReadBytes(4)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buff), nil
+}
+
+// ReadUint64 reads little endian uint64
+func (r *BinaryReader) ReadUint64() (uint64, error) {
+	buff, err := r.ReadBytes(8)
+	if err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buff), nil
+}
+
+// ReadIp reads 16 byte ip address, ipv4 is mapped to ipv6
+func (r *BinaryReader) ReadIp() (string, error) {
+	buff, err := r.ReadBytes(16)
+	if err != nil {
+		return "", err
+	}
+	return net.IP(buff).String(), nil
+}
+
+// ReadVarInt reads the variable length integer
+func (r *BinaryReader) ReadVarInt() (uint64, error) {
+	firstByte, err := r.ReadUint8()
+	if err != nil {
+		return 0, err
+	}
+	switch firstByte {
+	case 0xFD:
+		val, err := r.ReadUint16()
+		return uint64(val), err
+	case 0xFE:
+		val, err := r.ReadUint32()
+		return uint64(val), err
+	case 0xFF:
+		return r.ReadUint64()
+	}
+	return uint64(firstByte), nil
+}
+
+// ReadCount reads the number of elements that follow, it fails when the count is
+// over max or when the remaining data can't hold count elements of minItemSize bytes,
+// so the caller can safely allocate and loop over the count
+func (r *BinaryReader) ReadCount(max uint64, minItemSize int) (int, error) {
+	count, err := r.ReadVarInt()
+	if err != nil {
+		return 0, err
+	}
+	if count > max {
+		return 0, fmt.Errorf("%w: %d", ErrCountTooLarge, count)
+	}
+	if minItemSize > 0 && count > uint64(r.Len()/minItemSize) {
+		return 0, ErrShortRead
+	}
+	return int(count), nil
+}
+
+// ReadVarBytes reads the byte array prefixed with its length, the length is limited by max
+func (r *BinaryReader) ReadVarBytes(max uint64) ([]byte, error) {
+	size, err := r.ReadCount(max, 1)
+	if err != nil {
+		return nil, err
+	}
+	return r.ReadBytes(size)
+}
+
+// ReadVarString reads the string prefixed with its length, the length is limited by max
+func (r *BinaryReader) ReadVarString(max uint64) (string, error) {
+	buff, err := r.ReadVarBytes(max)
+	if err != nil {
+		return "", err
+	}
+	// trim buff
+	buff = bytes.TrimRight(buff, string(rune(0)))
+	return string(buff), nil
+}
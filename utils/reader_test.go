@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// FuzzBinaryReader reads the values in the order given by the ops, the reader
+// must never panic or read past the end of the data
+func FuzzBinaryReader(f *testing.F) {
+	f.Add([]byte{0, 1, 2, 3, 4, 5, 6, 7, 8}, []byte{0xfd, 0x10, 0x00, 0x01, 0x02})
+	f.Add([]byte{7, 8, 9}, []byte{0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff})
+	f.Add([]byte{6, 9}, []byte{0x03, 'a', 'b', 0x00, 0xfe, 0x00, 0x00, 0x00, 0x01})
+	f.Fuzz(func(t *testing.T, ops []byte, data []byte) {
+		r := NewBinaryReader(bytes.NewReader(data))
+		for _, op := range ops {
+			var err error
+			before := r.Len()
+			switch op % 10 {
+			case 0:
+				_, err = r.ReadUint8()
+			case 1:
+				_, err = r.ReadUint16()
+			case 2:
+				_, err = r.ReadUint16Be()
+			case 3:
+				_, err = r.ReadUint32()
+			case 4:
+				_, err = r.ReadUint64()
+			case 5:
+				_, err = r.ReadIp()
+			case 6:
+				_, err = r.ReadVarInt()
+			case 7:
+				_, err = r.ReadCount(uint64(op), int(op%4))
+			case 8:
+				_, err = r.ReadVarBytes(uint64(op) << 4)
+			case 9:
+				_, err = r.ReadVarString(uint64(op))
+			}
+			if r.Len() > before {
+				t.Fatalf("op %d moved the reader back", op)
+			}
+			if err != nil {
+				return
+			}
+		}
+	})
+}
+
+func TestReadCountErrors(t *testing.T) {
+	for _, test := range []struct {
+		data []byte
+		max  uint64
+		err  error
+	}{
+		{[]byte{0x03, 1, 2, 3}, 2, ErrCountTooLarge},
+		{[]byte{0xfd, 0x00, 0x01}, 1000, ErrShortRead},
+		{[]byte{0xfd, 0x00}, 1000, ErrShortRead},
+		{[]byte{0x02, 1, 2}, 2, nil},
+	} {
+		_, err := NewBinaryReader(bytes.NewReader(test.data)).ReadCount(test.max, 1)
+		if !errors.Is(err, test.err) {
+			t.Errorf("%x: expected %v, got %v", test.data, test.err, err)
+		}
+	}
+	if _, err := NewBinaryReader(bytes.NewReader([]byte{0x05, 'a'})).ReadVarBytes(4); !errors.Is(err, ErrCountTooLarge) {
+		t.Errorf("expected ErrCountTooLarge, got %v", err)
+	}
+}